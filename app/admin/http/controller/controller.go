@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"errors"
+	cmiddleware "hexagony/app/shared/http/middleware"
+	"hexagony/lib/audit"
+	"hexagony/lib/flags"
+	"hexagony/lib/rest"
+	"hexagony/lib/validation"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AdminHandler struct {
+	audit audit.Logger
+}
+
+func NewAdminHandler(c *chi.Mux) {
+	handler := AdminHandler{audit: audit.New()}
+
+	c.Route("/admin", func(r chi.Router) {
+		r.Use(cmiddleware.AuthMiddleware, cmiddleware.AdminMiddleware)
+
+		r.Post("/maintenance", handler.Maintenance)
+		r.Get("/features", handler.Features)
+		r.Post("/features/{name}", handler.SetFeature)
+	})
+}
+
+var errFeatureNotFound = errors.New("feature not found")
+
+type maintenanceRequest struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+}
+
+type maintenanceResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Maintenance godoc
+// @Summary      Toggle the maintenance mode
+// @Description  turns the maintenance mode on or off without a redeploy (admin only)
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization  header    string              true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        payload        body      maintenanceRequest  true  "the new maintenance mode"
+// @Success      200            {object}  maintenanceResponse
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      422            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /admin/maintenance [post]
+func (a *AdminHandler) Maintenance(w http.ResponseWriter, r *http.Request) {
+	var payload maintenanceRequest
+
+	if !rest.DecodeJSON(w, r, &payload) {
+		return
+	}
+
+	validation := validation.New()
+
+	if err := validation.BindStruct(r.Context(), payload); err != nil {
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	if !ok {
+		rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+		return
+	}
+
+	cmiddleware.SetMaintenance(*payload.Enabled)
+
+	action := "maintenance.off"
+	if *payload.Enabled {
+		action = "maintenance.on"
+	}
+
+	a.audit.Record(r.Context(), audit.Entry{Action: action, Actor: claims.UUID})
+
+	rest.JSON(w, http.StatusOK, &maintenanceResponse{Enabled: *payload.Enabled})
+}
+
+type featureRequest struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+}
+
+type featureResponse struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Features godoc
+// @Summary      List the feature flags
+// @Description  lists the feature flags and whether they are on (admin only)
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Success      200            {object}  []featureResponse
+// @Failure      403            {object}  rest.Message
+// @Router       /admin/features [get]
+func (a *AdminHandler) Features(w http.ResponseWriter, r *http.Request) {
+	names := flags.Names()
+
+	features := make([]featureResponse, len(names))
+	for i, name := range names {
+		features[i] = featureResponse{Name: name, Enabled: flags.Enabled(name)}
+	}
+
+	rest.JSON(w, http.StatusOK, features)
+}
+
+// SetFeature godoc
+// @Summary      Toggle a feature flag
+// @Description  turns the feature flag on or off until the restart, whatever FEATURE_FLAGS says (admin only)
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization  header    string          true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        name           path      string          true  "the feature flag"
+// @Param        payload        body      featureRequest  true  "the new value of the flag"
+// @Success      200            {object}  featureResponse
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      422            {object}  rest.Message
+// @Router       /admin/features/{name} [post]
+func (a *AdminHandler) SetFeature(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !flags.Defined(name) {
+		rest.DecodeError(w, r, errFeatureNotFound, http.StatusNotFound)
+		return
+	}
+
+	var payload featureRequest
+
+	if !rest.DecodeJSON(w, r, &payload) {
+		return
+	}
+
+	validation := validation.New()
+
+	if err := validation.BindStruct(r.Context(), payload); err != nil {
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	if !ok {
+		rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+		return
+	}
+
+	flags.Set(name, *payload.Enabled)
+
+	action := "feature." + name + ".off"
+	if *payload.Enabled {
+		action = "feature." + name + ".on"
+	}
+
+	a.audit.Record(r.Context(), audit.Entry{Action: action, Actor: claims.UUID})
+
+	rest.JSON(w, http.StatusOK, &featureResponse{Name: name, Enabled: *payload.Enabled})
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	cmiddleware "hexagony/app/shared/http/middleware"
+	"hexagony/lib/audit"
+	"hexagony/lib/flags"
+	"hexagony/lib/rest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditRecorder struct {
+	entries []audit.Entry
+}
+
+func (a *auditRecorder) Record(ctx context.Context, entry audit.Entry) {
+	a.entries = append(a.entries, entry)
+}
+
+func TestNewAdminHandler(t *testing.T) {
+	router := chi.NewRouter()
+
+	NewAdminHandler(router)
+}
+
+// TestJSONTags checks every field of the bodies has a snake_case json
+// tag, see rest.UntaggedFields.
+func TestJSONTags(t *testing.T) {
+	for _, v := range []interface{}{&maintenanceRequest{}, &maintenanceResponse{}, &featureRequest{}, &featureResponse{}} {
+		assert.Empty(t, rest.UntaggedFields(v))
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	defer cmiddleware.SetMaintenance(false)
+
+	admin := uuid.New()
+	recorder := &auditRecorder{}
+
+	handler := AdminHandler{audit: recorder}
+
+	router := chi.NewRouter()
+	router.Use(cmiddleware.MaintenanceMiddleware)
+	router.Post("/admin/maintenance", handler.Maintenance)
+	router.Post("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	serve := func(path, payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(payload))
+		assert.NoError(t, err)
+
+		req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: admin}))
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, serve("/user", "").Code)
+
+	rec := serve("/admin/maintenance", `{"enabled":true}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"enabled":true}`, rec.Body.String())
+	assert.True(t, cmiddleware.Maintenance())
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/user", "").Code)
+
+	rec = serve("/admin/maintenance", `{"enabled":false}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, cmiddleware.Maintenance())
+	assert.Equal(t, http.StatusCreated, serve("/user", "").Code)
+
+	assert.Equal(t, []audit.Entry{
+		{Action: "maintenance.on", Actor: admin},
+		{Action: "maintenance.off", Actor: admin},
+	}, recorder.entries)
+}
+
+func TestMaintenanceFail(t *testing.T) {
+	defer cmiddleware.SetMaintenance(false)
+
+	handler := AdminHandler{audit: &auditRecorder{}}
+
+	router := chi.NewRouter()
+	router.Post("/admin/maintenance", handler.Maintenance)
+
+	cases := []struct {
+		name     string
+		payload  string
+		expected int
+	}{
+		{"empty", ``, http.StatusBadRequest},
+		{"invalid-json", `{"enabled":`, http.StatusBadRequest},
+		{"wrong-type", `{"enabled":"yes"}`, http.StatusUnprocessableEntity},
+		{"missing-enabled", `{}`, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/admin/maintenance", bytes.NewBufferString(c.payload))
+			assert.NoError(t, err)
+
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: uuid.New()}))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+			assert.False(t, cmiddleware.Maintenance())
+		})
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	defer flags.Reset("dark-launch")
+
+	flags.Define("dark-launch", false)
+
+	admin := uuid.New()
+	recorder := &auditRecorder{}
+
+	handler := AdminHandler{audit: recorder}
+
+	router := chi.NewRouter()
+	router.Get("/admin/features", handler.Features)
+	router.Post("/admin/features/{name}", handler.SetFeature)
+
+	serve := func(method, path, payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(payload))
+		assert.NoError(t, err)
+
+		req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: admin}))
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/admin/features", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `{"name":"dark-launch","enabled":false}`)
+
+	rec = serve(http.MethodPost, "/admin/features/dark-launch", `{"enabled":true}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name":"dark-launch","enabled":true}`, rec.Body.String())
+	assert.True(t, flags.Enabled("dark-launch"))
+
+	rec = serve(http.MethodPost, "/admin/features/unknown", `{"enabled":true}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.False(t, flags.Defined("unknown"))
+
+	rec = serve(http.MethodPost, "/admin/features/dark-launch", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.True(t, flags.Enabled("dark-launch"))
+
+	assert.Equal(t, []audit.Entry{
+		{Action: "feature.dark-launch.on", Actor: admin},
+	}, recorder.entries)
+}
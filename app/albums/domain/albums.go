@@ -2,6 +2,8 @@ package domain
 
 import (
 	"context"
+	"encoding/json"
+	"hexagony/lib/rest"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,8 +13,19 @@ type Album struct {
 	UUID      uuid.UUID `db:"uuid" json:"id"`
 	Name      string    `db:"name" json:"name"`
 	Length    int       `db:"length" json:"length"`
-	CreatedAt time.Time `db:"created_at" json:"created_at" `
-	UpdatedAt time.Time `db:"updated_at" json:"updated_at" `
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// MarshalJSON writes the timestamps in RFC 3339, in UTC, see rest.Time.
+func (a Album) MarshalJSON() ([]byte, error) {
+	type album Album
+
+	return json.Marshal(struct {
+		album
+		CreatedAt rest.Time `json:"created_at"`
+		UpdatedAt rest.Time `json:"updated_at"`
+	}{album(a), rest.Time(a.CreatedAt), rest.Time(a.UpdatedAt)})
 }
 
 type AlbumRepository interface {
@@ -1,10 +1,10 @@
 package controller
 
 import (
-	"encoding/json"
 	"hexagony/app/albums/domain"
 	cmiddleware "hexagony/app/shared/http/middleware"
 	"hexagony/lib/clog"
+	"hexagony/lib/idgen"
 	"hexagony/lib/rest"
 	"hexagony/lib/validation"
 	"net/http"
@@ -21,12 +21,15 @@ type AlbumHandler struct {
 func NewAlbumHandler(c *chi.Mux, as domain.AlbumUseCase) {
 	handler := AlbumHandler{albumUseCase: as}
 
+	rest.RegisterProblemType(domain.ErrResourceNotFound, "not-found")
+	rest.RegisterProblemType(domain.ErrUUIDParse, "invalid-uuid")
+
 	c.Route("/album", func(r chi.Router) {
 		r.Use(cmiddleware.AuthMiddleware)
 
 		r.Get("/", handler.FindAll)
 		r.Get("/{uuid}", handler.FindByID)
-		r.Post("/", handler.Add)
+		r.With(cmiddleware.IdempotencyMiddleware).Post("/", handler.Add)
 		r.Put("/{uuid}", handler.Update)
 		r.Delete("/{uuid}", handler.Delete)
 	})
@@ -55,7 +58,7 @@ func (a *AlbumHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rest.JSON(w, http.StatusOK, &albums)
+	rest.JSONList(w, r, http.StatusOK, &albums, len(albums))
 }
 
 // FindByID godoc
@@ -85,7 +88,7 @@ func (a *AlbumHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rest.JSON(w, http.StatusOK, album)
+	rest.JSONResource(w, r, http.StatusOK, album)
 }
 
 // Add godoc
@@ -94,39 +97,37 @@ func (a *AlbumHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 // @Tags         album
 // @Accept       json
 // @Produce      json
-// @Param        Authorization  header    string        true  "Insert your access token"  default(Bearer <Add access token here>)
-// @Param        payload        body      albumRequest  true  "add a new album"
-// @Success      201            {object}  rest.Message
-// @Failure      400            {object}  rest.Message
-// @Failure      422            {object}  rest.Message
-// @Failure      500            {object}  rest.Message
+// @Param        Authorization    header    string        true   "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        payload          body      albumRequest  true   "add a new album"
+// @Param        Idempotency-Key  header    string        false  "replays the response of the first request with the same key"
+// @Success      201              {object}  rest.Message
+// @Failure      400              {object}  rest.Message
+// @Failure      422              {object}  rest.Message
+// @Failure      500              {object}  rest.Message
 // @Router       /album [post]
 func (a *AlbumHandler) Add(w http.ResponseWriter, r *http.Request) {
 	var payload albumRequest
 
-	err := json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
-		clog.Error(err, domain.ErrAdd.Error())
-		rest.DecodeError(w, r, domain.ErrAdd, http.StatusInternalServerError)
+	if !rest.DecodeJSON(w, r, &payload) {
 		return
 	}
 
 	validation := validation.New()
 
 	if err := validation.BindStruct(r.Context(), payload); err != nil {
-		validation.DecodeError(w, err)
+		validation.DecodeError(w, r, err)
 		return
 	}
 
 	album := domain.Album{
-		UUID:      uuid.New(),
+		UUID:      idgen.New(),
 		Name:      payload.Name,
 		Length:    payload.Length,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	err = a.albumUseCase.Add(r.Context(), &album)
+	err := a.albumUseCase.Add(r.Context(), &album)
 	if err != nil {
 		clog.Error(err, domain.ErrAdd.Error())
 		rest.DecodeError(w, r, domain.ErrAdd, http.StatusUnprocessableEntity)
@@ -146,6 +147,7 @@ func (a *AlbumHandler) Add(w http.ResponseWriter, r *http.Request) {
 // @Param        uuid           path      string        true  "album uuid"
 // @Param        payload        body      albumRequest  true  "update an album by uuid"
 // @Success      200            {object}  rest.Message
+// @Failure      400            {object}  rest.Message
 // @Failure      422            {object}  rest.Message
 // @Failure      500            {object}  rest.Message
 // @Router       /album/{uuid} [put]
@@ -159,10 +161,7 @@ func (a *AlbumHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	var payload albumRequest
 
-	err = json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
-		clog.Error(err, domain.ErrUpdate.Error())
-		rest.DecodeError(w, r, domain.ErrUpdate, http.StatusInternalServerError)
+	if !rest.DecodeJSON(w, r, &payload) {
 		return
 	}
 
@@ -170,7 +169,7 @@ func (a *AlbumHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	if err := validation.BindStruct(r.Context(), payload); err != nil {
 		clog.Error(err, domain.ErrUpdate.Error())
-		validation.DecodeError(w, err)
+		validation.DecodeError(w, r, err)
 		return
 	}
 
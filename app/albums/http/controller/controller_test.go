@@ -6,6 +6,7 @@ import (
 	"errors"
 	"hexagony/app/albums/domain"
 	"hexagony/app/albums/domain/mocks"
+	"hexagony/lib/rest"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -25,6 +26,14 @@ func TestNewAlbumHandler(t *testing.T) {
 	NewAlbumHandler(router, mockAlbumUseCase)
 }
 
+// TestJSONTags checks every field of the bodies has a snake_case json
+// tag, see rest.UntaggedFields.
+func TestJSONTags(t *testing.T) {
+	for _, v := range []interface{}{&domain.Album{}, &albumRequest{}} {
+		assert.Empty(t, rest.UntaggedFields(v))
+	}
+}
+
 func TestFindAll(t *testing.T) {
 	now := time.Now()
 	mockAlbumUseCase := new(mocks.AlbumUseCase)
@@ -238,7 +247,7 @@ func TestAddFail(t *testing.T) {
 	router.HandleFunc("/album", handler.Add)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
 	mockAlbumUseCase.AssertExpectations(t)
 
@@ -362,7 +371,7 @@ func TestUpdateFail(t *testing.T) {
 	router.HandleFunc("/album/{uuid}", handler.Update)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
 	mockAlbumUseCase.AssertExpectations(t)
 
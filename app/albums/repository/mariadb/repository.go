@@ -20,7 +20,7 @@ func NewMariaDBRepository(conn *sqlx.DB) domain.AlbumRepository {
 func (r *mariadbRepository) FindAll(
 	ctx context.Context,
 ) ([]*domain.Album, error) {
-	var albums []*domain.Album
+	albums := make([]*domain.Album, 0)
 
 	err := r.conn.SelectContext(
 		ctx,
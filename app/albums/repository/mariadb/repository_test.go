@@ -73,6 +73,36 @@ func TestFindAll(t *testing.T) {
 	assert.Equal(t, albumList[0].Name, "St. Anger")
 }
 
+func TestFindAllEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	rows := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"length",
+		"created_at",
+		"updated_at",
+	})
+
+	query := "SELECT \\* FROM albums"
+
+	mock.ExpectQuery(query).WillReturnRows(rows)
+
+	albumRepo := NewMariaDBRepository(dbx)
+	albumList, err := albumRepo.FindAll(context.TODO())
+
+	assert.NoError(t, err)
+	assert.NotNil(t, albumList)
+	assert.Len(t, albumList, 0)
+}
+
 func TestFindAllFail(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
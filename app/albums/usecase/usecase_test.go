@@ -28,7 +28,7 @@ func TestFindAll(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockAlbumRepo.On("FindAll",
-			mock.AnythingOfType("*context.emptyCtx")).
+			mock.Anything).
 			Return(mockListAlbum, nil).Once()
 
 		a := NewAlbumUseCase(mockAlbumRepo)
@@ -43,7 +43,7 @@ func TestFindAll(t *testing.T) {
 
 	t.Run("error-failed", func(t *testing.T) {
 		mockAlbumRepo.On("FindAll",
-			mock.AnythingOfType("*context.emptyCtx")).
+			mock.Anything).
 			Return(nil, errors.New("Unexpected error")).Once()
 
 		a := NewAlbumUseCase(mockAlbumRepo)
@@ -68,7 +68,7 @@ func TestFindByID(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockAlbumRepo.On("FindByID",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(mockAlbum, nil).Once()
 
@@ -82,7 +82,7 @@ func TestFindByID(t *testing.T) {
 
 	t.Run("failure", func(t *testing.T) {
 		mockAlbumRepo.On("FindByID",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(nil, errors.New("Unexpected error")).Once()
 
@@ -107,7 +107,7 @@ func TestAdd(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockAlbumRepo.On("Add",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("*domain.Album")).
 			Return(nil).Once()
 
@@ -120,7 +120,7 @@ func TestAdd(t *testing.T) {
 
 	t.Run("failure", func(t *testing.T) {
 		mockAlbumRepo.On("Add",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("*domain.Album")).
 			Return(errors.New("Unexpected error")).Once()
 
@@ -146,7 +146,7 @@ func TestUpdate(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockAlbumRepo.On("Update",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID"),
 			mock.AnythingOfType("*domain.Album")).
 			Return(nil).Once()
@@ -160,7 +160,7 @@ func TestUpdate(t *testing.T) {
 
 	t.Run("failure", func(t *testing.T) {
 		mockAlbumRepo.On("Update",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID"),
 			mock.AnythingOfType("*domain.Album")).
 			Return(errors.New("Unexpected error")).Once()
@@ -180,7 +180,7 @@ func TestDelete(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockAlbumRepo.On("Delete",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(nil).Once()
 
@@ -193,7 +193,7 @@ func TestDelete(t *testing.T) {
 
 	t.Run("failure", func(t *testing.T) {
 		mockAlbumRepo.On("Delete",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(errors.New("Unexpected error")).Once()
 
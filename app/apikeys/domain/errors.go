@@ -0,0 +1,15 @@
+package domain
+
+import "errors"
+
+var (
+	ErrMint      = errors.New("failed to mint the api key")
+	ErrRevoke    = errors.New("failed to revoke the api key")
+	ErrPurge     = errors.New("failed to purge the revoked api keys")
+	ErrUUIDParse = errors.New("failed to parse the UUID")
+
+	ErrInvalidKey  = errors.New("invalid api key")
+	ErrRevokedKey  = errors.New("the api key was revoked")
+	ErrKeyNotFound = errors.New("the api key could not be found")
+	ErrPurgeBefore = errors.New("revoked_before is required")
+)
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"errors"
+	"hexagony/app/apikeys/domain"
+	cmiddleware "hexagony/app/shared/http/middleware"
+	"hexagony/lib/clog"
+	"hexagony/lib/rest"
+	"hexagony/lib/validation"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type APIKeyHandler struct {
+	apiKeyUseCase domain.APIKeyUseCase
+}
+
+func NewAPIKeyHandler(c *chi.Mux, auc domain.APIKeyUseCase) {
+	handler := APIKeyHandler{apiKeyUseCase: auc}
+
+	rest.RegisterProblemType(domain.ErrKeyNotFound, "not-found")
+	rest.RegisterProblemType(domain.ErrUUIDParse, "invalid-uuid")
+
+	c.Group(func(r chi.Router) {
+		r.Use(cmiddleware.AuthMiddleware, cmiddleware.AdminMiddleware)
+
+		r.Post("/admin/api-keys", handler.Mint)
+		r.Delete("/admin/api-keys/{uuid}", handler.Revoke)
+		r.Delete("/admin/api-keys", handler.Purge)
+	})
+}
+
+type mintRequest struct {
+	Service string   `json:"service" validate:"required,max=100"`
+	Scopes  []string `json:"scopes" validate:"dive,required,excludesall=0x2C"`
+}
+
+// mintResponse carries the key itself, which can't be read again.
+// CreatedAt shadows the one of the embedded key, see rest.Time.
+type mintResponse struct {
+	*domain.APIKey
+	CreatedAt rest.Time `json:"created_at"`
+	Key       string    `json:"key"`
+}
+
+// Mint godoc
+// @Summary      Mint an api key
+// @Description  creates an api key for a service; the key is only shown in this response (admin only)
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        Authorization  header    string       true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        payload        body      mintRequest  true  "the service and its scopes"
+// @Success      201            {object}  mintResponse
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      422            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /admin/api-keys [post]
+func (a *APIKeyHandler) Mint(w http.ResponseWriter, r *http.Request) {
+	var payload mintRequest
+
+	if !rest.DecodeJSON(w, r, &payload) {
+		return
+	}
+
+	validation := validation.New()
+
+	if err := validation.BindStruct(r.Context(), payload); err != nil {
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	apiKey, key, err := a.apiKeyUseCase.Mint(r.Context(), payload.Service, payload.Scopes)
+	if err != nil {
+		clog.Error(err, domain.ErrMint.Error())
+		rest.DecodeError(w, r, domain.ErrMint, http.StatusInternalServerError)
+		return
+	}
+
+	rest.JSON(w, http.StatusCreated, &mintResponse{APIKey: apiKey, CreatedAt: rest.Time(apiKey.CreatedAt), Key: key})
+}
+
+// Revoke godoc
+// @Summary      Revoke an api key
+// @Description  revokes an api key by uuid (admin only)
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        uuid           path      string  true  "api key uuid"
+// @Success      200            {object}  rest.Message
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /admin/api-keys/{uuid} [delete]
+func (a *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	uuid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
+		return
+	}
+
+	err = a.apiKeyUseCase.Revoke(r.Context(), uuid)
+	if errors.Is(err, domain.ErrKeyNotFound) {
+		rest.DecodeError(w, r, domain.ErrKeyNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrRevoke.Error())
+		rest.DecodeError(w, r, domain.ErrRevoke, http.StatusInternalServerError)
+		return
+	}
+
+	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Revoked"})
+}
+
+// purgeResponse counts the deleted keys, see rest.Int64.
+type purgeResponse struct {
+	Deleted rest.Int64 `json:"deleted"`
+}
+
+// Purge godoc
+// @Summary      Purge the revoked api keys
+// @Description  deletes, in batches, the api keys revoked before the given time (admin only)
+// @Tags         admin
+// @Produce      json
+// @Param        Authorization   header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        revoked_before  query     string  true  "YYYY-MM-DD, YYYY-MM-DDTHH:MM:SS in UTC or RFC 3339"
+// @Success      200             {object}  purgeResponse
+// @Failure      400             {object}  rest.Message
+// @Failure      403             {object}  rest.Message
+// @Failure      500             {object}  rest.Message
+// @Router       /admin/api-keys [delete]
+func (a *APIKeyHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	before, err := rest.ParseTime(r.URL.Query().Get("revoked_before"))
+	if err != nil {
+		rest.DecodeError(w, r, domain.ErrPurgeBefore, http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := a.apiKeyUseCase.PurgeRevoked(r.Context(), before)
+	if err != nil {
+		clog.Error(err, domain.ErrPurge.Error())
+		rest.DecodeError(w, r, domain.ErrPurge, http.StatusInternalServerError)
+		return
+	}
+
+	rest.JSON(w, http.StatusOK, &purgeResponse{Deleted: rest.Int64(deleted)})
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"hexagony/app/apikeys/domain"
+	"hexagony/app/apikeys/domain/mocks"
+	"hexagony/lib/rest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewAPIKeyHandler(t *testing.T) {
+	router := chi.NewRouter()
+
+	mockAPIKeyUseCase := new(mocks.APIKeyUseCase)
+
+	NewAPIKeyHandler(router, mockAPIKeyUseCase)
+}
+
+// TestJSONTags checks every field of the bodies has a snake_case json
+// tag, see rest.UntaggedFields.
+func TestJSONTags(t *testing.T) {
+	for _, v := range []interface{}{&domain.APIKey{}, &mintRequest{}, &mintResponse{}, &purgeResponse{}} {
+		assert.Empty(t, rest.UntaggedFields(v))
+	}
+}
+
+func TestMint(t *testing.T) {
+	apiKey := &domain.APIKey{UUID: uuid.New(), Service: "billing", Scopes: domain.Scopes{"users:read"}}
+
+	mockAPIKeyUseCase := new(mocks.APIKeyUseCase)
+
+	mockAPIKeyUseCase.
+		On("Mint", mock.Anything, "billing", []string{"users:read"}).
+		Return(apiKey, "hxk_secret", nil)
+
+	handler := APIKeyHandler{apiKeyUseCase: mockAPIKeyUseCase}
+
+	router := chi.NewRouter()
+	router.Post("/admin/api-keys", handler.Mint)
+
+	payload := `{"service":"billing","scopes":["users:read"]}`
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/api-keys", bytes.NewBufferString(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "hxk_secret", body["key"])
+	assert.Equal(t, apiKey.UUID.String(), body["id"])
+	assert.NotContains(t, body, "key_hash")
+
+	mockAPIKeyUseCase.AssertExpectations(t)
+}
+
+func TestMintFailValidation(t *testing.T) {
+	mockAPIKeyUseCase := new(mocks.APIKeyUseCase)
+
+	handler := APIKeyHandler{apiKeyUseCase: mockAPIKeyUseCase}
+
+	router := chi.NewRouter()
+	router.Post("/admin/api-keys", handler.Mint)
+
+	for _, payload := range []string{
+		`{"scopes":["users:read"]}`,
+		`{"service":"billing","scopes":["users:read,users:write"]}`,
+		`{"service":`,
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/admin/api-keys", bytes.NewBufferString(payload))
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code, payload)
+	}
+
+	mockAPIKeyUseCase.AssertNotCalled(t, "Mint", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRevoke(t *testing.T) {
+	revoked := uuid.New()
+	missing := uuid.New()
+
+	mockAPIKeyUseCase := new(mocks.APIKeyUseCase)
+
+	mockAPIKeyUseCase.On("Revoke", mock.Anything, revoked).Return(nil)
+	mockAPIKeyUseCase.On("Revoke", mock.Anything, missing).Return(domain.ErrKeyNotFound)
+
+	handler := APIKeyHandler{apiKeyUseCase: mockAPIKeyUseCase}
+
+	router := chi.NewRouter()
+	router.Delete("/admin/api-keys/{uuid}", handler.Revoke)
+
+	cases := []struct {
+		name     string
+		uuid     string
+		expected int
+	}{
+		{"success", revoked.String(), http.StatusOK},
+		{"not-found", missing.String(), http.StatusNotFound},
+		{"invalid-uuid", "invalid", http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodDelete, "/admin/api-keys/"+c.uuid, nil)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+		})
+	}
+
+	mockAPIKeyUseCase.AssertExpectations(t)
+}
+
+func TestPurge(t *testing.T) {
+	before := time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)
+
+	mockAPIKeyUseCase := new(mocks.APIKeyUseCase)
+
+	mockAPIKeyUseCase.On("PurgeRevoked", mock.Anything, before).Return(int64(1200), nil).Once()
+
+	handler := APIKeyHandler{apiKeyUseCase: mockAPIKeyUseCase}
+
+	router := chi.NewRouter()
+	router.Delete("/admin/api-keys", handler.Purge)
+
+	cases := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"success", "?revoked_before=2022-06-06", http.StatusOK},
+		{"missing", "", http.StatusBadRequest},
+		{"invalid", "?revoked_before=yesterday", http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodDelete, "/admin/api-keys"+c.query, nil)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+			if c.expected == http.StatusOK {
+				assert.JSONEq(t, `{"deleted":1200}`, rec.Body.String())
+			}
+		})
+	}
+
+	mockAPIKeyUseCase.AssertExpectations(t)
+}
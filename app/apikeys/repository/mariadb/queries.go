@@ -0,0 +1,21 @@
+package mariadb
+
+const (
+	sqlAdd = `
+	INSERT INTO 
+	api_keys (uuid, tenant_id, service, scopes, key_hash) 
+	VALUES (?, ?, ?, ?, ?)
+	`
+
+	sqlCreatedAt = "SELECT created_at FROM api_keys WHERE uuid=?"
+
+	// Keys are looked up by hash across the tenants, the key itself
+	// tells its tenant.
+	sqlFindByHash = "SELECT * FROM api_keys WHERE key_hash=?"
+
+	// Revoking twice doesn't move revoked_at.
+	sqlRevoke = "UPDATE api_keys SET revoked_at=CURRENT_TIMESTAMP WHERE tenant_id=? AND uuid=? AND revoked_at IS NULL"
+
+	// The limit is the batch size, see database.DeleteInBatches.
+	sqlPurgeRevoked = "DELETE FROM api_keys WHERE revoked_at < ? LIMIT ?"
+)
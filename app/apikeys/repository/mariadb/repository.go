@@ -0,0 +1,81 @@
+package mariadb
+
+import (
+	"context"
+	"database/sql"
+	"hexagony/app/apikeys/domain"
+	"hexagony/lib/database"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type mariadbRepository struct {
+	conn *sqlx.DB
+}
+
+func NewMariaDBRepository(conn *sqlx.DB) domain.APIKeyRepository {
+	return &mariadbRepository{conn}
+}
+
+// Add inserts the key in the tenant of the context and reads back the
+// created_at set by the database.
+func (r *mariadbRepository) Add(ctx context.Context, key *domain.APIKey) error {
+	key.TenantID = database.Tenant(ctx)
+
+	if _, err := r.conn.ExecContext(
+		ctx,
+		sqlAdd,
+		key.UUID,
+		key.TenantID,
+		key.Service,
+		key.Scopes,
+		key.KeyHash,
+	); err != nil {
+		return err
+	}
+
+	return r.conn.GetContext(ctx, &key.CreatedAt, sqlCreatedAt, key.UUID)
+}
+
+// FindByHash returns ErrKeyNotFound when no key has the hash.
+func (r *mariadbRepository) FindByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	var key domain.APIKey
+
+	err := r.conn.GetContext(ctx, &key, sqlFindByHash, hash)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// Revoke returns ErrKeyNotFound when there's no active key with the
+// uuid in the tenant of the context.
+func (r *mariadbRepository) Revoke(ctx context.Context, uuid uuid.UUID) error {
+	result, err := r.conn.ExecContext(ctx, sqlRevoke, database.Tenant(ctx), uuid)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return domain.ErrKeyNotFound
+	}
+
+	return nil
+}
+
+// PurgeRevoked deletes the keys revoked before the given time, in
+// batches of PURGE_BATCH_SIZE rows, returning how many were deleted.
+func (r *mariadbRepository) PurgeRevoked(ctx context.Context, before time.Time) (int64, error) {
+	return database.DeleteInBatches(ctx, r.conn, sqlPurgeRevoked, database.PurgeBatchSize(), before)
+}
@@ -0,0 +1,139 @@
+package mariadb
+
+import (
+	"context"
+	"errors"
+	"hexagony/app/apikeys/domain"
+	"hexagony/lib/database"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMock(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
+func TestAdd(t *testing.T) {
+	dbx, mock := newMock(t)
+
+	now := time.Now()
+	key := &domain.APIKey{
+		UUID:    uuid.New(),
+		Service: "billing",
+		Scopes:  domain.Scopes{"users:read", "users:write"},
+		KeyHash: domain.HashKey("hxk_secret"),
+	}
+
+	mock.ExpectExec("INSERT INTO api_keys").
+		WithArgs(key.UUID, "acme", "billing", "users:read,users:write", key.KeyHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM api_keys WHERE uuid=\\?").
+		WithArgs(key.UUID).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now))
+
+	err := NewMariaDBRepository(dbx).Add(database.WithTenant(context.TODO(), "acme"), key)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", key.TenantID)
+	assert.Equal(t, now, key.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddFail(t *testing.T) {
+	dbx, mock := newMock(t)
+
+	mock.ExpectExec("INSERT INTO api_keys").
+		WillReturnError(errors.New("Unexpected error"))
+
+	err := NewMariaDBRepository(dbx).Add(context.TODO(), &domain.APIKey{UUID: uuid.New()})
+
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindByHash(t *testing.T) {
+	dbx, mock := newMock(t)
+
+	id := uuid.New()
+	now := time.Now()
+	hash := domain.HashKey("hxk_secret")
+	columns := []string{"uuid", "tenant_id", "service", "scopes", "key_hash", "created_at", "revoked_at"}
+	query := "SELECT \\* FROM api_keys WHERE key_hash=\\?"
+
+	mock.ExpectQuery(query).
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(id.String(), "acme", "billing", "users:read", hash, now, nil))
+	mock.ExpectQuery(query).
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	repo := NewMariaDBRepository(dbx)
+
+	key, err := repo.FindByHash(context.TODO(), hash)
+	assert.NoError(t, err)
+	assert.Equal(t, id, key.UUID)
+	assert.Equal(t, "acme", key.TenantID)
+	assert.Equal(t, domain.Scopes{"users:read"}, key.Scopes)
+	assert.Nil(t, key.RevokedAt)
+
+	_, err = repo.FindByHash(context.TODO(), hash)
+	assert.ErrorIs(t, err, domain.ErrKeyNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevoke(t *testing.T) {
+	dbx, mock := newMock(t)
+
+	id := uuid.New()
+	query := "UPDATE api_keys SET revoked_at=CURRENT_TIMESTAMP WHERE tenant_id=\\? AND uuid=\\? AND revoked_at IS NULL"
+
+	mock.ExpectExec(query).WithArgs("acme", id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs("acme", id).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(query).WithArgs("globex", id).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := NewMariaDBRepository(dbx)
+	ctx := database.WithTenant(context.TODO(), "acme")
+
+	assert.NoError(t, repo.Revoke(ctx, id))
+	assert.ErrorIs(t, repo.Revoke(ctx, id), domain.ErrKeyNotFound)
+
+	// The keys of another tenant can't be revoked.
+	assert.ErrorIs(t, repo.Revoke(database.WithTenant(context.TODO(), "globex"), id), domain.ErrKeyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeRevoked(t *testing.T) {
+	os.Setenv("PURGE_BATCH_SIZE", "2")
+	defer os.Unsetenv("PURGE_BATCH_SIZE")
+
+	dbx, mock := newMock(t)
+
+	before := time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)
+	query := "DELETE FROM api_keys WHERE revoked_at < \\? LIMIT \\?"
+
+	// 5 revoked keys, in batches of 2.
+	mock.ExpectExec(query).WithArgs(before, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(query).WithArgs(before, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(query).WithArgs(before, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo := NewMariaDBRepository(dbx)
+
+	deleted, err := repo.PurgeRevoked(context.TODO(), before)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,81 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hexagony/app/apikeys/domain"
+	"hexagony/lib/idgen"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// keyPrefix makes the keys easy to recognize, e.g. by secret scanners.
+const keyPrefix = "hxk_"
+
+type apiKeyUseCase struct {
+	apiKeyRepository domain.APIKeyRepository
+}
+
+func NewAPIKeyUseCase(ar domain.APIKeyRepository) domain.APIKeyUseCase {
+	return &apiKeyUseCase{apiKeyRepository: ar}
+}
+
+// Mint creates a key for the service. The key is returned only
+// here: just its hash is stored.
+func (a *apiKeyUseCase) Mint(ctx context.Context, service string, scopes []string) (*domain.APIKey, string, error) {
+	key, err := generateKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKey := &domain.APIKey{
+		UUID:    idgen.New(),
+		Service: service,
+		Scopes:  domain.Scopes(scopes),
+		KeyHash: domain.HashKey(key),
+	}
+
+	if err := a.apiKeyRepository.Add(ctx, apiKey); err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+func (a *apiKeyUseCase) Revoke(ctx context.Context, uuid uuid.UUID) error {
+	return a.apiKeyRepository.Revoke(ctx, uuid)
+}
+
+func (a *apiKeyUseCase) PurgeRevoked(ctx context.Context, before time.Time) (int64, error) {
+	return a.apiKeyRepository.PurgeRevoked(ctx, before)
+}
+
+// Verify returns the active key matching the given one. Unknown keys
+// are reported as ErrInvalidKey and revoked ones as ErrRevokedKey.
+func (a *apiKeyUseCase) Verify(ctx context.Context, key string) (*domain.APIKey, error) {
+	apiKey, err := a.apiKeyRepository.FindByHash(ctx, domain.HashKey(key))
+	if errors.Is(err, domain.ErrKeyNotFound) {
+		return nil, domain.ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey.RevokedAt != nil {
+		return nil, domain.ErrRevokedKey
+	}
+
+	return apiKey, nil
+}
+
+// generateKey returns a prefixed key with 256 random bits.
+func generateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"hexagony/app/apikeys/domain"
+	"hexagony/app/apikeys/domain/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMint(t *testing.T) {
+	mockAPIKeyRepo := new(mocks.APIKeyRepository)
+
+	var stored *domain.APIKey
+
+	mockAPIKeyRepo.
+		On("Add", mock.Anything, mock.AnythingOfType("*domain.APIKey")).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(*domain.APIKey) }).
+		Return(nil).Once()
+
+	a := NewAPIKeyUseCase(mockAPIKeyRepo)
+
+	apiKey, key, err := a.Mint(context.TODO(), "billing", []string{"users:read"})
+
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, keyPrefix))
+	assert.Equal(t, "billing", apiKey.Service)
+	assert.Equal(t, domain.Scopes{"users:read"}, apiKey.Scopes)
+	assert.Equal(t, domain.HashKey(key), stored.KeyHash)
+
+	mockAPIKeyRepo.AssertExpectations(t)
+}
+
+func TestMintFail(t *testing.T) {
+	mockAPIKeyRepo := new(mocks.APIKeyRepository)
+
+	mockAPIKeyRepo.
+		On("Add", mock.Anything, mock.Anything).
+		Return(errors.New("Unexpected error")).Once()
+
+	a := NewAPIKeyUseCase(mockAPIKeyRepo)
+
+	_, key, err := a.Mint(context.TODO(), "billing", nil)
+
+	assert.Error(t, err)
+	assert.Empty(t, key)
+
+	mockAPIKeyRepo.AssertExpectations(t)
+}
+
+func TestVerify(t *testing.T) {
+	revokedAt := time.Now()
+	active := &domain.APIKey{UUID: uuid.New(), Service: "billing"}
+	revoked := &domain.APIKey{UUID: uuid.New(), Service: "legacy", RevokedAt: &revokedAt}
+
+	mockAPIKeyRepo := new(mocks.APIKeyRepository)
+
+	mockAPIKeyRepo.
+		On("FindByHash", mock.Anything, domain.HashKey("hxk_active")).
+		Return(active, nil)
+	mockAPIKeyRepo.
+		On("FindByHash", mock.Anything, domain.HashKey("hxk_revoked")).
+		Return(revoked, nil)
+	mockAPIKeyRepo.
+		On("FindByHash", mock.Anything, domain.HashKey("hxk_unknown")).
+		Return(nil, domain.ErrKeyNotFound)
+
+	a := NewAPIKeyUseCase(mockAPIKeyRepo)
+
+	apiKey, err := a.Verify(context.TODO(), "hxk_active")
+	assert.NoError(t, err)
+	assert.Equal(t, active, apiKey)
+
+	_, err = a.Verify(context.TODO(), "hxk_revoked")
+	assert.ErrorIs(t, err, domain.ErrRevokedKey)
+
+	_, err = a.Verify(context.TODO(), "hxk_unknown")
+	assert.ErrorIs(t, err, domain.ErrInvalidKey)
+
+	mockAPIKeyRepo.AssertExpectations(t)
+}
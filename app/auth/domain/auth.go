@@ -3,25 +3,34 @@ package domain
 import (
 	"context"
 	"hexagony/app/users/domain"
+
+	"github.com/google/uuid"
 )
 
 // Auth represent the auth's model.
 type Auth struct {
-	Email    string `json:"email"`
-	Password string `json:"password,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Password   string `json:"password,omitempty"`
 }
 
-// AuthToken represent the token payload.
+// AuthToken represent the token payload. MustChangePassword tells
+// the client the password is older than PASSWORD_MAX_AGE, the token
+// being valid anyway.
 type AuthToken struct {
-	Token string `json:"token,omitempty"`
+	Token              string `json:"token,omitempty"`
+	MustChangePassword bool   `json:"must_change_password,omitempty"`
 }
 
 // AuthRepository represent the auth's repository contract.
 type AuthRepository interface {
-	Authenticate(ctx context.Context, email string) (*domain.User, error)
+	Authenticate(ctx context.Context, identifier string) (*domain.User, error)
+	FindByID(ctx context.Context, uuid uuid.UUID) (*domain.User, error)
 }
 
 // AuthUsecase represent the auth's usecases.
 type AuthUseCase interface {
-	Authenticate(ctx context.Context, email, password string) (*AuthToken, error)
+	Authenticate(ctx context.Context, identifier, password string) (*AuthToken, error)
+	Impersonate(ctx context.Context, admin, target uuid.UUID) (*AuthToken, error)
+	VerifyCredentials(ctx context.Context, email, password string) (bool, error)
 }
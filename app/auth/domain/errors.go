@@ -6,4 +6,8 @@ var (
 	ErrAuth       = errors.New("authentication failed")
 	ErrEmptyClaim = errors.New("claim is empty")
 	ErrSign       = errors.New("failed to sign the key")
+
+	ErrImpersonate         = errors.New("failed to impersonate the user")
+	ErrNestedImpersonation = errors.New("cannot impersonate while impersonating")
+	ErrUserNotFound        = errors.New("the user could not be found")
 )
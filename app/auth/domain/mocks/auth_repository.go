@@ -7,6 +7,8 @@ import (
 	domain "hexagony/app/users/domain"
 
 	mock "github.com/stretchr/testify/mock"
+
+	uuid "github.com/google/uuid"
 )
 
 // AuthRepository is an autogenerated mock type for the AuthRepository type
@@ -14,13 +16,13 @@ type AuthRepository struct {
 	mock.Mock
 }
 
-// Authenticate provides a mock function with given fields: ctx, email
-func (_m *AuthRepository) Authenticate(ctx context.Context, email string) (*domain.User, error) {
-	ret := _m.Called(ctx, email)
+// Authenticate provides a mock function with given fields: ctx, identifier
+func (_m *AuthRepository) Authenticate(ctx context.Context, identifier string) (*domain.User, error) {
+	ret := _m.Called(ctx, identifier)
 
 	var r0 *domain.User
 	if rf, ok := ret.Get(0).(func(context.Context, string) *domain.User); ok {
-		r0 = rf(ctx, email)
+		r0 = rf(ctx, identifier)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*domain.User)
@@ -29,7 +31,30 @@ func (_m *AuthRepository) Authenticate(ctx context.Context, email string) (*doma
 
 	var r1 error
 	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, email)
+		r1 = rf(ctx, identifier)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindByID provides a mock function with given fields: ctx, _a1
+func (_m *AuthRepository) FindByID(ctx context.Context, _a1 uuid.UUID) (*domain.User, error) {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 *domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) *domain.User); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*domain.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -7,6 +7,8 @@ import (
 	domain "hexagony/app/auth/domain"
 
 	mock "github.com/stretchr/testify/mock"
+
+	uuid "github.com/google/uuid"
 )
 
 // AuthUseCase is an autogenerated mock type for the AuthUseCase type
@@ -14,19 +16,63 @@ type AuthUseCase struct {
 	mock.Mock
 }
 
-// Authenticate provides a mock function with given fields: ctx, email, password
-func (_m *AuthUseCase) Authenticate(ctx context.Context, email string, password string) (*domain.AuthToken, error) {
-	ret := _m.Called(ctx, email, password)
+// Authenticate provides a mock function with given fields: ctx, identifier, password
+func (_m *AuthUseCase) Authenticate(ctx context.Context, identifier string, password string) (*domain.AuthToken, error) {
+	ret := _m.Called(ctx, identifier, password)
 
 	var r0 *domain.AuthToken
 	if rf, ok := ret.Get(0).(func(context.Context, string, string) *domain.AuthToken); ok {
-		r0 = rf(ctx, email, password)
+		r0 = rf(ctx, identifier, password)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*domain.AuthToken)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, identifier, password)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Impersonate provides a mock function with given fields: ctx, admin, target
+func (_m *AuthUseCase) Impersonate(ctx context.Context, admin uuid.UUID, target uuid.UUID) (*domain.AuthToken, error) {
+	ret := _m.Called(ctx, admin, target)
+
+	var r0 *domain.AuthToken
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, uuid.UUID) *domain.AuthToken); ok {
+		r0 = rf(ctx, admin, target)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*domain.AuthToken)
 		}
 	}
 
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, uuid.UUID) error); ok {
+		r1 = rf(ctx, admin, target)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// VerifyCredentials provides a mock function with given fields: ctx, email, password
+func (_m *AuthUseCase) VerifyCredentials(ctx context.Context, email string, password string) (bool, error) {
+	ret := _m.Called(ctx, email, password)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) bool); ok {
+		r0 = rf(ctx, email, password)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
 	var r1 error
 	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
 		r1 = rf(ctx, email, password)
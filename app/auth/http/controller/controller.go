@@ -1,29 +1,53 @@
 package controller
 
 import (
-	"encoding/json"
+	"errors"
 	"hexagony/app/auth/domain"
+	cmiddleware "hexagony/app/shared/http/middleware"
+	usersDomain "hexagony/app/users/domain"
 	"hexagony/lib/clog"
+	"hexagony/lib/crypto"
+	"hexagony/lib/flags"
+	"hexagony/lib/idgen"
 	"hexagony/lib/rest"
 	"hexagony/lib/validation"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+// defaultRegisterRateLimit is the number of sign-ups an IP can make per
+// hour when REGISTER_RATE_LIMIT is unset.
+const defaultRegisterRateLimit = 10
+
 type AuthHandler struct {
 	authUseCase domain.AuthUseCase
+	userUseCase usersDomain.UserUseCase
 }
 
-func NewAuthHandler(c *chi.Mux, auc domain.AuthUseCase) {
-	handler := AuthHandler{authUseCase: auc}
+// NewAuthHandler registers the login, the sign-up, which creates the
+// users with the user use case, and the impersonation.
+func NewAuthHandler(c *chi.Mux, auc domain.AuthUseCase, uuc usersDomain.UserUseCase) {
+	handler := AuthHandler{authUseCase: auc, userUseCase: uuc}
 
-	c.Post("/auth", handler.Authenticate)
+	c.With(cmiddleware.DrainMiddleware).Post("/auth", handler.Authenticate)
+	c.With(cmiddleware.DrainMiddleware, cmiddleware.SignupTenantMiddleware, cmiddleware.RateLimitMiddleware(registerRateLimit(), time.Hour), cmiddleware.IdempotencyMiddleware).
+		Post("/auth/register", handler.Register)
+	flags.Define(flags.Impersonation, true)
+	c.With(cmiddleware.FeatureMiddleware(flags.Impersonation), cmiddleware.DrainMiddleware, cmiddleware.AuthMiddleware, cmiddleware.AdminMiddleware).
+		Post("/user/{uuid}/impersonate", handler.Impersonate)
 }
 
-type authRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password,omitempty" validate:"required,gte=8"`
+// loginRequest accepts either an email or a username as identifier.
+// The email field is kept for clients that only log in by email.
+type loginRequest struct {
+	Identifier string `json:"identifier" validate:"required_without=Email"`
+	Email      string `json:"email" validate:"required_without=Identifier,omitempty,email"`
+	Password   string `json:"password" validate:"required"`
 }
 
 // Auth godoc
@@ -32,40 +56,253 @@ type authRequest struct {
 // @Tags         auth
 // @Accept       json
 // @Produce      json
-// @Param        payload  body      authRequest  true  "authenticates the user"
+// @Param        payload  body      loginRequest  true   "authenticates the user"
+// @Param        cookie   query     bool          false  "also set the token as an HttpOnly cookie"
 // @Success      200      {object}  domain.AuthToken
-// @Failure      422      {object}  rest.Message
 // @Failure      400      {object}  rest.Message
+// @Failure      422      {object}  rest.Message
 // @Failure      500      {object}  rest.Message
+// @Failure      503      {object}  rest.Message
 // @Router       /auth [post]
 func (a *AuthHandler) Authenticate(w http.ResponseWriter, r *http.Request) {
-	var payload authRequest
+	var payload loginRequest
 
-	err := json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
-		clog.Error(err, domain.ErrAuth.Error())
-		rest.DecodeError(w, r, domain.ErrAuth, http.StatusInternalServerError)
+	if !rest.DecodeJSON(w, r, &payload) {
 		return
 	}
 
 	validation := validation.New()
 
 	if err := validation.BindStruct(r.Context(), payload); err != nil {
-		validation.DecodeError(w, err)
+		validation.DecodeErrorStatus(w, r, err, http.StatusUnprocessableEntity)
 		return
 	}
 
 	user := domain.Auth{
-		Email:    payload.Email,
-		Password: payload.Password,
+		Identifier: payload.Identifier,
+		Email:      payload.Email,
+		Password:   payload.Password,
 	}
 
-	res, err := a.authUseCase.Authenticate(r.Context(), user.Email, user.Password)
+	if user.Identifier == "" {
+		user.Identifier = user.Email
+	}
+
+	res, err := a.authUseCase.Authenticate(r.Context(), user.Identifier, user.Password)
 	if err != nil {
 		clog.Error(err, err.Error())
 		rest.DecodeError(w, r, domain.ErrAuth, http.StatusUnprocessableEntity)
 		return
 	}
 
+	if r.URL.Query().Get("cookie") == "true" {
+		setTokenCookie(w, res.Token)
+	}
+
+	rest.JSON(w, http.StatusOK, &res)
+}
+
+// registerRateLimit reads REGISTER_RATE_LIMIT, the number of sign-ups
+// an IP can make per hour. Zero disables the limit.
+func registerRateLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("REGISTER_RATE_LIMIT"))
+	if err != nil || limit < 0 {
+		return defaultRegisterRateLimit
+	}
+	return limit
+}
+
+// registerRequest has no role, the users signing up are always
+// usersDomain.RoleUser.
+type registerRequest struct {
+	Name     string `json:"name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Username string `json:"username" validate:"omitempty,alphanum,min=3,max=30"`
+	Password string `json:"password" validate:"required"`
+}
+
+// registerResponse is the user created, along with a token when asked.
+type registerResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Username  *string   `json:"username,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt rest.Time `json:"created_at"`
+	Token     string    `json:"token,omitempty"`
+}
+
+// Register godoc
+// @Summary      Sign up
+// @Description  creates a user with the user role, without authentication, in the tenant of the host; rate limited by IP
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload          body      registerRequest  true   "the user signing up"
+// @Param        token            query     bool             false  "also log the user in and return a token"
+// @Param        Idempotency-Key  header    string           false  "replays the response of the first request with the same key"
+// @Success      201              {object}  registerResponse
+// @Header       201              {string}  Location  "/user/{uuid}"
+// @Failure      400              {object}  rest.Message
+// @Failure      403              {object}  rest.Message
+// @Failure      409              {object}  rest.Message
+// @Failure      422              {object}  rest.Message
+// @Failure      429              {object}  rest.Message
+// @Failure      500              {object}  rest.Message
+// @Failure      503              {object}  rest.Message
+// @Router       /auth/register [post]
+func (a *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var payload registerRequest
+
+	if !rest.DecodeJSON(w, r, &payload) {
+		return
+	}
+
+	validation := validation.New()
+
+	if err := validation.BindStruct(r.Context(), payload); err != nil {
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	user := usersDomain.User{
+		Name:  payload.Name,
+		Email: payload.Email,
+		Role:  usersDomain.RoleUser,
+	}
+	if payload.Username != "" {
+		user.Username = &payload.Username
+	}
+
+	err := a.userUseCase.ValidatePassword(r.Context(), payload.Password, &user)
+
+	var invalid *usersDomain.ValidationError
+
+	switch {
+	case errors.Is(err, usersDomain.ErrPasswordReused):
+		rest.DecodeError(w, r, usersDomain.ErrPasswordReused, http.StatusUnprocessableEntity)
+		return
+	case errors.As(err, &invalid):
+		rest.DecodeError(w, r, invalid, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		clog.Error(err, usersDomain.ErrAdd.Error())
+		rest.DecodeError(w, r, usersDomain.ErrAdd, http.StatusInternalServerError)
+		return
+	}
+
+	hashPass, err := crypto.New().HashPassword(payload.Password, 10)
+	if err != nil {
+		clog.Error(err, usersDomain.ErrHashPassword.Error())
+		rest.DecodeError(w, r, usersDomain.ErrHashPassword, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user.UUID = idgen.New()
+	user.Password = hashPass
+
+	err = a.userUseCase.Add(r.Context(), &user)
+
+	switch {
+	case errors.Is(err, usersDomain.ErrEmailTaken):
+		rest.DecodeError(w, r, usersDomain.ErrEmailTaken, http.StatusConflict)
+		return
+	case errors.Is(err, usersDomain.ErrUsernameTaken):
+		rest.DecodeError(w, r, usersDomain.ErrUsernameTaken, http.StatusConflict)
+		return
+	case errors.Is(err, usersDomain.ErrEmailDomainBlocked), errors.Is(err, usersDomain.ErrEmailNoMX):
+		rest.DecodeError(w, r, err, http.StatusUnprocessableEntity)
+		return
+	case errors.As(err, &invalid):
+		rest.DecodeError(w, r, invalid, http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, usersDomain.ErrQuotaExceeded):
+		rest.DecodeError(w, r, usersDomain.ErrQuotaExceeded, http.StatusForbidden)
+		return
+	case err != nil:
+		clog.Error(err, usersDomain.ErrAdd.Error())
+		rest.DecodeError(w, r, usersDomain.ErrAdd, http.StatusUnprocessableEntity)
+		return
+	}
+
+	response := registerResponse{
+		ID:        user.UUID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Username:  user.Username,
+		Role:      user.Role,
+		CreatedAt: rest.Time(user.CreatedAt),
+	}
+
+	// The login goes through Authenticate, the token is the same as
+	// the one of /auth.
+	if r.URL.Query().Get("token") == "true" {
+		res, err := a.authUseCase.Authenticate(r.Context(), user.Email, payload.Password)
+		if err != nil {
+			clog.Error(err, err.Error())
+		} else {
+			response.Token = res.Token
+		}
+	}
+
+	w.Header().Set("Location", "/user/"+user.UUID.String())
+	rest.JSON(w, http.StatusCreated, &response)
+}
+
+// setTokenCookie hands the token to web clients in a cookie scripts
+// can't read. It's a session cookie, the token expiring on its own.
+func setTokenCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cmiddleware.AuthCookieName(),
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Impersonate godoc
+// @Summary      Impersonate a user
+// @Description  issues a short-lived token to act as the user (admin only)
+// @Tags         auth
+// @Produce      json
+// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        uuid           path      string  true  "user uuid"
+// @Success      200            {object}  domain.AuthToken
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /user/{uuid}/impersonate [post]
+func (a *AuthHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	target, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		rest.DecodeError(w, r, usersDomain.ErrUUIDParse, http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	if !ok {
+		rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if claims.ImpersonatedBy != uuid.Nil {
+		rest.DecodeError(w, r, domain.ErrNestedImpersonation, http.StatusForbidden)
+		return
+	}
+
+	res, err := a.authUseCase.Impersonate(r.Context(), claims.UUID, target)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		rest.DecodeError(w, r, domain.ErrUserNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrImpersonate.Error())
+		rest.DecodeError(w, r, domain.ErrImpersonate, http.StatusInternalServerError)
+		return
+	}
+
 	rest.JSON(w, http.StatusOK, &res)
 }
@@ -5,11 +5,19 @@ import (
 	"encoding/json"
 	"hexagony/app/auth/domain"
 	"hexagony/app/auth/domain/mocks"
+	cmiddleware "hexagony/app/shared/http/middleware"
+	usersDomain "hexagony/app/users/domain"
+	usersMocks "hexagony/app/users/domain/mocks"
+	"hexagony/lib/flags"
+	"hexagony/lib/rest"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -57,6 +65,36 @@ func TestAuthenticate(t *testing.T) {
 	mockAuthUseCase.AssertExpectations(t)
 }
 
+// TestAuthenticateShortPassword checks the login doesn't apply the
+// password policy, the passwords set before it must still log in.
+func TestAuthenticateShortPassword(t *testing.T) {
+	mockAuthUseCase := new(mocks.AuthUseCase)
+
+	mockAuthUseCase.
+		On("Authenticate",
+			mock.Anything,
+			mock.Anything,
+			mock.Anything,
+		).
+		Return(&domain.AuthToken{Token: "token"}, nil).Once()
+
+	handler := AuthHandler{
+		authUseCase: mockAuthUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/auth", handler.Authenticate)
+
+	req, err := http.NewRequest(http.MethodPost, "/auth", bytes.NewBufferString(`{"email":"xorycx@gmail.com","password":"1234"}`))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	mockAuthUseCase.AssertExpectations(t)
+}
+
 func TestAuthenticateFail(t *testing.T) {
 	mockAuthUseCase := new(mocks.AuthUseCase)
 
@@ -119,7 +157,7 @@ func TestAuthenticateFailDecode(t *testing.T) {
 	router.HandleFunc("/auth", handler.Authenticate)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 }
 
 func TestAuthenticateFailValidation(t *testing.T) {
@@ -149,12 +187,404 @@ func TestAuthenticateFailValidation(t *testing.T) {
 	router.HandleFunc("/auth", handler.Authenticate)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestAuthenticateFailMissingFields(t *testing.T) {
+	mockAuthUseCase := new(mocks.AuthUseCase)
+
+	handler := AuthHandler{
+		authUseCase: mockAuthUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Post("/auth", handler.Authenticate)
+
+	cases := []struct {
+		name     string
+		payload  string
+		expected []string
+	}{
+		{"missing-email", `{"password":"12345678"}`, []string{
+			"the identifier field is required",
+			"the email field is required",
+		}},
+		{"missing-password", `{"email":"john@doe.com"}`, []string{"the password field is required"}},
+		{"invalid-email", `{"email":"john","password":"12345678"}`, []string{"the email field is not valid"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/auth", bytes.NewBufferString(c.payload))
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+			var body struct {
+				Errors []struct {
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+
+			messages := []string{}
+			for _, e := range body.Errors {
+				messages = append(messages, e.Message)
+			}
+			assert.Equal(t, c.expected, messages)
+		})
+	}
+
+	mockAuthUseCase.AssertNotCalled(t, "Authenticate", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestNewHandler(t *testing.T) {
 	c := chi.NewRouter()
 	mockAuthUseCase := new(mocks.AuthUseCase)
 
-	NewAuthHandler(c, mockAuthUseCase)
+	NewAuthHandler(c, mockAuthUseCase, new(usersMocks.UserUseCase))
+}
+
+// TestJSONTags checks every field of the bodies has a snake_case json
+// tag, see rest.UntaggedFields.
+func TestJSONTags(t *testing.T) {
+	for _, v := range []interface{}{&domain.Auth{}, &domain.AuthToken{}, &loginRequest{}, &registerRequest{}, &registerResponse{}} {
+		assert.Empty(t, rest.UntaggedFields(v))
+	}
+}
+
+func TestRegister(t *testing.T) {
+	serve := func(handler *AuthHandler, target, payload string) *httptest.ResponseRecorder {
+		router := chi.NewRouter()
+		router.Post("/auth/register", handler.Register)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(payload)))
+		return rec
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockUserUseCase := new(usersMocks.UserUseCase)
+		mockUserUseCase.On("ValidatePassword", mock.Anything, "12345678", mock.Anything).Return(nil).Once()
+		mockUserUseCase.On("Add", mock.Anything, mock.MatchedBy(func(user *usersDomain.User) bool {
+			return user.Email == "john@doe.com" && user.Role == usersDomain.RoleUser &&
+				strings.HasPrefix(user.Password, "$2a$")
+		})).Return(nil).Once()
+
+		handler := &AuthHandler{userUseCase: mockUserUseCase}
+
+		rec := serve(handler, "/auth/register", `{"name":"John Doe","email":"john@doe.com","password":"12345678"}`)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Header().Get("Location"), "/user/")
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, "john@doe.com", response["email"])
+		assert.Equal(t, usersDomain.RoleUser, response["role"])
+		assert.NotContains(t, response, "token")
+		assert.NotContains(t, response, "password")
+		mockUserUseCase.AssertExpectations(t)
+	})
+
+	t.Run("admin role", func(t *testing.T) {
+		mockUserUseCase := new(usersMocks.UserUseCase)
+		mockUserUseCase.On("ValidatePassword", mock.Anything, "12345678", mock.Anything).Return(nil).Once()
+		mockUserUseCase.On("Add", mock.Anything, mock.MatchedBy(func(user *usersDomain.User) bool {
+			return user.Role == usersDomain.RoleUser
+		})).Return(nil).Once()
+
+		handler := &AuthHandler{userUseCase: mockUserUseCase}
+
+		rec := serve(handler, "/auth/register", `{"name":"John Doe","email":"john@doe.com","password":"12345678","role":"admin"}`)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"role":"user"`)
+		mockUserUseCase.AssertExpectations(t)
+	})
+
+	t.Run("token", func(t *testing.T) {
+		mockUserUseCase := new(usersMocks.UserUseCase)
+		mockUserUseCase.On("ValidatePassword", mock.Anything, "12345678", mock.Anything).Return(nil).Once()
+		mockUserUseCase.On("Add", mock.Anything, mock.Anything).Return(nil).Once()
+
+		mockAuthUseCase := new(mocks.AuthUseCase)
+		mockAuthUseCase.On("Authenticate", mock.Anything, "john@doe.com", "12345678").
+			Return(&domain.AuthToken{Token: "token"}, nil).Once()
+
+		handler := &AuthHandler{authUseCase: mockAuthUseCase, userUseCase: mockUserUseCase}
+
+		rec := serve(handler, "/auth/register?token=true", `{"name":"John Doe","email":"john@doe.com","password":"12345678"}`)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"token":"token"`)
+		mockAuthUseCase.AssertExpectations(t)
+	})
+
+	t.Run("email taken", func(t *testing.T) {
+		mockUserUseCase := new(usersMocks.UserUseCase)
+		mockUserUseCase.On("ValidatePassword", mock.Anything, "12345678", mock.Anything).Return(nil).Once()
+		mockUserUseCase.On("Add", mock.Anything, mock.Anything).Return(usersDomain.ErrEmailTaken).Once()
+
+		handler := &AuthHandler{userUseCase: mockUserUseCase}
+
+		rec := serve(handler, "/auth/register", `{"name":"John Doe","email":"john@doe.com","password":"12345678"}`)
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("username taken", func(t *testing.T) {
+		mockUserUseCase := new(usersMocks.UserUseCase)
+		mockUserUseCase.On("ValidatePassword", mock.Anything, "12345678", mock.Anything).Return(nil).Once()
+		mockUserUseCase.On("Add", mock.Anything, mock.Anything).Return(usersDomain.ErrUsernameTaken).Once()
+
+		handler := &AuthHandler{userUseCase: mockUserUseCase}
+
+		rec := serve(handler, "/auth/register", `{"name":"John Doe","email":"john@doe.com","username":"johndoe","password":"12345678"}`)
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Contains(t, rec.Body.String(), usersDomain.ErrUsernameTaken.Error())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		mockUserUseCase := new(usersMocks.UserUseCase)
+
+		handler := &AuthHandler{userUseCase: mockUserUseCase}
+
+		rec := serve(handler, "/auth/register", `{"name":"John Doe","email":"john","password":"123"}`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		mockUserUseCase.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+	})
+
+	t.Run("weak password", func(t *testing.T) {
+		mockUserUseCase := new(usersMocks.UserUseCase)
+		mockUserUseCase.On("ValidatePassword", mock.Anything, "1234", mock.MatchedBy(func(user *usersDomain.User) bool {
+			return user.Email == "john@doe.com" && user.UUID == uuid.Nil
+		})).Return(&usersDomain.ValidationError{Field: "password", Message: "must be at least 8 characters"}).Once()
+
+		handler := &AuthHandler{userUseCase: mockUserUseCase}
+
+		rec := serve(handler, "/auth/register", `{"name":"John Doe","email":"john@doe.com","password":"1234"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "password must be at least 8 characters")
+		mockUserUseCase.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+	})
+}
+
+func TestRegisterRateLimit(t *testing.T) {
+	os.Setenv("REGISTER_RATE_LIMIT", "1")
+	defer os.Unsetenv("REGISTER_RATE_LIMIT")
+
+	mockUserUseCase := new(usersMocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockUserUseCase.On("Add", mock.Anything, mock.Anything).Return(nil).Once()
+
+	router := chi.NewRouter()
+	NewAuthHandler(router, new(mocks.AuthUseCase), mockUserUseCase)
+
+	payload := `{"name":"John Doe","email":"john@doe.com","password":"12345678"}`
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(payload)))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestAuthenticateCookie(t *testing.T) {
+	mockAuthUseCase := new(mocks.AuthUseCase)
+
+	mockAuthUseCase.
+		On("Authenticate", mock.Anything, "john@doe.com", "12345678").
+		Return(&domain.AuthToken{Token: "token"}, nil)
+
+	handler := AuthHandler{
+		authUseCase: mockAuthUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Post("/auth", handler.Authenticate)
+
+	payload := `{"email":"john@doe.com","password":"12345678"}`
+
+	for _, c := range []struct {
+		query  string
+		cookie bool
+	}{
+		{"", false},
+		{"?cookie=true", true},
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/auth"+c.query, bytes.NewBufferString(payload))
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		cookies := rec.Result().Cookies()
+		if !c.cookie {
+			assert.Empty(t, cookies)
+			continue
+		}
+
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, "token", cookies[0].Name)
+		assert.Equal(t, "token", cookies[0].Value)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.True(t, cookies[0].Secure)
+		assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+	}
+
+	mockAuthUseCase.AssertExpectations(t)
+}
+
+func TestAuthenticateDraining(t *testing.T) {
+	mockAuthUseCase := new(mocks.AuthUseCase)
+
+	router := chi.NewRouter()
+	NewAuthHandler(router, mockAuthUseCase, new(usersMocks.UserUseCase))
+
+	cmiddleware.SetDraining(true)
+	defer cmiddleware.SetDraining(false)
+
+	payload := `{"email":"john@doe.com","password":"12345678"}`
+
+	req, err := http.NewRequest(http.MethodPost, "/auth", bytes.NewBufferString(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	mockAuthUseCase.AssertNotCalled(t, "Authenticate", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestImpersonateFlag(t *testing.T) {
+	defer flags.Reset(flags.Impersonation)
+
+	mockAuthUseCase := new(mocks.AuthUseCase)
+
+	router := chi.NewRouter()
+	NewAuthHandler(router, mockAuthUseCase, new(usersMocks.UserUseCase))
+
+	serve := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, "/user/"+uuid.New().String()+"/impersonate", nil)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve().Code)
+
+	flags.Set(flags.Impersonation, false)
+
+	assert.Equal(t, http.StatusNotFound, serve().Code)
+
+	mockAuthUseCase.AssertNotCalled(t, "Impersonate", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAuthenticateIdentifier(t *testing.T) {
+	cases := []struct {
+		name       string
+		payload    string
+		identifier string
+	}{
+		{"email", `{"email": "xorycx@gmail.com", "password": "12345678"}`, "xorycx@gmail.com"},
+		{"identifier-email", `{"identifier": "xorycx@gmail.com", "password": "12345678"}`, "xorycx@gmail.com"},
+		{"identifier-username", `{"identifier": "cyro", "password": "12345678"}`, "cyro"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockAuthUseCase := new(mocks.AuthUseCase)
+
+			mockAuthUseCase.
+				On("Authenticate", mock.Anything, c.identifier, "12345678").
+				Return(&domain.AuthToken{Token: "token"}, nil)
+
+			handler := AuthHandler{
+				authUseCase: mockAuthUseCase,
+			}
+
+			router := chi.NewRouter()
+
+			req, err := http.NewRequest(http.MethodPost, "/auth", bytes.NewBufferString(c.payload))
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.HandleFunc("/auth", handler.Authenticate)
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			mockAuthUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestImpersonate(t *testing.T) {
+	admin := uuid.New()
+	target := uuid.New()
+	missing := uuid.New()
+
+	mockAuthUseCase := new(mocks.AuthUseCase)
+
+	mockAuthUseCase.
+		On("Impersonate", mock.Anything, admin, target).
+		Return(&domain.AuthToken{Token: "token"}, nil)
+	mockAuthUseCase.
+		On("Impersonate", mock.Anything, admin, missing).
+		Return(nil, domain.ErrUserNotFound)
+
+	handler := AuthHandler{
+		authUseCase: mockAuthUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Post("/user/{uuid}/impersonate", handler.Impersonate)
+
+	cases := []struct {
+		name     string
+		target   string
+		claims   *cmiddleware.Claims
+		expected int
+	}{
+		{"success", target.String(), &cmiddleware.Claims{UUID: admin}, http.StatusOK},
+		{"not-found", missing.String(), &cmiddleware.Claims{UUID: admin}, http.StatusNotFound},
+		{"invalid-uuid", "invalid", &cmiddleware.Claims{UUID: admin}, http.StatusBadRequest},
+		{"nested", target.String(), &cmiddleware.Claims{UUID: target, ImpersonatedBy: admin}, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/user/"+c.target+"/impersonate", nil)
+			assert.NoError(t, err)
+
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), c.claims))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+		})
+	}
+
+	mockAuthUseCase.AssertExpectations(t)
 }
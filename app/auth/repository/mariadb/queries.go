@@ -1,3 +1,8 @@
 package mariadb
 
-const sqlGetUser = "SELECT * from users WHERE email = ?"
+// The users are looked up in the tenant of the context, see database.Tenant.
+const (
+	sqlGetUser = "SELECT * from users WHERE tenant_id = ? AND (email_canonical = ? OR username = ?)"
+
+	sqlGetUserByID = "SELECT * from users WHERE tenant_id = ? AND uuid = ?"
+)
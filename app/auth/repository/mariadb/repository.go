@@ -5,25 +5,70 @@ import (
 	"database/sql"
 	authDomain "hexagony/app/auth/domain"
 	userDomain "hexagony/app/users/domain"
+	"hexagony/lib/database"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 type mariadbRepository struct {
-	Conn *sqlx.DB
+	Resolver database.Resolver
 }
 
 func NewMariaDBRepository(Conn *sqlx.DB) authDomain.AuthRepository {
-	return &mariadbRepository{Conn}
+	return &mariadbRepository{database.SingleDB(Conn)}
 }
 
-func (p *mariadbRepository) Authenticate(ctx context.Context, email string) (*userDomain.User, error) {
+// NewMariaDBTenantRepository looks the users up in the database the
+// resolver gives for the tenant of the request.
+func NewMariaDBTenantRepository(resolver database.Resolver) authDomain.AuthRepository {
+	return &mariadbRepository{resolver}
+}
+
+func (p *mariadbRepository) Authenticate(ctx context.Context, identifier string) (*userDomain.User, error) {
 	var user userDomain.User
 
-	err := p.Conn.GetContext(ctx, &user, sqlGetUser, email)
+	conn, err := p.Resolver.DB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.GetContext(
+		ctx,
+		&user,
+		sqlGetUser,
+		database.Tenant(ctx),
+		userDomain.CanonicalEmail(identifier),
+		identifier,
+	)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
 	return &user, nil
 }
+
+func (p *mariadbRepository) FindByID(ctx context.Context, uuid uuid.UUID) (*userDomain.User, error) {
+	var user userDomain.User
+
+	conn, err := p.Resolver.DB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.GetContext(
+		ctx,
+		&user,
+		sqlGetUserByID,
+		database.Tenant(ctx),
+		uuid,
+	)
+	if err == sql.ErrNoRows {
+		return nil, authDomain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
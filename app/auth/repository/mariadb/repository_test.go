@@ -2,7 +2,10 @@ package mariadb
 
 import (
 	"context"
+	"database/sql"
+	authDomain "hexagony/app/auth/domain"
 	domainUsers "hexagony/app/users/domain"
+	"hexagony/lib/database"
 	"testing"
 	"time"
 
@@ -47,7 +50,7 @@ func TestAuthenticate(t *testing.T) {
 		mockUser.UpdatedAt,
 	)
 
-	query := "SELECT \\* from users WHERE email = \\?"
+	query := "SELECT \\* from users WHERE tenant_id = \\? AND \\(email_canonical = \\?"
 
 	mock.ExpectQuery(query).WillReturnRows(row)
 
@@ -77,7 +80,7 @@ func TestAuthenticateFail(t *testing.T) {
 		"updated_at",
 	}).AddRow("", "", "", "", "", "")
 
-	query := "SELECT \\* from users WHERE email = \\?"
+	query := "SELECT \\* from users WHERE tenant_id = \\? AND \\(email_canonical = \\?"
 
 	mock.ExpectQuery(query).WillReturnRows(row)
 
@@ -87,3 +90,127 @@ func TestAuthenticateFail(t *testing.T) {
 	assert.Nil(t, user)
 	assert.Error(t, err)
 }
+
+func TestAuthenticateIdentifier(t *testing.T) {
+	username := "cyro"
+
+	for _, identifier := range []string{"xorycx@gmail.com", username} {
+		t.Run(identifier, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			defer db.Close()
+
+			dbx := sqlx.NewDb(db, "sqlmock")
+
+			row := sqlmock.NewRows([]string{
+				"uuid",
+				"name",
+				"email",
+				"username",
+				"password",
+				"created_at",
+				"updated_at",
+			}).AddRow(uuid.New(), "Cyro Dubeux", "xorycx@gmail.com", username, "12345678", time.Now(), time.Now())
+
+			query := "SELECT \\* from users WHERE tenant_id = \\? AND \\(email_canonical = \\? OR username = \\?\\)"
+
+			mock.ExpectQuery(query).WithArgs(database.DefaultTenant, identifier, identifier).WillReturnRows(row)
+
+			authRepo := NewMariaDBRepository(dbx)
+			user, err := authRepo.Authenticate(context.TODO(), identifier)
+
+			assert.NoError(t, err)
+			assert.Equal(t, "Cyro Dubeux", user.Name)
+			assert.Equal(t, &username, user.Username)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthenticateNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	row := sqlmock.NewRows([]string{"uuid", "name", "email", "username", "password", "created_at", "updated_at"})
+
+	query := "SELECT \\* from users WHERE tenant_id = \\? AND \\(email_canonical = \\? OR username = \\?\\)"
+
+	mock.ExpectQuery(query).WithArgs(database.DefaultTenant, "nobody", "nobody").WillReturnRows(row)
+
+	authRepo := NewMariaDBRepository(dbx)
+	user, err := authRepo.Authenticate(context.TODO(), "nobody")
+
+	assert.NoError(t, err)
+	assert.Empty(t, user.Password)
+}
+
+func TestAuthenticateCaseInsensitive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	row := sqlmock.NewRows([]string{"uuid", "name", "email", "email_canonical", "password", "created_at", "updated_at"}).
+		AddRow(uuid.New(), "Cyro Dubeux", "Xorycx@gmail.com", "xorycx@gmail.com", "12345678", time.Now(), time.Now())
+
+	query := "SELECT \\* from users WHERE tenant_id = \\? AND \\(email_canonical = \\? OR username = \\?\\)"
+
+	mock.ExpectQuery(query).WithArgs(database.DefaultTenant, "xorycx@gmail.com", " XoryCX@Gmail.com").WillReturnRows(row)
+
+	authRepo := NewMariaDBRepository(dbx)
+	user, err := authRepo.Authenticate(context.TODO(), " XoryCX@Gmail.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Xorycx@gmail.com", user.Email)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	userUUID := uuid.New()
+
+	row := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"email",
+		"password",
+		"created_at",
+		"updated_at",
+	}).AddRow(userUUID, "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now())
+
+	query := "SELECT \\* from users WHERE tenant_id = \\? AND uuid = \\?"
+
+	mock.ExpectQuery(query).WithArgs(database.DefaultTenant, userUUID).WillReturnRows(row)
+	mock.ExpectQuery(query).WithArgs(database.DefaultTenant, userUUID).WillReturnError(sql.ErrNoRows)
+
+	authRepo := NewMariaDBRepository(dbx)
+
+	user, err := authRepo.FindByID(context.TODO(), userUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Cyro Dubeux", user.Name)
+
+	user, err = authRepo.FindByID(context.TODO(), userUUID)
+	assert.Nil(t, user)
+	assert.ErrorIs(t, err, authDomain.ErrUserNotFound)
+}
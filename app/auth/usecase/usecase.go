@@ -5,6 +5,7 @@ import (
 	"errors"
 	authDomain "hexagony/app/auth/domain"
 	usersDomain "hexagony/app/users/domain"
+	"hexagony/lib/audit"
 	"hexagony/lib/crypto"
 	"os"
 	"time"
@@ -13,25 +14,29 @@ import (
 	"github.com/google/uuid"
 )
 
+// impersonationDuration caps the lifetime of impersonation
+// tokens, whatever JWT_DURATION says.
+const impersonationDuration = 15 * time.Minute
+
 type authUseCase struct {
 	authRepo authDomain.AuthRepository
+	audit    audit.Logger
 }
 
 func NewAuthUsecase(auth authDomain.AuthRepository) authDomain.AuthUseCase {
 	return &authUseCase{
 		authRepo: auth,
+		audit:    audit.New(),
 	}
 }
 
-func (a *authUseCase) Authenticate(ctx context.Context, email, password string) (*authDomain.AuthToken, error) {
-	user, err := a.authRepo.Authenticate(ctx, email)
+func (a *authUseCase) Authenticate(ctx context.Context, identifier, password string) (*authDomain.AuthToken, error) {
+	user, match, err := a.verify(ctx, identifier, password)
 	if err != nil {
 		return nil, err
 	}
 
-	bcrypt := crypto.New()
-
-	if match := bcrypt.CheckPasswordHash(password, user.Password); !match {
+	if !match {
 		return nil, errors.New("authentication failed")
 	}
 
@@ -39,58 +44,169 @@ func (a *authUseCase) Authenticate(ctx context.Context, email, password string)
 		UUID:  user.UUID,
 		Name:  user.Name,
 		Email: user.Email,
-	}
+		Role:  user.Role,
 
-	jwtDuration := os.Getenv("JWT_DURATION")
-
-	if jwtDuration == "" {
-		jwtDuration = "60m"
+		TokenVersion: user.TokenVersion,
+		TenantID:     user.TenantID,
 	}
 
-	duration, err := time.ParseDuration(jwtDuration)
+	duration, err := tokenDuration()
 	if err != nil {
 		return nil, err
 	}
 
-	expiration := time.Duration(time.Minute * duration)
-	tokenExpiration := time.Now().Add(expiration)
+	tokenExpiration := time.Now().Add(duration)
 
-	token, err := a.generateToken("user", customClaims, tokenExpiration)
+	token, err := a.generateToken("user", customClaims, time.Now(), tokenExpiration, uuid.Nil)
 	if err != nil {
 		return nil, err
 	}
 
 	authToken := authDomain.AuthToken{Token: token}
 
+	// An expired password doesn't block the login, which would lock
+	// the users out, the client asks for a new one.
+	if maxAge := passwordMaxAge(); maxAge > 0 && time.Since(user.PasswordChangedAt) > maxAge {
+		authToken.MustChangePassword = true
+	}
+
 	return &authToken, nil
 }
 
+// VerifyCredentials checks the password of the user with the email, or
+// username, the same way Authenticate does, without issuing a token.
+// An unknown user is just a mismatch.
+func (a *authUseCase) VerifyCredentials(ctx context.Context, email, password string) (bool, error) {
+	_, match, err := a.verify(ctx, email, password)
+	return match, err
+}
+
+// verify looks the user up by email or username and checks the password
+// against its hash.
+func (a *authUseCase) verify(ctx context.Context, identifier, password string) (*usersDomain.User, bool, error) {
+	user, err := a.authRepo.Authenticate(ctx, identifier)
+	if err != nil {
+		return nil, false, err
+	}
+
+	bcrypt := crypto.New()
+
+	return user, bcrypt.CheckPasswordHash(password, user.Password), nil
+}
+
+// Impersonate issues a short-lived token letting the admin act as the
+// target user. The token records the admin and the action is audited.
+func (a *authUseCase) Impersonate(ctx context.Context, admin, target uuid.UUID) (*authDomain.AuthToken, error) {
+	user, err := a.authRepo.FindByID(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := tokenDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	if duration > impersonationDuration {
+		duration = impersonationDuration
+	}
+
+	customClaims := &usersDomain.User{
+		UUID:  user.UUID,
+		Name:  user.Name,
+		Email: user.Email,
+		Role:  user.Role,
+
+		TokenVersion: user.TokenVersion,
+		TenantID:     user.TenantID,
+	}
+
+	token, err := a.generateToken("user", customClaims, time.Now(), time.Now().Add(duration), admin)
+	if err != nil {
+		return nil, err
+	}
+
+	a.audit.Record(ctx, audit.Entry{
+		Action: "impersonate",
+		Actor:  admin,
+		Target: user.UUID,
+	})
+
+	return &authDomain.AuthToken{Token: token}, nil
+}
+
+// tokenDuration reads JWT_DURATION, which defaults to an hour.
+func tokenDuration() (time.Duration, error) {
+	jwtDuration := os.Getenv("JWT_DURATION")
+
+	if jwtDuration == "" {
+		jwtDuration = "60m"
+	}
+
+	return time.ParseDuration(jwtDuration)
+}
+
+// passwordMaxAge reads PASSWORD_MAX_AGE, passwords never expiring
+// when it's unset or zero.
+func passwordMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(os.Getenv("PASSWORD_MAX_AGE"))
+	if err != nil || maxAge < 0 {
+		return 0
+	}
+	return maxAge
+}
+
+// generateToken signs a token for the user, valid from notBefore
+// (now when zero) until expiration. The impersonated_by claim is only
+// set when impersonatedBy isn't uuid.Nil.
 func (a *authUseCase) generateToken(
 	claimKey string,
 	claimValue *usersDomain.User,
+	notBefore time.Time,
 	expiration time.Time,
+	impersonatedBy uuid.UUID,
 ) (string, error) {
 	if claimKey == "" || claimValue == nil {
 		return "", authDomain.ErrEmptyClaim
 	}
 
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+
 	signingKey := []byte(os.Getenv("JWT_SECRET"))
 
+	var impersonator string
+	if impersonatedBy != uuid.Nil {
+		impersonator = impersonatedBy.String()
+	}
+
 	claims := struct {
 		jwt.RegisteredClaims
 		UUID  uuid.UUID `json:"id"`
 		Name  string    `json:"name"`
 		Email string    `json:"email"`
+		Role  string    `json:"role"`
+
+		TokenVersion   int    `json:"token_version"`
+		ImpersonatedBy string `json:"impersonated_by,omitempty"`
+		TenantID       string `json:"tenant_id,omitempty"`
 	}{
 		jwt.RegisteredClaims{
 			Issuer:    "Hexagony",
 			Subject:   "https://github.com/cyruzin/hexagony",
 			Audience:  jwt.ClaimStrings{"Clean Architecture"},
+			NotBefore: jwt.NewNumericDate(notBefore),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(expiration),
 		},
 		claimValue.UUID,
 		claimValue.Name,
 		claimValue.Email,
+		claimValue.Role,
+		claimValue.TokenVersion,
+		impersonator,
+		claimValue.TenantID,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
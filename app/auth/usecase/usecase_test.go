@@ -3,8 +3,12 @@ package usecase
 import (
 	"context"
 	"errors"
+	authDomain "hexagony/app/auth/domain"
 	"hexagony/app/auth/domain/mocks"
 	domainUsers "hexagony/app/users/domain"
+	"hexagony/lib/audit"
+	"hexagony/lib/token"
+	"os"
 	"testing"
 	"time"
 
@@ -27,7 +31,7 @@ func TestAuthenticate(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockAuthRepo.On("Authenticate",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("string")).
 			Return(mockUser, nil).
 			Once()
@@ -40,9 +44,41 @@ func TestAuthenticate(t *testing.T) {
 		mockAuthRepo.AssertExpectations(t)
 	})
 
+	t.Run("username", func(t *testing.T) {
+		mockAuthRepo.On("Authenticate",
+			mock.Anything,
+			"cyro").
+			Return(mockUser, nil).
+			Once()
+
+		a := NewAuthUsecase(mockAuthRepo)
+		token, err := a.Authenticate(context.TODO(), "cyro", "12345678")
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, token.Token)
+
+		mockAuthRepo.AssertExpectations(t)
+	})
+
+	t.Run("not-found", func(t *testing.T) {
+		mockAuthRepo.On("Authenticate",
+			mock.Anything,
+			"nobody").
+			Return(&domainUsers.User{}, nil).
+			Once()
+
+		a := NewAuthUsecase(mockAuthRepo)
+		token, err := a.Authenticate(context.TODO(), "nobody", "12345678")
+
+		assert.Nil(t, token)
+		assert.Error(t, err)
+
+		mockAuthRepo.AssertExpectations(t)
+	})
+
 	t.Run("error-failed", func(t *testing.T) {
 		mockAuthRepo.On("Authenticate",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("string"),
 			mock.AnythingOfType("string")).
 			Return(nil, errors.New("Unexpected error")).
@@ -57,3 +93,253 @@ func TestAuthenticate(t *testing.T) {
 		mockAuthRepo.AssertExpectations(t)
 	})
 }
+
+func TestAuthenticatePasswordExpiry(t *testing.T) {
+	t.Setenv("PASSWORD_MAX_AGE", "2160h")
+
+	for name, tc := range map[string]struct {
+		changedAt time.Time
+		expected  bool
+	}{
+		"expired": {time.Now().Add(-2161 * time.Hour), true},
+		"fresh":   {time.Now().Add(-time.Hour), false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockAuthRepo := new(mocks.AuthRepository)
+			mockAuthRepo.On("Authenticate", mock.Anything, "xorycx@gmail.com").Return(&domainUsers.User{
+				UUID:              uuid.New(),
+				Email:             "xorycx@gmail.com",
+				Password:          "$2a$10$Vm8jmbPV5NMgoCag3O/iM.LTfMs6rmmwgDwRUw9m8QGFyis7EA/Gy",
+				PasswordChangedAt: tc.changedAt,
+			}, nil).Once()
+
+			token, err := NewAuthUsecase(mockAuthRepo).Authenticate(context.TODO(), "xorycx@gmail.com", "12345678")
+
+			// The login succeeds either way.
+			assert.NoError(t, err)
+			assert.NotEmpty(t, token.Token)
+			assert.Equal(t, tc.expected, token.MustChangePassword)
+			mockAuthRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifyCredentials(t *testing.T) {
+	mockUser := &domainUsers.User{
+		UUID:     uuid.New(),
+		Email:    "xorycx@gmail.com",
+		Password: "$2a$10$Vm8jmbPV5NMgoCag3O/iM.LTfMs6rmmwgDwRUw9m8QGFyis7EA/Gy",
+	}
+
+	t.Run("correct", func(t *testing.T) {
+		mockAuthRepo := new(mocks.AuthRepository)
+		mockAuthRepo.On("Authenticate", mock.Anything, "xorycx@gmail.com").Return(mockUser, nil).Once()
+
+		match, err := NewAuthUsecase(mockAuthRepo).VerifyCredentials(context.TODO(), "xorycx@gmail.com", "12345678")
+
+		assert.NoError(t, err)
+		assert.True(t, match)
+		mockAuthRepo.AssertExpectations(t)
+	})
+
+	t.Run("incorrect", func(t *testing.T) {
+		mockAuthRepo := new(mocks.AuthRepository)
+		mockAuthRepo.On("Authenticate", mock.Anything, "xorycx@gmail.com").Return(mockUser, nil).Once()
+
+		match, err := NewAuthUsecase(mockAuthRepo).VerifyCredentials(context.TODO(), "xorycx@gmail.com", "87654321")
+
+		assert.NoError(t, err)
+		assert.False(t, match)
+		mockAuthRepo.AssertExpectations(t)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		mockAuthRepo := new(mocks.AuthRepository)
+		mockAuthRepo.On("Authenticate", mock.Anything, "nobody").Return(&domainUsers.User{}, nil).Once()
+
+		match, err := NewAuthUsecase(mockAuthRepo).VerifyCredentials(context.TODO(), "nobody", "12345678")
+
+		assert.NoError(t, err)
+		assert.False(t, match)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mockAuthRepo := new(mocks.AuthRepository)
+		mockAuthRepo.On("Authenticate", mock.Anything, "xorycx@gmail.com").Return(nil, errors.New("Unexpected error")).Once()
+
+		match, err := NewAuthUsecase(mockAuthRepo).VerifyCredentials(context.TODO(), "xorycx@gmail.com", "12345678")
+
+		assert.Error(t, err)
+		assert.False(t, match)
+	})
+}
+
+func TestGenerateTokenNotBefore(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	a := &authUseCase{}
+	user := &domainUsers.User{UUID: uuid.New(), Name: "Cyro Dubeux"}
+	expiration := time.Now().Add(2 * time.Hour)
+
+	scheduled, err := a.generateToken("user", user, time.Now().Add(time.Hour), expiration, uuid.Nil)
+	assert.NoError(t, err)
+
+	_, err = token.Parse(scheduled)
+	assert.ErrorIs(t, err, token.ErrNotYet)
+
+	started, err := a.generateToken("user", user, time.Now().Add(-time.Minute), expiration, uuid.Nil)
+	assert.NoError(t, err)
+
+	claims, err := token.Parse(started)
+	assert.NoError(t, err)
+	assert.Equal(t, user.UUID.String(), claims["id"])
+
+	immediate, err := a.generateToken("user", user, time.Time{}, expiration, uuid.Nil)
+	assert.NoError(t, err)
+
+	claims, err = token.Parse(immediate)
+	assert.NoError(t, err)
+	assert.Contains(t, claims, "nbf")
+}
+
+type auditRecorder struct {
+	entries []audit.Entry
+}
+
+func (a *auditRecorder) Record(ctx context.Context, entry audit.Entry) {
+	a.entries = append(a.entries, entry)
+}
+
+func TestImpersonate(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	os.Setenv("JWT_DURATION", "24h")
+	defer os.Unsetenv("JWT_SECRET")
+	defer os.Unsetenv("JWT_DURATION")
+
+	mockAuthRepo := new(mocks.AuthRepository)
+
+	admin := uuid.New()
+	mockUser := &domainUsers.User{
+		UUID:  uuid.New(),
+		Name:  "Cyro Dubeux",
+		Email: "xorycx@gmail.com",
+		Role:  domainUsers.RoleUser,
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockAuthRepo.On("FindByID",
+			mock.Anything,
+			mockUser.UUID).
+			Return(mockUser, nil).
+			Once()
+
+		recorder := &auditRecorder{}
+		a := &authUseCase{authRepo: mockAuthRepo, audit: recorder}
+
+		authToken, err := a.Impersonate(context.TODO(), admin, mockUser.UUID)
+		assert.NoError(t, err)
+
+		claims, err := token.Parse(authToken.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, mockUser.UUID.String(), claims["id"])
+		assert.Equal(t, admin.String(), claims["impersonated_by"])
+
+		expiresAt := time.Unix(int64(claims["exp"].(float64)), 0)
+		assert.WithinDuration(t, time.Now().Add(impersonationDuration), expiresAt, 5*time.Second)
+
+		assert.Equal(t, []audit.Entry{{
+			Action: "impersonate",
+			Actor:  admin,
+			Target: mockUser.UUID,
+		}}, recorder.entries)
+
+		mockAuthRepo.AssertExpectations(t)
+	})
+
+	t.Run("not-found", func(t *testing.T) {
+		missing := uuid.New()
+
+		mockAuthRepo.On("FindByID",
+			mock.Anything,
+			missing).
+			Return(nil, authDomain.ErrUserNotFound).
+			Once()
+
+		recorder := &auditRecorder{}
+		a := &authUseCase{authRepo: mockAuthRepo, audit: recorder}
+
+		authToken, err := a.Impersonate(context.TODO(), admin, missing)
+
+		assert.Nil(t, authToken)
+		assert.ErrorIs(t, err, authDomain.ErrUserNotFound)
+		assert.Empty(t, recorder.entries)
+
+		mockAuthRepo.AssertExpectations(t)
+	})
+}
+
+func TestAuthenticateNotImpersonated(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	a := &authUseCase{}
+
+	signed, err := a.generateToken("user", &domainUsers.User{UUID: uuid.New()}, time.Time{}, time.Now().Add(time.Hour), uuid.Nil)
+	assert.NoError(t, err)
+
+	claims, err := token.Parse(signed)
+	assert.NoError(t, err)
+	assert.NotContains(t, claims, "impersonated_by")
+}
+
+func TestAuthenticateTokenVersion(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	mockAuthRepo := new(mocks.AuthRepository)
+
+	mockAuthRepo.On("Authenticate", mock.Anything, "xorycx@gmail.com").
+		Return(&domainUsers.User{
+			UUID:         uuid.New(),
+			Password:     "$2a$10$Vm8jmbPV5NMgoCag3O/iM.LTfMs6rmmwgDwRUw9m8QGFyis7EA/Gy",
+			TokenVersion: 3,
+		}, nil).
+		Once()
+
+	a := NewAuthUsecase(mockAuthRepo)
+	authToken, err := a.Authenticate(context.TODO(), "xorycx@gmail.com", "12345678")
+	assert.NoError(t, err)
+
+	claims, err := token.Parse(authToken.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), claims["token_version"])
+	assert.Contains(t, claims, "iat")
+
+	mockAuthRepo.AssertExpectations(t)
+}
+
+func TestAuthenticateTenant(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	mockAuthRepo := new(mocks.AuthRepository)
+
+	mockAuthRepo.On("Authenticate", mock.Anything, "xorycx@gmail.com").
+		Return(&domainUsers.User{
+			UUID:     uuid.New(),
+			Password: "$2a$10$Vm8jmbPV5NMgoCag3O/iM.LTfMs6rmmwgDwRUw9m8QGFyis7EA/Gy",
+			TenantID: "acme",
+		}, nil).
+		Once()
+
+	a := NewAuthUsecase(mockAuthRepo)
+	authToken, err := a.Authenticate(context.TODO(), "xorycx@gmail.com", "12345678")
+	assert.NoError(t, err)
+
+	claims, err := token.Parse(authToken.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", claims["tenant_id"])
+
+	mockAuthRepo.AssertExpectations(t)
+}
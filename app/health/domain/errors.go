@@ -0,0 +1,8 @@
+package domain
+
+import "errors"
+
+var (
+	ErrDatabase      = errors.New("the database is unreachable")
+	ErrSchemaVersion = errors.New("failed to read the schema version")
+)
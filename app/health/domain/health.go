@@ -0,0 +1,13 @@
+package domain
+
+import "context"
+
+type HealthRepository interface {
+	SchemaVersion(context.Context) (int, error)
+}
+
+// HealthChecker is a repository telling if its data source can serve
+// queries, through the same pool and query path as its other methods.
+type HealthChecker interface {
+	HealthCheck(context.Context) error
+}
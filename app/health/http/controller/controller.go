@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"fmt"
+	"hexagony/app/health/domain"
+	cmiddleware "hexagony/app/shared/http/middleware"
+	"hexagony/lib/buildinfo"
+	"hexagony/lib/clog"
+	"hexagony/lib/rest"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// readyRetryAfter is when the load balancers check an instance that
+// isn't ready again.
+const readyRetryAfter = 5 * time.Second
+
+type HealthHandler struct {
+	healthRepository domain.HealthRepository
+	userRepository   domain.HealthChecker
+	schemaVersion    int
+}
+
+// NewHealthHandler registers the readiness check, which expects the
+// users repository to answer and the database schema to be at least
+// at schemaVersion. It's served over plain http even when FORCE_HTTPS
+// is enabled, for the load balancers. The build running is at /version.
+func NewHealthHandler(c *chi.Mux, hr domain.HealthRepository, ur domain.HealthChecker, schemaVersion int) {
+	handler := HealthHandler{healthRepository: hr, userRepository: ur, schemaVersion: schemaVersion}
+
+	cmiddleware.ExemptFromHTTPS("/readyz")
+
+	c.Get("/readyz", handler.Ready)
+	c.Get("/version", handler.Version)
+}
+
+// Version godoc
+// @Summary      Build information
+// @Description  returns the version, git commit and build time of the running build
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  buildinfo.Info
+// @Router       /version [get]
+func (h *HealthHandler) Version(w http.ResponseWriter, r *http.Request) {
+	rest.JSON(w, http.StatusOK, buildinfo.Get())
+}
+
+// Ready godoc
+// @Summary      Readiness check
+// @Description  checks the users repository answers and the database schema is up to date
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  rest.Message
+// @Failure      503  {object}  rest.Message
+// @Router       /readyz [get]
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	if err := h.userRepository.HealthCheck(r.Context()); err != nil {
+		clog.Error(err, domain.ErrDatabase.Error())
+		rest.RetryLater(w, r, domain.ErrDatabase, http.StatusServiceUnavailable, readyRetryAfter)
+		return
+	}
+
+	version, err := h.healthRepository.SchemaVersion(r.Context())
+	if err != nil {
+		clog.Error(err, domain.ErrSchemaVersion.Error())
+		rest.RetryLater(w, r, domain.ErrSchemaVersion, http.StatusServiceUnavailable, readyRetryAfter)
+		return
+	}
+
+	// A newer schema is fine: it's what runs during a rolling deploy.
+	if version < h.schemaVersion {
+		err := fmt.Errorf(
+			"the database schema is at version %d, expected %d",
+			version,
+			h.schemaVersion,
+		)
+		rest.RetryLater(w, r, err, http.StatusServiceUnavailable, readyRetryAfter)
+		return
+	}
+
+	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Ready"})
+}
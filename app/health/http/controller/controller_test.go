@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"hexagony/app/health/domain/mocks"
+	"hexagony/lib/buildinfo"
+	"hexagony/lib/rest"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewHealthHandler(t *testing.T) {
+	router := chi.NewRouter()
+
+	mockHealthRepo := new(mocks.HealthRepository)
+	mockUserRepo := new(mocks.HealthChecker)
+
+	NewHealthHandler(router, mockHealthRepo, mockUserRepo, 6)
+}
+
+func TestReady(t *testing.T) {
+	cases := []struct {
+		name     string
+		checkErr error
+		version  int
+		err      error
+		expected int
+		message  string
+	}{
+		{"ready", nil, 6, nil, http.StatusOK, "Ready"},
+		{"ahead", nil, 7, nil, http.StatusOK, "Ready"},
+		{"behind", nil, 5, nil, http.StatusServiceUnavailable, "the database schema is at version 5, expected 6"},
+		{"unreachable", errors.New("connection refused"), 0, nil, http.StatusServiceUnavailable, "the database is unreachable"},
+		{"no-migrations", nil, 0, errors.New("table doesn't exist"), http.StatusServiceUnavailable, "failed to read the schema version"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockHealthRepo := new(mocks.HealthRepository)
+			mockUserRepo := new(mocks.HealthChecker)
+
+			mockUserRepo.On("HealthCheck", mock.Anything).Return(c.checkErr)
+			if c.checkErr == nil {
+				mockHealthRepo.On("SchemaVersion", mock.Anything).Return(c.version, c.err)
+			}
+
+			handler := HealthHandler{
+				healthRepository: mockHealthRepo,
+				userRepository:   mockUserRepo,
+				schemaVersion:    6,
+			}
+
+			router := chi.NewRouter()
+
+			req, err := http.NewRequest(http.MethodGet, "/readyz", nil)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.HandleFunc("/readyz", handler.Ready)
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+			if c.expected == http.StatusServiceUnavailable {
+				assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+			} else {
+				assert.Empty(t, rec.Header().Get("Retry-After"))
+			}
+
+			var message rest.Message
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &message))
+			assert.Equal(t, c.message, message.Message)
+
+			mockHealthRepo.AssertExpectations(t)
+			mockUserRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	defer func(version, commit, buildTime string) {
+		buildinfo.Version, buildinfo.Commit, buildinfo.BuildTime = version, commit, buildTime
+	}(buildinfo.Version, buildinfo.Commit, buildinfo.BuildTime)
+
+	buildinfo.Version = "1.4.0"
+	buildinfo.Commit = "2540c70"
+	buildinfo.BuildTime = "2022-07-01T12:00:00Z"
+
+	router := chi.NewRouter()
+	NewHealthHandler(router, new(mocks.HealthRepository), new(mocks.HealthChecker), 6)
+
+	req, err := http.NewRequest(http.MethodGet, "/version", nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var info buildinfo.Info
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, "1.4.0", info.Version)
+	assert.Equal(t, "2540c70", info.Commit)
+	assert.Equal(t, "2022-07-01T12:00:00Z", info.BuildTime)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+}
+
+func TestVersionDefaults(t *testing.T) {
+	info := buildinfo.Get()
+
+	assert.Equal(t, "dev", info.Version)
+	assert.Equal(t, "unknown", info.Commit)
+	assert.Equal(t, "unknown", info.BuildTime)
+}
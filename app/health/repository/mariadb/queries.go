@@ -0,0 +1,3 @@
+package mariadb
+
+const sqlSchemaVersion = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
@@ -0,0 +1,31 @@
+package mariadb
+
+import (
+	"context"
+	"hexagony/app/health/domain"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type mariadbRepository struct {
+	conn *sqlx.DB
+}
+
+func NewMariaDBRepository(conn *sqlx.DB) domain.HealthRepository {
+	return &mariadbRepository{conn}
+}
+
+// SchemaVersion returns the latest applied migration, or 0 if none is.
+func (r *mariadbRepository) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+
+	if err := r.conn.GetContext(
+		ctx,
+		&version,
+		sqlSchemaVersion,
+	); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
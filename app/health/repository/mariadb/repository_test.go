@@ -0,0 +1,38 @@
+package mariadb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "SELECT COALESCE\\(MAX\\(version\\), 0\\) FROM schema_migrations"
+
+	mock.ExpectQuery(query).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(6))
+	mock.ExpectQuery(query).
+		WillReturnError(errors.New("table doesn't exist"))
+
+	healthRepo := NewMariaDBRepository(dbx)
+
+	version, err := healthRepo.SchemaVersion(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 6, version)
+
+	_, err = healthRepo.SchemaVersion(context.TODO())
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/lib/rest"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AcceptMiddleware answers 406 Not Acceptable when the Accept header
+// only lists media types the server can't produce. A missing header,
+// */* and an unparsable header are all accepted.
+func AcceptMiddleware(produces ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !acceptable(r.Header.Get("Accept"), produces) {
+				rest.DecodeError(w, r, errors.New("not acceptable"), http.StatusNotAcceptable)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// acceptable checks if one of the produced media types
+// matches a media range of the Accept header.
+func acceptable(accept string, produces []string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	parsed := 0
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		parsed++
+
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+
+		for _, produced := range produces {
+			if matchMediaType(mediaType, produced) {
+				return true
+			}
+		}
+	}
+
+	return parsed == 0
+}
+
+// matchMediaType checks if the media type falls in the media range,
+// which may be */* or a type/* wildcard.
+func matchMediaType(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+	}
+
+	return false
+}
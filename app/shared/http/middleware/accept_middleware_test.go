@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptMiddleware(t *testing.T) {
+	handler := AcceptMiddleware("application/json", "text/csv")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name     string
+		accept   string
+		expected int
+	}{
+		{"missing", "", http.StatusOK},
+		{"json", "application/json", http.StatusOK},
+		{"json-params", `application/json; profile="envelope"`, http.StatusOK},
+		{"any", "*/*", http.StatusOK},
+		{"browser", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK},
+		{"type-wildcard", "application/*", http.StatusOK},
+		{"csv", "text/csv", http.StatusOK},
+		{"pdf", "application/pdf", http.StatusNotAcceptable},
+		{"pdf-or-xml", "application/pdf, text/xml", http.StatusNotAcceptable},
+		{"json-refused", "application/json;q=0, application/pdf", http.StatusNotAcceptable},
+		{"unparsable", "???", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.accept != "" {
+				req.Header.Set("Accept", c.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+		})
+	}
+}
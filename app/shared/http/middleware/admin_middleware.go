@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/app/users/domain"
+	"hexagony/lib/rest"
+	"net/http"
+)
+
+// AdminMiddleware only lets users with the admin role through.
+// It must run after AuthMiddleware.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := UserClaims(r.Context())
+		if !ok {
+			rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+			return
+		}
+
+		if claims.Role != domain.RoleAdmin {
+			rest.DecodeError(w, r, errors.New("forbidden"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"hexagony/app/users/domain"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	handler := AdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name     string
+		claims   *Claims
+		expected int
+	}{
+		{"admin", &Claims{UUID: uuid.New(), Role: domain.RoleAdmin}, http.StatusOK},
+		{"user", &Claims{UUID: uuid.New(), Role: domain.RoleUser}, http.StatusForbidden},
+		{"anonymous", nil, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), claimsKey, c.claims))
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+		})
+	}
+}
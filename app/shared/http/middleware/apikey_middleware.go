@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	apiKeysDomain "hexagony/app/apikeys/domain"
+	"hexagony/lib/clog"
+	"hexagony/lib/database"
+	"hexagony/lib/rest"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyHeader carries the key of machine-to-machine callers.
+const APIKeyHeader = "X-API-Key"
+
+const serviceKey contextKey = "service"
+
+// Service is the caller authenticated by an API key. It's stored apart
+// from the user Claims, so a handler can tell a service from a user.
+type Service struct {
+	UUID   uuid.UUID
+	Name   string
+	Scopes []string
+}
+
+// ServicePrincipal returns the service stored in the context by APIKeyAuth.
+func ServicePrincipal(ctx context.Context) (*Service, bool) {
+	service, ok := ctx.Value(serviceKey).(*Service)
+	return service, ok
+}
+
+// APIKeyAuth checks the X-API-Key header against the active keys and
+// stores the service it belongs to in the context. Missing, unknown and
+// revoked keys are all answered with 401. Like a token, the key sets
+// the tenant of the request.
+func APIKeyAuth(auc apiKeysDomain.APIKeyUseCase) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+				return
+			}
+
+			apiKey, err := auc.Verify(r.Context(), key)
+			if errors.Is(err, apiKeysDomain.ErrInvalidKey) || errors.Is(err, apiKeysDomain.ErrRevokedKey) {
+				rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				clog.Error(err, "failed to verify the api key")
+				rest.DecodeError(w, r, errors.New("failed to verify the api key"), http.StatusInternalServerError)
+				return
+			}
+
+			ctx := database.WithTenant(r.Context(), apiKey.TenantID)
+			ctx = context.WithValue(ctx, serviceKey, &Service{
+				UUID:   apiKey.UUID,
+				Name:   apiKey.Service,
+				Scopes: apiKey.Scopes,
+			})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope only lets the services granted the scope through, the
+// others are answered with 403. It must run after APIKeyAuth.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			service, ok := ServicePrincipal(r.Context())
+			if !ok {
+				rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+				return
+			}
+
+			if !apiKeysDomain.Scopes(service.Scopes).Has(scope) {
+				rest.DecodeError(w, r, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIKeyOrAuth authenticates the requests carrying an X-API-Key header
+// as a service granted the scope, see APIKeyAuth and RequireScope, and
+// the others with AuthMiddleware.
+func APIKeyOrAuth(auc apiKeysDomain.APIKeyUseCase, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		services := APIKeyAuth(auc)(RequireScope(scope)(next))
+		users := AuthMiddleware(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(APIKeyHeader) != "" {
+				services.ServeHTTP(w, r)
+				return
+			}
+
+			users.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"hexagony/app/apikeys/domain"
+	"hexagony/app/apikeys/domain/mocks"
+	"hexagony/lib/database"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	apiKey := &domain.APIKey{UUID: uuid.New(), TenantID: "acme", Service: "billing", Scopes: domain.Scopes{"users:read"}}
+
+	mockAPIKeyUseCase := new(mocks.APIKeyUseCase)
+
+	mockAPIKeyUseCase.On("Verify", mock.Anything, "hxk_valid").Return(apiKey, nil)
+	mockAPIKeyUseCase.On("Verify", mock.Anything, "hxk_revoked").Return(nil, domain.ErrRevokedKey)
+	mockAPIKeyUseCase.On("Verify", mock.Anything, "hxk_unknown").Return(nil, domain.ErrInvalidKey)
+
+	var service *Service
+	var hasClaims bool
+	var tenant string
+
+	handler := APIKeyAuth(mockAPIKeyUseCase)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service, _ = ServicePrincipal(r.Context())
+		_, hasClaims = UserClaims(r.Context())
+		tenant = database.Tenant(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("hxk_valid"))
+		assert.Equal(t, &Service{UUID: apiKey.UUID, Name: "billing", Scopes: []string{"users:read"}}, service)
+		assert.False(t, hasClaims)
+		assert.Equal(t, "acme", tenant)
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve("hxk_revoked"))
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve("hxk_unknown"))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(""))
+	})
+
+	mockAPIKeyUseCase.AssertExpectations(t)
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := RequireScope("users:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(service *Service) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if service != nil {
+			req = req.WithContext(context.WithValue(req.Context(), serviceKey, service))
+		}
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(&Service{Scopes: []string{"users:write", "users:read"}}))
+	assert.Equal(t, http.StatusForbidden, serve(&Service{Scopes: []string{"users:write"}}))
+	assert.Equal(t, http.StatusUnauthorized, serve(nil))
+}
+
+func TestAPIKeyOrAuth(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	mockAPIKeyUseCase := new(mocks.APIKeyUseCase)
+
+	mockAPIKeyUseCase.On("Verify", mock.Anything, "hxk_reader").
+		Return(&domain.APIKey{UUID: uuid.New(), Service: "billing", Scopes: domain.Scopes{"users:read"}}, nil)
+	mockAPIKeyUseCase.On("Verify", mock.Anything, "hxk_writer").
+		Return(&domain.APIKey{UUID: uuid.New(), Service: "billing", Scopes: domain.Scopes{"users:write"}}, nil)
+
+	handler := APIKeyOrAuth(mockAPIKeyUseCase, "users:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	token := signToken(t, jwt.MapClaims{
+		"id":  uuid.NewString(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	assert.Equal(t, http.StatusOK, serve("Authorization", "Bearer "+token))
+	assert.Equal(t, http.StatusOK, serve(APIKeyHeader, "hxk_reader"))
+	assert.Equal(t, http.StatusForbidden, serve(APIKeyHeader, "hxk_writer"))
+	assert.Equal(t, http.StatusUnauthorized, serve("", ""))
+}
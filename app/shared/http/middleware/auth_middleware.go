@@ -1,59 +1,236 @@
 package middleware
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"hexagony/lib/clog"
+	"hexagony/lib/database"
 	"hexagony/lib/rest"
+	"hexagony/lib/token"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-// AuthMiddleware checks if the request contains Bearer Token
-// on the headers and if it is valid.
+const (
+	claimsKey     contextKey = "claims"
+	cookieAuthKey contextKey = "cookie_auth"
+)
+
+const defaultAuthCookieName = "token"
+
+// Claims represents the authenticated user taken from the token.
+type Claims struct {
+	UUID  uuid.UUID
+	Name  string
+	Email string
+	Role  string
+
+	// ImpersonatedBy is the admin acting as the user, or uuid.Nil.
+	ImpersonatedBy uuid.UUID
+
+	// IssuedAt is when the token was issued, zero if it doesn't say.
+	IssuedAt time.Time
+
+	// TokenVersion is the version of the user the token was issued at.
+	TokenVersion int
+
+	// TenantID is the tenant of the user, empty for the tokens issued
+	// before the tenants, which belong to the default one.
+	TenantID string
+}
+
+// UserClaims returns the claims stored in the context by AuthMiddleware.
+func UserClaims(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// WithClaims returns a copy of the context carrying the claims.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// SessionValidator tells the token version a user is at. Bumping it
+// invalidates the tokens issued with a previous one, and token.ErrRevoked
+// all of them, e.g. once the user is deleted.
+type SessionValidator interface {
+	TokenVersion(ctx context.Context, user uuid.UUID) (int, error)
+}
+
+var sessionValidator SessionValidator
+
+// ValidateSessionsWith makes AuthMiddleware reject the tokens whose
+// version isn't the current one of their user. Call it while setting
+// up the routes, before the server starts.
+func ValidateSessionsWith(v SessionValidator) {
+	sessionValidator = v
+}
+
+// sessionRevoked checks if the token carries an outdated version.
+// Tokens without the claim are at version 0.
+func sessionRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if sessionValidator == nil {
+		return false, nil
+	}
+
+	version, err := sessionValidator.TokenVersion(ctx, claims.UUID)
+	if errors.Is(err, token.ErrRevoked) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return claims.TokenVersion != version, nil
+}
+
+// authExempt holds the route patterns AuthMiddleware serves without
+// a token, so it can be applied broadly and still let public routes
+// through.
+var authExempt = map[string]bool{
+	"/readyz":       true,
+	"/openapi.json": true,
+}
+
+// ExemptFromAuth adds route patterns, e.g. /user/{uuid}/avatar, to the
+// ones served without a token. Call it while setting up the routes,
+// before the server starts.
+func ExemptFromAuth(patterns ...string) {
+	for _, pattern := range patterns {
+		authExempt[pattern] = true
+	}
+}
+
+// authExempted checks if the request is routed to an exempt pattern.
+func authExempted(r *http.Request) bool {
+	return routedTo(r, authExempt)
+}
+
+// AuthMiddleware checks if the request contains a valid token, either
+// as a Bearer Token on the headers or in the auth cookie. The header
+// wins when both are sent. Routes listed in the exemption set are
+// served without one.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authExempted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		// Capturing Authorizathion header.
-		tokenHeader := r.Header.Get("Authorization")
-
-		// Checking if the value is empty.
-		if tokenHeader == "" {
+		jwtString, fromCookie, ok := requestToken(r)
+		if !ok {
 			rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
 			return
 		}
 
-		// Checking if the header contains Bearer string and if the token exists.
-		if !strings.Contains(tokenHeader, "Bearer") || len(strings.Split(tokenHeader, "Bearer ")) == 1 {
-			rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized) // malformed token
+		// Parsing the token to verify its authenticity and validity.
+		mapClaims, err := token.Parse(jwtString)
+		if err != nil {
+			rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
 			return
 		}
 
-		// Capturing the token.
-		jwtString := strings.Split(tokenHeader, "Bearer ")[1]
+		claims, err := parseClaims(mapClaims)
+		if err != nil {
+			rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+			return
+		}
 
-		// Parsing the token to verify its authenticity.
-		token, err := jwt.Parse(jwtString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		// The queries of the request, the session check included, only
+		// see the tenant of the token.
+		ctx := database.WithTenant(r.Context(), claims.TenantID)
 
-		// Returning parsing errors.
+		revoked, err := sessionRevoked(ctx, claims)
 		if err != nil {
-			rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+			clog.Error(err, "failed to validate the session")
+			rest.DecodeError(w, r, errors.New("failed to validate the session"), http.StatusInternalServerError)
 			return
 		}
 
-		// If the token is valid.
-		if token.Valid {
-			next.ServeHTTP(w, r)
-		} else {
+		if revoked {
 			rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
 			return
 		}
+
+		ctx = WithClaims(ctx, claims)
+		if fromCookie {
+			ctx = context.WithValue(ctx, cookieAuthKey, true)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// requestToken returns the token of the request and whether it came
+// from the auth cookie. A malformed Authorization header isn't
+// replaced by the cookie.
+func requestToken(r *http.Request) (string, bool, bool) {
+	// Capturing Authorizathion header.
+	tokenHeader := r.Header.Get("Authorization")
+
+	if tokenHeader == "" {
+		cookie, err := r.Cookie(AuthCookieName())
+		if err != nil || cookie.Value == "" {
+			return "", false, false
+		}
+		return cookie.Value, true, true
+	}
+
+	// Checking if the header contains Bearer string and if the token exists.
+	if !strings.Contains(tokenHeader, "Bearer") || len(strings.Split(tokenHeader, "Bearer ")) == 1 {
+		return "", false, false // malformed token
+	}
+
+	return strings.Split(tokenHeader, "Bearer ")[1], false, true
+}
+
+// AuthCookieName is the cookie carrying the token for web clients,
+// AUTH_COOKIE_NAME or "token" when unset.
+func AuthCookieName() string {
+	return getEnv("AUTH_COOKIE_NAME", defaultAuthCookieName)
+}
+
+// CookieAuth reports whether the request was authenticated by the
+// auth cookie rather than by the Authorization header.
+func CookieAuth(ctx context.Context) bool {
+	fromCookie, _ := ctx.Value(cookieAuthKey).(bool)
+	return fromCookie
+}
+
+// parseClaims extracts the user claims from a validated token.
+func parseClaims(mapClaims jwt.MapClaims) (*Claims, error) {
+	id, _ := mapClaims["id"].(string)
+
+	userUUID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{UUID: userUUID}
+	claims.Name, _ = mapClaims["name"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.Role, _ = mapClaims["role"].(string)
+	claims.TenantID, _ = mapClaims["tenant_id"].(string)
+
+	if iat, ok := mapClaims["iat"].(float64); ok {
+		claims.IssuedAt = time.Unix(int64(iat), 0)
+	}
+
+	if version, ok := mapClaims["token_version"].(float64); ok {
+		claims.TokenVersion = int(version)
+	}
+
+	if impersonatedBy, ok := mapClaims["impersonated_by"].(string); ok {
+		admin, err := uuid.Parse(impersonatedBy)
+		if err != nil {
+			return nil, err
+		}
+		claims.ImpersonatedBy = admin
+	}
+
+	return claims, nil
+}
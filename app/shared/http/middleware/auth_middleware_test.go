@@ -0,0 +1,362 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"hexagony/lib/database"
+	"hexagony/lib/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func signToken(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	assert.NoError(t, err)
+
+	return signed
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	userUUID := uuid.New()
+
+	var claims *Claims
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = UserClaims(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("valid", func(t *testing.T) {
+		token := signToken(t, jwt.MapClaims{
+			"id":    userUUID.String(),
+			"name":  "Cyro Dubeux",
+			"email": "xorycx@gmail.com",
+			"role":  "admin",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, &Claims{
+			UUID:  userUUID,
+			Name:  "Cyro Dubeux",
+			Email: "xorycx@gmail.com",
+			Role:  "admin",
+		}, claims)
+	})
+
+	t.Run("impersonated", func(t *testing.T) {
+		adminUUID := uuid.New()
+
+		token := signToken(t, jwt.MapClaims{
+			"id":              userUUID.String(),
+			"impersonated_by": adminUUID.String(),
+			"exp":             time.Now().Add(time.Minute).Unix(),
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, adminUUID, claims.ImpersonatedBy)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		token := signToken(t, jwt.MapClaims{
+			"id":  userUUID.String(),
+			"exp": time.Now().Add(-time.Minute).Unix(),
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestAuthMiddlewareTransport(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	userUUID := uuid.New()
+
+	var claims *Claims
+	var fromCookie bool
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = UserClaims(r.Context())
+		fromCookie = CookieAuth(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	token := signToken(t, jwt.MapClaims{
+		"id":  userUUID.String(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	t.Run("header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, userUUID, claims.UUID)
+		assert.False(t, fromCookie)
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: defaultAuthCookieName, Value: token})
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, userUUID, claims.UUID)
+		assert.True(t, fromCookie)
+	})
+
+	t.Run("custom cookie", func(t *testing.T) {
+		os.Setenv("AUTH_COOKIE_NAME", "hexagony")
+		defer os.Unsetenv("AUTH_COOKIE_NAME")
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: defaultAuthCookieName, Value: token})
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "hexagony", Value: token})
+		rec = httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("invalid cookie", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: defaultAuthCookieName, Value: "not-a-token"})
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestAuthMiddlewareExempt(t *testing.T) {
+	ExemptFromAuth("/user/{uuid}/avatar")
+	defer delete(authExempt, "/user/{uuid}/avatar")
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := chi.NewRouter()
+	router.Use(AuthMiddleware)
+	router.Get("/readyz", ok)
+	router.Route("/user", func(r chi.Router) {
+		r.Get("/{uuid}", ok)
+		r.Get("/{uuid}/avatar", ok)
+	})
+
+	cases := []struct {
+		path     string
+		expected int
+	}{
+		{"/readyz", http.StatusOK},
+		{"/user/" + uuid.NewString() + "/avatar", http.StatusOK},
+		{"/user/" + uuid.NewString(), http.StatusUnauthorized},
+		{"/user/avatar", http.StatusUnauthorized},
+		{"/missing", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+
+			assert.Equal(t, c.expected, rec.Code)
+		})
+	}
+}
+
+// sessionStore is a SessionValidator keeping the versions in memory,
+// a negative one standing for a deleted user.
+type sessionStore map[uuid.UUID]int
+
+func (s sessionStore) TokenVersion(ctx context.Context, user uuid.UUID) (int, error) {
+	if user == uuid.Nil {
+		return 0, errors.New("connection refused")
+	}
+	if s[user] < 0 {
+		return 0, token.ErrRevoked
+	}
+	return s[user], nil
+}
+
+func TestAuthMiddlewareTokenVersion(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	sessions := sessionStore{}
+	ValidateSessionsWith(sessions)
+	defer ValidateSessionsWith(nil)
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	userUUID := uuid.New()
+
+	token := signToken(t, jwt.MapClaims{
+		"id":            userUUID.String(),
+		"token_version": 0,
+		"exp":           time.Now().Add(time.Minute).Unix(),
+	})
+
+	legacy := signToken(t, jwt.MapClaims{
+		"id":  userUUID.String(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	assert.Equal(t, http.StatusOK, serve(token))
+	assert.Equal(t, http.StatusOK, serve(legacy))
+
+	sessions[userUUID] = 1
+
+	assert.Equal(t, http.StatusUnauthorized, serve(token))
+	assert.Equal(t, http.StatusUnauthorized, serve(legacy))
+
+	t.Run("current-version", func(t *testing.T) {
+		token := signToken(t, jwt.MapClaims{
+			"id":            userUUID.String(),
+			"token_version": 1,
+			"exp":           time.Now().Add(time.Minute).Unix(),
+		})
+
+		assert.Equal(t, http.StatusOK, serve(token))
+	})
+
+	t.Run("other-user", func(t *testing.T) {
+		token := signToken(t, jwt.MapClaims{
+			"id":            uuid.NewString(),
+			"token_version": 0,
+			"exp":           time.Now().Add(time.Minute).Unix(),
+		})
+
+		assert.Equal(t, http.StatusOK, serve(token))
+	})
+
+	t.Run("validator-fails", func(t *testing.T) {
+		token := signToken(t, jwt.MapClaims{
+			"id":  uuid.Nil.String(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+
+		assert.Equal(t, http.StatusInternalServerError, serve(token))
+	})
+
+	t.Run("deleted-user", func(t *testing.T) {
+		deleted := uuid.New()
+		sessions[deleted] = -1
+
+		token := signToken(t, jwt.MapClaims{
+			"id":  deleted.String(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+
+		assert.Equal(t, http.StatusUnauthorized, serve(token))
+	})
+}
+
+func TestAuthMiddlewareTenant(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+	t.Setenv("TENANTS", "acme,globex")
+
+	var tenant string
+
+	handler := TenantMiddleware(AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = database.Tenant(r.Context())
+	})))
+
+	serve := func(token, header string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(TenantHeader, header)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	acme := signToken(t, jwt.MapClaims{
+		"id":        uuid.NewString(),
+		"tenant_id": "acme",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+	})
+
+	legacy := signToken(t, jwt.MapClaims{
+		"id":  uuid.NewString(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	// The header can't move a token to another tenant.
+	serve(acme, "globex")
+	assert.Equal(t, "acme", tenant)
+
+	serve(legacy, "globex")
+	assert.Equal(t, database.DefaultTenant, tenant)
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"hexagony/lib/clog"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// maxLoggedBody bounds the bytes buffered for the log, the larger
+	// bodies reach the handler whole but aren't logged.
+	maxLoggedBody = 64 << 10
+
+	// redacted replaces the values of the sensitive fields.
+	redacted = "[REDACTED]"
+)
+
+var (
+	// alwaysRedactFields are redacted whatever LOG_REDACT_FIELDS says.
+	alwaysRedactFields = []string{"password", "current_password", "token"}
+
+	// defaultRedactFields are redacted too when LOG_REDACT_FIELDS is unset.
+	defaultRedactFields = []string{"email"}
+)
+
+// BodyLoggerMiddleware logs the JSON request bodies when LOG_REQUEST_BODY
+// is "true", for debugging. The values of the credentials and of the
+// fields named in the comma separated LOG_REDACT_FIELDS, matched
+// case-insensitively at any depth, are replaced by [REDACTED]. The bodies that aren't JSON, or are too
+// large to be parsed, are never logged raw, only their size is.
+func BodyLoggerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if os.Getenv("LOG_REQUEST_BODY") != "true" || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedBody+1))
+
+		// The handler reads the buffered bytes, then the rest of the body.
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		if err != nil || len(body) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fields := map[string]interface{}{
+			"message":        "request body",
+			"method":         r.Method,
+			"url":            r.URL.String(),
+			"correlation_id": CorrelationID(r.Context()),
+		}
+
+		switch {
+		case len(body) > maxLoggedBody:
+			fields["body_size"] = "more than 64KiB"
+		case !isJSON(r.Header.Get("Content-Type")):
+			fields["body_size"] = len(body)
+		default:
+			var value interface{}
+			if err := json.Unmarshal(body, &value); err != nil {
+				fields["body_size"] = len(body)
+				fields["body_error"] = "malformed JSON"
+			} else {
+				fields["body"] = redact(value, redactFields())
+			}
+		}
+
+		clog.Custom(fields)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isJSON tells whether the content type is application/json or a +json one.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// redactFields merges LOG_REDACT_FIELDS into the credentials, lowercased.
+func redactFields() map[string]bool {
+	configured := defaultRedactFields
+	if value := os.Getenv("LOG_REDACT_FIELDS"); value != "" {
+		configured = strings.Split(value, ",")
+	}
+
+	fields := make(map[string]bool, len(alwaysRedactFields)+len(configured))
+	for _, name := range append(append([]string{}, alwaysRedactFields...), configured...) {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// redact replaces the values of the sensitive fields of the decoded JSON,
+// in the nested objects and arrays too.
+func redact(value interface{}, fields map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if fields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = redact(field, fields)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item, fields)
+		}
+	}
+	return value
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = logger }()
+
+	var received string
+
+	handler := BodyLoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		received = string(body)
+	}))
+
+	serve := func(contentType, body string) map[string]interface{} {
+		buf.Reset()
+
+		req := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, body, received)
+
+		if buf.Len() == 0 {
+			return nil
+		}
+
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		return entry
+	}
+
+	login := `{"identifier":"cyro","password":"s3cr3t-passw0rd","session":{"Token":"abc.def"},"tags":[{"password":"0ther"}]}`
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Nil(t, serve("application/json", login))
+	})
+
+	t.Setenv("LOG_REQUEST_BODY", "true")
+
+	t.Run("redacted", func(t *testing.T) {
+		entry := serve("application/json; charset=utf-8", login)
+
+		assert.NotContains(t, buf.String(), "s3cr3t-passw0rd")
+		assert.NotContains(t, buf.String(), "abc.def")
+		assert.Equal(t, map[string]interface{}{
+			"identifier": "cyro",
+			"password":   redacted,
+			"session":    map[string]interface{}{"Token": redacted},
+			"tags":       []interface{}{map[string]interface{}{"password": redacted}},
+		}, entry["body"])
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("LOG_REDACT_FIELDS", "password, identifier")
+
+		entry := serve("application/json", login)
+
+		assert.Equal(t, redacted, entry["body"].(map[string]interface{})["identifier"])
+		assert.NotContains(t, buf.String(), "s3cr3t-passw0rd")
+	})
+
+	t.Run("configured without credentials", func(t *testing.T) {
+		t.Setenv("LOG_REDACT_FIELDS", "identifier")
+
+		entry := serve("application/json", login)
+
+		body := entry["body"].(map[string]interface{})
+		assert.Equal(t, redacted, body["identifier"])
+		assert.Equal(t, redacted, body["password"])
+		assert.Equal(t, map[string]interface{}{"Token": redacted}, body["session"])
+		assert.NotContains(t, buf.String(), "s3cr3t-passw0rd")
+		assert.NotContains(t, buf.String(), "abc.def")
+	})
+
+	t.Run("not json", func(t *testing.T) {
+		entry := serve("text/plain", "password=s3cr3t-passw0rd")
+
+		assert.NotContains(t, buf.String(), "s3cr3t-passw0rd")
+		assert.Nil(t, entry["body"])
+		assert.EqualValues(t, 24, entry["body_size"])
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		entry := serve("application/json", `{"password":"s3cr3t-passw0rd"`)
+
+		assert.NotContains(t, buf.String(), "s3cr3t-passw0rd")
+		assert.Equal(t, "malformed JSON", entry["body_error"])
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		large := `{"password":"s3cr3t-passw0rd","padding":"` + strings.Repeat("x", maxLoggedBody) + `"}`
+		entry := serve("application/json", large)
+
+		assert.NotContains(t, buf.String(), "s3cr3t-passw0rd")
+		assert.Nil(t, entry["body"])
+	})
+}
@@ -0,0 +1,15 @@
+//go:build brotli
+
+package middleware
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Brotli is preferred over gzip when the client weighs both the same.
+func init() {
+	encoders["br"] = func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }
+	encodingPreference = append([]string{"br"}, encodingPreference...)
+}
@@ -0,0 +1,44 @@
+//go:build brotli
+
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressMiddlewareBrotli(t *testing.T) {
+	handler := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"Cyro"}`))
+	}))
+
+	t.Run("preferred", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "br", rec.Header().Get("Content-Encoding"))
+
+		body, err := io.ReadAll(brotli.NewReader(rec.Body))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"Cyro"}`, string(body))
+	})
+
+	t.Run("must encode", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "identity;q=0, br")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "br", rec.Header().Get("Content-Encoding"))
+	})
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"errors"
+	"hexagony/lib/rest"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// encoders are the content codings the responses can be compressed
+// with, brotli is added when built with the brotli tag.
+var encoders = map[string]func(io.Writer) io.WriteCloser{
+	"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+}
+
+// encodingPreference breaks the ties between codings of the same
+// q-value, the first one wins.
+var encodingPreference = []string{"gzip"}
+
+// CompressMiddleware compresses the responses with the coding the
+// Accept-Encoding header weighs the most. Without the header, or when
+// identity weighs more, the responses go uncompressed; when identity
+// is refused (identity;q=0 or *;q=0) and no coding the server has is
+// accepted the client gets a 406.
+func CompressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !ok {
+			rest.DecodeError(w, r, errors.New("not acceptable"), http.StatusNotAcceptable)
+			return
+		}
+
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks the coding to compress with, empty for
+// identity. It's false when none of the acceptable codings can be
+// produced.
+func negotiateEncoding(header string) (string, bool) {
+	weights := parseAcceptEncoding(header)
+
+	weight := func(coding string) (float64, bool) {
+		if q, ok := weights[coding]; ok {
+			return q, true
+		}
+		q, ok := weights["*"]
+		return q, ok
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range encodingPreference {
+		if q, _ := weight(coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	// Unless listed, identity is acceptable but loses to any coding.
+	identityQ, listed := weight("identity")
+	if !listed {
+		return best, true
+	}
+
+	if best != "" && bestQ >= identityQ {
+		return best, true
+	}
+
+	return "", identityQ > 0
+}
+
+// parseAcceptEncoding maps the codings of the header to their
+// q-values, 1 when missing. The entries with an invalid q-value are
+// ignored.
+func parseAcceptEncoding(header string) map[string]float64 {
+	weights := make(map[string]float64)
+
+	for _, entry := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(entry, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q, valid := 1.0, true
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				valid = false
+			}
+			q = parsed
+		}
+
+		if valid {
+			weights[coding] = q
+		}
+	}
+
+	return weights
+}
+
+// compressWriter compresses the body once the status is known, unless
+// the response has no body or the handler already encoded it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (rw *compressWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+
+	if code != http.StatusNoContent && code != http.StatusNotModified && code >= http.StatusOK &&
+		rw.Header().Get("Content-Encoding") == "" {
+		rw.Header().Set("Content-Encoding", rw.encoding)
+		rw.Header().Del("Content-Length")
+		rw.encoder = encoders[rw.encoding](rw.ResponseWriter)
+	}
+
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *compressWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.encoder == nil {
+		return rw.ResponseWriter.Write(b)
+	}
+	return rw.encoder.Write(b)
+}
+
+// Flush pushes what the encoder holds so the streamed responses keep
+// flushing.
+func (rw *compressWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := rw.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes the end of the compressed body.
+func (rw *compressWriter) Close() error {
+	if rw.encoder == nil {
+		return nil
+	}
+	return rw.encoder.Close()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *compressWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withBrotli registers a stand-in br coding, preferred over gzip, for
+// the duration of the test, unless built with the brotli tag.
+func withBrotli(t *testing.T) {
+	if _, ok := encoders["br"]; ok {
+		return
+	}
+
+	savedEncoders, savedPreference := encoders, encodingPreference
+	t.Cleanup(func() { encoders, encodingPreference = savedEncoders, savedPreference })
+
+	encoders = map[string]func(io.Writer) io.WriteCloser{"br": savedEncoders["gzip"]}
+	for coding, encoder := range savedEncoders {
+		encoders[coding] = encoder
+	}
+	encodingPreference = []string{"br", "gzip"}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	withBrotli(t)
+
+	cases := map[string]struct {
+		header   string
+		encoding string
+		ok       bool
+	}{
+		"missing":                 {"", "", true},
+		"gzip":                    {"gzip", "gzip", true},
+		"preference":              {"gzip, br", "br", true},
+		"q-values":                {"br;q=0.5, gzip;q=0.8", "gzip", true},
+		"case":                    {"GZIP;Q=0.9", "gzip", true},
+		"unsupported":             {"zstd", "", true},
+		"refused":                 {"br;q=0, gzip;q=0", "", true},
+		"wildcard":                {"*", "br", true},
+		"wildcard with exclusion": {"br;q=0, *;q=0.5", "gzip", true},
+		"identity preferred":      {"gzip;q=0.5, identity", "", true},
+		"identity tie":            {"gzip, identity", "gzip", true},
+		"invalid q-value":         {"br;q=high, gzip", "gzip", true},
+		"identity refused":        {"identity;q=0, gzip;q=0.1", "gzip", true},
+		"must encode":             {"identity;q=0", "", false},
+		"must encode unsupported": {"identity;q=0, zstd", "", false},
+		"nothing acceptable":      {"*;q=0", "", false},
+		"identity allowed":        {"*;q=0, identity", "", true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			encoding, ok := negotiateEncoding(c.header)
+
+			assert.Equal(t, c.encoding, encoding)
+			assert.Equal(t, c.ok, ok)
+		})
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	handler := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "16")
+		w.Write([]byte(`{"name":"Cyro"}` + "\n"))
+	}))
+
+	serve := func(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		rec := serve(handler, "br;q=0.2, gzip;q=0.9")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		assert.Empty(t, rec.Header().Get("Content-Length"))
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+
+		reader, err := gzip.NewReader(rec.Body)
+		assert.NoError(t, err)
+		body, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"Cyro"}`+"\n", string(body))
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		rec := serve(handler, "")
+
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "16", rec.Header().Get("Content-Length"))
+		assert.Equal(t, `{"name":"Cyro"}`+"\n", rec.Body.String())
+	})
+
+	t.Run("must encode", func(t *testing.T) {
+		rec := serve(handler, "identity;q=0, zstd")
+
+		assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		empty := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		rec := serve(empty, "gzip")
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("already encoded", func(t *testing.T) {
+		encoded := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte("compressed"))
+		}))
+
+		rec := serve(encoded, "gzip")
+
+		assert.Equal(t, "br", rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "compressed", rec.Body.String())
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		streaming := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("first line\n"))
+			w.(http.Flusher).Flush()
+
+			reader, err := gzip.NewReader(w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder).Body)
+			assert.NoError(t, err)
+			line := make([]byte, len("first line\n"))
+			_, err = io.ReadFull(reader, line)
+			assert.NoError(t, err)
+			assert.Equal(t, "first line\n", string(line))
+		}))
+
+		rec := serve(streaming, "gzip")
+
+		assert.True(t, rec.Flushed)
+	})
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"hexagony/lib/database"
+	"hexagony/lib/idgen"
+	"hexagony/lib/rest"
+	"net/http"
+	"regexp"
+)
+
+// CorrelationHeader carries the id tying together the requests of a flow.
+const CorrelationHeader = "X-Correlation-ID"
+
+const correlationKey contextKey = "correlation_id"
+
+// validCorrelationID restricts client ids to safe characters for
+// headers and logs, with a bounded length.
+var validCorrelationID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// CorrelationMiddleware reads the X-Correlation-ID header, generating
+// a new id when it's missing or invalid, stores it in the context, for
+// the logs, the query comments and the response meta, and echoes it
+// in the response.
+func CorrelationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationHeader)
+		if !validCorrelationID.MatchString(id) {
+			id = idgen.New().String()
+		}
+
+		w.Header().Set(CorrelationHeader, id)
+
+		ctx := context.WithValue(r.Context(), correlationKey, id)
+		ctx = database.WithRequestID(ctx, id)
+		ctx = rest.WithRequestID(ctx, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CorrelationID returns the correlation id of the request, to be
+// logged and forwarded on outbound calls.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey).(string)
+	return id
+}
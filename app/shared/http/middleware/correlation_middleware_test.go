@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"hexagony/lib/rest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationMiddleware(t *testing.T) {
+	var correlationID string
+
+	handler := CorrelationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationID = CorrelationID(r.Context())
+	}))
+
+	t.Run("client", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(CorrelationHeader, "checkout-42")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "checkout-42", correlationID)
+		assert.Equal(t, "checkout-42", rec.Header().Get(CorrelationHeader))
+	})
+
+	for name, header := range map[string]string{
+		"missing":  "",
+		"invalid":  "bad id\r\n",
+		"too-long": strings.Repeat("a", 129),
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if header != "" {
+				req.Header.Set(CorrelationHeader, header)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			_, err := uuid.Parse(correlationID)
+			assert.NoError(t, err)
+			assert.Equal(t, correlationID, rec.Header().Get(CorrelationHeader))
+		})
+	}
+}
+
+func TestCorrelationMiddlewareLogs(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = logger }()
+
+	handler := CorrelationMiddleware(LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set(CorrelationHeader, "checkout-42")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+
+	assert.Equal(t, "checkout-42", entry["correlation_id"])
+	assert.Equal(t, "checkout-42", rec.Header().Get(CorrelationHeader))
+}
+
+func TestCorrelationMiddlewareMeta(t *testing.T) {
+	t.Setenv("RESPONSE_META", "true")
+
+	handler := CorrelationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest.JSONResource(w, r, http.StatusOK, map[string]string{"name": "foo"})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set(CorrelationHeader, "checkout-42")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	var envelope struct {
+		Meta rest.Meta `json:"meta"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &envelope))
+
+	assert.Equal(t, "checkout-42", envelope.Meta.RequestID)
+}
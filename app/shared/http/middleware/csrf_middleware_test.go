@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	handler := CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cookieRequest := func(method string) *http.Request {
+		req := httptest.NewRequest(method, "/user", nil)
+		req.AddCookie(&http.Cookie{Name: defaultAuthCookieName, Value: "token"})
+		return req
+	}
+
+	t.Run("issue", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodGet, "/user", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		cookies := rec.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, CSRFCookie, cookies[0].Name)
+		assert.Len(t, cookies[0].Value, 64)
+		assert.False(t, cookies[0].HttpOnly)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		req := cookieRequest(http.MethodPost)
+		req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "csrf"})
+		req.Header.Set(CSRFHeader, "csrf")
+
+		rec := serve(req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Result().Cookies())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		req := cookieRequest(http.MethodPost)
+		req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "csrf"})
+
+		assert.Equal(t, http.StatusForbidden, serve(req).Code)
+
+		assert.Equal(t, http.StatusForbidden, serve(cookieRequest(http.MethodDelete)).Code)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		req := cookieRequest(http.MethodPut)
+		req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "csrf"})
+		req.Header.Set(CSRFHeader, "other")
+
+		assert.Equal(t, http.StatusForbidden, serve(req).Code)
+	})
+
+	t.Run("read", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(cookieRequest(http.MethodGet)).Code)
+	})
+
+	t.Run("bearer", func(t *testing.T) {
+		req := cookieRequest(http.MethodPost)
+		req.Header.Set("Authorization", "Bearer token")
+
+		rec := serve(req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Result().Cookies())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		os.Setenv("CSRF_PROTECTION", "false")
+		defer os.Unsetenv("CSRF_PROTECTION")
+
+		rec := serve(cookieRequest(http.MethodPost))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Result().Cookies())
+	})
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/lib/rest"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var errDraining = errors.New("shutting down, try another instance")
+
+// drainRetryAfter is short, a retry reaches another instance.
+const drainRetryAfter = time.Second
+
+// draining is 1 once the instance is shutting down.
+var draining int32
+
+// SetDraining marks the instance as shutting down, or not.
+func SetDraining(on bool) {
+	var value int32
+	if on {
+		value = 1
+	}
+	atomic.StoreInt32(&draining, value)
+}
+
+// Draining reports whether the instance is shutting down.
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// DrainMiddleware answers 503 while the instance is draining. It guards
+// the routes issuing tokens, so clients don't get a token from an
+// instance about to go away, while the other requests are still served.
+func DrainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Draining() {
+			rest.RetryLater(w, r, errDraining, http.StatusServiceUnavailable, drainRetryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainMiddleware(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := chi.NewRouter()
+	router.With(DrainMiddleware).Post("/auth", ok)
+	router.Get("/user", ok)
+	router.Post("/user", ok)
+
+	serve := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/auth"))
+
+	SetDraining(true)
+	defer SetDraining(false)
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPost, "/auth"))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth", nil))
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/user"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/user"))
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"hexagony/lib/flags"
+	"net/http"
+)
+
+// FeatureMiddleware answers 404 while the flag is off, as if the route
+// wasn't mounted. Put it first so the disabled routes don't ask for a
+// token either.
+func FeatureMiddleware(flag string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !flags.Enabled(flag) {
+				http.NotFound(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
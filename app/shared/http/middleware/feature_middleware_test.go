@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"hexagony/lib/flags"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureMiddleware(t *testing.T) {
+	defer flags.Reset("on-feature")
+	defer flags.Reset("off-feature")
+
+	flags.Define("on-feature", true)
+	flags.Define("off-feature", false)
+
+	router := chi.NewRouter()
+	router.With(FeatureMiddleware("on-feature")).Post("/on", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.With(FeatureMiddleware("off-feature"), AuthMiddleware).Post("/off", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec
+	}
+
+	t.Run("flagged on", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, serve("/on").Code)
+	})
+
+	t.Run("flagged off", func(t *testing.T) {
+		rec := serve("/off")
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, serve("/unmounted").Body.String(), rec.Body.String())
+	})
+
+	t.Run("runtime toggle", func(t *testing.T) {
+		flags.Set("on-feature", false)
+		assert.Equal(t, http.StatusNotFound, serve("/on").Code)
+
+		flags.Set("on-feature", true)
+		assert.Equal(t, http.StatusCreated, serve("/on").Code)
+	})
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/lib/rest"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The checks of HeaderMiddleware, see HEADER_STRICTNESS.
+const (
+	HeaderStrictnessOff    = "off"
+	HeaderStrictnessNormal = "normal"
+	HeaderStrictnessStrict = "strict"
+)
+
+// singletonHeaders may only be sent once in strict mode, a proxy and
+// the server could each pick a different copy.
+var singletonHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	APIKeyHeader,
+	TenantHeader,
+}
+
+// HeaderMiddleware answers 400 to the requests whose headers a proxy
+// in front could read differently than the server, the signs of a
+// request smuggling attempt. HEADER_STRICTNESS=normal, the default,
+// refuses the repeated or invalid Content-Length, a Content-Length
+// next to a Transfer-Encoding and any Transfer-Encoding but a single
+// chunked; strict also refuses the repeated singletonHeaders and the
+// header names with an underscore, which some proxies turn into
+// dashes. off disables the checks.
+func HeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := checkHeaders(r, headerStrictness()); err != nil {
+			rest.DecodeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func headerStrictness() string {
+	switch strictness := os.Getenv("HEADER_STRICTNESS"); strictness {
+	case HeaderStrictnessOff, HeaderStrictnessStrict:
+		return strictness
+	default:
+		return HeaderStrictnessNormal
+	}
+}
+
+// checkHeaders returns the first anomaly of the headers at the given
+// strictness.
+func checkHeaders(r *http.Request, strictness string) error {
+	if strictness == HeaderStrictnessOff {
+		return nil
+	}
+
+	contentLength := r.Header.Values("Content-Length")
+	if len(contentLength) > 1 || strings.Contains(strings.Join(contentLength, ""), ",") {
+		return errors.New("duplicate content-length")
+	}
+	if len(contentLength) == 1 && !digits(strings.TrimSpace(contentLength[0])) {
+		return errors.New("invalid content-length")
+	}
+
+	// The server parses Transfer-Encoding into the request and drops
+	// the header, both are looked at.
+	transferEncoding := append([]string{}, r.TransferEncoding...)
+	for _, value := range r.Header.Values("Transfer-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			transferEncoding = append(transferEncoding, strings.TrimSpace(coding))
+		}
+	}
+	if len(transferEncoding) > 0 {
+		if len(contentLength) > 0 {
+			return errors.New("content-length with transfer-encoding")
+		}
+		if len(transferEncoding) > 1 || !strings.EqualFold(transferEncoding[0], "chunked") {
+			return errors.New("unsupported transfer-encoding")
+		}
+	}
+
+	if strictness != HeaderStrictnessStrict {
+		return nil
+	}
+
+	for _, name := range singletonHeaders {
+		if len(r.Header.Values(name)) > 1 {
+			return errors.New("duplicate " + strings.ToLower(name))
+		}
+	}
+
+	for name := range r.Header {
+		if strings.Contains(name, "_") {
+			return errors.New("invalid header name")
+		}
+	}
+
+	return nil
+}
+
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderMiddleware(t *testing.T) {
+	handler := HeaderMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(headers http.Header, transferEncoding ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{}`))
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+		req.TransferEncoding = transferEncoding
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("normal request", func(t *testing.T) {
+		rec := serve(http.Header{
+			"Content-Length": {"2"},
+			"Content-Type":   {"application/json"},
+			"Authorization":  {"Bearer token"},
+		})
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(nil, "chunked").Code)
+	})
+
+	t.Run("duplicate content-length", func(t *testing.T) {
+		rec := serve(http.Header{"Content-Length": {"2", "2"}})
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "duplicate content-length")
+
+		rec = serve(http.Header{"Content-Length": {"2, 40"}})
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid content-length", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, serve(http.Header{"Content-Length": {"+2"}}).Code)
+	})
+
+	t.Run("content-length with transfer-encoding", func(t *testing.T) {
+		rec := serve(http.Header{"Content-Length": {"2"}}, "chunked")
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("conflicting transfer-encoding", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, serve(nil, "gzip", "chunked").Code)
+		assert.Equal(t, http.StatusBadRequest, serve(http.Header{"Transfer-Encoding": {"chunked", "identity"}}).Code)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		duplicate := http.Header{"Authorization": {"Bearer a", "Bearer b"}}
+		underscore := http.Header{"X_tenant_id": {"acme"}}
+
+		assert.Equal(t, http.StatusOK, serve(duplicate).Code)
+		assert.Equal(t, http.StatusOK, serve(underscore).Code)
+
+		t.Setenv("HEADER_STRICTNESS", HeaderStrictnessStrict)
+
+		rec := serve(duplicate)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "duplicate authorization")
+		assert.Equal(t, http.StatusBadRequest, serve(underscore).Code)
+		assert.Equal(t, http.StatusOK, serve(http.Header{"Content-Length": {"2"}}).Code)
+	})
+
+	t.Run("off", func(t *testing.T) {
+		t.Setenv("HEADER_STRICTNESS", HeaderStrictnessOff)
+
+		assert.Equal(t, http.StatusOK, serve(http.Header{"Content-Length": {"2", "3"}}).Code)
+	})
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/lib/rest"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var errHTTPSRequired = errors.New("https is required")
+
+// httpsExempt lists the route patterns served over plain http too,
+// like the health check of a load balancer.
+var httpsExempt = map[string]bool{}
+
+// ExemptFromHTTPS adds route patterns, e.g. /readyz, to the ones served
+// over plain http when FORCE_HTTPS is enabled. Call it while setting up
+// the routes, before the server starts.
+func ExemptFromHTTPS(patterns ...string) {
+	for _, pattern := range patterns {
+		httpsExempt[pattern] = true
+	}
+}
+
+// HTTPSMiddleware sends the plain http requests to https when
+// FORCE_HTTPS is enabled: GET and HEAD are redirected with a 301, the
+// other methods with a 308 so the body is sent again, or rejected with
+// a 403 when FORCE_HTTPS=reject, since their body already crossed the
+// network in the clear. A request is secure when it came over TLS, or
+// through a trusted proxy sending X-Forwarded-Proto: https.
+func HTTPSMiddleware(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mode := os.Getenv("FORCE_HTTPS")
+			if (mode != "true" && mode != "reject") || secure(r, trusted) || routedTo(r, httpsExempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			target := "https://" + r.Host + r.URL.RequestURI()
+
+			switch {
+			case r.Method == http.MethodGet || r.Method == http.MethodHead:
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+			case mode == "reject":
+				rest.DecodeError(w, r, errHTTPSRequired, http.StatusForbidden)
+			default:
+				http.Redirect(w, r, target, http.StatusPermanentRedirect)
+			}
+		})
+	}
+}
+
+// secure checks if the request came over TLS, to the server or to the
+// trusted proxy in front of it.
+func secure(r *http.Request, trusted []*net.IPNet) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if !isTrusted(peer, trusted) {
+		return false
+	}
+
+	// The closest proxy appends the last value.
+	protos := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(protos[len(protos)-1]), "https")
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSMiddleware(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.1")
+	assert.NoError(t, err)
+
+	ExemptFromHTTPS("/readyz")
+	defer delete(httpsExempt, "/readyz")
+
+	router := chi.NewRouter()
+	router.Use(HTTPSMiddleware(trusted))
+	router.Get("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.Post("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	os.Setenv("FORCE_HTTPS", "true")
+	defer os.Unsetenv("FORCE_HTTPS")
+
+	t.Run("redirect", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodGet, "http://api.example.com/user?page=2", nil))
+
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "https://api.example.com/user?page=2", rec.Header().Get("Location"))
+	})
+
+	t.Run("redirect write", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodPost, "http://api.example.com/user", strings.NewReader("{}")))
+
+		assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+		assert.Equal(t, "https://api.example.com/user", rec.Header().Get("Location"))
+	})
+
+	t.Run("reject write", func(t *testing.T) {
+		os.Setenv("FORCE_HTTPS", "reject")
+		defer os.Setenv("FORCE_HTTPS", "true")
+
+		rec := serve(httptest.NewRequest(http.MethodPost, "http://api.example.com/user", strings.NewReader("{}")))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Empty(t, rec.Header().Get("Location"))
+
+		rec = serve(httptest.NewRequest(http.MethodGet, "http://api.example.com/user", nil))
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "https://api.example.com/user", nil)
+		req.TLS = &tls.ConnectionState{}
+
+		assert.Equal(t, http.StatusOK, serve(req).Code)
+	})
+
+	t.Run("behind proxy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "http://api.example.com/user", nil)
+		req.RemoteAddr = "10.0.0.1:4242"
+		req.Header.Set("X-Forwarded-Proto", "https")
+
+		assert.Equal(t, http.StatusCreated, serve(req).Code)
+	})
+
+	t.Run("behind proxy over http", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://api.example.com/user", nil)
+		req.RemoteAddr = "10.0.0.1:4242"
+		req.Header.Set("X-Forwarded-Proto", "https, http")
+
+		assert.Equal(t, http.StatusMovedPermanently, serve(req).Code)
+	})
+
+	t.Run("untrusted proxy", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://api.example.com/user", nil)
+		req.RemoteAddr = "203.0.113.7:4242"
+		req.Header.Set("X-Forwarded-Proto", "https")
+
+		assert.Equal(t, http.StatusMovedPermanently, serve(req).Code)
+	})
+
+	t.Run("exempt", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodGet, "http://api.example.com/readyz", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		os.Unsetenv("FORCE_HTTPS")
+		defer os.Setenv("FORCE_HTTPS", "true")
+
+		rec := serve(httptest.NewRequest(http.MethodGet, "http://api.example.com/user", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hexagony/lib/cache"
+	"hexagony/lib/database"
+	"hexagony/lib/rest"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader names the key a client sends to retry a
+	// request safely, the response of the first one being replayed.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is "true" on the replayed responses.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// defaultIdempotencyTTL is how long responses are kept when
+	// IDEMPOTENCY_TTL is unset.
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	// maxIdempotentBody is the largest body kept for a replay, and the
+	// largest request body hashed to tell the reused keys.
+	maxIdempotentBody = 1 << 20
+)
+
+var (
+	errIdempotencyInProgress = errors.New("a request with this idempotency key is in progress, try again later")
+	errIdempotencyKeyReused  = errors.New("the idempotency key was used with another request body")
+)
+
+// idempotentResponse is a response recorded for its replays, along
+// with the hash of the request body it answered.
+type idempotentResponse struct {
+	request string
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+// IdempotencyMiddleware replays the response of the first request to the
+// later requests carrying the same Idempotency-Key, status, headers and
+// body verbatim, so a retried create answers the original 201 and its
+// Location instead of creating another resource. A duplicate arriving
+// while the first one runs gets 409 with a Retry-After, and a key
+// reused with another body 422.
+//
+// The keys are scoped to the tenant, the caller, the method and the
+// path, and the responses kept for IDEMPOTENCY_TTL, 24h by default, in
+// memory, so each instance replays its own. Server errors aren't kept,
+// the request can be retried with the same key. Requests without a key,
+// or with a body over 1 MiB, are served as usual.
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	ttl := defaultIdempotencyTTL
+	if parsed, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL")); err == nil && parsed >= 0 {
+		ttl = parsed
+	}
+
+	var (
+		responses = cache.New(ttl)
+		mu        sync.Mutex
+		inFlight  = map[string]string{}
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || ttl == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		request, ok := hashBody(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key = idempotencyScope(r, key)
+
+		mu.Lock()
+		recorded, ok := responses.Get(key)
+		running, isRunning := inFlight[key]
+		if !ok && !isRunning {
+			inFlight[key] = request
+		}
+		mu.Unlock()
+
+		if ok {
+			if recorded.(*idempotentResponse).request != request {
+				rest.DecodeError(w, r, errIdempotencyKeyReused, http.StatusUnprocessableEntity)
+				return
+			}
+			replay(w, recorded.(*idempotentResponse))
+			return
+		}
+
+		if isRunning {
+			if running != request {
+				rest.DecodeError(w, r, errIdempotencyKeyReused, http.StatusUnprocessableEntity)
+				return
+			}
+			rest.RetryLater(w, r, errIdempotencyInProgress, http.StatusConflict, time.Second)
+			return
+		}
+
+		recorder := &idempotencyWriter{ResponseWriter: w, before: w.Header().Clone()}
+
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if recorder.status != 0 && recorder.status < http.StatusInternalServerError && !recorder.tooLarge {
+				responses.Set(key, &idempotentResponse{
+					request: request,
+					status:  recorder.status,
+					header:  recorder.header,
+					body:    recorder.body.Bytes(),
+				})
+			}
+			delete(inFlight, key)
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// idempotencyScope keys the client key by tenant, caller, method and
+// path, so a key can only replay the responses of its own caller.
+func idempotencyScope(r *http.Request, key string) string {
+	return database.Tenant(r.Context()) + " " + idempotencyPrincipal(r) + " " +
+		r.Method + " " + r.URL.Path + " " + key
+}
+
+// idempotencyPrincipal names the caller: the user of the token, the
+// service of the API key, or for the routes not authenticated yet the
+// hash of the credentials sent, the token cookie included. Anonymous
+// callers are told apart by their IP.
+func idempotencyPrincipal(r *http.Request) string {
+	if claims, ok := UserClaims(r.Context()); ok {
+		return "user:" + claims.UUID.String() + "/" + claims.ImpersonatedBy.String()
+	}
+
+	if service, ok := ServicePrincipal(r.Context()); ok {
+		return "service:" + service.UUID.String()
+	}
+
+	credentials := r.Header.Get("Authorization") + "\n" + r.Header.Get(APIKeyHeader)
+	if cookie, err := r.Cookie(AuthCookieName()); err == nil {
+		credentials += "\n" + cookie.Value
+	}
+	if credentials != "\n" {
+		hash := sha256.Sum256([]byte(credentials))
+		return "credentials:" + hex.EncodeToString(hash[:])
+	}
+
+	ip := ClientIP(r.Context())
+	if ip == "" {
+		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	return "anonymous:" + ip
+}
+
+// hashBody hashes the request body, which is put back for the handler.
+// It's false when the body is too large to be hashed.
+func hashBody(r *http.Request) (string, bool) {
+	if r.Body == nil {
+		hash := sha256.Sum256(nil)
+		return hex.EncodeToString(hash[:]), true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil || len(body) > maxIdempotentBody {
+		return "", false
+	}
+
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:]), true
+}
+
+// replay writes the recorded response. The headers already set by the
+// middlewares running before, e.g. the correlation id, are this
+// request's own and kept.
+func replay(w http.ResponseWriter, recorded *idempotentResponse) {
+	for name, values := range recorded.header {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+
+	w.WriteHeader(recorded.status)
+	if _, err := w.Write(recorded.body); err != nil {
+		return
+	}
+}
+
+// idempotencyWriter records the status, the headers the handler set and
+// the body while writing them through.
+type idempotencyWriter struct {
+	http.ResponseWriter
+	before   http.Header
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (rw *idempotencyWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+		rw.header = http.Header{}
+		for name, values := range rw.Header() {
+			if _, ok := rw.before[name]; !ok {
+				rw.header[name] = append([]string(nil), values...)
+			}
+		}
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *idempotencyWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !rw.tooLarge {
+		if rw.body.Len()+len(b) > maxIdempotentBody {
+			rw.tooLarge = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(b)
+		}
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush keeps the streamed responses flushing.
+func (rw *idempotencyWriter) Flush() {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *idempotencyWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	var created int32
+
+	handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&created, 1)
+
+		w.Header().Set("Location", "/user/"+string(rune('0'+n)))
+		w.WriteHeader(http.StatusCreated)
+		if _, err := w.Write([]byte(`{"id":"` + string(rune('0'+n)) + `"}`)); err != nil {
+			return
+		}
+	}))
+
+	serve := func(key, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/user", nil)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		rec.Header().Set(CorrelationHeader, key+authorization)
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve("key-1", "Bearer a")
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, "/user/1", first.Header().Get("Location"))
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+	replayed := serve("key-1", "Bearer a")
+	assert.Equal(t, http.StatusCreated, replayed.Code)
+	assert.Equal(t, "/user/1", replayed.Header().Get("Location"))
+	assert.Equal(t, `{"id":"1"}`, replayed.Body.String())
+	assert.Equal(t, "true", replayed.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created), "the replay doesn't create again")
+
+	assert.Equal(t, "/user/2", serve("key-2", "Bearer a").Header().Get("Location"), "another key")
+	assert.Equal(t, "/user/3", serve("key-1", "Bearer b").Header().Get("Location"), "other credentials")
+	assert.Equal(t, "/user/4", serve("", "Bearer a").Header().Get("Location"), "no key")
+	assert.Equal(t, "/user/5", serve("", "Bearer a").Header().Get("Location"), "no key")
+
+	t.Run("server error", func(t *testing.T) {
+		var calls int32
+
+		handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodPost, "/user", nil)
+			req.Header.Set(IdempotencyKeyHeader, "key")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		}
+
+		assert.Equal(t, int32(2), calls, "the failed request can be retried")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		os.Setenv("IDEMPOTENCY_TTL", "0")
+		defer os.Unsetenv("IDEMPOTENCY_TTL")
+
+		var calls int32
+
+		handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodPost, "/user", nil)
+			req.Header.Set(IdempotencyKeyHeader, "key")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		assert.Equal(t, int32(2), calls)
+	})
+}
+
+func TestIdempotencyMiddlewareInProgress(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	request := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/user", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		return req
+	}
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(first, request())
+		close(done)
+	}()
+
+	<-started
+
+	duplicate := httptest.NewRecorder()
+	handler.ServeHTTP(duplicate, request())
+	assert.Equal(t, http.StatusConflict, duplicate.Code)
+	assert.Equal(t, "1", duplicate.Header().Get("Retry-After"))
+
+	close(release)
+	<-done
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	// Once the first one is over, the duplicates get its response.
+	replayed := httptest.NewRecorder()
+	handler.ServeHTTP(replayed, request())
+	assert.Equal(t, http.StatusCreated, replayed.Code)
+	assert.Equal(t, "true", replayed.Header().Get(IdempotentReplayedHeader))
+}
+
+func TestIdempotencyMiddlewareScope(t *testing.T) {
+	var created int32
+
+	handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		n := atomic.AddInt32(&created, 1)
+		w.WriteHeader(http.StatusCreated)
+		if _, err := w.Write([]byte(string(rune('0'+n)) + " " + string(body))); err != nil {
+			return
+		}
+	}))
+
+	serve := func(prepare func(r *http.Request) *http.Request, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		req = prepare(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	user := func(id uuid.UUID) func(r *http.Request) *http.Request {
+		return func(r *http.Request) *http.Request {
+			r.AddCookie(&http.Cookie{Name: AuthCookieName(), Value: "cookie-token"})
+			return r.WithContext(WithClaims(r.Context(), &Claims{UUID: id}))
+		}
+	}
+
+	anonymous := func(ip string) func(r *http.Request) *http.Request {
+		return func(r *http.Request) *http.Request {
+			r.RemoteAddr = ip + ":4242"
+			return r
+		}
+	}
+
+	t.Run("users", func(t *testing.T) {
+		alice, bob := uuid.New(), uuid.New()
+
+		first := serve(user(alice), `{"name":"alice"}`)
+		assert.Equal(t, http.StatusCreated, first.Code)
+
+		replayed := serve(user(alice), `{"name":"alice"}`)
+		assert.Equal(t, first.Body.String(), replayed.Body.String())
+		assert.Equal(t, "true", replayed.Header().Get(IdempotentReplayedHeader))
+
+		other := serve(user(bob), `{"name":"alice"}`)
+		assert.NotEqual(t, first.Body.String(), other.Body.String(), "another user with the same cookie name")
+		assert.Empty(t, other.Header().Get(IdempotentReplayedHeader))
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		first := serve(anonymous("192.0.2.1"), `{"email":"john@doe.com"}`)
+		other := serve(anonymous("192.0.2.2"), `{"email":"john@doe.com"}`)
+
+		assert.NotEqual(t, first.Body.String(), other.Body.String())
+		assert.Empty(t, other.Header().Get(IdempotentReplayedHeader))
+	})
+
+	t.Run("other body", func(t *testing.T) {
+		first := serve(anonymous("192.0.2.3"), `{"email":"john@doe.com"}`)
+		assert.Equal(t, http.StatusCreated, first.Code)
+
+		reused := serve(anonymous("192.0.2.3"), `{"email":"jane@doe.com"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+		assert.Contains(t, reused.Body.String(), "another request body")
+	})
+}
@@ -7,17 +7,23 @@ import (
 
 func LoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteIP := ClientIP(r.Context())
+		if remoteIP == "" {
+			remoteIP = r.RemoteAddr
+		}
+
+		next.ServeHTTP(w, r)
 
 		clog.Custom(map[string]interface{}{
-			"host":      r.Host,
-			"method":    r.Method,
-			"url":       r.URL.String(),
-			"agent":     r.UserAgent(),
-			"referer":   r.Referer(),
-			"proto":     r.Proto,
-			"remote_ip": r.RemoteAddr,
+			"host":           r.Host,
+			"method":         r.Method,
+			"url":            r.URL.String(),
+			"agent":          r.UserAgent(),
+			"referer":        r.Referer(),
+			"proto":          r.Proto,
+			"remote_ip":      remoteIP,
+			"route":          RoutePattern(r.Context()),
+			"correlation_id": CorrelationID(r.Context()),
 		})
-
-		next.ServeHTTP(w, r)
 	})
 }
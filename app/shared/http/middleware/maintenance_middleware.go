@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/lib/rest"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRetryAfter is the Retry-After sent with the writes refused
+// during maintenance. MAINTENANCE_RETRY_AFTER overrides it, in seconds.
+const defaultRetryAfter = 120 * time.Second
+
+var errMaintenance = errors.New("under maintenance, try again later")
+
+// maintenanceBypass lists the paths served even for writes during
+// maintenance, so probes keep working and the mode can be turned off.
+var maintenanceBypass = map[string]bool{
+	"/readyz":            true,
+	"/admin/maintenance": true,
+}
+
+// maintenance is 1 while in maintenance mode. It's read on every
+// request, so it can be flipped at runtime without a restart.
+var maintenance int32
+
+// SetMaintenance turns maintenance mode on or off.
+func SetMaintenance(on bool) {
+	var value int32
+	if on {
+		value = 1
+	}
+	atomic.StoreInt32(&maintenance, value)
+}
+
+// Maintenance reports whether maintenance mode is on.
+func Maintenance() bool {
+	return atomic.LoadInt32(&maintenance) == 1
+}
+
+// MaintenanceMiddleware answers 503 with a Retry-After to the mutating
+// requests (POST, PUT, PATCH and DELETE) while maintenance mode is on.
+// Reads keep being served.
+func MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Maintenance() && mutating(r.Method) && !maintenanceBypass[r.URL.Path] {
+			rest.RetryLater(w, r, errMaintenance, http.StatusServiceUnavailable, maintenanceRetryAfter())
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenanceRetryAfter reads MAINTENANCE_RETRY_AFTER, falling back to
+// the default when it isn't a number of seconds.
+func maintenanceRetryAfter() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("MAINTENANCE_RETRY_AFTER"))
+	if err != nil || seconds <= 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// mutating checks if the method changes state on the server.
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	handler := MaintenanceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	t.Run("off", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/user").Code)
+	})
+
+	SetMaintenance(true)
+	defer SetMaintenance(false)
+
+	t.Run("write", func(t *testing.T) {
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			rec := serve(method, "/user")
+
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code, method)
+			assert.Equal(t, "120", rec.Header().Get("Retry-After"), method)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/user").Code)
+		assert.Equal(t, http.StatusOK, serve(http.MethodOptions, "/user").Code)
+	})
+
+	t.Run("health", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/readyz").Code)
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		os.Setenv("MAINTENANCE_RETRY_AFTER", "30")
+		defer os.Unsetenv("MAINTENANCE_RETRY_AFTER")
+
+		assert.Equal(t, "30", serve(http.MethodPost, "/user").Header().Get("Retry-After"))
+
+		// A value that isn't a number of seconds isn't sent as is.
+		os.Setenv("MAINTENANCE_RETRY_AFTER", "soon")
+
+		assert.Equal(t, "120", serve(http.MethodPost, "/user").Header().Get("Retry-After"))
+	})
+}
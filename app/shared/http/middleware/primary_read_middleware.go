@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"hexagony/lib/database"
+	"net/http"
+)
+
+// PrimaryReadMiddleware tracks the writes of the request, so the reads
+// following a write in the same handler go to the primary database and
+// see it, instead of a replica that may lag behind.
+func PrimaryReadMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(database.TrackWrites(r.Context())))
+	})
+}
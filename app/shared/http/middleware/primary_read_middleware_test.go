@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"hexagony/lib/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrimaryReadMiddleware(t *testing.T) {
+	var before, after bool
+
+	handler := PrimaryReadMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before = database.PrimaryRead(r.Context())
+		database.MarkWrite(r.Context())
+		after = database.PrimaryRead(r.Context())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/user", nil))
+
+	assert.False(t, before)
+	assert.True(t, after)
+
+	// Each request tracks its own writes.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/user", nil))
+
+	assert.False(t, before)
+}
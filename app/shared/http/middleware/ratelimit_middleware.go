@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/lib/rest"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errRateLimited = errors.New("too many requests, try again later")
+
+// rateWindow counts the requests of a client since start.
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimitMiddleware lets each client IP, as resolved by
+// RealIPMiddleware, make up to limit requests per window, answering
+// 429 with a Retry-After beyond. The counts are kept in memory, so each
+// instance limits on its own. A limit of zero disables it.
+func RateLimitMiddleware(limit int, window time.Duration) func(http.Handler) http.Handler {
+	var (
+		mu        sync.Mutex
+		windows   = map[string]*rateWindow{}
+		lastSweep time.Time
+	)
+
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := ClientIP(r.Context())
+			if client == "" {
+				client, _, _ = net.SplitHostPort(r.RemoteAddr)
+			}
+
+			now := time.Now()
+
+			mu.Lock()
+
+			// The windows over are dropped once per window, so the
+			// clients gone don't pile up.
+			if now.Sub(lastSweep) >= window {
+				for ip, counted := range windows {
+					if now.Sub(counted.start) >= window {
+						delete(windows, ip)
+					}
+				}
+				lastSweep = now
+			}
+
+			counted, ok := windows[client]
+			if !ok || now.Sub(counted.start) >= window {
+				counted = &rateWindow{start: now}
+				windows[client] = counted
+			}
+			counted.count++
+
+			allowed := counted.count <= limit
+			retryAfter := counted.start.Add(window).Sub(now)
+
+			mu.Unlock()
+
+			if !allowed {
+				rest.RetryLater(w, r, errRateLimited, http.StatusTooManyRequests, retryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
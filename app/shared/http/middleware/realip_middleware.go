@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const clientIPKey contextKey = "client_ip"
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or
+// single IPs, e.g. TRUSTED_PROXIES="10.0.0.0/8,172.16.0.1".
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	trusted := make([]*net.IPNet, 0)
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		trusted = append(trusted, network)
+	}
+
+	return trusted, nil
+}
+
+// RealIPMiddleware resolves the client IP and stores it in the request context.
+// X-Forwarded-For and X-Real-IP are only honored when the immediate
+// peer is a trusted proxy, otherwise RemoteAddr is used.
+func RealIPMiddleware(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := realIP(r, trusted)
+
+			ctx := context.WithValue(r.Context(), clientIPKey, ip)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP returns the client IP resolved by RealIPMiddleware.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// realIP walks the forwarded chain from the closest hop and returns
+// the first address that isn't a trusted proxy. A malformed hop stops
+// the walk at the last trusted proxy: what's before it, X-Real-IP
+// included, could have been set by the client.
+func realIP(r *http.Request, trusted []*net.IPNet) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if !isTrusted(peer, trusted) {
+		return peer
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		last := peer
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				return last
+			}
+
+			if i == 0 || !isTrusted(hop, trusted) {
+				return hop
+			}
+
+			last = hop
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+
+	return peer
+}
+
+// isTrusted checks if the given IP belongs to one of the trusted networks.
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealIPMiddleware(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 172.16.0.1")
+	assert.NoError(t, err)
+
+	var clientIP string
+
+	handler := RealIPMiddleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP = ClientIP(r.Context())
+	}))
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{"untrusted-peer", "203.0.113.7:1234", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted-peer", "10.0.0.2:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted-chain", "10.0.0.2:1234", "198.51.100.1, 172.16.0.1", "", "198.51.100.1"},
+		{"spoofed-chain", "10.0.0.2:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"trusted-real-ip", "172.16.0.1:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"trusted-no-headers", "10.0.0.2:1234", "", "", "10.0.0.2"},
+		{"malformed-hop", "10.0.0.2:1234", "garbage", "198.51.100.2", "10.0.0.2"},
+		{"malformed-hop-in-chain", "10.0.0.2:1234", "198.51.100.1, garbage, 172.16.0.1", "198.51.100.2", "172.16.0.1"},
+		{"malformed-hop-after-client", "10.0.0.2:1234", "garbage, 198.51.100.1", "198.51.100.2", "198.51.100.1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = c.remoteAddr
+			if c.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", c.forwarded)
+			}
+			if c.realIP != "" {
+				req.Header.Set("X-Real-IP", c.realIP)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, c.expected, clientIP)
+		})
+	}
+}
+
+func TestParseTrustedProxiesFail(t *testing.T) {
+	_, err := ParseTrustedProxies("10.0.0.0/99")
+	assert.Error(t, err)
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ResponseTimeHeader carries the milliseconds the server took until it
+// started writing the response.
+const ResponseTimeHeader = "X-Response-Time"
+
+// ResponseTimeMiddleware sets the X-Response-Time header on every
+// response, unless RESPONSE_TIME_HEADER is "false". It goes first so
+// the time covers the other middlewares.
+func ResponseTimeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if os.Getenv("RESPONSE_TIME_HEADER") == "false" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(&responseTimeWriter{ResponseWriter: w, start: time.Now()}, r)
+	})
+}
+
+// responseTimeWriter sets the header right before the status line is
+// written, the last moment headers can change.
+type responseTimeWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (rw *responseTimeWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		elapsed := float64(time.Since(rw.start).Microseconds()) / 1000
+		rw.Header().Set(ResponseTimeHeader, strconv.FormatFloat(elapsed, 'f', 3, 64))
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseTimeWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush keeps the streamed responses flushing.
+func (rw *responseTimeWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseTimeWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
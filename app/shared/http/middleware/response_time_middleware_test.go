@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseTimeMiddleware(t *testing.T) {
+	handler := ResponseTimeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+
+	t.Run("header", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		ms, err := strconv.ParseFloat(rec.Header().Get(ResponseTimeHeader), 64)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, ms, 2.0)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "{}", rec.Body.String())
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		streaming := ResponseTimeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.(http.Flusher).Flush()
+		}))
+		rec := httptest.NewRecorder()
+
+		streaming.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.True(t, rec.Flushed)
+		assert.NotEmpty(t, rec.Header().Get(ResponseTimeHeader))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("RESPONSE_TIME_HEADER", "false")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Empty(t, rec.Header().Get(ResponseTimeHeader))
+	})
+}
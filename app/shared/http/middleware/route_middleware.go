@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const routeKey contextKey = "route"
+
+// RouteMiddleware keeps the chi routing context of the request, so
+// middleware running before the routing can read the matched route
+// template with RoutePattern once the handler has been served.
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), routeKey, rctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routedTo checks if the request is routed to one of the patterns.
+// The route is looked up from the top router rather than taken from
+// the raw path, so /readyz/ or /user/{uuid} match as chi would, even
+// before the routing.
+func routedTo(r *http.Request, patterns map[string]bool) bool {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return false
+	}
+
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, r.Method, path) {
+		return false
+	}
+
+	return patterns[tctx.RoutePattern()]
+}
+
+// RoutePattern returns the route template matched for the request,
+// e.g. /user/{uuid}, and an empty string when it wasn't routed yet
+// or didn't match any route.
+func RoutePattern(ctx context.Context) string {
+	rctx, ok := ctx.Value(routeKey).(*chi.Context)
+	if !ok {
+		rctx = chi.RouteContext(ctx)
+	}
+
+	if rctx == nil {
+		return ""
+	}
+
+	return rctx.RoutePattern()
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteMiddleware(t *testing.T) {
+	var before, after, downstream string
+
+	router := chi.NewRouter()
+	router.Use(RouteMiddleware)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			before = RoutePattern(r.Context())
+			next.ServeHTTP(w, r)
+			after = RoutePattern(r.Context())
+		})
+	})
+	router.Route("/user", func(r chi.Router) {
+		r.Get("/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+			downstream = RoutePattern(r.Context())
+		})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/user/7d31cb7e-ef0c-4b2c-8e26-2b4cf7e05a43", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "", before)
+	assert.Equal(t, "/user/{uuid}", downstream)
+	assert.Equal(t, "/user/{uuid}", after)
+}
+
+func TestRoutePatternNotRouted(t *testing.T) {
+	assert.Equal(t, "", RoutePattern(context.Background()))
+
+	var pattern string
+
+	handler := RouteMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern = RoutePattern(r.Context())
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, "", pattern)
+}
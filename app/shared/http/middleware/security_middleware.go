@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+)
+
+// Default values for the security headers. Each of them can be
+// overridden through its environment variable. The API only serves
+// data, so the policy allows nothing; CONTENT_SECURITY_POLICY loosens
+// it for the pages of the docs.
+const (
+	defaultHSTS           = "max-age=63072000; includeSubDomains"
+	defaultReferrerPolicy = "no-referrer"
+	defaultCSP            = "default-src 'none'; frame-ancestors 'none'"
+)
+
+// SecurityMiddleware sets the standard security headers on every response.
+// HSTS is only sent over TLS or when BEHIND_TLS_PROXY is enabled.
+func SecurityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := w.Header()
+
+		if r.TLS != nil || os.Getenv("BEHIND_TLS_PROXY") == "true" {
+			headers.Set("Strict-Transport-Security", getEnv("HSTS", defaultHSTS))
+		}
+
+		headers.Set("X-Content-Type-Options", "nosniff")
+		headers.Set("X-Frame-Options", "DENY")
+		headers.Set("Referrer-Policy", getEnv("REFERRER_POLICY", defaultReferrerPolicy))
+		headers.Set("Content-Security-Policy", getEnv("CONTENT_SECURITY_POLICY", defaultCSP))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getEnv returns the value of the given environment
+// variable or the fallback when it is empty.
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
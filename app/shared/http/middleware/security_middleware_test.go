@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityMiddleware(t *testing.T) {
+	handler := SecurityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("plain", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+		assert.Equal(t, defaultReferrerPolicy, rec.Header().Get("Referrer-Policy"))
+		assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", rec.Header().Get("Content-Security-Policy"))
+		assert.Empty(t, rec.Header().Get("Strict-Transport-Security"))
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{}
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, defaultHSTS, rec.Header().Get("Strict-Transport-Security"))
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		os.Setenv("BEHIND_TLS_PROXY", "true")
+		os.Setenv("CONTENT_SECURITY_POLICY", "default-src 'self'; script-src 'self' 'unsafe-inline'")
+		defer os.Unsetenv("BEHIND_TLS_PROXY")
+		defer os.Unsetenv("CONTENT_SECURITY_POLICY")
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, defaultHSTS, rec.Header().Get("Strict-Transport-Security"))
+		assert.Equal(t, "default-src 'self'; script-src 'self' 'unsafe-inline'", rec.Header().Get("Content-Security-Policy"))
+	})
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// slashExempt lists the path prefixes whose trailing slashes are kept,
+// like the Swagger UI served under /docs/.
+var slashExempt = []string{"/docs/"}
+
+// idCollections lists the collections whose items are at /{collection}/{id},
+// see RequireID.
+var idCollections []string
+
+// RequireID tells SlashMiddleware that a trailing slash right after the
+// collection, as in /user/ or /user//logout-all, is an empty id and not
+// a path to canonicalize, so the routes answer 400 instead of serving
+// the collection. Call it while setting up the routes.
+func RequireID(collection string) {
+	idCollections = append(idCollections, strings.TrimSuffix(collection, "/"))
+}
+
+// EmptyID tells if the id segment following one of the collections of
+// RequireID is empty or blank.
+func EmptyID(path string) bool {
+	for _, collection := range idCollections {
+		if !strings.HasPrefix(path, collection+"/") {
+			continue
+		}
+
+		id := strings.SplitN(strings.TrimPrefix(path, collection+"/"), "/", 2)[0]
+		if strings.TrimSpace(id) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// SlashMiddleware makes /user/ and /user reach the same handler, the
+// canonical path having no trailing slash. By default the client is
+// redirected, with a 301 for GET and HEAD and a 308 for the other
+// methods so the body is sent again. TRAILING_SLASH=strip serves the
+// canonical route right away instead. The empty ids of RequireID are
+// left alone.
+func SlashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if len(path) <= 1 || !strings.HasSuffix(path, "/") || slashExempted(path) || EmptyID(path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Leading slashes are collapsed, so //evil.com/ can't become
+		// a redirect to another host.
+		canonical := "/" + strings.Trim(path, "/")
+
+		if os.Getenv("TRAILING_SLASH") == "strip" {
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				rctx.RoutePath = canonical
+			}
+			r.URL.Path = canonical
+			r.URL.RawPath = ""
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.URL.RawQuery != "" {
+			canonical += "?" + r.URL.RawQuery
+		}
+
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+
+		http.Redirect(w, r, canonical, code)
+	})
+}
+
+func slashExempted(path string) bool {
+	for _, prefix := range slashExempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlashMiddleware(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(SlashMiddleware)
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.Get("/user", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte("users")); err != nil {
+			return
+		}
+	})
+	router.Post("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	t.Run("canonical", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/").Code)
+		assert.Equal(t, "users", serve(http.MethodGet, "/user").Body.String())
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/user/?page=2")
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "/user?page=2", rec.Header().Get("Location"))
+
+		rec = serve(http.MethodPost, "/user/")
+		assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+		assert.Equal(t, "/user", rec.Header().Get("Location"))
+	})
+
+	t.Run("exempt", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/docs/").Code)
+	})
+
+	t.Run("empty id", func(t *testing.T) {
+		defer func(collections []string) { idCollections = collections }(idCollections)
+		RequireID("/item")
+
+		// Left to the routes, which have none here.
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/item/").Code)
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/item/%20/").Code)
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/item//logout-all/").Code)
+
+		assert.Equal(t, http.StatusMovedPermanently, serve(http.MethodGet, "/item/7/").Code)
+		assert.Equal(t, http.StatusMovedPermanently, serve(http.MethodGet, "/user/").Code)
+	})
+
+	t.Run("other host", func(t *testing.T) {
+		rec := serve(http.MethodGet, "//evil.com/")
+		assert.Equal(t, "/evil.com", rec.Header().Get("Location"))
+	})
+
+	t.Run("strip", func(t *testing.T) {
+		os.Setenv("TRAILING_SLASH", "strip")
+		defer os.Unsetenv("TRAILING_SLASH")
+
+		rec := serve(http.MethodGet, "/user/")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "users", rec.Body.String())
+
+		assert.Equal(t, http.StatusCreated, serve(http.MethodPost, "/user/").Code)
+	})
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"errors"
+	"hexagony/lib/database"
+	"hexagony/lib/rest"
+	"net/http"
+)
+
+// TenantHeader names the tenant of the requests without a token, e.g.
+// a login. The tenant of a token always wins over it.
+const TenantHeader = "X-Tenant-ID"
+
+// errTenantMismatch answers a header naming another tenant than the host.
+var errTenantMismatch = errors.New("the tenant doesn't match the host")
+
+// TenantMiddleware scopes the queries of the request to the tenant that
+// database.HostTenant maps the host to or, on the other hosts, to the
+// one of the X-Tenant-ID header, the default tenant when it's missing.
+// An invalid id, one database.KnownTenant doesn't know, or one other
+// than the tenant of the host, is answered 400. AuthMiddleware replaces
+// it with the tenant of the token, so a token can't reach another
+// tenant.
+func TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(TenantHeader)
+
+		if host := database.HostTenant(r.Host); host != "" {
+			if id != "" && id != host {
+				rest.DecodeError(w, r, errTenantMismatch, http.StatusBadRequest)
+				return
+			}
+			id = host
+		}
+
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !database.ValidTenant(id) {
+			rest.DecodeError(w, r, errors.New("invalid tenant"), http.StatusBadRequest)
+			return
+		}
+
+		if !database.KnownTenant(id) {
+			rest.DecodeError(w, r, database.ErrUnknownTenant, http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(database.WithTenant(r.Context(), id)))
+	})
+}
+
+// SignupTenantMiddleware scopes the sign-ups to the tenant of their host,
+// the default tenant on the hosts TENANT_HOSTS doesn't map, whatever the
+// X-Tenant-ID header says: the client picks the tenant it logs in to,
+// not the one it joins.
+func SignupTenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(database.WithTenant(r.Context(), database.HostTenant(r.Host))))
+	})
+}
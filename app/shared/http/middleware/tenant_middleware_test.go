@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"hexagony/lib/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	var tenant string
+
+	handler := TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = database.Tenant(r.Context())
+	}))
+
+	serve := func(host, header string) int {
+		tenant = ""
+
+		req := httptest.NewRequest(http.MethodPost, "/auth", nil)
+		req.Host = host
+		req.Header.Set(TenantHeader, header)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("api.example.com", ""))
+	assert.Equal(t, database.DefaultTenant, tenant)
+
+	assert.Equal(t, http.StatusBadRequest, serve("api.example.com", "acme' OR 1=1"))
+
+	// Without TENANTS only the default tenant is known.
+	assert.Equal(t, http.StatusBadRequest, serve("api.example.com", "acme"))
+
+	t.Setenv("TENANTS", "acme,globex")
+
+	for header, expected := range map[string]int{
+		"acme":   http.StatusOK,
+		"junk-1": http.StatusBadRequest,
+	} {
+		assert.Equal(t, expected, serve("api.example.com", header))
+	}
+	assert.Equal(t, http.StatusOK, serve("api.example.com", "acme"))
+	assert.Equal(t, "acme", tenant)
+
+	t.Setenv("TENANT_HOSTS", "acme.example.com=acme")
+
+	for _, header := range []string{"", "acme"} {
+		assert.Equal(t, http.StatusOK, serve("ACME.example.com:8080", header))
+		assert.Equal(t, "acme", tenant)
+	}
+
+	// The host can't be paired with another tenant.
+	assert.Equal(t, http.StatusBadRequest, serve("acme.example.com", "globex"))
+}
+
+func TestSignupTenantMiddleware(t *testing.T) {
+	t.Setenv("TENANTS", "acme,globex")
+	t.Setenv("TENANT_HOSTS", "acme.example.com=acme")
+
+	var tenant string
+
+	handler := TenantMiddleware(SignupTenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = database.Tenant(r.Context())
+	})))
+
+	for name, c := range map[string]struct {
+		host, header, expected string
+	}{
+		"header":      {"api.example.com", "globex", database.DefaultTenant},
+		"host":        {"acme.example.com", "", "acme"},
+		"host header": {"acme.example.com", "acme", "acme"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/register", nil)
+			req.Host = c.host
+			req.Header.Set(TenantHeader, c.header)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, c.expected, tenant)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hexagony/lib/clog"
+	"hexagony/lib/rest"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultRequestTimeout bounds a request when REQUEST_TIMEOUT is unset.
+const defaultRequestTimeout = 30 * time.Second
+
+var (
+	errTimeout  = errors.New("the request took too long")
+	errCanceled = errors.New("the request was canceled")
+)
+
+// timeoutExempt lists the route patterns served without a deadline,
+// they stream their response for as long as it takes.
+var timeoutExempt = map[string]bool{}
+
+// streamExempt lists the route patterns of the lists streamed with
+// stream=true, served without a deadline when asked to stream.
+var streamExempt = map[string]bool{}
+
+// ExemptFromTimeout adds route patterns, e.g. /user/export.csv, to the
+// ones served without a deadline. Call it while setting up the routes,
+// before the server starts.
+func ExemptFromTimeout(patterns ...string) {
+	for _, pattern := range patterns {
+		timeoutExempt[pattern] = true
+	}
+}
+
+// ExemptStreamFromTimeout adds the route patterns, e.g. /user/, whose
+// GET requests with stream=true are served without a deadline. The
+// parameter is ignored on the other routes, so it can't lift the
+// deadline of any request.
+func ExemptStreamFromTimeout(patterns ...string) {
+	for _, pattern := range patterns {
+		streamExempt[pattern] = true
+	}
+}
+
+// requestTimeout reads REQUEST_TIMEOUT, e.g. "10s". Zero disables it.
+func requestTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT"))
+	if err != nil || timeout < 0 {
+		return defaultRequestTimeout
+	}
+	return timeout
+}
+
+// TimeoutMiddleware gives every request a deadline, answering 504 when
+// it passes, or 503 when the client goes away first, without waiting
+// for the handler. The handler sees its context canceled, so the work
+// it started stops. Its response is buffered until it returns, which
+// is why the exempt routes and the streamed lists (stream=true) are
+// served without a deadline; their write deadline, SERVER_WRITE_TIMEOUT,
+// is lifted too so the stream isn't cut.
+func TimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if timeoutExempted(r) {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				clog.Error(err, "failed to lift the write deadline")
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timeout := requestTimeout()
+		if timeout == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+					return
+				}
+				close(done)
+			}()
+
+			next.ServeHTTP(tw, r.WithContext(ctx))
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			if tw.code == 0 {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			_, _ = w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+
+			tw.timedOut = true
+
+			w.Header().Set("Content-Type", "application/json")
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				rest.DecodeError(w, r, errTimeout, http.StatusGatewayTimeout)
+				return
+			}
+			rest.RetryLater(w, r, errCanceled, http.StatusServiceUnavailable, time.Second)
+		}
+	})
+}
+
+// timeoutWriter buffers the response of the handler, discarding what
+// it writes once the request timed out.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// timeoutExempted checks if the request streams its response, see
+// ExemptFromTimeout and ExemptStreamFromTimeout.
+func timeoutExempted(r *http.Request) bool {
+	if routedTo(r, timeoutExempt) {
+		return true
+	}
+
+	return r.Method == http.MethodGet && r.URL.Query().Get("stream") == "true" && routedTo(r, streamExempt)
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	os.Setenv("REQUEST_TIMEOUT", "20ms")
+	defer os.Unsetenv("REQUEST_TIMEOUT")
+
+	canceled := make(chan bool, 1)
+
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(time.Second):
+			canceled <- false
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	fast := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Fast", "true")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}
+
+	router := chi.NewRouter()
+	router.Use(TimeoutMiddleware)
+	router.Get("/slow", slow)
+	router.Get("/fast", fast)
+	router.Get("/export.csv", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		_, deadline := r.Context().Deadline()
+		assert.False(t, deadline)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	router.Get("/list", slow)
+	router.Post("/list", slow)
+
+	ExemptFromTimeout("/export.csv")
+	defer delete(timeoutExempt, "/export.csv")
+	ExemptStreamFromTimeout("/list")
+	defer delete(streamExempt, "/list")
+
+	t.Run("slow", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+		assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+		assert.JSONEq(t, `{"message":"the request took too long","status":504}`, rec.Body.String())
+		assert.True(t, <-canceled)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(5*time.Millisecond, cancel)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		assert.True(t, <-canceled)
+	})
+
+	t.Run("fast", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "true", rec.Header().Get("X-Fast"))
+		assert.Equal(t, "created", rec.Body.String())
+	})
+
+	t.Run("exempt", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export.csv", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/list?stream=true", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.False(t, <-canceled)
+	})
+
+	t.Run("stream elsewhere", func(t *testing.T) {
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/slow?stream=true", nil),
+			httptest.NewRequest(http.MethodPost, "/list?stream=true", nil),
+			httptest.NewRequest(http.MethodGet, "/list", nil),
+		} {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusGatewayTimeout, rec.Code, req.Method+" "+req.URL.String())
+			assert.True(t, <-canceled)
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		router := chi.NewRouter()
+		router.Use(TimeoutMiddleware)
+		router.Get("/", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+		assert.PanicsWithValue(t, "boom", func() {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
+
+func TestTimeoutMiddlewareWriteDeadline(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(TimeoutMiddleware)
+	router.Get("/export.csv", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("id,name\n"))
+	})
+
+	ExemptFromTimeout("/export.csv")
+	defer delete(timeoutExempt, "/export.csv")
+
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.WriteTimeout = 20 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/export.csv")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "id,name\n", string(body), "the stream outlives the write timeout")
+}
@@ -4,12 +4,47 @@ import "errors"
 
 var (
 	ErrFindAll   = errors.New("failed to list the users")
+	ErrExport    = errors.New("failed to export the users")
 	ErrFindByID  = errors.New("failed to get the user")
+	ErrSearch    = errors.New("failed to search the users")
+	ErrStats     = errors.New("failed to count the signups")
 	ErrAdd       = errors.New("failed to insert the user")
 	ErrUpdate    = errors.New("failed to update the user")
 	ErrDelete    = errors.New("failed to delete the user")
+	ErrLogout    = errors.New("failed to log out the user")
+	ErrReset     = errors.New("failed to reset the password")
+	ErrVerify    = errors.New("failed to verify the current password")
+	ErrAvatar    = errors.New("failed to store the avatar")
 	ErrUUIDParse = errors.New("failed to parse the UUID")
+	ErrFields    = errors.New("unknown field requested")
 
-	ErrResourceNotFound = errors.New("the resource you requested could not be found")
-	ErrHashPassword     = errors.New("failed to hash the password")
+	ErrResourceNotFound   = errors.New("the resource you requested could not be found")
+	ErrEmailTaken         = errors.New("the email is already in use")
+	ErrUsernameTaken      = errors.New("the username is already in use")
+	ErrEmailNoMX          = errors.New("the domain of the email doesn't accept mail")
+	ErrEmailDomainBlocked = errors.New("the domain of the email is not allowed")
+	ErrQuotaExceeded      = errors.New("the maximum number of users has been reached")
+	ErrTooManyIDs         = errors.New("too many ids requested")
+	ErrSearchTooShort     = errors.New("the search query is too short")
+	ErrHashPassword       = errors.New("failed to hash the password")
+	ErrCurrentPassword    = errors.New("the current password is missing or incorrect")
+	ErrStatsRange         = errors.New("from and to are required and from must be before to")
+	ErrStatsInterval      = errors.New("the interval must be day, week or month")
+	ErrAvatarType         = errors.New("the avatar must be a PNG, JPEG, GIF or WebP image")
+	ErrAvatarTooLarge     = errors.New("the avatar is too large")
+	ErrAvatarMissing      = errors.New("the avatar part of the multipart form is missing or empty")
+	ErrPasswordReused     = errors.New("the password was used recently, choose another one")
+	ErrUnknownColumn      = errors.New("the column can't be updated")
+	ErrModifiedSince      = errors.New("the user was modified since If-Unmodified-Since")
 )
+
+// ValidationError reports a user breaking an invariant of the domain,
+// e.g. a user without name, whatever the entry point that built it.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
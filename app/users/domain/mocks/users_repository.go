@@ -8,6 +8,8 @@ import (
 
 	mock "github.com/stretchr/testify/mock"
 
+	time "time"
+
 	uuid "github.com/google/uuid"
 )
 
@@ -30,6 +32,78 @@ func (_m *UserRepository) Add(_a0 context.Context, _a1 *domain.User) error {
 	return r0
 }
 
+// AddPasswordHistory provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *UserRepository) AddPasswordHistory(_a0 context.Context, _a1 uuid.UUID, _a2 string, _a3 int) error {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, int) error); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddWithinQuota provides a mock function with given fields: _a0, _a1, _a2
+func (_m *UserRepository) AddWithinQuota(_a0 context.Context, _a1 *domain.User, _a2 int) error {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.User, int) error); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Count provides a mock function with given fields: _a0
+func (_m *UserRepository) Count(_a0 context.Context) (int, error) {
+	ret := _m.Called(_a0)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context) int); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountSignups provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *UserRepository) CountSignups(_a0 context.Context, _a1 time.Time, _a2 time.Time, _a3 string) ([]*domain.SignupBucket, error) {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
+
+	var r0 []*domain.SignupBucket
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time, string) []*domain.SignupBucket); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.SignupBucket)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Time, string) error); ok {
+		r1 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Delete provides a mock function with given fields: _a0, _a1
 func (_m *UserRepository) Delete(_a0 context.Context, _a1 uuid.UUID) error {
 	ret := _m.Called(_a0, _a1)
@@ -67,6 +141,20 @@ func (_m *UserRepository) FindAll(_a0 context.Context) ([]*domain.User, error) {
 	return r0, r1
 }
 
+// FindAllStream provides a mock function with given fields: _a0, _a1
+func (_m *UserRepository) FindAllStream(_a0 context.Context, _a1 func(*domain.User) error) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(*domain.User) error) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // FindByID provides a mock function with given fields: _a0, _a1
 func (_m *UserRepository) FindByID(_a0 context.Context, _a1 uuid.UUID) (*domain.User, error) {
 	ret := _m.Called(_a0, _a1)
@@ -90,6 +178,198 @@ func (_m *UserRepository) FindByID(_a0 context.Context, _a1 uuid.UUID) (*domain.
 	return r0, r1
 }
 
+// FindByIDs provides a mock function with given fields: _a0, _a1
+func (_m *UserRepository) FindByIDs(_a0 context.Context, _a1 []uuid.UUID) ([]*domain.User, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []*domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, []uuid.UUID) []*domain.User); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []uuid.UUID) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindPage provides a mock function with given fields: _a0, _a1, _a2
+func (_m *UserRepository) FindPage(_a0 context.Context, _a1 *domain.ListPosition, _a2 int) ([]*domain.User, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 []*domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.ListPosition, int) []*domain.User); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *domain.ListPosition, int) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// HealthCheck provides a mock function with given fields: _a0
+func (_m *UserRepository) HealthCheck(_a0 context.Context) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ListVersion provides a mock function with given fields: _a0
+func (_m *UserRepository) ListVersion(_a0 context.Context) (*domain.ListVersion, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *domain.ListVersion
+	if rf, ok := ret.Get(0).(func(context.Context) *domain.ListVersion); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*domain.ListVersion)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LogoutAll provides a mock function with given fields: _a0, _a1
+func (_m *UserRepository) LogoutAll(_a0 context.Context, _a1 uuid.UUID) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PasswordHistory provides a mock function with given fields: _a0, _a1, _a2
+func (_m *UserRepository) PasswordHistory(_a0 context.Context, _a1 uuid.UUID, _a2 int) ([]string, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, int) []string); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, int) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ResetPassword provides a mock function with given fields: _a0, _a1, _a2
+func (_m *UserRepository) ResetPassword(_a0 context.Context, _a1 uuid.UUID, _a2 string) error {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) error); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SearchByName provides a mock function with given fields: _a0, _a1, _a2
+func (_m *UserRepository) SearchByName(_a0 context.Context, _a1 string, _a2 int) ([]*domain.User, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 []*domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) []*domain.User); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetAvatar provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *UserRepository) SetAvatar(_a0 context.Context, _a1 uuid.UUID, _a2 *string, _a3 *string) error {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, *string, *string) error); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TokenVersion provides a mock function with given fields: _a0, _a1
+func (_m *UserRepository) TokenVersion(_a0 context.Context, _a1 uuid.UUID) (int, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) int); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Update provides a mock function with given fields: _a0, _a1, _a2
 func (_m *UserRepository) Update(_a0 context.Context, _a1 uuid.UUID, _a2 *domain.User) error {
 	ret := _m.Called(_a0, _a1, _a2)
@@ -104,6 +384,20 @@ func (_m *UserRepository) Update(_a0 context.Context, _a1 uuid.UUID, _a2 *domain
 	return r0
 }
 
+// UpdateFields provides a mock function with given fields: _a0, _a1, _a2
+func (_m *UserRepository) UpdateFields(_a0 context.Context, _a1 uuid.UUID, _a2 map[string]interface{}) error {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, map[string]interface{}) error); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewUserRepository interface {
 	mock.TestingT
 	Cleanup(func())
@@ -6,8 +6,12 @@ import (
 	context "context"
 	domain "hexagony/app/users/domain"
 
+	io "io"
+
 	mock "github.com/stretchr/testify/mock"
 
+	time "time"
+
 	uuid "github.com/google/uuid"
 )
 
@@ -30,6 +34,64 @@ func (_m *UserUseCase) Add(ctx context.Context, user *domain.User) error {
 	return r0
 }
 
+// CheckPasswordReuse provides a mock function with given fields: ctx, _a1, password
+func (_m *UserUseCase) CheckPasswordReuse(ctx context.Context, _a1 uuid.UUID, password string) error {
+	ret := _m.Called(ctx, _a1, password)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) error); ok {
+		r0 = rf(ctx, _a1, password)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Count provides a mock function with given fields: ctx
+func (_m *UserUseCase) Count(ctx context.Context) (int, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context) int); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountSignups provides a mock function with given fields: ctx, from, to, interval
+func (_m *UserUseCase) CountSignups(ctx context.Context, from time.Time, to time.Time, interval string) ([]*domain.SignupBucket, error) {
+	ret := _m.Called(ctx, from, to, interval)
+
+	var r0 []*domain.SignupBucket
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time, string) []*domain.SignupBucket); ok {
+		r0 = rf(ctx, from, to, interval)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.SignupBucket)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Time, string) error); ok {
+		r1 = rf(ctx, from, to, interval)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Delete provides a mock function with given fields: ctx, _a1
 func (_m *UserUseCase) Delete(ctx context.Context, _a1 uuid.UUID) error {
 	ret := _m.Called(ctx, _a1)
@@ -67,6 +129,20 @@ func (_m *UserUseCase) FindAll(ctx context.Context) ([]*domain.User, error) {
 	return r0, r1
 }
 
+// FindAllStream provides a mock function with given fields: ctx, fn
+func (_m *UserUseCase) FindAllStream(ctx context.Context, fn func(*domain.User) error) error {
+	ret := _m.Called(ctx, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(*domain.User) error) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // FindByID provides a mock function with given fields: ctx, _a1
 func (_m *UserUseCase) FindByID(ctx context.Context, _a1 uuid.UUID) (*domain.User, error) {
 	ret := _m.Called(ctx, _a1)
@@ -90,6 +166,179 @@ func (_m *UserUseCase) FindByID(ctx context.Context, _a1 uuid.UUID) (*domain.Use
 	return r0, r1
 }
 
+// FindByIDs provides a mock function with given fields: ctx, uuids
+func (_m *UserUseCase) FindByIDs(ctx context.Context, uuids []uuid.UUID) ([]*domain.User, []uuid.UUID, error) {
+	ret := _m.Called(ctx, uuids)
+
+	var r0 []*domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, []uuid.UUID) []*domain.User); ok {
+		r0 = rf(ctx, uuids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.User)
+		}
+	}
+
+	var r1 []uuid.UUID
+	if rf, ok := ret.Get(1).(func(context.Context, []uuid.UUID) []uuid.UUID); ok {
+		r1 = rf(ctx, uuids)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]uuid.UUID)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, []uuid.UUID) error); ok {
+		r2 = rf(ctx, uuids)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// FindPage provides a mock function with given fields: ctx, after, limit
+func (_m *UserUseCase) FindPage(ctx context.Context, after *domain.ListPosition, limit int) ([]*domain.User, error) {
+	ret := _m.Called(ctx, after, limit)
+
+	var r0 []*domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.ListPosition, int) []*domain.User); ok {
+		r0 = rf(ctx, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *domain.ListPosition, int) error); ok {
+		r1 = rf(ctx, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListVersion provides a mock function with given fields: ctx
+func (_m *UserUseCase) ListVersion(ctx context.Context) (*domain.ListVersion, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *domain.ListVersion
+	if rf, ok := ret.Get(0).(func(context.Context) *domain.ListVersion); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*domain.ListVersion)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LogoutAll provides a mock function with given fields: ctx, _a1
+func (_m *UserUseCase) LogoutAll(ctx context.Context, _a1 uuid.UUID) error {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetPassword provides a mock function with given fields: ctx, _a1, password
+func (_m *UserUseCase) ResetPassword(ctx context.Context, _a1 uuid.UUID, password string) error {
+	ret := _m.Called(ctx, _a1, password)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) error); ok {
+		r0 = rf(ctx, _a1, password)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SearchByName provides a mock function with given fields: ctx, prefix, limit
+func (_m *UserUseCase) SearchByName(ctx context.Context, prefix string, limit int) ([]*domain.User, error) {
+	ret := _m.Called(ctx, prefix, limit)
+
+	var r0 []*domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) []*domain.User); ok {
+		r0 = rf(ctx, prefix, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*domain.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int) error); ok {
+		r1 = rf(ctx, prefix, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetAvatar provides a mock function with given fields: ctx, _a1, image, contentType
+func (_m *UserUseCase) SetAvatar(ctx context.Context, _a1 uuid.UUID, image io.Reader, contentType string) (*domain.User, error) {
+	ret := _m.Called(ctx, _a1, image, contentType)
+
+	var r0 *domain.User
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, io.Reader, string) *domain.User); ok {
+		r0 = rf(ctx, _a1, image, contentType)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*domain.User)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, io.Reader, string) error); ok {
+		r1 = rf(ctx, _a1, image, contentType)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// TokenVersion provides a mock function with given fields: ctx, _a1
+func (_m *UserUseCase) TokenVersion(ctx context.Context, _a1 uuid.UUID) (int, error) {
+	ret := _m.Called(ctx, _a1)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) int); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Update provides a mock function with given fields: ctx, _a1, user
 func (_m *UserUseCase) Update(ctx context.Context, _a1 uuid.UUID, user *domain.User) error {
 	ret := _m.Called(ctx, _a1, user)
@@ -104,6 +353,20 @@ func (_m *UserUseCase) Update(ctx context.Context, _a1 uuid.UUID, user *domain.U
 	return r0
 }
 
+// ValidatePassword provides a mock function with given fields: ctx, password, user
+func (_m *UserUseCase) ValidatePassword(ctx context.Context, password string, user *domain.User) error {
+	ret := _m.Called(ctx, password, user)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *domain.User) error); ok {
+		r0 = rf(ctx, password, user)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewUserUseCase interface {
 	mock.TestingT
 	Cleanup(func())
@@ -2,32 +2,181 @@ package domain
 
 import (
 	"context"
+	"encoding/json"
+	"hexagony/lib/rest"
+	"io"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	UUID      uuid.UUID `db:"uuid" json:"id"`
-	Name      string    `db:"name" json:"name"`
-	Email     string    `db:"email" json:"email"`
-	Password  string    `db:"password" json:"password"`
-	CreatedAt time.Time `db:"created_at" json:"created_at" `
-	UpdatedAt time.Time `db:"updated_at" json:"updated_at" `
+	UUID           uuid.UUID `db:"uuid" json:"id"`
+	Name           string    `db:"name" json:"name"`
+	Email          string    `db:"email" json:"email"`
+	EmailCanonical string    `db:"email_canonical" json:"-"`
+	Username       *string   `db:"username" json:"username,omitempty"`
+	Password       string    `db:"password" json:"password"`
+	Role           string    `db:"role" json:"role"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+
+	// TokenVersion is embedded in the tokens of the user, bumping
+	// it invalidates them all.
+	TokenVersion int `db:"token_version" json:"-"`
+
+	// TenantID isolates the users, who only see the ones of their
+	// tenant. It comes from the claims, never from a payload.
+	TenantID string `db:"tenant_id" json:"-"`
+
+	// AvatarKey locates the avatar in the blob storage, AvatarURL is
+	// where clients download it from.
+	AvatarKey *string `db:"avatar_key" json:"-"`
+	AvatarURL *string `db:"avatar_url" json:"avatar_url,omitempty"`
+
+	// PasswordChangedAt is when the password was last set, the login
+	// asks for a new one once it's older than PASSWORD_MAX_AGE.
+	PasswordChangedAt time.Time `db:"password_changed_at" json:"-"`
+}
+
+// MarshalJSON writes the timestamps in RFC 3339, in UTC, see rest.Time.
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+
+	return json.Marshal(struct {
+		user
+		CreatedAt rest.Time `json:"created_at"`
+		UpdatedAt rest.Time `json:"updated_at"`
+	}{user(u), rest.Time(u.CreatedAt), rest.Time(u.UpdatedAt)})
+}
+
+// Validate checks the invariants every stored user holds: a name and a
+// valid email, without display name. It returns a *ValidationError.
+func (u *User) Validate() error {
+	if strings.TrimSpace(u.Name) == "" {
+		return &ValidationError{Field: "name", Message: "is required"}
+	}
+
+	if strings.TrimSpace(u.Email) == "" {
+		return &ValidationError{Field: "email", Message: "is required"}
+	}
+
+	if address, err := mail.ParseAddress(u.Email); err != nil || address.Address != u.Email {
+		return &ValidationError{Field: "email", Message: "is not a valid email"}
+	}
+
+	return nil
+}
+
+// ValidateNew checks a user about to be created, which must also
+// have a password hash. Updates may leave it empty to keep the
+// current one.
+func (u *User) ValidateNew() error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	if u.Password == "" {
+		return &ValidationError{Field: "password", Message: "is required"}
+	}
+
+	return nil
+}
+
+// CanonicalEmail normalizes an email for lookups and uniqueness checks.
+// The original email is kept for display.
+func CanonicalEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Signup intervals, the size of the buckets of the signup stats.
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// SignupBucket counts the users created in the interval starting at Bucket.
+type SignupBucket struct {
+	Bucket time.Time `db:"bucket" json:"bucket"`
+	Count  int64     `db:"count" json:"count"`
+}
+
+// ListVersion tells when the list of users last changed: the latest
+// update and the number of users, which a deletion changes.
+type ListVersion struct {
+	LastModified time.Time `db:"last_modified"`
+	Count        int       `db:"count"`
+}
+
+// ListPosition is the keyset of the list of users, the sort keys of the
+// last user of a page, which the next page starts after.
+type ListPosition struct {
+	CreatedAt time.Time `json:"created_at"`
+	UUID      uuid.UUID `json:"id"`
 }
 
 type UserRepository interface {
 	FindAll(context.Context) ([]*User, error)
+	FindAllStream(context.Context, func(*User) error) error
 	FindByID(context.Context, uuid.UUID) (*User, error)
+	FindByIDs(context.Context, []uuid.UUID) ([]*User, error)
+	FindPage(context.Context, *ListPosition, int) ([]*User, error)
+	SearchByName(context.Context, string, int) ([]*User, error)
+	Count(context.Context) (int, error)
+	CountSignups(context.Context, time.Time, time.Time, string) ([]*SignupBucket, error)
+	ListVersion(context.Context) (*ListVersion, error)
 	Add(context.Context, *User) error
+	AddWithinQuota(context.Context, *User, int) error
 	Update(context.Context, uuid.UUID, *User) error
+	UpdateFields(context.Context, uuid.UUID, map[string]interface{}) error
 	Delete(context.Context, uuid.UUID) error
+	LogoutAll(context.Context, uuid.UUID) error
+	ResetPassword(context.Context, uuid.UUID, string) error
+	PasswordHistory(context.Context, uuid.UUID, int) ([]string, error)
+	AddPasswordHistory(context.Context, uuid.UUID, string, int) error
+	TokenVersion(context.Context, uuid.UUID) (int, error)
+	SetAvatar(context.Context, uuid.UUID, *string, *string) error
+	HealthCheck(context.Context) error
+}
+
+// PasswordPolicy decides which passwords a user may set. It returns a
+// *ValidationError of the password field, or ErrPasswordReused. The
+// users being created have no uuid yet, the resets only set it.
+type PasswordPolicy interface {
+	Validate(ctx context.Context, plaintext string, user *User) error
+}
+
+// CredentialsVerifier checks the password of a user without logging
+// them in. It's implemented by the auth use case.
+type CredentialsVerifier interface {
+	VerifyCredentials(ctx context.Context, email, password string) (bool, error)
 }
 
 type UserUseCase interface {
 	FindAll(ctx context.Context) ([]*User, error)
+	FindAllStream(ctx context.Context, fn func(user *User) error) error
 	FindByID(ctx context.Context, uuid uuid.UUID) (*User, error)
+	FindByIDs(ctx context.Context, uuids []uuid.UUID) ([]*User, []uuid.UUID, error)
+	FindPage(ctx context.Context, after *ListPosition, limit int) ([]*User, error)
+	SearchByName(ctx context.Context, prefix string, limit int) ([]*User, error)
+	Count(ctx context.Context) (int, error)
+	CountSignups(ctx context.Context, from, to time.Time, interval string) ([]*SignupBucket, error)
+	ListVersion(ctx context.Context) (*ListVersion, error)
 	Add(ctx context.Context, user *User) error
 	Update(ctx context.Context, uuid uuid.UUID, user *User) error
 	Delete(ctx context.Context, uuid uuid.UUID) error
+	LogoutAll(ctx context.Context, uuid uuid.UUID) error
+	ResetPassword(ctx context.Context, uuid uuid.UUID, password string) error
+	CheckPasswordReuse(ctx context.Context, uuid uuid.UUID, password string) error
+	ValidatePassword(ctx context.Context, password string, user *User) error
+	TokenVersion(ctx context.Context, uuid uuid.UUID) (int, error)
+	SetAvatar(ctx context.Context, uuid uuid.UUID, image io.Reader, contentType string) (*User, error)
 }
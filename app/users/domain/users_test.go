@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserValidate(t *testing.T) {
+	valid := func() *User {
+		return &User{Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "$2a$10$hash"}
+	}
+
+	assert.NoError(t, valid().Validate())
+	assert.NoError(t, valid().ValidateNew())
+
+	for name, tc := range map[string]struct {
+		change func(*User)
+		field  string
+	}{
+		"no name":       {func(u *User) { u.Name = "" }, "name"},
+		"blank name":    {func(u *User) { u.Name = "  " }, "name"},
+		"no email":      {func(u *User) { u.Email = "" }, "email"},
+		"invalid email": {func(u *User) { u.Email = "xorycx" }, "email"},
+		"display name":  {func(u *User) { u.Email = "Cyro <xorycx@gmail.com>" }, "email"},
+		"spaced email":  {func(u *User) { u.Email = " xorycx@gmail.com" }, "email"},
+		"no password":   {func(u *User) { u.Password = "" }, "password"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			user := valid()
+			tc.change(user)
+
+			var invalid *ValidationError
+			if assert.True(t, errors.As(user.ValidateNew(), &invalid)) {
+				assert.Equal(t, tc.field, invalid.Field)
+			}
+		})
+	}
+
+	t.Run("update keeps the password", func(t *testing.T) {
+		user := valid()
+		user.Password = ""
+
+		assert.NoError(t, user.Validate())
+	})
+}
@@ -1,15 +1,29 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	apiKeysDomain "hexagony/app/apikeys/domain"
 	cmiddleware "hexagony/app/shared/http/middleware"
 	"hexagony/app/users/domain"
+	"hexagony/lib/audit"
 	"hexagony/lib/clog"
 	"hexagony/lib/crypto"
+	"hexagony/lib/cursor"
+	"hexagony/lib/database"
+	"hexagony/lib/idgen"
 	"hexagony/lib/rest"
 	"hexagony/lib/validation"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -17,44 +31,204 @@ import (
 
 type UserHandler struct {
 	userUseCase domain.UserUseCase
+	credentials domain.CredentialsVerifier
+	audit       audit.Logger
+	cursors     *cursor.Signer
 }
 
-func NewUserHandler(c *chi.Mux, as domain.UserUseCase) {
-	handler := UserHandler{userUseCase: as}
+// scopeUsersRead lets the API keys read the users.
+const scopeUsersRead = "users:read"
+
+// NewUserHandler registers the user routes. The credentials verify the
+// current password of the users changing their own email or password,
+// and the API keys authenticate the services reading the users.
+func NewUserHandler(c *chi.Mux, as domain.UserUseCase, cv domain.CredentialsVerifier, auc apiKeysDomain.APIKeyUseCase) {
+	handler := UserHandler{userUseCase: as, credentials: cv, audit: audit.New(), cursors: cursor.FromEnv()}
+
+	cmiddleware.ExemptFromTimeout("/user/export.csv")
+	cmiddleware.ExemptStreamFromTimeout("/user/")
+	cmiddleware.RequireID("/user")
+
+	rest.RegisterProblemType(domain.ErrResourceNotFound, "not-found")
+	rest.RegisterProblemType(domain.ErrUUIDParse, "invalid-uuid")
+	rest.RegisterProblemType(domain.ErrEmailTaken, "email-taken")
+	rest.RegisterProblemType(domain.ErrUsernameTaken, "username-taken")
+	rest.RegisterProblemType(domain.ErrEmailNoMX, "email-undeliverable")
+	rest.RegisterProblemType(domain.ErrEmailDomainBlocked, "email-domain-blocked")
+	rest.RegisterProblemType(domain.ErrQuotaExceeded, "quota-exceeded")
+	rest.RegisterProblemType(cursor.ErrInvalid, "invalid-cursor")
 
 	c.Route("/user", func(r chi.Router) {
+		// The services read the users of their tenant with an API key
+		// granted the scope.
+		r.Group(func(r chi.Router) {
+			r.Use(cmiddleware.APIKeyOrAuth(auc, scopeUsersRead), rejectEmptyUUID)
+
+			r.Get("/", handler.FindAll)
+			r.Get("/{uuid}", handler.FindByID)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(cmiddleware.AuthMiddleware, rejectEmptyUUID)
+
+			r.With(cmiddleware.AdminMiddleware).Get("/export.csv", handler.Export)
+			r.With(cmiddleware.AdminMiddleware).Get("/search", handler.Search)
+			r.With(cmiddleware.AdminMiddleware).Get("/stats/signups", handler.Signups)
+			r.With(cmiddleware.AdminMiddleware, cmiddleware.IdempotencyMiddleware).Post("/", handler.Add)
+			r.Put("/{uuid}", handler.Update)
+			r.Patch("/{uuid}", handler.Patch)
+			r.Delete("/{uuid}", handler.Delete)
+			r.With(cmiddleware.AdminMiddleware).Post("/{uuid}/logout-all", handler.LogoutAll)
+			r.With(cmiddleware.AdminMiddleware).Post("/{uuid}/reset-password", handler.ResetPassword)
+		})
+	})
+
+	c.Route("/me", func(r chi.Router) {
 		r.Use(cmiddleware.AuthMiddleware)
 
-		r.Get("/", handler.FindAll)
-		r.Get("/{uuid}", handler.FindByID)
-		r.Post("/", handler.Add)
-		r.Put("/{uuid}", handler.Update)
-		r.Delete("/{uuid}", handler.Delete)
+		r.Post("/avatar", handler.Avatar)
+		r.Post("/password", handler.ChangePassword)
 	})
 }
 
+// createUserRequest is sent by admins, who can choose the role.
+// The users signing up themselves go through /auth/register.
 type createUserRequest struct {
 	Name     string `json:"name" validate:"required"`
 	Email    string `json:"email" validate:"required"`
-	Password string `json:"password" validate:"required,gte=8"`
+	Username string `json:"username" validate:"omitempty,alphanum,min=3,max=30"`
+	Password string `json:"password" validate:"required"`
+	Role     string `json:"role" validate:"omitempty,oneof=user admin"`
+}
+
+// createdUserResponse is the user returned by Add.
+type createdUserResponse struct {
+	userListItem
+}
+
+// userListItem is a user of the responses. It's written like
+// domain.User, its fields and tags mirror it, without the password
+// hash, and the uuid and the timestamps are appended without an
+// allocation per user, see rest.UUID.
+type userListItem struct {
+	UUID     rest.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Username *string   `json:"username,omitempty"`
+	Role     string    `json:"role"`
+
+	CreatedAt rest.Time `json:"created_at"`
+	UpdatedAt rest.Time `json:"updated_at"`
+
+	AvatarURL *string `json:"avatar_url,omitempty"`
+}
+
+// userList converts the users in a single allocation.
+func userList(users []*domain.User) []userListItem {
+	if users == nil {
+		return nil
+	}
+
+	list := make([]userListItem, len(users))
+	for i, user := range users {
+		list[i] = newUserListItem(user)
+	}
+
+	return list
 }
 
+func newUserListItem(user *domain.User) userListItem {
+	return userListItem{
+		UUID:      rest.UUID(user.UUID),
+		Name:      user.Name,
+		Email:     user.Email,
+		Username:  user.Username,
+		Role:      user.Role,
+		CreatedAt: rest.Time(user.CreatedAt),
+		UpdatedAt: rest.Time(user.UpdatedAt),
+		AvatarURL: user.AvatarURL,
+	}
+}
+
+// updateUserRequest needs the CurrentPassword of the user when the
+// email changes, see stepUpEmail.
 type updateUserRequest struct {
-	Name  string `json:"name" validate:"required"`
-	Email string `json:"email" validate:"required"`
+	Name            string `json:"name" validate:"required"`
+	Email           string `json:"email" validate:"required"`
+	Username        string `json:"username" validate:"omitempty,alphanum,min=3,max=30"`
+	CurrentPassword string `json:"current_password,omitempty"`
+}
+
+// patchUserRequest only changes the fields present in the payload.
+type patchUserRequest struct {
+	Name            nullable `json:"name" swaggertype:"string"`
+	Email           nullable `json:"email" swaggertype:"string"`
+	Username        nullable `json:"username" swaggertype:"string"`
+	CurrentPassword string   `json:"current_password"`
+}
+
+// nullable tells an absent field (Set is false) apart from
+// an explicit null (Set is true and Value is nil).
+type nullable struct {
+	Set   bool
+	Value *string
+}
+
+func (n *nullable) UnmarshalJSON(data []byte) error {
+	n.Set = true
+
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+
+	return json.Unmarshal(data, &n.Value)
 }
 
 // FindAll godoc
 // @Summary      List of users
-// @Description  lists all users
+// @Description  lists all users, a page of them with limit or cursor, or the users given by ids, for the users or the services granted users:read
 // @Tags         user
 // @Accept       json
 // @Produce      json
-// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        Authorization  header    string  false  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        X-API-Key      header    string  false  "the API key of a service, instead of the access token"
+// @Param        fields         query     string  false  "comma separated list of fields to return"
+// @Param        stream         query     bool    false  "streams the list as a raw JSON array"
+// @Param        ids            query     string  false  "comma separated list of user uuids to get"
+// @Param        limit          query     int     false  "pages the list, maximum number of users (20 by default, 100 at most)"
+// @Param        cursor         query     string  false  "the cursor of the Link rel=next header of the previous page"
 // @Success      200            {object}  []domain.User
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
 // @Failure      500            {object}  rest.Message
 // @Router       /user [get]
 func (u *UserHandler) FindAll(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r)
+	if err != nil {
+		rest.DecodeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Has("ids") {
+		u.findByIDs(w, r, fields)
+		return
+	}
+
+	if r.URL.Query().Get("stream") == "true" {
+		u.findAllStream(w, r, fields)
+		return
+	}
+
+	if r.URL.Query().Has("limit") || r.URL.Query().Has(cursor.Param) {
+		u.findPage(w, r, fields)
+		return
+	}
+
+	if u.notModified(w, r, fields) {
+		return
+	}
+
 	users, err := u.userUseCase.FindAll(r.Context())
 	if err != nil {
 		clog.Error(err, domain.ErrFindAll.Error())
@@ -62,26 +236,436 @@ func (u *UserHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rest.JSON(w, http.StatusOK, &users)
+	writeUsers(w, r, users, fields)
+}
+
+// findPage lists a page of users, keyset paginated. The next page, if
+// any, is linked with a Link rel=next header whose cursor is signed for
+// this query, so it can't be moved to another row nor reused with other
+// parameters.
+func (u *UserHandler) findPage(w http.ResponseWriter, r *http.Request, fields []string) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	scope := cursor.Scope(r)
+
+	var after *domain.ListPosition
+	if value := r.URL.Query().Get(cursor.Param); value != "" {
+		after = new(domain.ListPosition)
+		if err := u.cursors.Decode(value, scope, after); err != nil {
+			rest.DecodeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// One more user tells whether there's a next page.
+	users, err := u.userUseCase.FindPage(r.Context(), after, limit+1)
+	if err != nil {
+		clog.Error(err, domain.ErrFindAll.Error())
+		rest.DecodeError(w, r, domain.ErrFindAll, http.StatusInternalServerError)
+		return
+	}
+
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[limit-1]
+
+		next, err := u.cursors.Encode(scope, domain.ListPosition{CreatedAt: last.CreatedAt, UUID: last.UUID})
+		if err != nil {
+			clog.Error(err, domain.ErrFindAll.Error())
+			rest.DecodeError(w, r, domain.ErrFindAll, http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		query.Set(cursor.Param, next)
+		w.Header().Set("Link", "<"+r.URL.Path+"?"+query.Encode()+`>; rel="next"`)
+	}
+
+	writeUsers(w, r, users, fields)
+}
+
+// writeUsers writes the list of users, with only the fields when any.
+func writeUsers(w http.ResponseWriter, r *http.Request, users []*domain.User, fields []string) {
+	if len(fields) == 0 {
+		rest.JSONList(w, r, http.StatusOK, userList(users), len(users))
+		return
+	}
+
+	list := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		list = append(list, selectFields(user, fields))
+	}
+
+	rest.JSONList(w, r, http.StatusOK, &list, len(list))
+}
+
+// notModified sets the ETag of the list, made of the latest update, to
+// the microsecond, and the number of users so that a deletion changes
+// it too, and its Last-Modified, the latest update. It answers 304 when
+// the If-None-Match of the request has the ETag or, without one, when
+// the If-Modified-Since isn't older. HTTP dates have a precision of a
+// second, so Last-Modified is only set once the second of the latest
+// update is over: a later change in that second would otherwise go
+// unnoticed. Deletions don't move it either, only the ETag tells them.
+// The ETag depends on the representation too, the fields and their
+// case, and the envelope asked for with Accept is declared with Vary.
+// When the version can't be read the list is just sent.
+func (u *UserHandler) notModified(w http.ResponseWriter, r *http.Request, fields []string) bool {
+	w.Header().Add("Vary", "Accept")
+
+	version, err := u.userUseCase.ListVersion(r.Context())
+	if err != nil {
+		clog.Error(err, "failed to read the last modification of the users")
+		return false
+	}
+
+	etag := listETag(version, fields)
+	w.Header().Set("ETag", etag)
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if !etagMatches(ifNoneMatch, etag) {
+			return false
+		}
+
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	lastModified := version.LastModified.UTC().Truncate(time.Second)
+	if lastModified.IsZero() || time.Now().Before(lastModified.Add(time.Second)) {
+		return false
+	}
+
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// listETag is the weak ETag of the list version in the representation
+// of the fields, e.g. W/"16f5a3b2c4d00000-12-1c9a3e07".
+func listETag(version *domain.ListVersion, fields []string) string {
+	var nanos int64
+	if !version.LastModified.IsZero() {
+		nanos = version.LastModified.UnixNano()
+	}
+
+	representation := crc32.ChecksumIEEE([]byte(string(rest.ResponseFieldCase()) + ":" + strings.Join(fields, ",")))
+
+	return `W/"` + strconv.FormatInt(nanos, 16) + "-" + strconv.Itoa(version.Count) + "-" +
+		strconv.FormatUint(uint64(representation), 16) + `"`
+}
+
+// etagMatches checks if the If-None-Match header lists the ETag, or is
+// *. The comparison is weak, W/ prefixes are ignored.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// findAllStream encodes the users one by one as a JSON array,
+// so memory stays bounded regardless of the number of rows.
+func (u *UserHandler) findAllStream(w http.ResponseWriter, r *http.Request, fields []string) {
+	flusher, _ := w.(http.Flusher)
+	encoder := rest.NewEncoder(w)
+
+	rows := 0
+
+	err := u.userUseCase.FindAllStream(r.Context(), func(user *domain.User) error {
+		separator := []byte(",")
+		if rows == 0 {
+			w.WriteHeader(http.StatusOK)
+			separator = []byte("[")
+		}
+
+		if _, err := w.Write(separator); err != nil {
+			return err
+		}
+
+		var dest interface{} = newUserListItem(user)
+		if len(fields) > 0 {
+			dest = selectFields(user, fields)
+		}
+
+		if err := encoder.Encode(dest); err != nil {
+			return err
+		}
+
+		rows++
+		if flusher != nil && rows%flushRows == 0 {
+			flusher.Flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		clog.Error(err, domain.ErrFindAll.Error())
+		if rows == 0 {
+			rest.DecodeError(w, r, domain.ErrFindAll, http.StatusInternalServerError)
+		}
+		// Otherwise the response is already streaming, so the
+		// array is left unterminated for the client to notice.
+		return
+	}
+
+	if rows == 0 {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte("[")); err != nil {
+			return
+		}
+	}
+
+	if _, err := w.Write([]byte("]\n")); err != nil {
+		return
+	}
+}
+
+// findByIDsResponse lists the requested users in the requested order
+// and the ids that don't match any user.
+type findByIDsResponse struct {
+	Users    interface{} `json:"users"`
+	NotFound []uuid.UUID `json:"not_found"`
+}
+
+// findByIDs gets several users in a single lookup.
+func (u *UserHandler) findByIDs(w http.ResponseWriter, r *http.Request, fields []string) {
+	uuids, err := parseIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		rest.DecodeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	users, notFound, err := u.userUseCase.FindByIDs(r.Context(), uuids)
+	if err != nil {
+		clog.Error(err, domain.ErrFindAll.Error())
+		rest.DecodeError(w, r, domain.ErrFindAll, http.StatusInternalServerError)
+		return
+	}
+
+	response := findByIDsResponse{Users: userList(users), NotFound: notFound}
+
+	if len(fields) > 0 {
+		list := make([]map[string]interface{}, 0, len(users))
+		for _, user := range users {
+			list = append(list, selectFields(user, fields))
+		}
+		response.Users = list
+	}
+
+	rest.JSONResource(w, r, http.StatusOK, &response)
+}
+
+// Search godoc
+// @Summary      Search users
+// @Description  lists the id, name and email of the users whose name starts with q, ordered by name (admin only)
+// @Tags         user
+// @Produce      json
+// @Param        Authorization  header    string  true   "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        q              query     string  true   "name prefix, at least 2 characters"
+// @Param        limit          query     int     false  "maximum number of users (10 by default, 50 at most)"
+// @Success      200            {object}  []searchResultResponse
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /user/search [get]
+func (u *UserHandler) Search(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSpace(r.URL.Query().Get("q"))
+	if utf8.RuneCountInString(prefix) < minSearchLength {
+		rest.DecodeError(w, r, domain.ErrSearchTooShort, http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	users, err := u.userUseCase.SearchByName(r.Context(), prefix, limit)
+	if err != nil {
+		clog.Error(err, domain.ErrSearch.Error())
+		rest.DecodeError(w, r, domain.ErrSearch, http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]searchResultResponse, 0, len(users))
+	for _, user := range users {
+		results = append(results, searchResultResponse{ID: user.UUID, Name: user.Name, Email: user.Email})
+	}
+
+	rest.JSONList(w, r, http.StatusOK, &results, len(results))
+}
+
+// searchResultResponse is a user found by Search, with just what's needed
+// to pick one.
+type searchResultResponse struct {
+	ID    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+}
+
+// Signups godoc
+// @Summary      Count the signups
+// @Description  counts the users created from from (inclusive) to to (exclusive) by day, week or month (admin only)
+// @Tags         user
+// @Produce      json
+// @Param        Authorization  header    string  true   "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        from           query     string  true   "start date, YYYY-MM-DD, YYYY-MM-DDTHH:MM:SS in UTC or RFC 3339"
+// @Param        to             query     string  true   "end date, YYYY-MM-DD, YYYY-MM-DDTHH:MM:SS in UTC or RFC 3339"
+// @Param        interval       query     string  false  "day (default), week or month"
+// @Success      200            {object}  []signupBucketResponse
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /user/stats/signups [get]
+func (u *UserHandler) Signups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	from, err := rest.ParseTime(query.Get("from"))
+	if err != nil {
+		rest.DecodeError(w, r, domain.ErrStatsRange, http.StatusBadRequest)
+		return
+	}
+
+	to, err := rest.ParseTime(query.Get("to"))
+	if err != nil {
+		rest.DecodeError(w, r, domain.ErrStatsRange, http.StatusBadRequest)
+		return
+	}
+
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = domain.IntervalDay
+	}
+
+	buckets, err := u.userUseCase.CountSignups(r.Context(), from, to, interval)
+	if errors.Is(err, domain.ErrStatsRange) || errors.Is(err, domain.ErrStatsInterval) {
+		rest.DecodeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrStats.Error())
+		rest.DecodeError(w, r, domain.ErrStats, http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]signupBucketResponse, 0, len(buckets))
+	for _, bucket := range buckets {
+		response = append(response, signupBucketResponse{Bucket: rest.Time(bucket.Bucket), Count: rest.Int64(bucket.Count)})
+	}
+
+	rest.JSONList(w, r, http.StatusOK, &response, len(response))
+}
+
+// signupBucketResponse is a SignupBucket whose count can be sent as a
+// string, see rest.Int64.
+type signupBucketResponse struct {
+	Bucket rest.Time  `json:"bucket"`
+	Count  rest.Int64 `json:"count"`
+}
+
+// Export godoc
+// @Summary      Export the users
+// @Description  exports all users as CSV (admin only)
+// @Tags         user
+// @Produce      text/csv
+// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Success      200            {string}  string
+// @Failure      403            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /user/export.csv [get]
+func (u *UserHandler) Export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+
+	flusher, _ := w.(http.Flusher)
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"name", "email", "created_at"}); err != nil {
+		clog.Error(err, domain.ErrExport.Error())
+		return
+	}
+
+	rows := 0
+
+	err := u.userUseCase.FindAllStream(r.Context(), func(user *domain.User) error {
+		if err := writer.Write([]string{
+			user.Name,
+			user.Email,
+			user.CreatedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%flushRows == 0 {
+			writer.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		return writer.Error()
+	})
+	if err != nil {
+		// The response is already streaming, so the status can't change.
+		clog.Error(err, domain.ErrExport.Error())
+		return
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		clog.Error(err, domain.ErrExport.Error())
+	}
 }
 
 // FindByID godoc
 // @Summary      List an user
-// @Description  lists an user by uuid
+// @Description  lists an user by uuid, for the users or the services granted users:read
 // @Tags         user
 // @Accept       json
 // @Produce      json
-// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
-// @Param        uuid           path      string  true  "user uuid"
+// @Param        Authorization  header    string  false  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        X-API-Key      header    string  false  "the API key of a service, instead of the access token"
+// @Param        uuid           path      string  true   "user uuid, or me"
+// @Param        fields         query     string  false  "comma separated list of fields to return"
 // @Success      200            {object}  domain.User
+// @Header       200            {string}  Last-Modified  "updated_at of the user, as an HTTP date"
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
 // @Failure      422            {object}  rest.Message
 // @Failure      500            {object}  rest.Message
 // @Router       /user/{uuid} [get]
 func (u *UserHandler) FindByID(w http.ResponseWriter, r *http.Request) {
-	uuid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	uuid, err := pathUUID(r)
 	if err != nil {
 		clog.Error(err, domain.ErrUUIDParse.Error())
-		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusInternalServerError)
+		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
+		return
+	}
+
+	fields, err := parseFields(r)
+	if err != nil {
+		rest.DecodeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -92,36 +676,72 @@ func (u *UserHandler) FindByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rest.JSON(w, http.StatusOK, user)
+	// The users of the other tenants are just not found, so their
+	// existence doesn't leak.
+	if user == nil || user.UUID != uuid {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	setLastModified(w, user)
+
+	if len(fields) == 0 {
+		item := newUserListItem(user)
+		rest.JSONResource(w, r, http.StatusOK, &item)
+		return
+	}
+
+	rest.JSONResource(w, r, http.StatusOK, selectFields(user, fields))
+}
+
+// setLastModified sets the Last-Modified of the user, its updated_at,
+// which the clients send back in If-Unmodified-Since.
+func setLastModified(w http.ResponseWriter, user *domain.User) {
+	if !user.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", user.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
 }
 
 // Add godoc
 // @Summary      Add an user
-// @Description  add a new user
+// @Description  add a new user, with any role (admin only)
 // @Tags         user
 // @Accept       json
 // @Produce      json
-// @Param        Authorization  header    string             true  "Insert your access token"  default(Bearer <Add access token here>)
-// @Param        payload        body      createUserRequest  true  "add a new user"
-// @Success      201            {object}  rest.Message
-// @Failure      400            {object}  rest.Message
-// @Failure      422            {object}  rest.Message
-// @Failure      500            {object}  rest.Message
+// @Param        Authorization    header    string             true   "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        payload          body      createUserRequest  true   "add a new user"
+// @Param        Idempotency-Key  header    string             false  "replays the response of the first request with the same key"
+// @Success      201              {object}  createdUserResponse
+// @Header       201              {string}  Location  "/user/{uuid}"
+// @Failure      400              {object}  rest.Message
+// @Failure      403              {object}  rest.Message
+// @Failure      409              {object}  rest.Message
+// @Failure      422              {object}  rest.Message
+// @Failure      500              {object}  rest.Message
 // @Router       /user [post]
 func (u *UserHandler) Add(w http.ResponseWriter, r *http.Request) {
 	var payload createUserRequest
 
-	err := json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
-		clog.Error(err, domain.ErrAdd.Error())
-		rest.DecodeError(w, r, domain.ErrAdd, http.StatusInternalServerError)
+	if !rest.DecodeJSON(w, r, &payload) {
 		return
 	}
 
 	validation := validation.New()
 
 	if err := validation.BindStruct(r.Context(), payload); err != nil {
-		validation.DecodeError(w, err)
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	user := domain.User{
+		Name:     payload.Name,
+		Email:    payload.Email,
+		Username: optional(payload.Username),
+		Role:     payload.Role,
+	}
+
+	// The user has no uuid yet, the policy knows it's being created.
+	if !u.validatePassword(w, r, payload.Password, &user) {
 		return
 	}
 
@@ -134,23 +754,43 @@ func (u *UserHandler) Add(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := domain.User{
-		UUID:      uuid.New(),
-		Name:      payload.Name,
-		Email:     payload.Email,
-		Password:  hashPass,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
+	user.UUID = idgen.New()
+	user.Password = hashPass
 
 	err = u.userUseCase.Add(r.Context(), &user)
+	if errors.Is(err, domain.ErrEmailTaken) {
+		rest.DecodeError(w, r, domain.ErrEmailTaken, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrUsernameTaken) {
+		rest.DecodeError(w, r, domain.ErrUsernameTaken, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrEmailDomainBlocked) {
+		rest.DecodeError(w, r, domain.ErrEmailDomainBlocked, http.StatusUnprocessableEntity)
+		return
+	}
+	var invalid *domain.ValidationError
+	if errors.As(err, &invalid) {
+		rest.DecodeError(w, r, invalid, http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, domain.ErrEmailNoMX) {
+		rest.DecodeError(w, r, domain.ErrEmailNoMX, http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, domain.ErrQuotaExceeded) {
+		rest.DecodeError(w, r, domain.ErrQuotaExceeded, http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		clog.Error(err, domain.ErrAdd.Error())
 		rest.DecodeError(w, r, domain.ErrAdd, http.StatusUnprocessableEntity)
 		return
 	}
 
-	rest.JSON(w, http.StatusCreated, &rest.Message{Message: "Created"})
+	w.Header().Set("Location", "/user/"+user.UUID.String())
+	rest.JSONResource(w, r, http.StatusCreated, &createdUserResponse{userListItem: newUserListItem(&user)})
 }
 
 // Update godoc
@@ -160,27 +800,27 @@ func (u *UserHandler) Add(w http.ResponseWriter, r *http.Request) {
 // @Accept       json
 // @Produce      json
 // @Param        Authorization  header    string             true  "Insert your access token"  default(Bearer <Add access token here>)
-// @Param        uuid           path      string             true  "user uuid"
+// @Param        uuid           path      string             true  "user uuid, or me"
 // @Param        payload        body      updateUserRequest  true  "update an user by uuid"
 // @Success      200            {object}  rest.Message
 // @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      409            {object}  rest.Message
 // @Failure      422            {object}  rest.Message
 // @Failure      500            {object}  rest.Message
 // @Router       /user/{uuid} [put]
 func (u *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
-	uuid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	uuid, err := pathUUID(r)
 	if err != nil {
 		clog.Error(err, domain.ErrUUIDParse.Error())
-		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusInternalServerError)
+		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
 		return
 	}
 
 	var payload updateUserRequest
 
-	err = json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
-		clog.Error(err, domain.ErrUpdate.Error())
-		rest.DecodeError(w, r, domain.ErrUpdate, http.StatusUnprocessableEntity)
+	if !rest.DecodeJSON(w, r, &payload) {
 		return
 	}
 
@@ -188,47 +828,205 @@ func (u *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	if err := validation.BindStruct(r.Context(), payload); err != nil {
 		clog.Error(err, domain.ErrUpdate.Error())
-		validation.DecodeError(w, err)
+		validation.DecodeError(w, r, err)
 		return
 	}
 
+	if stepUpEmail(r, uuid) {
+		current, err := u.userUseCase.FindByID(database.WithPrimaryRead(r.Context()), uuid)
+		if err != nil {
+			clog.Error(err, domain.ErrUpdate.Error())
+			rest.DecodeError(w, r, domain.ErrUpdate, http.StatusUnprocessableEntity)
+			return
+		}
+
+		// A missing user is reported by the update.
+		if current != nil && current.UUID == uuid &&
+			domain.CanonicalEmail(current.Email) != domain.CanonicalEmail(payload.Email) &&
+			!u.confirmPassword(w, r, current, payload.CurrentPassword) {
+			return
+		}
+	}
+
 	user := domain.User{
-		Name:      payload.Name,
-		Email:     payload.Email,
-		UpdatedAt: time.Now(),
+		Name:     payload.Name,
+		Email:    payload.Email,
+		Username: optional(payload.Username),
 	}
 
 	err = u.userUseCase.Update(r.Context(), uuid, &user)
-	if err != nil {
-		clog.Error(err, domain.ErrUpdate.Error())
-		rest.DecodeError(w, r, domain.ErrUpdate, http.StatusUnprocessableEntity)
+	if errors.Is(err, domain.ErrResourceNotFound) {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
 		return
 	}
-
-	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Updated"})
-}
-
-// Update godoc
-// @Summary      Delete an user
-// @Description  delete an user by uuid
+	if errors.Is(err, domain.ErrEmailTaken) {
+		rest.DecodeError(w, r, domain.ErrEmailTaken, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrUsernameTaken) {
+		rest.DecodeError(w, r, domain.ErrUsernameTaken, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrEmailDomainBlocked) {
+		rest.DecodeError(w, r, domain.ErrEmailDomainBlocked, http.StatusUnprocessableEntity)
+		return
+	}
+	var invalid *domain.ValidationError
+	if errors.As(err, &invalid) {
+		rest.DecodeError(w, r, invalid, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrUpdate.Error())
+		rest.DecodeError(w, r, domain.ErrUpdate, http.StatusUnprocessableEntity)
+		return
+	}
+
+	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Updated"})
+}
+
+// Patch godoc
+// @Summary      Patch an user
+// @Description  update only the given fields of an user by uuid; a null username clears it
 // @Tags         user
 // @Accept       json
 // @Produce      json
-// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
-// @Param        uuid           path      string  true  "user uuid"
+// @Param        Authorization  header    string            true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        uuid           path      string            true  "user uuid, or me"
+// @Param        payload        body      patchUserRequest  true  "the fields to update"
 // @Success      200            {object}  rest.Message
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      409            {object}  rest.Message
 // @Failure      422            {object}  rest.Message
 // @Failure      500            {object}  rest.Message
+// @Router       /user/{uuid} [patch]
+func (u *UserHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	uuid, err := pathUUID(r)
+	if err != nil {
+		clog.Error(err, domain.ErrUUIDParse.Error())
+		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
+		return
+	}
+
+	var payload patchUserRequest
+
+	if !rest.DecodeJSON(w, r, &payload) {
+		return
+	}
+
+	for field, value := range map[string]nullable{"name": payload.Name, "email": payload.Email} {
+		if value.Set && value.Value == nil {
+			err := fmt.Errorf("the %s field cannot be null", field)
+			rest.DecodeError(w, r, err, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// The current user is read from the primary, as it's about to be written.
+	user, err := u.userUseCase.FindByID(database.WithPrimaryRead(r.Context()), uuid)
+	if err != nil {
+		clog.Error(err, domain.ErrUpdate.Error())
+		rest.DecodeError(w, r, domain.ErrUpdate, http.StatusUnprocessableEntity)
+		return
+	}
+	if user == nil || user.UUID != uuid {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if payload.Email.Set && stepUpEmail(r, uuid) &&
+		domain.CanonicalEmail(user.Email) != domain.CanonicalEmail(*payload.Email.Value) &&
+		!u.confirmPassword(w, r, user, payload.CurrentPassword) {
+		return
+	}
+
+	if payload.Name.Set {
+		user.Name = *payload.Name.Value
+	}
+	if payload.Email.Set {
+		user.Email = *payload.Email.Value
+	}
+	if payload.Username.Set {
+		user.Username = payload.Username.Value
+	}
+
+	// The merged user goes through the same rules as a full update.
+	merged := updateUserRequest{Name: user.Name, Email: user.Email}
+	if user.Username != nil {
+		merged.Username = *user.Username
+	}
+
+	validation := validation.New()
+
+	if err := validation.BindStruct(r.Context(), merged); err != nil {
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	user.Username = optional(merged.Username)
+
+	err = u.userUseCase.Update(r.Context(), uuid, user)
+	if errors.Is(err, domain.ErrEmailTaken) {
+		rest.DecodeError(w, r, domain.ErrEmailTaken, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrUsernameTaken) {
+		rest.DecodeError(w, r, domain.ErrUsernameTaken, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrEmailDomainBlocked) {
+		rest.DecodeError(w, r, domain.ErrEmailDomainBlocked, http.StatusUnprocessableEntity)
+		return
+	}
+	var invalid *domain.ValidationError
+	if errors.As(err, &invalid) {
+		rest.DecodeError(w, r, invalid, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrUpdate.Error())
+		rest.DecodeError(w, r, domain.ErrUpdate, http.StatusUnprocessableEntity)
+		return
+	}
+
+	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Updated"})
+}
+
+// Update godoc
+// @Summary      Delete an user
+// @Description  delete an user by uuid, unless it was modified since If-Unmodified-Since
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Param        Authorization        header    string  true   "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        uuid                 path      string  true   "user uuid, or me"
+// @Param        If-Unmodified-Since  header    string  false  "HTTP date, the Last-Modified of the user last seen"
+// @Success      200                  {object}  rest.Message
+// @Failure      400                  {object}  rest.Message
+// @Failure      404                  {object}  rest.Message
+// @Failure      412                  {object}  rest.Message
+// @Failure      422                  {object}  rest.Message
+// @Failure      500                  {object}  rest.Message
 // @Router       /user/{uuid} [delete]
 func (u *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	uuid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	uuid, err := pathUUID(r)
 	if err != nil {
-		clog.Error(err, domain.ErrDelete.Error())
-		rest.DecodeError(w, r, domain.ErrDelete, http.StatusInternalServerError)
+		clog.Error(err, domain.ErrUUIDParse.Error())
+		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
+		return
+	}
+
+	if !u.unmodifiedSince(w, r, uuid) {
 		return
 	}
 
 	err = u.userUseCase.Delete(r.Context(), uuid)
+	if errors.Is(err, domain.ErrResourceNotFound) {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		clog.Error(err, domain.ErrDelete.Error())
 		rest.DecodeError(w, r, domain.ErrDelete, http.StatusUnprocessableEntity)
@@ -237,3 +1035,542 @@ func (u *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Deleted"})
 }
+
+// unmodifiedSince checks the If-Unmodified-Since of the request, answering
+// 412 when the user was updated after it. HTTP dates are to the second,
+// so is the comparison. Without the header, or when it isn't a date,
+// there is nothing to check. It returns false once it has answered.
+func (u *UserHandler) unmodifiedSince(w http.ResponseWriter, r *http.Request, uuid uuid.UUID) bool {
+	since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	if err != nil {
+		return true
+	}
+
+	// Read from the primary, a lagging replica could miss the update.
+	user, err := u.userUseCase.FindByID(database.WithPrimaryRead(r.Context()), uuid)
+	if err != nil {
+		clog.Error(err, domain.ErrDelete.Error())
+		rest.DecodeError(w, r, domain.ErrDelete, http.StatusUnprocessableEntity)
+		return false
+	}
+	if user == nil || user.UUID != uuid {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return false
+	}
+
+	if user.UpdatedAt.UTC().Truncate(time.Second).After(since) {
+		setLastModified(w, user)
+		rest.DecodeError(w, r, domain.ErrModifiedSince, http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+// LogoutAll godoc
+// @Summary      Log out a user everywhere
+// @Description  bumps the token version of the user, invalidating all their tokens (admin only)
+// @Tags         user
+// @Produce      json
+// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        uuid           path      string  true  "user uuid, or me"
+// @Success      200            {object}  rest.Message
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /user/{uuid}/logout-all [post]
+func (u *UserHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	uuid, err := pathUUID(r)
+	if err != nil {
+		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
+		return
+	}
+
+	err = u.userUseCase.LogoutAll(r.Context(), uuid)
+	if errors.Is(err, domain.ErrResourceNotFound) {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrLogout.Error())
+		rest.DecodeError(w, r, domain.ErrLogout, http.StatusInternalServerError)
+		return
+	}
+
+	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Logged out"})
+}
+
+// resetPasswordRequest needs the CurrentPassword when admins reset
+// their own password.
+type resetPasswordRequest struct {
+	Password        string `json:"password" validate:"required"`
+	CurrentPassword string `json:"current_password,omitempty"`
+}
+
+// ResetPassword godoc
+// @Summary      Reset the password of a user
+// @Description  sets a new password without the current one and logs the user out everywhere (admin only); the latest passwords of the user can't be reused
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Param        Authorization  header    string                true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        uuid           path      string                true  "user uuid, or me"
+// @Param        payload        body      resetPasswordRequest  true  "the new password"
+// @Success      200            {object}  rest.Message
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      422            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /user/{uuid}/reset-password [post]
+func (u *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	uuid, err := pathUUID(r)
+	if err != nil {
+		rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
+		return
+	}
+
+	var payload resetPasswordRequest
+
+	if !rest.DecodeJSON(w, r, &payload) {
+		return
+	}
+
+	validation := validation.New()
+
+	if err := validation.BindStruct(r.Context(), payload); err != nil {
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	if !ok {
+		rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if claims.UUID == uuid {
+		current, err := u.userUseCase.FindByID(database.WithPrimaryRead(r.Context()), uuid)
+		if err != nil {
+			clog.Error(err, domain.ErrReset.Error())
+			rest.DecodeError(w, r, domain.ErrReset, http.StatusInternalServerError)
+			return
+		}
+		if current == nil || current.UUID != uuid {
+			rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+
+		if !u.confirmPassword(w, r, current, payload.CurrentPassword) {
+			return
+		}
+	}
+
+	if !u.setPassword(w, r, uuid, payload.Password) {
+		return
+	}
+
+	u.audit.Record(r.Context(), audit.Entry{Action: "user.reset-password", Actor: claims.UUID, Target: uuid})
+
+	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Password reset"})
+}
+
+// changePasswordRequest always needs the CurrentPassword.
+type changePasswordRequest struct {
+	Password        string `json:"password" validate:"required"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// ChangePassword godoc
+// @Summary      Change the own password
+// @Description  sets a new password given the current one, logs the user out everywhere and renews the password age; the latest passwords can't be reused
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Param        Authorization  header    string                 true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        payload        body      changePasswordRequest  true  "the current and the new password"
+// @Success      200            {object}  rest.Message
+// @Failure      400            {object}  rest.Message
+// @Failure      403            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      422            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /me/password [post]
+func (u *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	if !ok {
+		rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var payload changePasswordRequest
+
+	if !rest.DecodeJSON(w, r, &payload) {
+		return
+	}
+
+	validation := validation.New()
+
+	if err := validation.BindStruct(r.Context(), payload); err != nil {
+		validation.DecodeError(w, r, err)
+		return
+	}
+
+	current, err := u.userUseCase.FindByID(database.WithPrimaryRead(r.Context()), claims.UUID)
+	if err != nil {
+		clog.Error(err, domain.ErrReset.Error())
+		rest.DecodeError(w, r, domain.ErrReset, http.StatusInternalServerError)
+		return
+	}
+	if current == nil || current.UUID != claims.UUID {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if !u.confirmPassword(w, r, current, payload.CurrentPassword) {
+		return
+	}
+
+	if !u.setPassword(w, r, claims.UUID, payload.Password) {
+		return
+	}
+
+	u.audit.Record(r.Context(), audit.Entry{Action: "user.change-password", Actor: claims.UUID, Target: claims.UUID})
+
+	rest.JSON(w, http.StatusOK, &rest.Message{Message: "Password changed"})
+}
+
+// validatePassword checks the password against the policy, answering
+// 422 to the password it rejects. It returns false on failure.
+func (u *UserHandler) validatePassword(w http.ResponseWriter, r *http.Request, password string, user *domain.User) bool {
+	err := u.userUseCase.ValidatePassword(r.Context(), password, user)
+	if errors.Is(err, domain.ErrPasswordReused) {
+		rest.DecodeError(w, r, domain.ErrPasswordReused, http.StatusUnprocessableEntity)
+		return false
+	}
+	var invalid *domain.ValidationError
+	if errors.As(err, &invalid) {
+		rest.DecodeError(w, r, invalid, http.StatusUnprocessableEntity)
+		return false
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrReset.Error())
+		rest.DecodeError(w, r, domain.ErrReset, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// setPassword hashes and sets the password of the user, unless the
+// policy rejects it. It answers the request and returns false on failure.
+func (u *UserHandler) setPassword(w http.ResponseWriter, r *http.Request, uuid uuid.UUID, password string) bool {
+	if !u.validatePassword(w, r, password, &domain.User{UUID: uuid}) {
+		return false
+	}
+
+	hashPass, err := crypto.New().HashPassword(password, 10)
+	if err != nil {
+		clog.Error(err, domain.ErrHashPassword.Error())
+		rest.DecodeError(w, r, domain.ErrHashPassword, http.StatusUnprocessableEntity)
+		return false
+	}
+
+	err = u.userUseCase.ResetPassword(r.Context(), uuid, hashPass)
+	if errors.Is(err, domain.ErrResourceNotFound) {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrReset.Error())
+		rest.DecodeError(w, r, domain.ErrReset, http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// maxAvatarSize bounds the avatar images, the multipart body may be
+// up to avatarFormOverhead larger for the headers of its parts.
+const (
+	maxAvatarSize      = 2 << 20
+	avatarFormOverhead = 64 << 10
+)
+
+// avatarTypes are the types an avatar can be, sniffed from the image
+// itself rather than trusting the type sent by the client.
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Avatar godoc
+// @Summary      Upload an avatar
+// @Description  sets the avatar of the authenticated user from the avatar part of a multipart form, replacing the previous one
+// @Tags         user
+// @Accept       mpfd
+// @Produce      json
+// @Param        Authorization  header    string  true  "Insert your access token"  default(Bearer <Add access token here>)
+// @Param        avatar         formData  file    true  "a PNG, JPEG, GIF or WebP image of up to 2 MiB"
+// @Success      200            {object}  createdUserResponse
+// @Failure      400            {object}  rest.Message
+// @Failure      401            {object}  rest.Message
+// @Failure      404            {object}  rest.Message
+// @Failure      413            {object}  rest.Message
+// @Failure      415            {object}  rest.Message
+// @Failure      500            {object}  rest.Message
+// @Router       /me/avatar [post]
+func (u *UserHandler) Avatar(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	if !ok {
+		rest.DecodeError(w, r, errors.New("unathorized"), http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+avatarFormOverhead)
+
+	image, err := readAvatar(r)
+	if errors.Is(err, domain.ErrAvatarTooLarge) {
+		rest.DecodeError(w, r, domain.ErrAvatarTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		rest.DecodeError(w, r, domain.ErrAvatarMissing, http.StatusBadRequest)
+		return
+	}
+
+	contentType := http.DetectContentType(image)
+	if !avatarTypes[contentType] {
+		rest.DecodeError(w, r, domain.ErrAvatarType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	user, err := u.userUseCase.SetAvatar(r.Context(), claims.UUID, bytes.NewReader(image), contentType)
+	if errors.Is(err, domain.ErrResourceNotFound) {
+		rest.DecodeError(w, r, domain.ErrResourceNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		clog.Error(err, domain.ErrAvatar.Error())
+		rest.DecodeError(w, r, domain.ErrAvatar, http.StatusInternalServerError)
+		return
+	}
+
+	rest.JSONResource(w, r, http.StatusOK, &createdUserResponse{userListItem: newUserListItem(user)})
+}
+
+// readAvatar reads the avatar part of the multipart body, skipping the
+// other parts. It returns domain.ErrAvatarTooLarge beyond maxAvatarSize.
+func readAvatar(r *http.Request) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, tooLarge(err)
+		}
+
+		if part.FormName() != "avatar" {
+			continue
+		}
+
+		image, err := io.ReadAll(io.LimitReader(part, maxAvatarSize+1))
+		if err != nil {
+			return nil, tooLarge(err)
+		}
+		if len(image) > maxAvatarSize {
+			return nil, domain.ErrAvatarTooLarge
+		}
+		if len(image) == 0 {
+			return nil, domain.ErrAvatarMissing
+		}
+
+		return image, nil
+	}
+}
+
+// tooLarge tells the body cut by http.MaxBytesReader apart from the
+// malformed ones.
+func tooLarge(err error) error {
+	if err != nil && err.Error() == "http: request body too large" {
+		return domain.ErrAvatarTooLarge
+	}
+	return err
+}
+
+// rejectEmptyUUID answers 400 to the paths whose uuid is empty or blank,
+// e.g. /user/ or /user//logout-all, which chi would otherwise route to
+// the list or to nowhere.
+func rejectEmptyUUID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cmiddleware.EmptyID(r.URL.Path) {
+			rest.DecodeError(w, r, domain.ErrUUIDParse, http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// meAlias stands for the authenticated user in the {uuid} of the routes,
+// e.g. GET /user/me.
+const meAlias = "me"
+
+// pathUUID parses the {uuid} of the route, meAlias being the uuid of the
+// authenticated user.
+func pathUUID(r *http.Request) (uuid.UUID, error) {
+	param := chi.URLParam(r, "uuid")
+
+	if claims, ok := cmiddleware.UserClaims(r.Context()); ok && param == meAlias {
+		return claims.UUID, nil
+	}
+
+	return uuid.Parse(param)
+}
+
+// ownUser checks if the authenticated user is the one with the uuid.
+func ownUser(r *http.Request, uuid uuid.UUID) bool {
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	return ok && claims.UUID == uuid
+}
+
+// stepUpEmail checks if changing the email of the user with the uuid
+// needs the current password of that user: it always does for the own
+// email of the caller, and for the others unless the caller is an admin.
+func stepUpEmail(r *http.Request, uuid uuid.UUID) bool {
+	if ownUser(r, uuid) {
+		return true
+	}
+
+	claims, ok := cmiddleware.UserClaims(r.Context())
+	return !ok || claims.Role != domain.RoleAdmin
+}
+
+// confirmPassword checks the current password of the user, asked again
+// before their email or password is changed, so a stolen token alone
+// can't take the account over. It answers the request with a 403
+// and returns false when the password is missing or doesn't match.
+func (u *UserHandler) confirmPassword(w http.ResponseWriter, r *http.Request, user *domain.User, password string) bool {
+	if password == "" {
+		rest.DecodeError(w, r, domain.ErrCurrentPassword, http.StatusForbidden)
+		return false
+	}
+
+	match, err := u.credentials.VerifyCredentials(r.Context(), user.Email, password)
+	if err != nil {
+		clog.Error(err, domain.ErrVerify.Error())
+		rest.DecodeError(w, r, domain.ErrVerify, http.StatusInternalServerError)
+		return false
+	}
+
+	if !match {
+		rest.DecodeError(w, r, domain.ErrCurrentPassword, http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// flushRows is the number of rows written between flushes when streaming.
+const flushRows = 100
+
+// maxBatchIDs is the maximum number of users requested at once.
+const maxBatchIDs = 100
+
+// The pages of the list are bounded like the searches.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// The search needs a few characters not to scan most of the index.
+const (
+	minSearchLength    = 2
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// userFields lists the fields a client is allowed to select.
+// The password must never be part of it.
+var userFields = map[string]func(user *domain.User) interface{}{
+	"id":         func(user *domain.User) interface{} { return user.UUID },
+	"name":       func(user *domain.User) interface{} { return user.Name },
+	"email":      func(user *domain.User) interface{} { return user.Email },
+	"username":   func(user *domain.User) interface{} { return user.Username },
+	"role":       func(user *domain.User) interface{} { return user.Role },
+	"avatar_url": func(user *domain.User) interface{} { return user.AvatarURL },
+	"created_at": func(user *domain.User) interface{} { return rest.Time(user.CreatedAt) },
+	"updated_at": func(user *domain.User) interface{} { return rest.Time(user.UpdatedAt) },
+}
+
+// parseFields reads the fields query param and checks it against
+// the allowed fields. An empty list means all fields.
+func parseFields(r *http.Request) ([]string, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+
+	fields := make([]string, 0)
+
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := userFields[field]; !ok {
+			return nil, domain.ErrFields
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// parseIDs reads a comma separated list of uuids,
+// skipping the duplicates.
+func parseIDs(param string) ([]uuid.UUID, error) {
+	uuids := make([]uuid.UUID, 0)
+	seen := make(map[uuid.UUID]bool)
+
+	for _, id := range strings.Split(param, ",") {
+		parsed, err := uuid.Parse(strings.TrimSpace(id))
+		if err != nil {
+			return nil, domain.ErrUUIDParse
+		}
+
+		if seen[parsed] {
+			continue
+		}
+		seen[parsed] = true
+
+		uuids = append(uuids, parsed)
+	}
+
+	if len(uuids) > maxBatchIDs {
+		return nil, domain.ErrTooManyIDs
+	}
+
+	return uuids, nil
+}
+
+// selectFields builds a map containing only the given fields of the user.
+func selectFields(user *domain.User, fields []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(fields))
+
+	for _, field := range fields {
+		selected[field] = userFields[field](user)
+	}
+
+	return selected
+}
+
+// optional returns nil for empty strings so they are stored as NULL.
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
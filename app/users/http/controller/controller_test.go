@@ -2,12 +2,26 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	apiKeysDomain "hexagony/app/apikeys/domain"
+	apiKeysMocks "hexagony/app/apikeys/domain/mocks"
+	cmiddleware "hexagony/app/shared/http/middleware"
 	"hexagony/app/users/domain"
 	"hexagony/app/users/domain/mocks"
+	"hexagony/lib/audit"
+	"hexagony/lib/cursor"
+	"hexagony/lib/database"
+	"hexagony/lib/rest"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,7 +36,63 @@ func TestNewUserHandler(t *testing.T) {
 
 	mockUserUseCase := new(mocks.UserUseCase)
 
-	NewUserHandler(router, mockUserUseCase)
+	NewUserHandler(router, mockUserUseCase, &fakeCredentials{}, new(apiKeysMocks.APIKeyUseCase))
+}
+
+// TestUserRoutesAPIKey checks the services read the users of the tenant
+// of their key, and only read them.
+func TestUserRoutesAPIKey(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockAPIKeyUseCase := new(apiKeysMocks.APIKeyUseCase)
+
+	inTenant := mock.MatchedBy(func(ctx context.Context) bool {
+		return database.Tenant(ctx) == "acme"
+	})
+
+	mockUserUseCase.
+		On("FindByID", inTenant, newUUID).
+		Return(&domain.User{UUID: newUUID, Name: "Cyro Dubeux", Password: "hash"}, nil).Once()
+	mockAPIKeyUseCase.
+		On("Verify", mock.Anything, "hxk_reader").
+		Return(&apiKeysDomain.APIKey{UUID: uuid.New(), TenantID: "acme", Service: "billing", Scopes: apiKeysDomain.Scopes{"users:read"}}, nil)
+	mockAPIKeyUseCase.
+		On("Verify", mock.Anything, "hxk_other").
+		Return(&apiKeysDomain.APIKey{UUID: uuid.New(), TenantID: "acme", Service: "billing", Scopes: apiKeysDomain.Scopes{"albums:read"}}, nil)
+
+	router := chi.NewRouter()
+	NewUserHandler(router, mockUserUseCase, &fakeCredentials{}, mockAPIKeyUseCase)
+
+	serve := func(method, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/user/"+newUUID.String(), strings.NewReader(`{"name": "Cyro"}`))
+		req.Header.Set(cmiddleware.APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "hxk_reader")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Cyro Dubeux")
+	assert.NotContains(t, rec.Body.String(), "password")
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "hxk_other").Code)
+
+	// The writes still take a user token.
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPatch, "hxk_reader").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodDelete, "hxk_reader").Code)
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+// TestJSONTags checks every field of the bodies has a snake_case json
+// tag, see rest.UntaggedFields.
+func TestJSONTags(t *testing.T) {
+	for _, v := range []interface{}{&domain.User{}, &domain.SignupBucket{}, &createUserRequest{}, &createdUserResponse{}, &updateUserRequest{}, &patchUserRequest{}, &findByIDsResponse{}, &signupBucketResponse{}, &searchResultResponse{}, &resetPasswordRequest{}, &changePasswordRequest{}} {
+		assert.Empty(t, rest.UntaggedFields(v))
+	}
 }
 
 func TestFindAll(t *testing.T) {
@@ -40,6 +110,7 @@ func TestFindAll(t *testing.T) {
 	}
 	mockUserList = append(mockUserList, &mockUser)
 
+	mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{}, nil)
 	mockUserUseCase.
 		On("FindAll", mock.Anything).
 		Return(mockUserList, nil)
@@ -66,6 +137,7 @@ func TestFindAll(t *testing.T) {
 func TestFindAllFail(t *testing.T) {
 	mockUserUseCase := new(mocks.UserUseCase)
 
+	mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{}, nil)
 	mockUserUseCase.
 		On("FindAll", mock.Anything).
 		Return(nil, domain.ErrFindAll)
@@ -89,6 +161,240 @@ func TestFindAllFail(t *testing.T) {
 	mockUserUseCase.AssertExpectations(t)
 }
 
+func TestFindAllPage(t *testing.T) {
+	created := time.Date(2022, 6, 6, 10, 30, 15, 123456000, time.UTC)
+
+	users := make([]*domain.User, 3)
+	for i := range users {
+		users[i] = &domain.User{
+			UUID:      uuid.New(),
+			Name:      "Cyro Dubeux",
+			Email:     "xorycx@gmail.com",
+			CreatedAt: created.Add(-time.Duration(i) * time.Minute),
+		}
+	}
+
+	serve := func(mockUserUseCase *mocks.UserUseCase, target string) *httptest.ResponseRecorder {
+		handler := UserHandler{
+			userUseCase: mockUserUseCase,
+			cursors:     cursor.New([]byte("secret")),
+		}
+
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		handler.FindAll(rec, req)
+
+		return rec
+	}
+
+	// next is the target of the Link rel=next header.
+	next := func(t *testing.T, rec *httptest.ResponseRecorder) string {
+		link := rec.Header().Get("Link")
+		assert.True(t, strings.HasSuffix(link, `>; rel="next"`), link)
+		return strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+	}
+
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("FindPage", mock.Anything, (*domain.ListPosition)(nil), 3).Return(users, nil).Once()
+
+	first := serve(mockUserUseCase, "/user?limit=2&fields=id,name")
+
+	assert.Equal(t, http.StatusOK, first.Code)
+	var page []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(first.Body.Bytes(), &page))
+	assert.Len(t, page, 2)
+	assert.Equal(t, users[1].UUID.String(), page[1]["id"])
+
+	target := next(t, first)
+
+	t.Run("next page", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("FindPage",
+			mock.Anything,
+			&domain.ListPosition{CreatedAt: users[1].CreatedAt, UUID: users[1].UUID},
+			3).
+			Return(users[2:], nil).Once()
+
+		rec := serve(mockUserUseCase, target)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Link"), "the last page links nothing")
+		mockUserUseCase.AssertExpectations(t)
+	})
+
+	invalid := func(t *testing.T, target string) {
+		mockUserUseCase := new(mocks.UserUseCase)
+
+		rec := serve(mockUserUseCase, target)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), cursor.ErrInvalid.Error())
+		mockUserUseCase.AssertNotCalled(t, "FindPage", mock.Anything, mock.Anything, mock.Anything)
+	}
+
+	parsed, err := url.Parse(target)
+	assert.NoError(t, err)
+	query := parsed.Query()
+	value := query.Get(cursor.Param)
+
+	t.Run("tampered", func(t *testing.T) {
+		payload, mac, _ := strings.Cut(value, ".")
+		forged, err := cursor.New([]byte("secret")).Encode("/user?other", domain.ListPosition{UUID: uuid.New()})
+		assert.NoError(t, err)
+		forgedPayload, _, _ := strings.Cut(forged, ".")
+
+		assert.NotEqual(t, payload, forgedPayload)
+
+		query.Set(cursor.Param, forgedPayload+"."+mac)
+		invalid(t, "/user?"+query.Encode())
+	})
+
+	t.Run("other query", func(t *testing.T) {
+		query := parsed.Query()
+		query.Set("fields", "id,name,email")
+		invalid(t, "/user?"+query.Encode())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("FindPage", mock.Anything, (*domain.ListPosition)(nil), defaultPageLimit+1).Return(nil, errors.New("Unexpected error")).Once()
+
+		rec := serve(mockUserUseCase, "/user?limit=oops")
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		mockUserUseCase.AssertExpectations(t)
+	})
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestFindAllLastModified(t *testing.T) {
+	lastModified := time.Date(2022, 6, 6, 10, 30, 15, 0, time.UTC)
+
+	serve := func(mockUserUseCase *mocks.UserUseCase, ifModifiedSince string) *httptest.ResponseRecorder {
+		handler := UserHandler{
+			userUseCase: mockUserUseCase,
+		}
+
+		router := chi.NewRouter()
+		router.HandleFunc("/user", handler.FindAll)
+
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		if ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", ifModifiedSince)
+		}
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("not modified", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{LastModified: lastModified.Add(250 * time.Millisecond), Count: 2}, nil)
+
+		rec := serve(mockUserUseCase, "Mon, 06 Jun 2022 10:30:15 GMT")
+
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Equal(t, "Mon, 06 Jun 2022 10:30:15 GMT", rec.Header().Get("Last-Modified"))
+		assert.Empty(t, rec.Body.String())
+		mockUserUseCase.AssertExpectations(t)
+	})
+
+	t.Run("modified since", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{LastModified: lastModified, Count: 2}, nil)
+		mockUserUseCase.On("FindAll", mock.Anything).Return([]*domain.User{}, nil)
+
+		rec := serve(mockUserUseCase, "Mon, 06 Jun 2022 10:30:14 GMT")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "Mon, 06 Jun 2022 10:30:15 GMT", rec.Header().Get("Last-Modified"))
+		mockUserUseCase.AssertExpectations(t)
+	})
+
+	t.Run("no users", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{}, nil)
+		mockUserUseCase.On("FindAll", mock.Anything).Return([]*domain.User{}, nil)
+
+		rec := serve(mockUserUseCase, "Mon, 06 Jun 2022 10:30:15 GMT")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Last-Modified"))
+	})
+
+	t.Run("current second", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{LastModified: time.Now().Add(time.Second), Count: 2}, nil)
+		mockUserUseCase.On("FindAll", mock.Anything).Return([]*domain.User{}, nil)
+
+		rec := serve(mockUserUseCase, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Last-Modified"))
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		version := &domain.ListVersion{LastModified: lastModified, Count: 2}
+
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("ListVersion", mock.Anything).Return(version, nil)
+		mockUserUseCase.On("FindAll", mock.Anything).Return([]*domain.User{}, nil).Once()
+
+		rec := serve(mockUserUseCase, "")
+		etag := rec.Header().Get("ETag")
+		assert.Equal(t, listETag(version, nil), etag)
+		assert.Equal(t, []string{"Accept"}, rec.Header().Values("Vary"))
+
+		handler := UserHandler{userUseCase: mockUserUseCase}
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Set("If-None-Match", `"other", `+etag)
+		rec = httptest.NewRecorder()
+		handler.FindAll(rec, req)
+
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+
+	t.Run("same second", func(t *testing.T) {
+		first := listETag(&domain.ListVersion{LastModified: lastModified.Add(100 * time.Microsecond), Count: 2}, nil)
+		second := listETag(&domain.ListVersion{LastModified: lastModified.Add(200 * time.Microsecond), Count: 2}, nil)
+
+		assert.NotEqual(t, first, second, "two updates in a second must not share the ETag")
+	})
+
+	t.Run("representation", func(t *testing.T) {
+		version := &domain.ListVersion{LastModified: lastModified, Count: 2}
+
+		all := listETag(version, nil)
+		assert.NotEqual(t, all, listETag(version, []string{"id", "name"}))
+
+		t.Setenv("JSON_FIELD_CASE", "camel")
+		assert.NotEqual(t, all, listETag(version, nil))
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		before := listETag(&domain.ListVersion{LastModified: lastModified, Count: 2}, nil)
+
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{LastModified: lastModified, Count: 1}, nil)
+		mockUserUseCase.On("FindAll", mock.Anything).Return([]*domain.User{}, nil)
+
+		handler := UserHandler{userUseCase: mockUserUseCase}
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Set("If-None-Match", before)
+		req.Header.Set("If-Modified-Since", "Mon, 06 Jun 2022 10:30:15 GMT")
+		rec := httptest.NewRecorder()
+		handler.FindAll(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, "the deletion changes the ETag, not the date")
+		assert.NotEqual(t, before, rec.Header().Get("ETag"))
+		mockUserUseCase.AssertExpectations(t)
+	})
+}
+
 func TestFetchByID(t *testing.T) {
 	now := time.Now()
 	newUUID := uuid.New()
@@ -126,6 +432,176 @@ func TestFetchByID(t *testing.T) {
 	mockUserUseCase.AssertExpectations(t)
 }
 
+func TestFetchByIDTimestamps(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	// The database may hand back times in any location, with fractions.
+	local := time.FixedZone("BRT", -3*60*60)
+	mockUser := &domain.User{
+		UUID:      newUUID,
+		Name:      "Cyro Dubeux",
+		Email:     "xorycx@gmail.com",
+		CreatedAt: time.Date(2022, 6, 6, 7, 30, 15, 500, local),
+		UpdatedAt: time.Date(2022, 6, 7, 21, 0, 0, 0, local),
+	}
+
+	mockUserUseCase.
+		On("FindByID", mock.Anything, newUUID).
+		Return(mockUser, nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user/{uuid}", handler.FindByID)
+
+	for path, expected := range map[string]string{
+		"":                        `"created_at":"2022-06-06T10:30:15Z","updated_at":"2022-06-08T00:00:00Z"`,
+		"?fields=created_at":      `"created_at":"2022-06-06T10:30:15Z"`,
+		"?fields=updated_at,name": `"updated_at":"2022-06-08T00:00:00Z"`,
+	} {
+		req, err := http.NewRequest(http.MethodGet, "/user/"+newUUID.String()+path, nil)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), expected, path)
+	}
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestFetchByIDOtherTenant(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	// The repository finds no user of another tenant.
+	mockUserUseCase.
+		On("FindByID", mock.Anything, newUUID).
+		Return(&domain.User{}, nil)
+	mockUserUseCase.
+		On("Delete", mock.Anything, newUUID).
+		Return(domain.ErrResourceNotFound)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Get("/user/{uuid}", handler.FindByID)
+	router.Delete("/user/{uuid}", handler.Delete)
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/user/"+newUUID.String(), nil)
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code, method)
+	}
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestFetchByIDMe(t *testing.T) {
+	caller := uuid.New()
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	mockUserUseCase.
+		On("FindByID", mock.Anything, caller).
+		Return(&domain.User{UUID: caller, Name: "Cyro Dubeux", Email: "xorycx@gmail.com"}, nil).Once()
+	mockUserUseCase.
+		On("Delete", mock.Anything, caller).
+		Return(nil).Once()
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Get("/user/{uuid}", handler.FindByID)
+	router.Delete("/user/{uuid}", handler.Delete)
+
+	serve := func(method, path string, claims *cmiddleware.Claims) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if claims != nil {
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), claims))
+		}
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/user/me", &cmiddleware.Claims{UUID: caller})
+
+	var user domain.User
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &user))
+	assert.Equal(t, caller, user.UUID)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/user/me", &cmiddleware.Claims{UUID: caller}).Code)
+
+	// Without claims, or spelled differently, it's not a uuid.
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/user/me", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/user/ME", &cmiddleware.Claims{UUID: caller}).Code)
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestEmptyUUID(t *testing.T) {
+	cmiddleware.RequireID("/user")
+
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{}, nil)
+	mockUserUseCase.On("FindAll", mock.Anything).Return([]*domain.User{}, nil).Once()
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Use(cmiddleware.SlashMiddleware)
+	router.Route("/user", func(r chi.Router) {
+		r.Use(rejectEmptyUUID)
+
+		r.Get("/", handler.FindAll)
+		r.Get("/{uuid}", handler.FindByID)
+		r.Post("/{uuid}/logout-all", handler.LogoutAll)
+	})
+
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/user/"},
+		{http.MethodGet, "/user//"},
+		{http.MethodGet, "/user/%20"},
+		{http.MethodGet, "/user/%20/"},
+		{http.MethodPost, "/user//logout-all"},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), domain.ErrUUIDParse.Error())
+		})
+	}
+
+	// The list itself is still served.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
 func TestFetchByIDFail(t *testing.T) {
 	newUUID := uuid.New()
 	mockUserUseCase := new(mocks.UserUseCase)
@@ -166,7 +642,7 @@ func TestFetchByIDFail(t *testing.T) {
 	router.HandleFunc("/user/{uuid}", handler.FindByID)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
 	mockUserUseCase.AssertExpectations(t)
 }
@@ -175,6 +651,7 @@ func TestAdd(t *testing.T) {
 	now := time.Now()
 	newUUID := uuid.New()
 	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	mockUser := &domain.User{
 		UUID:      newUUID,
@@ -208,11 +685,22 @@ func TestAdd(t *testing.T) {
 
 	assert.Equal(t, http.StatusCreated, rec.Code)
 
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+
+	id, err := uuid.Parse(body["id"].(string))
+	assert.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, id)
+	assert.Equal(t, "/user/"+id.String(), rec.Header().Get("Location"))
+	assert.Equal(t, "Cyro Dubeux", body["name"])
+	assert.NotContains(t, body, "password")
+
 	mockUserUseCase.AssertExpectations(t)
 }
 
 func TestAddFail(t *testing.T) {
 	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	mockUserUseCase.
 		On("Add", mock.Anything, mock.Anything).
@@ -258,7 +746,7 @@ func TestAddFail(t *testing.T) {
 	router.HandleFunc("/user", handler.Add)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
 	mockUserUseCase.AssertExpectations(t)
 
@@ -283,6 +771,49 @@ func TestAddFail(t *testing.T) {
 	mockUserUseCase.AssertExpectations(t)
 }
 
+func TestAddDecodeFail(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Post("/user", handler.Add)
+
+	cases := []struct {
+		name    string
+		payload string
+		code    int
+		message string
+	}{
+		{"empty", ``, http.StatusBadRequest, "body required"},
+		{"malformed", `{"name":}`, http.StatusBadRequest, "malformed JSON at offset 9"},
+		{"wrong-type", `{"name":42}`, http.StatusUnprocessableEntity, "the name field must be a string"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/user", strings.NewReader(c.payload))
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.code, rec.Code)
+
+			var message struct {
+				Message string `json:"message"`
+			}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&message))
+			assert.Equal(t, c.message, message.Message)
+		})
+	}
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
 func TestUpdate(t *testing.T) {
 	now := time.Now()
 	newUUID := uuid.New()
@@ -295,6 +826,10 @@ func TestUpdate(t *testing.T) {
 		UpdatedAt: now,
 	}
 
+	// The email doesn't change, so no current password is needed.
+	mockUserUseCase.
+		On("FindByID", mock.Anything, newUUID).
+		Return(&domain.User{UUID: newUUID, Email: "xorycx@gmail.com"}, nil)
 	mockUserUseCase.
 		On("Update", mock.Anything, mock.Anything, mock.Anything).
 		Return(nil)
@@ -321,6 +856,189 @@ func TestUpdate(t *testing.T) {
 	mockUserUseCase.AssertExpectations(t)
 }
 
+// fakeCredentials accepts the password, for the email of the user
+// checked, and records the calls.
+type fakeCredentials struct {
+	password string
+	err      error
+	calls    int
+}
+
+func (f *fakeCredentials) VerifyCredentials(ctx context.Context, email, password string) (bool, error) {
+	f.calls++
+	return password == f.password, f.err
+}
+
+func TestUpdateOwnEmail(t *testing.T) {
+	self := uuid.New()
+	current := &domain.User{UUID: self, Name: "Cyro Dubeux", Email: "xorycx@gmail.com"}
+
+	cases := []struct {
+		name     string
+		payload  string
+		expected int
+		verified int
+	}{
+		{"same email", `{"name":"Cyro","email":"XORYCX@gmail.com"}`, http.StatusOK, 0},
+		{"no password", `{"name":"Cyro","email":"cyro@example.com"}`, http.StatusForbidden, 0},
+		{"wrong password", `{"name":"Cyro","email":"cyro@example.com","current_password":"wrong"}`, http.StatusForbidden, 1},
+		{"password", `{"name":"Cyro","email":"cyro@example.com","current_password":"12345678"}`, http.StatusOK, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+			mockUserUseCase.On("FindByID", mock.Anything, self).Return(current, nil).Once()
+			if c.expected == http.StatusOK {
+				mockUserUseCase.On("Update", mock.Anything, self, mock.Anything).Return(nil).Once()
+			}
+
+			credentials := &fakeCredentials{password: "12345678"}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+				credentials: credentials,
+			}
+
+			router := chi.NewRouter()
+			router.Put("/user/{uuid}", handler.Update)
+
+			req := httptest.NewRequest(http.MethodPut, "/user/"+self.String(), strings.NewReader(c.payload))
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: self, Role: domain.RoleUser}))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+			assert.Equal(t, c.verified, credentials.calls)
+			if c.expected == http.StatusForbidden {
+				assert.Contains(t, rec.Body.String(), domain.ErrCurrentPassword.Error())
+			}
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+
+	t.Run("other user", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("Update", mock.Anything, self, mock.Anything).Return(nil).Once()
+
+		credentials := &fakeCredentials{}
+
+		handler := UserHandler{
+			userUseCase: mockUserUseCase,
+			credentials: credentials,
+		}
+
+		router := chi.NewRouter()
+		router.Put("/user/{uuid}", handler.Update)
+
+		req := httptest.NewRequest(http.MethodPut, "/user/"+self.String(), strings.NewReader(`{"name":"Cyro","email":"cyro@example.com"}`))
+		req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: uuid.New(), Role: domain.RoleAdmin}))
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Zero(t, credentials.calls)
+		mockUserUseCase.AssertExpectations(t)
+	})
+}
+
+func TestPatchOwnEmail(t *testing.T) {
+	self := uuid.New()
+
+	for name, tc := range map[string]struct {
+		payload  string
+		expected int
+	}{
+		"wrong password": {`{"email":"cyro@example.com","current_password":"wrong"}`, http.StatusForbidden},
+		"password":       {`{"email":"cyro@example.com","current_password":"12345678"}`, http.StatusOK},
+		"other field":    {`{"name":"Cyro"}`, http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+			mockUserUseCase.On("FindByID", mock.Anything, self).
+				Return(&domain.User{UUID: self, Name: "Cyro Dubeux", Email: "xorycx@gmail.com"}, nil).Once()
+			if tc.expected == http.StatusOK {
+				mockUserUseCase.On("Update", mock.Anything, self, mock.Anything).Return(nil).Once()
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+				credentials: &fakeCredentials{password: "12345678"},
+			}
+
+			router := chi.NewRouter()
+			router.Patch("/user/{uuid}", handler.Patch)
+
+			req := httptest.NewRequest(http.MethodPatch, "/user/"+self.String(), strings.NewReader(tc.payload))
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: self, Role: domain.RoleUser}))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+// TestEmailOtherUser checks a user changing the email of another one
+// needs the password of that user, unless they are an admin.
+func TestEmailOtherUser(t *testing.T) {
+	target := uuid.New()
+
+	cases := []struct {
+		name     string
+		role     string
+		password string
+		expected int
+		verified int
+	}{
+		{"no password", domain.RoleUser, "", http.StatusForbidden, 0},
+		{"wrong password", domain.RoleUser, "wrong", http.StatusForbidden, 1},
+		{"password", domain.RoleUser, "12345678", http.StatusOK, 1},
+		{"admin", domain.RoleAdmin, "", http.StatusOK, 0},
+	}
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		for _, c := range cases {
+			t.Run(method+" "+c.name, func(t *testing.T) {
+				mockUserUseCase := new(mocks.UserUseCase)
+				mockUserUseCase.
+					On("FindByID", mock.Anything, target).
+					Return(&domain.User{UUID: target, Name: "Cyro Dubeux", Email: "xorycx@gmail.com"}, nil).Maybe()
+				if c.expected == http.StatusOK {
+					mockUserUseCase.On("Update", mock.Anything, target, mock.Anything).Return(nil).Once()
+				}
+
+				credentials := &fakeCredentials{password: "12345678"}
+
+				handler := UserHandler{
+					userUseCase: mockUserUseCase,
+					credentials: credentials,
+				}
+
+				router := chi.NewRouter()
+				router.Put("/user/{uuid}", handler.Update)
+				router.Patch("/user/{uuid}", handler.Patch)
+
+				payload := fmt.Sprintf(`{"name":"Cyro","email":"cyro@example.com","current_password":%q}`, c.password)
+
+				req := httptest.NewRequest(method, "/user/"+target.String(), strings.NewReader(payload))
+				req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: uuid.New(), Role: c.role}))
+				rec := httptest.NewRecorder()
+
+				router.ServeHTTP(rec, req)
+
+				assert.Equal(t, c.expected, rec.Code)
+				assert.Equal(t, c.verified, credentials.calls)
+				mockUserUseCase.AssertExpectations(t)
+			})
+		}
+	}
+}
+
 func TestUpdateFail(t *testing.T) {
 	now := time.Now()
 	newUUID := uuid.New()
@@ -333,6 +1051,9 @@ func TestUpdateFail(t *testing.T) {
 		UpdatedAt: now,
 	}
 
+	mockUserUseCase.
+		On("FindByID", mock.Anything, newUUID).
+		Return(&domain.User{UUID: newUUID, Email: "xorycx@gmail.com"}, nil)
 	mockUserUseCase.
 		On("Update", mock.Anything, mock.Anything, mock.Anything).
 		Return(domain.ErrUpdate)
@@ -372,7 +1093,7 @@ func TestUpdateFail(t *testing.T) {
 	router.HandleFunc("/user/{uuid}", handler.Update)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
 	mockUserUseCase.AssertExpectations(t)
 
@@ -392,7 +1113,7 @@ func TestUpdateFail(t *testing.T) {
 	router.HandleFunc("/user/{uuid}", handler.Update)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
 	mockUserUseCase.AssertExpectations(t)
 
@@ -444,6 +1165,78 @@ func TestDelete(t *testing.T) {
 	mockUserUseCase.AssertExpectations(t)
 }
 
+func TestDeleteUnmodifiedSince(t *testing.T) {
+	newUUID := uuid.New()
+	updatedAt := time.Date(2022, 7, 1, 12, 0, 0, 500, time.UTC)
+
+	cases := []struct {
+		name     string
+		header   string
+		deleted  bool
+		expected int
+	}{
+		{"current", updatedAt.Format(http.TimeFormat), true, http.StatusOK},
+		{"later", updatedAt.Add(time.Hour).Format(http.TimeFormat), true, http.StatusOK},
+		{"stale", updatedAt.Add(-time.Second).Format(http.TimeFormat), false, http.StatusPreconditionFailed},
+		{"not a date", "yesterday", true, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+
+			mockUserUseCase.
+				On("FindByID", mock.Anything, newUUID).
+				Return(&domain.User{UUID: newUUID, UpdatedAt: updatedAt}, nil).Maybe()
+			if c.deleted {
+				mockUserUseCase.On("Delete", mock.Anything, newUUID).Return(nil).Once()
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+			}
+
+			router := chi.NewRouter()
+			router.Delete("/user/{uuid}", handler.Delete)
+
+			req := httptest.NewRequest(http.MethodDelete, "/user/"+newUUID.String(), nil)
+			req.Header.Set("If-Unmodified-Since", c.header)
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+			if !c.deleted {
+				assert.Contains(t, rec.Body.String(), domain.ErrModifiedSince.Error())
+				assert.Equal(t, updatedAt.Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+			}
+
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("FindByID", mock.Anything, newUUID).Return(nil, nil)
+
+		handler := UserHandler{
+			userUseCase: mockUserUseCase,
+		}
+
+		router := chi.NewRouter()
+		router.Delete("/user/{uuid}", handler.Delete)
+
+		req := httptest.NewRequest(http.MethodDelete, "/user/"+newUUID.String(), nil)
+		req.Header.Set("If-Unmodified-Since", updatedAt.Format(http.TimeFormat))
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		mockUserUseCase.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	})
+}
+
 func TestDeleteFail(t *testing.T) {
 	newUUID := uuid.New()
 	mockUserUseCase := new(mocks.UserUseCase)
@@ -484,7 +1277,7 @@ func TestDeleteFail(t *testing.T) {
 	router.HandleFunc("/user/{uuid}", handler.Delete)
 	router.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
 	mockUserUseCase.AssertExpectations(t)
 
@@ -508,3 +1301,1328 @@ func TestDeleteFail(t *testing.T) {
 
 	mockUserUseCase.AssertExpectations(t)
 }
+
+func TestFindAllFields(t *testing.T) {
+	now := time.Now()
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	mockUserList := []*domain.User{
+		{
+			UUID:      uuid.New(),
+			Name:      "Cyro Dubeux",
+			Email:     "xorycx@gmail.com",
+			Password:  "12345678",
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+
+	mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{}, nil)
+	mockUserUseCase.
+		On("FindAll", mock.Anything).
+		Return(mockUserList, nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.FindAll)
+
+	t.Run("subset", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/user?fields=name,email", nil)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var body []map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Len(t, body, 1)
+		assert.Equal(t, map[string]interface{}{
+			"name":  "Cyro Dubeux",
+			"email": "xorycx@gmail.com",
+		}, body[0])
+	})
+
+	t.Run("unknown-field", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/user?fields=name,password", nil)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "12345678")
+	})
+
+	t.Run("default", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/user", nil)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var body []map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Len(t, body, 1)
+		assert.Contains(t, body[0], "id")
+		assert.Contains(t, body[0], "name")
+		assert.Contains(t, body[0], "email")
+		assert.Contains(t, body[0], "created_at")
+		assert.Contains(t, body[0], "updated_at")
+	})
+}
+
+func TestFetchByIDFields(t *testing.T) {
+	now := time.Now()
+	newUUID := uuid.New()
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	mockUser := &domain.User{
+		UUID:      newUUID,
+		Name:      "Cyro Dubeux",
+		Email:     "xorycx@gmail.com",
+		Password:  "12345678",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	mockUserUseCase.
+		On("FindByID", mock.Anything, mock.Anything).
+		Return(mockUser, nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user/{uuid}", handler.FindByID)
+
+	req, err := http.NewRequest(http.MethodGet, "/user/"+newUUID.String()+"?fields=id,name", nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, map[string]interface{}{
+		"id":   newUUID.String(),
+		"name": "Cyro Dubeux",
+	}, body)
+
+	req, err = http.NewRequest(http.MethodGet, "/user/"+newUUID.String()+"?fields=foo", nil)
+	assert.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestExport(t *testing.T) {
+	createdAt := time.Date(2022, 6, 19, 16, 53, 9, 0, time.UTC)
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	mockUsers := []*domain.User{
+		{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "12345678", CreatedAt: createdAt},
+		{UUID: uuid.New(), Name: "John Doe", Email: "john@doe.com", Password: "12345678", CreatedAt: createdAt},
+	}
+
+	mockUserUseCase.
+		On("FindAllStream", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(1).(func(*domain.User) error)
+			for _, user := range mockUsers {
+				assert.NoError(t, fn(user))
+			}
+		}).
+		Return(nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+
+	req, err := http.NewRequest(http.MethodGet, "/user/export.csv", nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+
+	router.HandleFunc("/user/export.csv", handler.Export)
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="users.csv"`, rec.Header().Get("Content-Disposition"))
+	assert.Equal(t,
+		"name,email,created_at\n"+
+			"Cyro Dubeux,xorycx@gmail.com,2022-06-19T16:53:09Z\n"+
+			"John Doe,john@doe.com,2022-06-19T16:53:09Z\n",
+		rec.Body.String(),
+	)
+	assert.NotContains(t, rec.Body.String(), "12345678")
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestFindAllStream(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	mockUsers := []*domain.User{
+		{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "12345678", CreatedAt: now, UpdatedAt: now},
+		{UUID: uuid.New(), Name: "John Doe", Email: "john@doe.com", Password: "12345678", CreatedAt: now, UpdatedAt: now},
+	}
+
+	stream := func(users []*domain.User, err error) *mocks.UserUseCase {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.
+			On("FindAllStream", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				fn := args.Get(1).(func(*domain.User) error)
+				for _, user := range users {
+					assert.NoError(t, fn(user))
+				}
+			}).
+			Return(err)
+		return mockUserUseCase
+	}
+
+	serve := func(mockUserUseCase *mocks.UserUseCase) *httptest.ResponseRecorder {
+		handler := UserHandler{
+			userUseCase: mockUserUseCase,
+		}
+
+		router := chi.NewRouter()
+		router.HandleFunc("/user", handler.FindAll)
+
+		req, err := http.NewRequest(http.MethodGet, "/user?stream=true", nil)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		mockUserUseCase.AssertExpectations(t)
+
+		return rec
+	}
+
+	t.Run("success", func(t *testing.T) {
+		rec := serve(stream(mockUsers, nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		assert.NotContains(t, rec.Body.String(), "password")
+
+		var users []*domain.User
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &users))
+		assert.Len(t, users, len(mockUsers))
+		for i, user := range users {
+			expected := *mockUsers[i]
+			expected.Password = ""
+			assert.Equal(t, &expected, user)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		rec := serve(stream(nil, nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[]`, rec.Body.String())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		rec := serve(stream(nil, domain.ErrFindAll))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+func TestAddUsername(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserUseCase.
+		On("Add", mock.Anything, mock.MatchedBy(func(user *domain.User) bool {
+			return user.Username != nil && *user.Username == "cyro"
+		})).
+		Return(nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.Add)
+
+	payload := []byte(`{"name":"Cyro Dubeux","email":"xorycx@gmail.com","username":"cyro","password":"12345678"}`)
+
+	req, err := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	mockUserUseCase.AssertExpectations(t)
+
+	// invalid username
+
+	payload = []byte(`{"name":"Cyro Dubeux","email":"xorycx@gmail.com","username":"cy ro","password":"12345678"}`)
+
+	req, err = http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAddEmailTaken(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserUseCase.
+		On("Add", mock.Anything, mock.Anything).
+		Return(domain.ErrEmailTaken)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.Add)
+
+	payload := []byte(`{"name":"Cyro Dubeux","email":"XoryCX@Gmail.com","password":"12345678"}`)
+
+	req, err := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestUsernameTaken(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserUseCase.
+		On("Add", mock.Anything, mock.Anything).
+		Return(domain.ErrUsernameTaken)
+	mockUserUseCase.
+		On("FindByID", mock.Anything, newUUID).
+		Return(&domain.User{UUID: newUUID, Name: "Cyro Dubeux", Email: "xorycx@gmail.com"}, nil)
+	mockUserUseCase.
+		On("Update", mock.Anything, newUUID, mock.Anything).
+		Return(domain.ErrUsernameTaken)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Post("/user", handler.Add)
+	router.Put("/user/{uuid}", handler.Update)
+	router.Patch("/user/{uuid}", handler.Patch)
+
+	for _, tc := range []struct {
+		method  string
+		path    string
+		payload string
+	}{
+		{http.MethodPost, "/user", `{"name":"Cyro Dubeux","email":"xorycx@gmail.com","username":"cyro","password":"12345678"}`},
+		{http.MethodPut, "/user/" + newUUID.String(), `{"name":"Cyro Dubeux","email":"xorycx@gmail.com","username":"cyro"}`},
+		{http.MethodPatch, "/user/" + newUUID.String(), `{"username":"cyro"}`},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.payload))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Contains(t, rec.Body.String(), domain.ErrUsernameTaken.Error())
+		})
+	}
+}
+
+func TestAddRole(t *testing.T) {
+	for name, tc := range map[string]struct {
+		role     string
+		expected int
+		stored   string
+	}{
+		"admin":   {`"admin"`, http.StatusCreated, domain.RoleAdmin},
+		"user":    {`"user"`, http.StatusCreated, domain.RoleUser},
+		"unknown": {`"root"`, http.StatusBadRequest, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+			if tc.expected == http.StatusCreated {
+				mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+				mockUserUseCase.On("Add", mock.Anything, mock.MatchedBy(func(user *domain.User) bool {
+					return user.Role == tc.stored
+				})).Return(nil).Once()
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+			}
+
+			router := chi.NewRouter()
+			router.Post("/user", handler.Add)
+
+			payload := `{"name":"Cyro Dubeux","email":"xorycx@gmail.com","password":"12345678","role":` + tc.role + `}`
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(payload)))
+
+			assert.Equal(t, tc.expected, rec.Code)
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAddEmailNoMX(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserUseCase.
+		On("Add", mock.Anything, mock.Anything).
+		Return(domain.ErrEmailNoMX)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.Add)
+
+	payload := []byte(`{"name":"Cyro Dubeux","email":"xorycx@gmial.com","password":"12345678"}`)
+
+	req, err := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), domain.ErrEmailNoMX.Error())
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestAddInvalidUser(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserUseCase.
+		On("Add", mock.Anything, mock.Anything).
+		Return(&domain.ValidationError{Field: "email", Message: "is not a valid email"})
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.Add)
+
+	payload := []byte(`{"name":"Cyro Dubeux","email":"xorycx@gmail.com","password":"12345678"}`)
+
+	req, err := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "email is not a valid email")
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestAddWeakPassword(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	mockUserUseCase.
+		On("ValidatePassword", mock.Anything, "1234", mock.MatchedBy(func(user *domain.User) bool {
+			return user.Email == "xorycx@gmail.com" && user.UUID == uuid.Nil
+		})).
+		Return(&domain.ValidationError{Field: "password", Message: "must be at least 8 characters"})
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.Add)
+
+	payload := []byte(`{"name":"Cyro Dubeux","email":"xorycx@gmail.com","password":"1234"}`)
+
+	req, err := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "password must be at least 8 characters")
+	mockUserUseCase.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestAddEmailDomainBlocked(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserUseCase.
+		On("Add", mock.Anything, mock.Anything).
+		Return(domain.ErrEmailDomainBlocked)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.Add)
+
+	payload := []byte(`{"name":"Cyro Dubeux","email":"xorycx@yopmail.com","password":"12345678"}`)
+
+	req, err := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), domain.ErrEmailDomainBlocked.Error())
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestAddQuotaExceeded(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockUserUseCase.
+		On("Add", mock.Anything, mock.Anything).
+		Return(domain.ErrQuotaExceeded)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.HandleFunc("/user", handler.Add)
+
+	payload := []byte(`{"name":"Cyro Dubeux","email":"xorycx@gmail.com","password":"12345678"}`)
+
+	req, err := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(payload))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestFindAllEmpty(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{}, nil)
+	mockUserUseCase.
+		On("FindAll", mock.Anything).
+		Return(make([]*domain.User, 0), nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+
+	req, err := http.NewRequest(http.MethodGet, "/user", nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+
+	router.HandleFunc("/user", handler.FindAll)
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[]\n", rec.Body.String())
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestFindByIDs(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	first := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux"}
+	second := &domain.User{UUID: uuid.New(), Name: "John Doe"}
+	missing := uuid.New()
+
+	mockUserUseCase.
+		On("FindByIDs", mock.Anything, []uuid.UUID{second.UUID, missing, first.UUID}).
+		Return([]*domain.User{second, first}, []uuid.UUID{missing}, nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+
+	ids := second.UUID.String() + "," + missing.String() + "," + first.UUID.String() + "," + second.UUID.String()
+	req, err := http.NewRequest(http.MethodGet, "/user?fields=id,name&ids="+ids, nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+
+	router.HandleFunc("/user", handler.FindAll)
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response struct {
+		Users    []map[string]interface{} `json:"users"`
+		NotFound []uuid.UUID              `json:"not_found"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+	assert.Len(t, response.Users, 2)
+	assert.Equal(t, "John Doe", response.Users[0]["name"])
+	assert.Equal(t, "Cyro Dubeux", response.Users[1]["name"])
+	assert.Equal(t, []uuid.UUID{missing}, response.NotFound)
+
+	mockUserUseCase.AssertExpectations(t)
+}
+
+// TestFindByIDsPassword checks the users given by ids are written
+// without their password hash.
+func TestFindByIDsPassword(t *testing.T) {
+	user := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux", Password: "$2a$10$hash"}
+
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.
+		On("FindByIDs", mock.Anything, []uuid.UUID{user.UUID}).
+		Return([]*domain.User{user}, []uuid.UUID{}, nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/user?ids="+user.UUID.String(), nil)
+	rec := httptest.NewRecorder()
+	handler.FindAll(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"name":"Cyro Dubeux"`)
+	assert.NotContains(t, rec.Body.String(), "password")
+	assert.NotContains(t, rec.Body.String(), "$2a$10$hash")
+	mockUserUseCase.AssertExpectations(t)
+}
+
+func TestFindByIDsFail(t *testing.T) {
+	tooMany := make([]string, 0, maxBatchIDs+1)
+	for i := 0; i <= maxBatchIDs; i++ {
+		tooMany = append(tooMany, uuid.NewString())
+	}
+
+	cases := []struct {
+		name string
+		ids  string
+	}{
+		{"invalid-uuid", "not-an-uuid"},
+		{"too-many", strings.Join(tooMany, ",")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+			}
+
+			router := chi.NewRouter()
+
+			req, err := http.NewRequest(http.MethodGet, "/user?ids="+c.ids, nil)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.HandleFunc("/user", handler.FindAll)
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			mockUserUseCase.AssertNotCalled(t, "FindByIDs", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestNullable(t *testing.T) {
+	var payload patchUserRequest
+
+	err := json.Unmarshal([]byte(`{"name": "Cyro", "username": null}`), &payload)
+	assert.NoError(t, err)
+
+	assert.True(t, payload.Name.Set)
+	assert.Equal(t, "Cyro", *payload.Name.Value)
+	assert.True(t, payload.Username.Set)
+	assert.Nil(t, payload.Username.Value)
+	assert.False(t, payload.Email.Set)
+}
+
+func TestPatch(t *testing.T) {
+	userUUID := uuid.New()
+
+	current := func() *domain.User {
+		return &domain.User{
+			UUID:     userUUID,
+			Name:     "Cyro Dubeux",
+			Email:    "xorycx@gmail.com",
+			Username: optional("cyro"),
+			Password: "hash",
+		}
+	}
+
+	cases := []struct {
+		name     string
+		payload  string
+		expected *domain.User
+		code     int
+	}{
+		{
+			"absent", `{}`,
+			&domain.User{UUID: userUUID, Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Username: optional("cyro"), Password: "hash"},
+			http.StatusOK,
+		},
+		{
+			"valued", `{"name": "John Doe"}`,
+			&domain.User{UUID: userUUID, Name: "John Doe", Email: "xorycx@gmail.com", Username: optional("cyro"), Password: "hash"},
+			http.StatusOK,
+		},
+		{
+			"null-optional", `{"username": null}`,
+			&domain.User{UUID: userUUID, Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "hash"},
+			http.StatusOK,
+		},
+		{"null-required", `{"email": null}`, nil, http.StatusUnprocessableEntity},
+		{"invalid", `{"username": "a!"}`, nil, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+
+			if c.code != http.StatusUnprocessableEntity {
+				mockUserUseCase.
+					On("FindByID", mock.Anything, userUUID).
+					Return(current(), nil)
+			}
+			if c.expected != nil {
+				mockUserUseCase.
+					On("Update", mock.Anything, userUUID, c.expected).
+					Return(nil)
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+			}
+
+			router := chi.NewRouter()
+
+			req, err := http.NewRequest(http.MethodPatch, "/user/"+userUUID.String(), strings.NewReader(c.payload))
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.HandleFunc("/user/{uuid}", handler.Patch)
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.code, rec.Code)
+
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPatchNotFound(t *testing.T) {
+	mockUserUseCase := new(mocks.UserUseCase)
+
+	mockUserUseCase.
+		On("FindByID", mock.Anything, mock.Anything).
+		Return(&domain.User{}, nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+
+	req, err := http.NewRequest(http.MethodPatch, "/user/"+uuid.NewString(), strings.NewReader(`{"name": "John Doe"}`))
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+
+	router.HandleFunc("/user/{uuid}", handler.Patch)
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	mockUserUseCase.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearch(t *testing.T) {
+	mockUser := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "$2a$10$hash", Role: domain.RoleUser}
+
+	cases := []struct {
+		name   string
+		query  string
+		prefix string
+		limit  int
+		users  []*domain.User
+		code   int
+		body   string
+	}{
+		{"match", "?q=Cy", "Cy", defaultSearchLimit, []*domain.User{mockUser}, http.StatusOK, `[{"id":"` + mockUser.UUID.String() + `","name":"Cyro Dubeux","email":"xorycx@gmail.com"}]` + "\n"},
+		{"limit", "?q=Cy&limit=500", "Cy", maxSearchLimit, []*domain.User{mockUser}, http.StatusOK, ""},
+		{"no-match", "?q=Zz", "Zz", defaultSearchLimit, make([]*domain.User, 0), http.StatusOK, "[]\n"},
+		{"too-short", "?q=C", "", 0, nil, http.StatusBadRequest, ""},
+		{"missing", "", "", 0, nil, http.StatusBadRequest, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+
+			if c.users != nil {
+				mockUserUseCase.
+					On("SearchByName", mock.Anything, c.prefix, c.limit).
+					Return(c.users, nil)
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+			}
+
+			router := chi.NewRouter()
+
+			req, err := http.NewRequest(http.MethodGet, "/user/search"+c.query, nil)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.HandleFunc("/user/search", handler.Search)
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.code, rec.Code)
+			if c.body != "" {
+				assert.Equal(t, c.body, rec.Body.String())
+			}
+
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSignups(t *testing.T) {
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	buckets := []*domain.SignupBucket{
+		{Bucket: time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC), Count: 3},
+		{Bucket: time.Date(2022, 6, 13, 0, 0, 0, 0, time.UTC), Count: 1},
+	}
+
+	cases := []struct {
+		name     string
+		query    string
+		interval string
+		err      error
+		code     int
+	}{
+		{"day", "?from=2022-06-01&to=2022-07-01", domain.IntervalDay, nil, http.StatusOK},
+		{"week", "?from=2022-06-01T00:00:00Z&to=2022-07-01&interval=week", domain.IntervalWeek, nil, http.StatusOK},
+		{"unknown-interval", "?from=2022-06-01&to=2022-07-01&interval=year", "year", domain.ErrStatsInterval, http.StatusBadRequest},
+		{"failed", "?from=2022-06-01&to=2022-07-01", domain.IntervalDay, errors.New("Unexpected error"), http.StatusInternalServerError},
+		{"missing-from", "?to=2022-07-01", "", nil, http.StatusBadRequest},
+		{"missing-to", "?from=2022-06-01", "", nil, http.StatusBadRequest},
+		{"invalid-date", "?from=june&to=2022-07-01", "", nil, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+
+			if c.interval != "" {
+				if c.err != nil {
+					mockUserUseCase.
+						On("CountSignups", mock.Anything, from, to, c.interval).
+						Return(nil, c.err)
+				} else {
+					mockUserUseCase.
+						On("CountSignups", mock.Anything, from, to, c.interval).
+						Return(buckets, nil)
+				}
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+			}
+
+			router := chi.NewRouter()
+
+			req, err := http.NewRequest(http.MethodGet, "/user/stats/signups"+c.query, nil)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.HandleFunc("/user/stats/signups", handler.Signups)
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.code, rec.Code)
+			if c.code == http.StatusOK {
+				assert.JSONEq(t, `[
+					{"bucket":"2022-06-06T00:00:00Z","count":3},
+					{"bucket":"2022-06-13T00:00:00Z","count":1}
+				]`, rec.Body.String())
+			}
+
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSignupsInt64AsString(t *testing.T) {
+	os.Setenv("JSON_INT64_AS_STRING", "true")
+	defer os.Unsetenv("JSON_INT64_AS_STRING")
+
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mockUserUseCase := new(mocks.UserUseCase)
+	mockUserUseCase.
+		On("CountSignups", mock.Anything, from, from.AddDate(0, 1, 0), domain.IntervalMonth).
+		Return([]*domain.SignupBucket{{Bucket: from, Count: 1<<53 + 1}}, nil)
+
+	handler := UserHandler{
+		userUseCase: mockUserUseCase,
+	}
+
+	router := chi.NewRouter()
+	router.Get("/user/stats/signups", handler.Signups)
+
+	req, err := http.NewRequest(http.MethodGet, "/user/stats/signups?from=2022-06-01&to=2022-07-01&interval=month", nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"bucket":"2022-06-01T00:00:00Z","count":"9007199254740993"}]`, rec.Body.String())
+}
+
+func TestLogoutAll(t *testing.T) {
+	cases := []struct {
+		name     string
+		uuid     string
+		err      error
+		expected int
+	}{
+		{"success", uuid.NewString(), nil, http.StatusOK},
+		{"not-found", uuid.NewString(), domain.ErrResourceNotFound, http.StatusNotFound},
+		{"failed", uuid.NewString(), errors.New("Unexpected error"), http.StatusInternalServerError},
+		{"invalid-uuid", "invalid", nil, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+
+			if c.expected != http.StatusBadRequest {
+				mockUserUseCase.
+					On("LogoutAll", mock.Anything, uuid.MustParse(c.uuid)).
+					Return(c.err).Once()
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+			}
+
+			router := chi.NewRouter()
+			router.Post("/user/{uuid}/logout-all", handler.LogoutAll)
+
+			req, err := http.NewRequest(http.MethodPost, "/user/"+c.uuid+"/logout-all", nil)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+type auditRecorder struct {
+	entries []audit.Entry
+}
+
+func (a *auditRecorder) Record(ctx context.Context, entry audit.Entry) {
+	a.entries = append(a.entries, entry)
+}
+
+func TestResetPassword(t *testing.T) {
+	admin := uuid.New()
+
+	cases := []struct {
+		name     string
+		uuid     string
+		role     string
+		payload  string
+		policy   error
+		err      error
+		expected int
+	}{
+		{"success", uuid.NewString(), domain.RoleAdmin, `{"password":"n3w-passw0rd"}`, nil, nil, http.StatusOK},
+		{"not-admin", uuid.NewString(), domain.RoleUser, `{"password":"n3w-passw0rd"}`, nil, nil, http.StatusForbidden},
+		{"too-short", uuid.NewString(), domain.RoleAdmin, `{"password":"123"}`, &domain.ValidationError{Field: "password", Message: "must be at least 8 characters"}, nil, http.StatusUnprocessableEntity},
+		{"reused", uuid.NewString(), domain.RoleAdmin, `{"password":"n3w-passw0rd"}`, domain.ErrPasswordReused, nil, http.StatusUnprocessableEntity},
+		{"policy-failed", uuid.NewString(), domain.RoleAdmin, `{"password":"n3w-passw0rd"}`, errors.New("Unexpected error"), nil, http.StatusInternalServerError},
+		{"not-found", uuid.NewString(), domain.RoleAdmin, `{"password":"n3w-passw0rd"}`, nil, domain.ErrResourceNotFound, http.StatusNotFound},
+		{"failed", uuid.NewString(), domain.RoleAdmin, `{"password":"n3w-passw0rd"}`, nil, errors.New("Unexpected error"), http.StatusInternalServerError},
+		{"invalid-uuid", "invalid", domain.RoleAdmin, `{"password":"n3w-passw0rd"}`, nil, nil, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+			recorder := &auditRecorder{}
+
+			reaches := c.role == domain.RoleAdmin && c.uuid != "invalid" && c.expected != http.StatusBadRequest
+			if reaches {
+				mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, &domain.User{UUID: uuid.MustParse(c.uuid)}).
+					Return(c.policy).Once()
+			}
+			if reaches && c.policy == nil {
+				mockUserUseCase.
+					On("ResetPassword", mock.Anything, uuid.MustParse(c.uuid), mock.MatchedBy(func(hash string) bool {
+						return strings.HasPrefix(hash, "$2a$") && !strings.Contains(hash, "n3w-passw0rd")
+					})).
+					Return(c.err).Once()
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+				audit:       recorder,
+			}
+
+			router := chi.NewRouter()
+			router.With(cmiddleware.AdminMiddleware).Post("/user/{uuid}/reset-password", handler.ResetPassword)
+
+			req, err := http.NewRequest(http.MethodPost, "/user/"+c.uuid+"/reset-password", strings.NewReader(c.payload))
+			assert.NoError(t, err)
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: admin, Role: c.role}))
+
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expected, rec.Code)
+
+			if c.expected == http.StatusOK {
+				assert.Equal(t, []audit.Entry{
+					{Action: "user.reset-password", Actor: admin, Target: uuid.MustParse(c.uuid)},
+				}, recorder.entries)
+			} else {
+				assert.Empty(t, recorder.entries)
+			}
+
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResetOwnPassword(t *testing.T) {
+	admin := uuid.New()
+
+	for name, tc := range map[string]struct {
+		payload  string
+		expected int
+	}{
+		"no password":    {`{"password":"n3w-passw0rd"}`, http.StatusForbidden},
+		"wrong password": {`{"password":"n3w-passw0rd","current_password":"wrong"}`, http.StatusForbidden},
+		"password":       {`{"password":"n3w-passw0rd","current_password":"12345678"}`, http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+			mockUserUseCase.On("FindByID", mock.Anything, admin).
+				Return(&domain.User{UUID: admin, Email: "admin@example.com"}, nil).Once()
+			if tc.expected == http.StatusOK {
+				mockUserUseCase.On("ValidatePassword", mock.Anything, "n3w-passw0rd", &domain.User{UUID: admin}).Return(nil).Once()
+				mockUserUseCase.On("ResetPassword", mock.Anything, admin, mock.Anything).Return(nil).Once()
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+				credentials: &fakeCredentials{password: "12345678"},
+				audit:       &auditRecorder{},
+			}
+
+			router := chi.NewRouter()
+			router.Post("/user/{uuid}/reset-password", handler.ResetPassword)
+
+			req := httptest.NewRequest(http.MethodPost, "/user/"+admin.String()+"/reset-password", strings.NewReader(tc.payload))
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: admin, Role: domain.RoleAdmin}))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	id := uuid.New()
+
+	for name, tc := range map[string]struct {
+		payload  string
+		policy   error
+		expected int
+	}{
+		"no password":    {`{"password":"n3w-passw0rd"}`, nil, http.StatusForbidden},
+		"wrong password": {`{"password":"n3w-passw0rd","current_password":"wrong"}`, nil, http.StatusForbidden},
+		"too short":      {`{"password":"123","current_password":"12345678"}`, &domain.ValidationError{Field: "password", Message: "must be at least 8 characters"}, http.StatusUnprocessableEntity},
+		"reused":         {`{"password":"n3w-passw0rd","current_password":"12345678"}`, domain.ErrPasswordReused, http.StatusUnprocessableEntity},
+		"password":       {`{"password":"n3w-passw0rd","current_password":"12345678"}`, nil, http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+			recorder := &auditRecorder{}
+
+			mockUserUseCase.On("FindByID", mock.Anything, id).
+				Return(&domain.User{UUID: id, Email: "xorycx@gmail.com"}, nil).Once()
+			if tc.policy != nil || tc.expected == http.StatusOK {
+				mockUserUseCase.On("ValidatePassword", mock.Anything, mock.Anything, &domain.User{UUID: id}).Return(tc.policy).Once()
+			}
+			if tc.expected == http.StatusOK {
+				mockUserUseCase.On("ResetPassword", mock.Anything, id, mock.Anything).Return(nil).Once()
+			}
+
+			handler := UserHandler{
+				userUseCase: mockUserUseCase,
+				credentials: &fakeCredentials{password: "12345678"},
+				audit:       recorder,
+			}
+
+			router := chi.NewRouter()
+			router.Post("/me/password", handler.ChangePassword)
+
+			req := httptest.NewRequest(http.MethodPost, "/me/password", strings.NewReader(tc.payload))
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: id, Role: domain.RoleUser}))
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+			if tc.expected == http.StatusOK {
+				assert.Equal(t, []audit.Entry{{Action: "user.change-password", Actor: id, Target: id}}, recorder.entries)
+			}
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+// avatarForm builds a multipart body with the file as its avatar part.
+func avatarForm(t *testing.T, field string, file []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+
+	part, err := form.CreateFormFile(field, "avatar.png")
+	assert.NoError(t, err)
+
+	_, err = part.Write(file)
+	assert.NoError(t, err)
+	assert.NoError(t, form.Close())
+
+	return body, form.FormDataContentType()
+}
+
+func TestAvatar(t *testing.T) {
+	id := uuid.New()
+	url := "/uploads/avatars/" + id.String() + "/a.png"
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+
+	for name, tc := range map[string]struct {
+		field    string
+		file     []byte
+		expected int
+	}{
+		"png":       {"avatar", png, http.StatusOK},
+		"text":      {"avatar", []byte("<svg onload=alert(1)>"), http.StatusUnsupportedMediaType},
+		"too large": {"avatar", append(png, make([]byte, maxAvatarSize)...), http.StatusRequestEntityTooLarge},
+		"empty":     {"avatar", nil, http.StatusBadRequest},
+		"missing":   {"picture", png, http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserUseCase := new(mocks.UserUseCase)
+			if tc.expected == http.StatusOK {
+				mockUserUseCase.On("SetAvatar", mock.Anything, id, mock.Anything, "image/png").
+					Return(&domain.User{UUID: id, Password: "hash", AvatarURL: &url}, nil).Once()
+			}
+
+			handler := UserHandler{userUseCase: mockUserUseCase}
+
+			body, contentType := avatarForm(t, tc.field, tc.file)
+
+			req := httptest.NewRequest(http.MethodPost, "/me/avatar", body)
+			req.Header.Set("Content-Type", contentType)
+			req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: id, Role: domain.RoleUser}))
+			rec := httptest.NewRecorder()
+
+			http.HandlerFunc(handler.Avatar).ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+			if tc.expected == http.StatusOK {
+				assert.Contains(t, rec.Body.String(), `"avatar_url":"`+url+`"`)
+				assert.NotContains(t, rec.Body.String(), "hash")
+			}
+			mockUserUseCase.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAvatarNotMultipart(t *testing.T) {
+	handler := UserHandler{userUseCase: new(mocks.UserUseCase)}
+
+	req := httptest.NewRequest(http.MethodPost, "/me/avatar", strings.NewReader(`{"avatar":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(cmiddleware.WithClaims(req.Context(), &cmiddleware.Claims{UUID: uuid.New(), Role: domain.RoleUser}))
+	rec := httptest.NewRecorder()
+
+	http.HandlerFunc(handler.Avatar).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestUserList(t *testing.T) {
+	username := "cyro"
+	now := time.Now()
+
+	users := []*domain.User{
+		{
+			UUID:           uuid.New(),
+			Name:           "Cyro Dubeux",
+			Email:          "xorycx@gmail.com",
+			EmailCanonical: "xorycx@gmail.com",
+			Username:       &username,
+			Password:       "12345678",
+			Role:           domain.RoleAdmin,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+			TokenVersion:   3,
+		},
+		{UUID: uuid.New(), Name: "John Doe <john@doe.com>", Role: domain.RoleUser},
+	}
+
+	domainPayload, err := json.Marshal(users)
+	assert.NoError(t, err)
+
+	// The list writes domain.User, in the same order, but for the hash.
+	var written []map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(domainPayload, &written))
+	for _, user := range written {
+		delete(user, "password")
+	}
+
+	payload, err := json.Marshal(userList(users))
+	assert.NoError(t, err)
+
+	var listed []map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(payload, &listed))
+
+	assert.Equal(t, written, listed)
+	assert.NotContains(t, string(payload), "password")
+	assert.NotContains(t, string(payload), "12345678")
+
+	// Every other field domain.User writes must be mirrored, with the
+	// same tag.
+	item := reflect.TypeOf(userListItem{})
+	user := reflect.TypeOf(domain.User{})
+
+	for i := 0; i < user.NumField(); i++ {
+		field := user.Field(i)
+		if field.Tag.Get("json") == "-" || field.Name == "Password" {
+			continue
+		}
+
+		mirrored, ok := item.FieldByName(field.Name)
+		if assert.True(t, ok, field.Name) {
+			assert.Equal(t, field.Tag.Get("json"), mirrored.Tag.Get("json"), field.Name)
+		}
+	}
+}
+
+// BenchmarkFindAll measures the list response of 10k users. Encoding
+// the domain users, as FindAll used to, costs an allocation per user
+// for its uuid:
+//
+//	BenchmarkFindAll/domain    117    11860920 ns/op    3208251 B/op    10006 allocs/op
+//	BenchmarkFindAll/list       99    11515668 ns/op    4103662 B/op      100 allocs/op
+func BenchmarkFindAll(b *testing.B) {
+	now := time.Now()
+
+	users := make([]*domain.User, 10000)
+	for i := range users {
+		users[i] = &domain.User{
+			UUID:      uuid.New(),
+			Name:      "Cyro Dubeux",
+			Email:     "xorycx@gmail.com",
+			Password:  "$2a$10$Vm8jmbPV5NMgoCag3O/iM.LTfMs6rmmwgDwRUw9m8QGFyis7EA/Gy",
+			Role:      domain.RoleUser,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+	}
+
+	b.Run("domain", func(b *testing.B) {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rest.JSONList(httptest.NewRecorder(), req, http.StatusOK, &users, len(users))
+		}
+	})
+
+	b.Run("list", func(b *testing.B) {
+		mockUserUseCase := new(mocks.UserUseCase)
+		mockUserUseCase.On("ListVersion", mock.Anything).Return(&domain.ListVersion{}, nil)
+		mockUserUseCase.On("FindAll", mock.Anything).Return(users, nil)
+
+		handler := UserHandler{
+			userUseCase: mockUserUseCase,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.FindAll(httptest.NewRecorder(), req)
+		}
+	})
+}
@@ -1,21 +1,95 @@
 package mariadb
 
+// Every query is scoped to the tenant of the context, see
+// database.Tenant, which comes right before the uuid.
 const (
-	sqlFindAll = "SELECT * FROM users"
+	// The uuid breaks ties between users created in the same instant,
+	// so the order is the same on every call.
+	sqlFindAll = "SELECT * FROM users WHERE tenant_id=? ORDER BY created_at DESC, uuid DESC"
 
-	sqlFindByID = "SELECT * FROM users WHERE uuid=?"
+	// The pages follow the order of sqlFindAll, each starting after the
+	// last user of the previous one.
+	sqlFindPage = "SELECT * FROM users WHERE tenant_id=? ORDER BY created_at DESC, uuid DESC LIMIT ?"
+
+	sqlFindPageAfter = `
+	SELECT * FROM users 
+	WHERE tenant_id=? AND (created_at < ? OR (created_at = ? AND uuid < ?)) 
+	ORDER BY created_at DESC, uuid DESC 
+	LIMIT ?
+	`
+
+	sqlFindByID = "SELECT * FROM users WHERE tenant_id=? AND uuid=?"
+
+	sqlFindByIDs = "SELECT * FROM users WHERE tenant_id=? AND uuid IN (?)"
+
+	// The prefix match can use the users_name index, unlike a %x% match.
+	sqlSearchByName = "SELECT * FROM users WHERE tenant_id=? AND name LIKE CONCAT(?, '%') ORDER BY name LIMIT ?"
+
+	sqlCount = "SELECT COUNT(*) FROM users WHERE tenant_id=?"
+
+	sqlCountForUpdate = "SELECT COUNT(*) FROM users WHERE tenant_id=? FOR UPDATE"
+
+	sqlListVersion = "SELECT MAX(updated_at) AS last_modified, COUNT(*) AS count FROM users WHERE tenant_id=?"
+
+	// The bucket expression comes from signupBuckets, never from the client.
+	sqlCountSignups = `
+	SELECT %s AS bucket, COUNT(*) AS count 
+	FROM users 
+	WHERE tenant_id=? AND created_at >= ? AND created_at < ? 
+	GROUP BY bucket 
+	ORDER BY bucket
+	`
 
 	sqlAdd = `
 	INSERT INTO 
-	users (uuid, name, email, password, created_at, updated_at) 
-	VALUES (?, ?, ?, ?, ?, ?)
+	users (uuid, tenant_id, name, email, email_canonical, username, password, role) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
+	sqlTimestamps = "SELECT created_at, updated_at FROM users WHERE tenant_id=? AND uuid=?"
+
+	// The assignments run left to right, so the version and the change
+	// time are set comparing against the previous password. A NULL
+	// password keeps the current one.
 	sqlUpdate = `
 	UPDATE users 
-	SET name=?, email=?, password=?, updated_at=?
-	WHERE uuid=?
+	SET name=?, email=?, email_canonical=?, username=?, 
+	token_version=IF(password = COALESCE(?, password), token_version, token_version + 1), 
+	password_changed_at=IF(password = COALESCE(?, password), password_changed_at, CURRENT_TIMESTAMP), 
+	password=COALESCE(?, password)
+	WHERE tenant_id=? AND uuid=?
 	`
 
-	sqlDelete = "DELETE FROM users WHERE uuid=?"
+	// The assignments come from updatableColumns, never from the caller.
+	sqlUpdateFields = "UPDATE users SET %s WHERE tenant_id=? AND uuid=?"
+
+	sqlDelete = "DELETE FROM users WHERE tenant_id=? AND uuid=?"
+
+	sqlDeletePasswordHistory = "DELETE FROM password_history WHERE tenant_id=? AND user_uuid=?"
+
+	sqlLogoutAll = "UPDATE users SET token_version=token_version + 1 WHERE tenant_id=? AND uuid=?"
+
+	sqlResetPassword = "UPDATE users SET password=?, password_changed_at=CURRENT_TIMESTAMP, token_version=token_version + 1 WHERE tenant_id=? AND uuid=?"
+
+	sqlPasswordHistory = "SELECT password FROM password_history WHERE tenant_id=? AND user_uuid=? ORDER BY id DESC LIMIT ?"
+
+	sqlAddPasswordHistory = "INSERT INTO password_history (tenant_id, user_uuid, password) VALUES (?, ?, ?)"
+
+	// The derived table lets the delete read the table it deletes from.
+	sqlPrunePasswordHistory = `
+	DELETE FROM password_history
+	WHERE tenant_id=? AND user_uuid=? AND id NOT IN (
+		SELECT id FROM (
+			SELECT id FROM password_history
+			WHERE tenant_id=? AND user_uuid=?
+			ORDER BY id DESC LIMIT ?
+		) AS recent
+	)
+	`
+
+	sqlSetAvatar = "UPDATE users SET avatar_key=?, avatar_url=? WHERE tenant_id=? AND uuid=?"
+
+	sqlTokenVersion = "SELECT token_version FROM users WHERE tenant_id=? AND uuid=?"
+
+	sqlHealthCheck = "SELECT 1"
 )
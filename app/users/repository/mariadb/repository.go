@@ -3,29 +3,105 @@ package mariadb
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"hexagony/app/users/domain"
+	"hexagony/lib/database"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
+// mysqlDuplicateEntry is the error number of unique key violations.
+const mysqlDuplicateEntry = 1062
+
 type mariadbRepository struct {
-	conn *sqlx.DB
+	conn     *sqlx.DB
+	read     *sqlx.DB
+	resolver database.Resolver
+	stmts    *statements
 }
 
+// NewMariaDBRepository returns the users repository. It's an io.Closer
+// closing its prepared statements, to be called before the pool is.
 func NewMariaDBRepository(conn *sqlx.DB) domain.UserRepository {
-	return &mariadbRepository{conn}
+	return NewMariaDBReadWriteRepository(conn, nil)
+}
+
+// NewMariaDBReadWriteRepository sends the reads to the read pool
+// and the mutations to the primary. Without a read pool both use the primary.
+func NewMariaDBReadWriteRepository(conn, read *sqlx.DB) domain.UserRepository {
+	if read == nil {
+		read = conn
+	}
+	return NewMariaDBTenantRepository(database.SingleDB(conn), conn, read)
+}
+
+// NewMariaDBTenantRepository gets the database of each request from the
+// resolver. The read pool only serves the tenants resolved to conn,
+// the others reading from their own database.
+func NewMariaDBTenantRepository(resolver database.Resolver, conn, read *sqlx.DB) domain.UserRepository {
+	if read == nil {
+		read = conn
+	}
+	return &mariadbRepository{
+		conn:     conn,
+		read:     read,
+		resolver: resolver,
+		stmts:    newStatements(),
+	}
+}
+
+// Close closes the prepared statements.
+func (r *mariadbRepository) Close() error {
+	return r.stmts.close()
+}
+
+// reader returns the pool for reads, which is the primary
+// when the context asks for it.
+func (r *mariadbRepository) reader(ctx context.Context) (*sqlx.DB, error) {
+	conn, err := r.primary(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if conn == r.conn && !database.PrimaryRead(ctx) {
+		return r.read, nil
+	}
+	return conn, nil
+}
+
+// writer returns the primary, marking the write so the following
+// reads of the request use the primary too.
+func (r *mariadbRepository) writer(ctx context.Context) (*sqlx.DB, error) {
+	database.MarkWrite(ctx)
+	return r.primary(ctx)
+}
+
+// primary returns the database of the tenant of the request.
+func (r *mariadbRepository) primary(ctx context.Context) (*sqlx.DB, error) {
+	return r.resolver.DB(ctx)
 }
 
 func (r *mariadbRepository) FindAll(
 	ctx context.Context,
 ) ([]*domain.User, error) {
-	var users []*domain.User
+	users := make([]*domain.User, 0)
+
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	err := r.conn.SelectContext(
+	err = conn.SelectContext(
 		ctx,
 		&users,
-		sqlFindAll,
+		database.Annotate(ctx, sqlFindAll),
+		database.Tenant(ctx),
 	)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
@@ -34,16 +110,60 @@ func (r *mariadbRepository) FindAll(
 	return users, nil
 }
 
+func (r *mariadbRepository) FindAllStream(
+	ctx context.Context,
+	fn func(*domain.User) error,
+) error {
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryxContext(
+		ctx,
+		database.Annotate(ctx, sqlFindAll),
+		database.Tenant(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user domain.User
+
+		if err := rows.StructScan(&user); err != nil {
+			return err
+		}
+
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 func (r *mariadbRepository) FindByID(
 	ctx context.Context,
 	uuid uuid.UUID,
 ) (*domain.User, error) {
 	var user domain.User
 
-	err := r.conn.GetContext(
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.stmts.get(ctx, conn, sqlFindByID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stmt.GetContext(
 		ctx,
 		&user,
-		sqlFindByID,
+		database.Tenant(ctx),
 		uuid,
 	)
 	if err != nil && err != sql.ErrNoRows {
@@ -53,69 +173,661 @@ func (r *mariadbRepository) FindByID(
 	return &user, nil
 }
 
+// FindByIDs gets the users in a single query. The rows come back
+// in no particular order and missing users are simply left out.
+func (r *mariadbRepository) FindByIDs(
+	ctx context.Context,
+	uuids []uuid.UUID,
+) ([]*domain.User, error) {
+	users := make([]*domain.User, 0, len(uuids))
+
+	if len(uuids) == 0 {
+		return users, nil
+	}
+
+	query, args, err := sqlx.In(sqlFindByIDs, database.Tenant(ctx), uuids)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SelectContext(
+		ctx,
+		&users,
+		database.Annotate(ctx, conn.Rebind(query)),
+		args...,
+	); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// FindPage lists up to limit users in the order of FindAll, starting
+// after the position, or at the first user when it's nil.
+func (r *mariadbRepository) FindPage(
+	ctx context.Context,
+	after *domain.ListPosition,
+	limit int,
+) ([]*domain.User, error) {
+	users := make([]*domain.User, 0)
+
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args := sqlFindPage, []interface{}{database.Tenant(ctx), limit}
+	if after != nil {
+		query = sqlFindPageAfter
+		args = []interface{}{database.Tenant(ctx), after.CreatedAt, after.CreatedAt, after.UUID, limit}
+	}
+
+	if err := conn.SelectContext(ctx, &users, database.Annotate(ctx, query), args...); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SearchByName lists up to limit users whose name starts with prefix.
+func (r *mariadbRepository) SearchByName(
+	ctx context.Context,
+	prefix string,
+	limit int,
+) ([]*domain.User, error) {
+	users := make([]*domain.User, 0)
+
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SelectContext(
+		ctx,
+		&users,
+		database.Annotate(ctx, sqlSearchByName),
+		database.Tenant(ctx),
+		likeEscaper.Replace(prefix),
+		limit,
+	); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// likeEscaper escapes the LIKE wildcards so the prefix is matched literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// signupBuckets maps each interval to the date starting its bucket.
+// Weeks start on Monday.
+var signupBuckets = map[string]string{
+	domain.IntervalDay:   "DATE(created_at)",
+	domain.IntervalWeek:  "DATE(created_at - INTERVAL WEEKDAY(created_at) DAY)",
+	domain.IntervalMonth: "DATE(DATE_FORMAT(created_at, '%Y-%m-01'))",
+}
+
+// CountSignups counts the users created in [from, to) by interval.
+func (r *mariadbRepository) CountSignups(
+	ctx context.Context,
+	from, to time.Time,
+	interval string,
+) ([]*domain.SignupBucket, error) {
+	bucket, ok := signupBuckets[interval]
+	if !ok {
+		return nil, domain.ErrStatsInterval
+	}
+
+	buckets := make([]*domain.SignupBucket, 0)
+
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SelectContext(
+		ctx,
+		&buckets,
+		database.Annotate(ctx, fmt.Sprintf(sqlCountSignups, bucket)),
+		database.Tenant(ctx),
+		from,
+		to,
+	); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+func (r *mariadbRepository) Count(
+	ctx context.Context,
+) (int, error) {
+	var count int
+
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := conn.GetContext(
+		ctx,
+		&count,
+		database.Annotate(ctx, sqlCount),
+		database.Tenant(ctx),
+	); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ListVersion returns the latest updated_at of the users, the zero
+// time when there is none, and their count.
+func (r *mariadbRepository) ListVersion(
+	ctx context.Context,
+) (*domain.ListVersion, error) {
+	var row struct {
+		LastModified sql.NullTime `db:"last_modified"`
+		Count        int          `db:"count"`
+	}
+
+	conn, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.GetContext(
+		ctx,
+		&row,
+		database.Annotate(ctx, sqlListVersion),
+		database.Tenant(ctx),
+	); err != nil {
+		return nil, err
+	}
+
+	return &domain.ListVersion{LastModified: row.LastModified.Time, Count: row.Count}, nil
+}
+
+// Add inserts the user and reads back the timestamps set by the database.
+// Both statements are prepared.
 func (r *mariadbRepository) Add(
 	ctx context.Context,
 	user *domain.User,
 ) error {
-	if _, err := r.conn.ExecContext(
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	add, err := r.stmts.get(ctx, conn, sqlAdd)
+	if err != nil {
+		return err
+	}
+
+	user.TenantID = database.Tenant(ctx)
+
+	if _, err := add.ExecContext(
 		ctx,
-		sqlAdd,
 		user.UUID,
+		user.TenantID,
 		user.Name,
 		user.Email,
+		domain.CanonicalEmail(user.Email),
+		user.Username,
 		user.Password,
-		user.CreatedAt,
-		user.UpdatedAt,
+		user.Role,
 	); err != nil {
+		return mapError(err)
+	}
+
+	timestamps, err := r.stmts.get(ctx, conn, sqlTimestamps)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return timestamps.GetContext(ctx, user, user.TenantID, user.UUID)
 }
 
+// AddWithinQuota inserts the user only if there are fewer than quota users.
+// The count locks the table rows so concurrent inserts can't exceed the quota.
+func (r *mariadbRepository) AddWithinQuota(
+	ctx context.Context,
+	user *domain.User,
+	quota int,
+) error {
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+
+	if err := tx.GetContext(
+		ctx,
+		&count,
+		database.Annotate(ctx, sqlCountForUpdate),
+		database.Tenant(ctx),
+	); err != nil {
+		return err
+	}
+
+	if count >= quota {
+		return domain.ErrQuotaExceeded
+	}
+
+	if err := insert(ctx, tx, user); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insert runs the insert statement on a connection or transaction.
+func insert(
+	ctx context.Context,
+	ext sqlx.ExtContext,
+	user *domain.User,
+) error {
+	user.TenantID = database.Tenant(ctx)
+
+	if _, err := ext.ExecContext(
+		ctx,
+		database.Annotate(ctx, sqlAdd),
+		user.UUID,
+		user.TenantID,
+		user.Name,
+		user.Email,
+		domain.CanonicalEmail(user.Email),
+		user.Username,
+		user.Password,
+		user.Role,
+	); err != nil {
+		return mapError(err)
+	}
+
+	return timestamps(ctx, ext, user)
+}
+
+// timestamps reads the created_at and updated_at generated by the database,
+// so they don't depend on the clock of each app instance.
+func timestamps(
+	ctx context.Context,
+	queryer sqlx.QueryerContext,
+	user *domain.User,
+) error {
+	return sqlx.GetContext(
+		ctx,
+		queryer,
+		user,
+		database.Annotate(ctx, sqlTimestamps),
+		database.Tenant(ctx),
+		user.UUID,
+	)
+}
+
+// Update updates the user and reads back the timestamps set by the database.
+// An empty password keeps the current one, a new one bumps the token version.
 func (r *mariadbRepository) Update(
 	ctx context.Context,
 	uuid uuid.UUID,
 	user *domain.User,
 ) error {
-	result, err := r.conn.ExecContext(
+	password := sql.NullString{String: user.Password, Valid: user.Password != ""}
+
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := conn.ExecContext(
 		ctx,
-		sqlUpdate,
+		database.Annotate(ctx, sqlUpdate),
 		user.Name,
 		user.Email,
-		user.Password,
-		user.UpdatedAt,
+		domain.CanonicalEmail(user.Email),
+		user.Username,
+		password,
+		password,
+		password,
+		database.Tenant(ctx),
 		uuid,
 	)
 	if err != nil {
+		return mapError(err)
+	}
+
+	if err := expectAffected(result, 1); err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	user.UUID = uuid
+
+	return timestamps(ctx, conn, user)
+}
+
+// updatableColumns are the columns UpdateFields may set. The tenant,
+// the uuid and the password, whose changes go through Update and
+// ResetPassword, aren't.
+var updatableColumns = map[string]bool{
+	"name":                true,
+	"email":               true,
+	"email_canonical":     true,
+	"username":            true,
+	"role":                true,
+	"token_version":       true,
+	"avatar_key":          true,
+	"avatar_url":          true,
+	"password_changed_at": true,
+}
+
+// UpdateFields sets only the given columns of the user, e.g. just its
+// token_version. A column out of updatableColumns fails with
+// domain.ErrUnknownColumn before anything is written, no field at all
+// is a no-op.
+func (r *mariadbRepository) UpdateFields(
+	ctx context.Context,
+	uuid uuid.UUID,
+	fields map[string]interface{},
+) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	columns := make([]string, 0, len(fields))
+	for column := range fields {
+		if !updatableColumns[column] {
+			return fmt.Errorf("%w: %s", domain.ErrUnknownColumn, column)
+		}
+		columns = append(columns, column)
+	}
+
+	// Sorted, the same fields always make the same statement.
+	sort.Strings(columns)
+
+	assignments := make([]string, len(columns))
+	args := make([]interface{}, 0, len(columns)+2)
+	for i, column := range columns {
+		assignments[i] = column + "=?"
+		args = append(args, fields[column])
+	}
+	args = append(args, database.Tenant(ctx), uuid)
+
+	conn, err := r.writer(ctx)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return domain.ErrResourceNotFound
+	result, err := conn.ExecContext(
+		ctx,
+		database.Annotate(ctx, fmt.Sprintf(sqlUpdateFields, strings.Join(assignments, ", "))),
+		args...,
+	)
+	if err != nil {
+		return mapError(err)
 	}
 
-	return nil
+	return expectAffected(result, 1)
 }
 
+// Delete removes the user along with the rows referencing it, in a
+// transaction so none is left behind.
 func (r *mariadbRepository) Delete(
 	ctx context.Context,
 	uuid uuid.UUID,
 ) error {
-	result, err := r.conn.ExecContext(
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tenant := database.Tenant(ctx)
+
+	if _, err := tx.ExecContext(
+		ctx,
+		database.Annotate(ctx, sqlDeletePasswordHistory),
+		tenant,
+		uuid,
+	); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(
 		ctx,
-		sqlDelete,
+		database.Annotate(ctx, sqlDelete),
+		tenant,
 		uuid,
 	)
 	if err != nil {
 		return err
 	}
 
+	if err := expectAffected(result, 1); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// LogoutAll invalidates the tokens issued to the user until now
+// by bumping their version.
+func (r *mariadbRepository) LogoutAll(
+	ctx context.Context,
+	uuid uuid.UUID,
+) error {
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := conn.ExecContext(
+		ctx,
+		database.Annotate(ctx, sqlLogoutAll),
+		database.Tenant(ctx),
+		uuid,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, 1)
+}
+
+// ResetPassword replaces the password hash and bumps the token
+// version, logging the user out everywhere.
+func (r *mariadbRepository) ResetPassword(
+	ctx context.Context,
+	uuid uuid.UUID,
+	password string,
+) error {
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := conn.ExecContext(
+		ctx,
+		database.Annotate(ctx, sqlResetPassword),
+		password,
+		database.Tenant(ctx),
+		uuid,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, 1)
+}
+
+// PasswordHistory returns up to limit of the latest password hashes
+// of the user, the newest first. It reads from the primary, the hash
+// just recorded must be there.
+func (r *mariadbRepository) PasswordHistory(
+	ctx context.Context,
+	uuid uuid.UUID,
+	limit int,
+) ([]string, error) {
+	hashes := make([]string, 0, limit)
+
+	conn, err := r.primary(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SelectContext(
+		ctx,
+		&hashes,
+		database.Annotate(ctx, sqlPasswordHistory),
+		database.Tenant(ctx),
+		uuid,
+		limit,
+	); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// AddPasswordHistory records the password hash of the user and prunes
+// the history to the keep latest ones.
+func (r *mariadbRepository) AddPasswordHistory(
+	ctx context.Context,
+	uuid uuid.UUID,
+	password string,
+	keep int,
+) error {
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tenant := database.Tenant(ctx)
+
+	if _, err := tx.ExecContext(
+		ctx,
+		database.Annotate(ctx, sqlAddPasswordHistory),
+		tenant,
+		uuid,
+		password,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		database.Annotate(ctx, sqlPrunePasswordHistory),
+		tenant,
+		uuid,
+		tenant,
+		uuid,
+		keep,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetAvatar replaces the avatar of the user, nil removes it.
+func (r *mariadbRepository) SetAvatar(
+	ctx context.Context,
+	uuid uuid.UUID,
+	key *string,
+	url *string,
+) error {
+	conn, err := r.writer(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := conn.ExecContext(
+		ctx,
+		database.Annotate(ctx, sqlSetAvatar),
+		key,
+		url,
+		database.Tenant(ctx),
+		uuid,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, 1)
+}
+
+// TokenVersion returns the version the tokens of the user must carry.
+// It reads from the primary, a lagging replica would let revoked
+// tokens through.
+func (r *mariadbRepository) TokenVersion(
+	ctx context.Context,
+	uuid uuid.UUID,
+) (int, error) {
+	var version int
+
+	conn, err := r.primary(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = conn.GetContext(
+		ctx,
+		&version,
+		database.Annotate(ctx, sqlTokenVersion),
+		database.Tenant(ctx),
+		uuid,
+	)
+	if err == sql.ErrNoRows {
+		return 0, domain.ErrResourceNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// HealthCheck runs a trivial query on the primary and, when there is
+// one, on the read pool, so it fails if either can't serve the users.
+// The databases of the other tenants aren't checked.
+func (r *mariadbRepository) HealthCheck(ctx context.Context) error {
+	var one int
+
+	if err := r.conn.GetContext(ctx, &one, sqlHealthCheck); err != nil {
+		return err
+	}
+
+	if r.read != r.conn {
+		return r.read.GetContext(ctx, &one, sqlHealthCheck)
+	}
+
+	return nil
+}
+
+// expectAffected checks the statement affected exactly n rows.
+// No row at all means the resource doesn't exist.
+func expectAffected(result sql.Result, n int64) error {
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
@@ -125,5 +837,26 @@ func (r *mariadbRepository) Delete(
 		return domain.ErrResourceNotFound
 	}
 
+	if rowsAffected != n {
+		return fmt.Errorf("expected %d affected rows, got %d", n, rowsAffected)
+	}
+
 	return nil
 }
+
+// mapError translates unique key violations into domain errors.
+func mapError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != mysqlDuplicateEntry {
+		return err
+	}
+
+	switch {
+	case strings.Contains(mysqlErr.Message, "users_email_canonical_unique"):
+		return domain.ErrEmailTaken
+	case strings.Contains(mysqlErr.Message, "users_username_unique"):
+		return domain.ErrUsernameTaken
+	}
+
+	return err
+}
@@ -3,12 +3,17 @@ package mariadb
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"hexagony/app/users/domain"
+	"hexagony/lib/database"
+	"io"
+	"os"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
@@ -78,7 +83,7 @@ func TestFindAll(t *testing.T) {
 	assert.Equal(t, userList[0].Name, "Cyro Dubeux")
 }
 
-func TestFindAllFail(t *testing.T) {
+func TestFindAllEmpty(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -95,19 +100,25 @@ func TestFindAllFail(t *testing.T) {
 		"password",
 		"created_at",
 		"updated_at",
-	}).
-		AddRow("", "", "", "", "", "")
+	})
 
 	query := "SELECT \\* FROM users"
+
 	mock.ExpectQuery(query).WillReturnRows(rows)
 
 	userRepo := NewMariaDBRepository(dbx)
-	_, err = userRepo.FindAll(context.TODO())
+	userList, err := userRepo.FindAll(context.TODO())
 
-	assert.NotNil(t, err)
+	assert.NoError(t, err)
+	assert.NotNil(t, userList)
+	assert.Len(t, userList, 0)
+
+	body, err := json.Marshal(userList)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(body))
 }
 
-func TestFindByID(t *testing.T) {
+func TestFindAllNewestFirst(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -117,8 +128,10 @@ func TestFindByID(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	newUUID := uuid.New()
+	older := time.Now().Add(-time.Minute)
+	newer := time.Now()
 
+	// The database sorts the rows, so they come back in the query order.
 	rows := sqlmock.NewRows([]string{
 		"uuid",
 		"name",
@@ -127,22 +140,24 @@ func TestFindByID(t *testing.T) {
 		"created_at",
 		"updated_at",
 	}).
-		AddRow(newUUID, "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now())
+		AddRow(uuid.New(), "John Doe", "john@doe.com", "12345678", newer, newer).
+		AddRow(uuid.New(), "Cyro Dubeux", "xorycx@gmail.com", "12345678", older, older)
+
+	query := "SELECT \\* FROM users WHERE tenant_id=\\? ORDER BY created_at DESC, uuid DESC"
 
-	query := "SELECT \\* FROM users WHERE uuid=\\?"
 	mock.ExpectQuery(query).WillReturnRows(rows)
 
 	userRepo := NewMariaDBRepository(dbx)
-	currentUser, err := userRepo.FindByID(context.TODO(), newUUID)
+	userList, err := userRepo.FindAll(context.TODO())
 
 	assert.NoError(t, err)
-	assert.NotNil(t, currentUser)
-	assert.Equal(t, "Cyro Dubeux", currentUser.Name)
+	assert.Len(t, userList, 2)
+	assert.Equal(t, "John Doe", userList[0].Name)
+	assert.True(t, userList[0].CreatedAt.After(userList[1].CreatedAt))
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestGetByIDFail(t *testing.T) {
-	newUUID := uuid.New()
-	ctx := context.TODO()
+func TestFindAllFail(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -162,27 +177,16 @@ func TestGetByIDFail(t *testing.T) {
 	}).
 		AddRow("", "", "", "", "", "")
 
-	query := "SELECT \\* FROM users WHERE uuid=\\?"
+	query := "SELECT \\* FROM users"
 	mock.ExpectQuery(query).WillReturnRows(rows)
 
 	userRepo := NewMariaDBRepository(dbx)
-	_, err = userRepo.FindByID(ctx, newUUID)
+	_, err = userRepo.FindAll(context.TODO())
 
 	assert.NotNil(t, err)
 }
 
-func TestAdd(t *testing.T) {
-	now := time.Now()
-	newUUID := uuid.New()
-	user := &domain.User{
-		UUID:      newUUID,
-		Name:      "Cyro Dubeux",
-		Email:     "xorycx@gmail.com",
-		Password:  "12345678",
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-
+func TestFindAllStream(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -192,23 +196,34 @@ func TestAdd(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	query := `INSERT INTO 
-	users (uuid, name, email, password, created_at, updated_at) 
-	VALUES (?, ?, ?, ?, ?, ?)`
+	rows := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"email",
+		"password",
+		"created_at",
+		"updated_at",
+	}).
+		AddRow(uuid.New(), "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now()).
+		AddRow(uuid.New(), "John Doe", "john@doe.com", "12345678", time.Now(), time.Now())
 
-	mock.ExpectExec(regexp.QuoteMeta(query)).
-		WithArgs(newUUID, user.Name, user.Email, user.Password, user.CreatedAt, user.UpdatedAt).
-		WillReturnResult(sqlmock.NewResult(1, 1)) // Using UUID
+	query := "SELECT \\* FROM users"
+	mock.ExpectQuery(query).WillReturnRows(rows)
+
+	names := make([]string, 0)
 
 	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Add(context.TODO(), user)
+	err = userRepo.FindAllStream(context.TODO(), func(user *domain.User) error {
+		names = append(names, user.Name)
+		return nil
+	})
 
 	assert.NoError(t, err)
+	assert.Equal(t, []string{"Cyro Dubeux", "John Doe"}, names)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestStoreFail(t *testing.T) {
-	user := &domain.User{}
-
+func TestFindAllStreamFail(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -218,39 +233,28 @@ func TestStoreFail(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	query := `
-	  INSERT INTO users (
-		uuid,
-		name,
-		email,
-		password,
-		created_at,
-		updated_at
-		)
-		VALUES (?, ?, ?, ?, ?, ?)
-		`
+	rows := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"email",
+		"password",
+		"created_at",
+		"updated_at",
+	}).
+		AddRow("", "", "", "", "", "")
 
-	mock.ExpectExec(regexp.QuoteMeta(query)).
-		WithArgs("", "", "", "", "").
-		WillReturnResult(sqlmock.NewResult(1, 1)) // Using UUID
+	query := "SELECT \\* FROM users"
+	mock.ExpectQuery(query).WillReturnRows(rows)
 
 	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Add(context.TODO(), user)
+	err = userRepo.FindAllStream(context.TODO(), func(user *domain.User) error {
+		return nil
+	})
 
 	assert.NotNil(t, err)
 }
 
-func TestUpdate(t *testing.T) {
-	now := time.Now()
-	newUUID := uuid.New()
-	user := &domain.User{
-		UUID:      newUUID,
-		Name:      "Cyro Dubeux",
-		Email:     "xorycx@gmail.com",
-		Password:  "12345678",
-		UpdatedAt: now,
-	}
-
+func TestFindByID(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -260,30 +264,30 @@ func TestUpdate(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	query := `
-		UPDATE users
-		SET
-		name=?,
-		email=?,
-		password=?,
-		updated_at=?
-		WHERE uuid=?
-	`
+	newUUID := uuid.New()
 
-	mock.ExpectExec(regexp.QuoteMeta(query)).
-		WithArgs(user.Name, user.Email, user.Password, user.UpdatedAt, user.UUID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	rows := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"email",
+		"password",
+		"created_at",
+		"updated_at",
+	}).
+		AddRow(newUUID, "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now())
+
+	query := "SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?"
+	mock.ExpectPrepare(query).ExpectQuery().WillReturnRows(rows)
 
 	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Update(context.TODO(), newUUID, user)
+	currentUser, err := userRepo.FindByID(context.TODO(), newUUID)
 
 	assert.NoError(t, err)
+	assert.NotNil(t, currentUser)
+	assert.Equal(t, "Cyro Dubeux", currentUser.Name)
 }
 
-func TestUpdateFail(t *testing.T) {
-	newUUID := uuid.New()
-	user := &domain.User{}
-
+func TestFindByIDs(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -293,37 +297,33 @@ func TestUpdateFail(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	query := `
-		UPDATE users
-		SET
-		name=?,
-		email=?,
-		password=?,
-		updated_at=?
-		WHERE uuid=?
-	`
+	first, second := uuid.New(), uuid.New()
 
-	mock.ExpectExec(regexp.QuoteMeta(query)).
-		WithArgs("", "", "", "", "", "").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	rows := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"email",
+		"password",
+		"created_at",
+		"updated_at",
+	}).
+		AddRow(second, "John Doe", "john@doe.com", "12345678", time.Now(), time.Now()).
+		AddRow(first, "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now())
+
+	query := "SELECT \\* FROM users WHERE tenant_id=\\? AND uuid IN \\(\\?, \\?\\)"
+	mock.ExpectQuery(query).
+		WithArgs(database.DefaultTenant, first, second).
+		WillReturnRows(rows)
 
 	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Update(context.TODO(), newUUID, user)
+	users, err := userRepo.FindByIDs(context.TODO(), []uuid.UUID{first, second})
 
-	assert.NotNil(t, err)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestUpdateRowsAffected(t *testing.T) {
-	now := time.Now()
-	newUUID := uuid.New()
-	user := &domain.User{
-		UUID:      newUUID,
-		Name:      "Cyro Dubeux",
-		Email:     "xorycx@gmail.com",
-		Password:  "12345678",
-		UpdatedAt: now,
-	}
-
+func TestFindByIDsEmpty(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -333,122 +333,158 @@ func TestUpdateRowsAffected(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	query := `
-		UPDATE users
-		SET
-		name=?,
-		email=?,
-		password=?,
-		updated_at=?
-		WHERE uuid=?
-	`
-
-	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(
-		user.Name,
-		user.Email,
-		user.Password,
-		user.UpdatedAt,
-		user.UUID,
-	).WillReturnResult(sqlmock.NewResult(1, 0))
-
 	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Update(context.TODO(), newUUID, user)
+	users, err := userRepo.FindByIDs(context.TODO(), nil)
 
-	assert.NotNil(t, err)
+	assert.NoError(t, err)
+	assert.NotNil(t, users)
+	assert.Len(t, users, 0)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestUpdateRowsAffectedFail(t *testing.T) {
-	now := time.Now()
-	newUUID := uuid.New()
-	user := &domain.User{
-		UUID:      newUUID,
-		Name:      "Cyro Dubeux",
-		Email:     "xorycx@gmail.com",
-		Password:  "12345678",
-		UpdatedAt: now,
+func TestReadWriteSplit(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
-	db, mock, err := sqlmock.New()
+	defer primary.Close()
+
+	replica, replicaMock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
-	defer db.Close()
+	defer replica.Close()
 
-	dbx := sqlx.NewDb(db, "sqlmock")
+	newUUID := uuid.New()
+	columns := []string{"uuid", "name", "email", "password", "created_at", "updated_at"}
+
+	replicaMock.ExpectPrepare("SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?").ExpectQuery().
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(newUUID, "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now()))
+	replicaMock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectExec("DELETE FROM password_history WHERE tenant_id=\\? AND user_uuid=\\?").
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	primaryMock.ExpectExec("DELETE FROM users WHERE tenant_id=\\? AND uuid=\\?").
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	primaryMock.ExpectCommit()
+	primaryMock.ExpectPrepare("SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?").ExpectQuery().
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(newUUID, "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now()))
+
+	userRepo := NewMariaDBReadWriteRepository(
+		sqlx.NewDb(primary, "sqlmock"),
+		sqlx.NewDb(replica, "sqlmock"),
+	)
 
-	query := `
-		UPDATE users
-		SET
-		name=?,
-		email=?,
-		password=?,
-		updated_at=?
-		WHERE uuid=?
-	`
+	_, err = userRepo.FindByID(context.TODO(), newUUID)
+	assert.NoError(t, err)
 
-	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(
-		user.Name,
-		user.Email,
-		user.Password,
-		user.UpdatedAt,
-		user.UUID,
-	).WillReturnResult(sqlmock.NewErrorResult(sql.ErrNoRows))
+	_, err = userRepo.Count(context.TODO())
+	assert.NoError(t, err)
 
-	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Update(context.TODO(), newUUID, user)
+	err = userRepo.Delete(context.TODO(), newUUID)
+	assert.NoError(t, err)
 
-	assert.NotNil(t, err)
+	_, err = userRepo.FindByID(database.WithPrimaryRead(context.TODO()), newUUID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, primaryMock.ExpectationsWereMet())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
 }
 
-func TestDelete(t *testing.T) {
-	newUUID := uuid.New()
-	db, mock, err := sqlmock.New()
+func TestReadYourWrites(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
-	defer db.Close()
+	defer primary.Close()
 
-	dbx := sqlx.NewDb(db, "sqlmock")
+	replica, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
 
-	query := "DELETE FROM users WHERE uuid=\\?"
+	defer replica.Close()
 
-	mock.ExpectExec(query).
-		WithArgs(newUUID).
+	newUUID := uuid.New()
+
+	replicaMock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	primaryMock.ExpectExec("UPDATE users SET token_version=token_version \\+ 1 WHERE tenant_id=\\? AND uuid=\\?").
+		WithArgs(database.DefaultTenant, newUUID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	primaryMock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 
-	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Delete(context.TODO(), newUUID)
+	userRepo := NewMariaDBReadWriteRepository(
+		sqlx.NewDb(primary, "sqlmock"),
+		sqlx.NewDb(replica, "sqlmock"),
+	)
+
+	ctx := database.TrackWrites(context.TODO())
 
+	_, err = userRepo.Count(ctx)
 	assert.NoError(t, err)
+
+	err = userRepo.LogoutAll(ctx, newUUID)
+	assert.NoError(t, err)
+
+	_, err = userRepo.Count(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, primaryMock.ExpectationsWereMet())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
 }
 
-func TestDeleteFailure(t *testing.T) {
-	newUUID := uuid.New()
-	db, mock, err := sqlmock.New()
+func TestHealthCheck(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
-	defer db.Close()
+	defer primary.Close()
 
-	dbx := sqlx.NewDb(db, "sqlmock")
+	replica, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
 
-	query := "DELETE FROM users WHERE uuid=\\?"
+	defer replica.Close()
 
-	mock.ExpectExec(query).
-		WithArgs(0).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	primaryMock.ExpectQuery("SELECT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	replicaMock.ExpectQuery("SELECT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
 
-	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Delete(context.TODO(), newUUID)
+	primaryMock.ExpectQuery("SELECT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	replicaMock.ExpectQuery("SELECT 1").
+		WillReturnError(sql.ErrConnDone)
 
-	assert.NotNil(t, err)
+	userRepo := NewMariaDBReadWriteRepository(
+		sqlx.NewDb(primary, "sqlmock"),
+		sqlx.NewDb(replica, "sqlmock"),
+	)
+
+	assert.NoError(t, userRepo.HealthCheck(context.TODO()))
+	assert.Error(t, userRepo.HealthCheck(context.TODO()))
+
+	assert.NoError(t, primaryMock.ExpectationsWereMet())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
 }
 
-func TestDeleteRowsAffected(t *testing.T) {
+func TestGetByIDFail(t *testing.T) {
 	newUUID := uuid.New()
+	ctx := context.TODO()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -458,15 +494,721 @@ func TestDeleteRowsAffected(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	query := "DELETE FROM users WHERE uuid=\\?"
-
-	mock.ExpectExec(query).
-		WithArgs(newUUID).
-		WillReturnResult(sqlmock.NewResult(1, 0))
-
-	userRepo := NewMariaDBRepository(dbx)
-	err = userRepo.Delete(context.TODO(), newUUID)
-
+	rows := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"email",
+		"password",
+		"created_at",
+		"updated_at",
+	}).
+		AddRow("", "", "", "", "", "")
+
+	query := "SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?"
+	mock.ExpectQuery(query).WillReturnRows(rows)
+
+	userRepo := NewMariaDBRepository(dbx)
+	_, err = userRepo.FindByID(ctx, newUUID)
+
+	assert.NotNil(t, err)
+}
+
+func TestAdd(t *testing.T) {
+	now := time.Now()
+	newUUID := uuid.New()
+	user := &domain.User{
+		UUID:      newUUID,
+		Name:      "Cyro Dubeux",
+		Email:     "xorycx@gmail.com",
+		Password:  "12345678",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `INSERT INTO 
+	users (uuid, tenant_id, name, email, email_canonical, username, password, role) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	mock.ExpectPrepare(regexp.QuoteMeta(query)).ExpectExec().
+		WithArgs(newUUID, database.DefaultTenant, user.Name, user.Email, domain.CanonicalEmail(user.Email), user.Username, user.Password, user.Role).
+		WillReturnResult(sqlmock.NewResult(1, 1)) // Using UUID
+
+	createdAt := now.Add(-time.Hour).UTC().Truncate(time.Second)
+
+	mock.ExpectPrepare(regexp.QuoteMeta("SELECT created_at, updated_at FROM users WHERE tenant_id=? AND uuid=?")).ExpectQuery().
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(createdAt, createdAt))
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Add(context.TODO(), user)
+
+	assert.NoError(t, err)
+	assert.Equal(t, createdAt, user.CreatedAt)
+	assert.Equal(t, createdAt, user.UpdatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddEmailTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `INSERT INTO 
+	users (uuid, tenant_id, name, email, email_canonical, username, password, role) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	first := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "12345678"}
+	second := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "XoryCX@Gmail.com", Password: "12345678"}
+
+	add := mock.ExpectPrepare(regexp.QuoteMeta(query))
+	add.ExpectExec().
+		WithArgs(first.UUID, database.DefaultTenant, first.Name, first.Email, "xorycx@gmail.com", nil, first.Password, first.Role).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectPrepare(regexp.QuoteMeta("SELECT created_at, updated_at FROM users WHERE tenant_id=? AND uuid=?")).ExpectQuery().
+		WithArgs(database.DefaultTenant, first.UUID).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+
+	// The insert statement is reused.
+	add.ExpectExec().
+		WithArgs(second.UUID, database.DefaultTenant, second.Name, second.Email, "xorycx@gmail.com", nil, second.Password, second.Role).
+		WillReturnError(&mysql.MySQLError{
+			Number:  1062,
+			Message: "Duplicate entry 'xorycx@gmail.com' for key 'users_email_canonical_unique'",
+		})
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	assert.NoError(t, userRepo.Add(context.TODO(), first))
+	assert.ErrorIs(t, userRepo.Add(context.TODO(), second), domain.ErrEmailTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddUsernameTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `INSERT INTO 
+	users (uuid, tenant_id, name, email, email_canonical, username, password, role) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	username := "cyro"
+	user := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Username: &username, Password: "12345678"}
+
+	mock.ExpectPrepare(regexp.QuoteMeta(query)).ExpectExec().
+		WithArgs(user.UUID, database.DefaultTenant, user.Name, user.Email, "xorycx@gmail.com", &username, user.Password, user.Role).
+		WillReturnError(&mysql.MySQLError{
+			Number:  1062,
+			Message: "Duplicate entry 'default-cyro' for key 'users_username_unique'",
+		})
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	err = userRepo.Add(context.TODO(), user)
+	assert.ErrorIs(t, err, domain.ErrUsernameTaken)
+	assert.NotErrorIs(t, err, domain.ErrEmailTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	userRepo := NewMariaDBRepository(dbx)
+	count, err := userRepo.Count(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestListVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+	userRepo := NewMariaDBRepository(dbx)
+
+	t.Run("users", func(t *testing.T) {
+		lastModified := time.Date(2022, 6, 6, 10, 30, 0, 0, time.UTC)
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT MAX(updated_at) AS last_modified, COUNT(*) AS count FROM users")).
+			WillReturnRows(sqlmock.NewRows([]string{"last_modified", "count"}).AddRow(lastModified, 2))
+
+		got, err := userRepo.ListVersion(context.TODO())
+
+		assert.NoError(t, err)
+		assert.Equal(t, &domain.ListVersion{LastModified: lastModified, Count: 2}, got)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT MAX(updated_at) AS last_modified, COUNT(*) AS count FROM users")).
+			WillReturnRows(sqlmock.NewRows([]string{"last_modified", "count"}).AddRow(nil, 0))
+
+		got, err := userRepo.ListVersion(context.TODO())
+
+		assert.NoError(t, err)
+		assert.True(t, got.LastModified.IsZero())
+		assert.Equal(t, 0, got.Count)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountSignups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		interval string
+		bucket   string
+	}{
+		{domain.IntervalDay, "DATE(created_at)"},
+		{domain.IntervalWeek, "DATE(created_at - INTERVAL WEEKDAY(created_at) DAY)"},
+		{domain.IntervalMonth, "DATE(DATE_FORMAT(created_at, '%Y-%m-01'))"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.interval, func(t *testing.T) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT "+c.bucket+" AS bucket, COUNT(*) AS count")).
+				WithArgs(database.DefaultTenant, from, to).
+				WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).
+					AddRow(time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC), 3).
+					AddRow(time.Date(2022, 6, 13, 0, 0, 0, 0, time.UTC), 1))
+
+			userRepo := NewMariaDBRepository(dbx)
+			buckets, err := userRepo.CountSignups(context.TODO(), from, to, c.interval)
+
+			assert.NoError(t, err)
+			assert.Equal(t, []*domain.SignupBucket{
+				{Bucket: time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC), Count: 3},
+				{Bucket: time.Date(2022, 6, 13, 0, 0, 0, 0, time.UTC), Count: 1},
+			}, buckets)
+		})
+	}
+
+	t.Run("unknown-interval", func(t *testing.T) {
+		userRepo := NewMariaDBRepository(dbx)
+		_, err := userRepo.CountSignups(context.TODO(), from, to, "year")
+
+		assert.ErrorIs(t, err, domain.ErrStatsInterval)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddWithinQuota(t *testing.T) {
+	user := &domain.User{
+		UUID:     uuid.New(),
+		Name:     "Cyro Dubeux",
+		Email:    "xorycx@gmail.com",
+		Password: "12345678",
+	}
+
+	query := `INSERT INTO 
+	users (uuid, tenant_id, name, email, email_canonical, username, password, role) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	t.Run("under-limit", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+
+		dbx := sqlx.NewDb(db, "sqlmock")
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE tenant_id=? FOR UPDATE")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(query)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT created_at, updated_at FROM users WHERE tenant_id=? AND uuid=?")).
+			WithArgs(database.DefaultTenant, user.UUID).
+			WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+		mock.ExpectCommit()
+
+		userRepo := NewMariaDBRepository(dbx)
+		err = userRepo.AddWithinQuota(context.TODO(), user, 2)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("at-limit", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+
+		dbx := sqlx.NewDb(db, "sqlmock")
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE tenant_id=? FOR UPDATE")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectRollback()
+
+		userRepo := NewMariaDBRepository(dbx)
+		err = userRepo.AddWithinQuota(context.TODO(), user, 2)
+
+		assert.ErrorIs(t, err, domain.ErrQuotaExceeded)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStoreFail(t *testing.T) {
+	user := &domain.User{}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `
+	  INSERT INTO users (
+		uuid,
+		name,
+		email,
+		password,
+		created_at,
+		updated_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs("", "", "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1)) // Using UUID
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Add(context.TODO(), user)
+
+	assert.NotNil(t, err)
+}
+
+func TestUpdate(t *testing.T) {
+	now := time.Now()
+	newUUID := uuid.New()
+	user := &domain.User{
+		UUID:      newUUID,
+		Name:      "Cyro Dubeux",
+		Email:     "xorycx@gmail.com",
+		Password:  "12345678",
+		UpdatedAt: now,
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `
+		UPDATE users
+		SET
+		name=?,
+		email=?,
+		email_canonical=?,
+		username=?,
+		token_version=IF(password = COALESCE(?, password), token_version, token_version + 1),
+		password_changed_at=IF(password = COALESCE(?, password), password_changed_at, CURRENT_TIMESTAMP),
+		password=COALESCE(?, password)
+		WHERE tenant_id=? AND uuid=?
+	`
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs(user.Name, user.Email, domain.CanonicalEmail(user.Email), user.Username, user.Password, user.Password, user.Password, database.DefaultTenant, user.UUID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	createdAt := now.Add(-time.Hour).UTC().Truncate(time.Second)
+	updatedAt := now.UTC().Truncate(time.Second)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT created_at, updated_at FROM users WHERE tenant_id=? AND uuid=?")).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(createdAt, updatedAt))
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Update(context.TODO(), newUUID, user)
+
+	assert.NoError(t, err)
+	assert.Equal(t, createdAt, user.CreatedAt)
+	assert.Equal(t, updatedAt, user.UpdatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateKeepsPassword(t *testing.T) {
+	newUUID := uuid.New()
+	user := &domain.User{
+		Name:  "Cyro Dubeux",
+		Email: "xorycx@gmail.com",
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	// Without a password both the password and the token version are kept.
+	mock.ExpectExec("UPDATE users").
+		WithArgs(user.Name, user.Email, domain.CanonicalEmail(user.Email), user.Username, nil, nil, nil, database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT created_at, updated_at FROM users WHERE tenant_id=? AND uuid=?")).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	assert.NoError(t, userRepo.Update(context.TODO(), newUUID, user))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFail(t *testing.T) {
+	newUUID := uuid.New()
+	user := &domain.User{}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `
+		UPDATE users
+		SET
+		name=?,
+		email=?,
+		email_canonical=?,
+		username=?,
+		token_version=IF(password = COALESCE(?, password), token_version, token_version + 1),
+		password_changed_at=IF(password = COALESCE(?, password), password_changed_at, CURRENT_TIMESTAMP),
+		password=COALESCE(?, password)
+		WHERE tenant_id=? AND uuid=?
+	`
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs("", "", "", "", "", "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Update(context.TODO(), newUUID, user)
+
+	assert.NotNil(t, err)
+}
+
+func TestUpdateRowsAffected(t *testing.T) {
+	now := time.Now()
+	newUUID := uuid.New()
+	user := &domain.User{
+		UUID:      newUUID,
+		Name:      "Cyro Dubeux",
+		Email:     "xorycx@gmail.com",
+		Password:  "12345678",
+		UpdatedAt: now,
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `
+		UPDATE users
+		SET
+		name=?,
+		email=?,
+		email_canonical=?,
+		username=?,
+		token_version=IF(password = COALESCE(?, password), token_version, token_version + 1),
+		password_changed_at=IF(password = COALESCE(?, password), password_changed_at, CURRENT_TIMESTAMP),
+		password=COALESCE(?, password)
+		WHERE tenant_id=? AND uuid=?
+	`
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(
+		user.Name,
+		user.Email,
+		domain.CanonicalEmail(user.Email),
+		user.Username,
+		user.Password,
+		user.UUID,
+	).WillReturnResult(sqlmock.NewResult(1, 0))
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Update(context.TODO(), newUUID, user)
+
+	assert.NotNil(t, err)
+}
+
+func TestUpdateRowsAffectedFail(t *testing.T) {
+	now := time.Now()
+	newUUID := uuid.New()
+	user := &domain.User{
+		UUID:      newUUID,
+		Name:      "Cyro Dubeux",
+		Email:     "xorycx@gmail.com",
+		Password:  "12345678",
+		UpdatedAt: now,
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := `
+		UPDATE users
+		SET
+		name=?,
+		email=?,
+		email_canonical=?,
+		username=?,
+		token_version=IF(password = COALESCE(?, password), token_version, token_version + 1),
+		password_changed_at=IF(password = COALESCE(?, password), password_changed_at, CURRENT_TIMESTAMP),
+		password=COALESCE(?, password)
+		WHERE tenant_id=? AND uuid=?
+	`
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(
+		user.Name,
+		user.Email,
+		domain.CanonicalEmail(user.Email),
+		user.Username,
+		user.Password,
+		user.UUID,
+	).WillReturnResult(sqlmock.NewErrorResult(sql.ErrNoRows))
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Update(context.TODO(), newUUID, user)
+
+	assert.NotNil(t, err)
+}
+
+func TestUpdateFields(t *testing.T) {
+	newUUID := uuid.New()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET token_version=? WHERE tenant_id=? AND uuid=?")).
+		WithArgs(3, database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// The columns are set in their sorted order.
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET avatar_url=?, name=? WHERE tenant_id=? AND uuid=?")).
+		WithArgs(nil, "Cyro Dubeux", database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	assert.NoError(t, userRepo.UpdateFields(context.TODO(), newUUID, map[string]interface{}{"token_version": 3}))
+	assert.ErrorIs(t, userRepo.UpdateFields(context.TODO(), newUUID, map[string]interface{}{"name": "Cyro Dubeux", "avatar_url": nil}), domain.ErrResourceNotFound)
+	assert.NoError(t, userRepo.UpdateFields(context.TODO(), newUUID, nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFieldsUnknownColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	// Nothing is written when any of the columns isn't whitelisted.
+	for _, column := range []string{"password", "tenant_id", "uuid", "name=name, role"} {
+		err := userRepo.UpdateFields(context.TODO(), uuid.New(), map[string]interface{}{"name": "Cyro Dubeux", column: "admin"})
+		assert.ErrorIs(t, err, domain.ErrUnknownColumn)
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDelete(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "DELETE FROM users WHERE tenant_id=\\? AND uuid=\\?"
+
+	// The password history of the user goes in the same transaction.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM password_history WHERE tenant_id=\\? AND user_uuid=\\?").
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(query).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Delete(context.TODO(), newUUID)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteRequestIDComment(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	os.Setenv("SQL_COMMENTS", "true")
+	defer os.Unsetenv("SQL_COMMENTS")
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlDeletePasswordHistory + " /* request_id=checkout-42 */").
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(sqlDelete + " /* request_id=checkout-42 */").
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	ctx := database.WithRequestID(context.TODO(), "checkout-42")
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Delete(ctx, newUUID)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteFailure(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "DELETE FROM users WHERE tenant_id=\\? AND uuid=\\?"
+
+	mock.ExpectExec(query).
+		WithArgs(0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Delete(context.TODO(), newUUID)
+
+	assert.NotNil(t, err)
+}
+
+func TestDeleteRowsAffected(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "DELETE FROM users WHERE tenant_id=\\? AND uuid=\\?"
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM password_history").
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(query).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(1, 0))
+	mock.ExpectRollback()
+
+	userRepo := NewMariaDBRepository(dbx)
+	err = userRepo.Delete(context.TODO(), newUUID)
+
 	assert.NotNil(t, err)
 }
 
@@ -481,14 +1223,507 @@ func TestDeleteRowsAffectedFail(t *testing.T) {
 
 	dbx := sqlx.NewDb(db, "sqlmock")
 
-	query := "DELETE FROM users WHERE uuid=\\?"
+	query := "DELETE FROM users WHERE tenant_id=\\? AND uuid=\\?"
 
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM password_history").
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(query).
-		WithArgs(newUUID).
+		WithArgs(database.DefaultTenant, newUUID).
 		WillReturnResult(sqlmock.NewErrorResult(sql.ErrNoRows))
+	mock.ExpectRollback()
 
 	userRepo := NewMariaDBRepository(dbx)
 	err = userRepo.Delete(context.TODO(), newUUID)
 
 	assert.NotNil(t, err)
 }
+
+func TestExpectAffected(t *testing.T) {
+	cases := []struct {
+		name     string
+		result   sql.Result
+		expected string
+	}{
+		{"zero", sqlmock.NewResult(0, 0), domain.ErrResourceNotFound.Error()},
+		{"one", sqlmock.NewResult(0, 1), ""},
+		{"many", sqlmock.NewResult(0, 3), "expected 1 affected rows, got 3"},
+		{"error", sqlmock.NewErrorResult(sql.ErrConnDone), sql.ErrConnDone.Error()},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := expectAffected(c.result, 1)
+
+			if c.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, c.expected)
+		})
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	after := &domain.ListPosition{CreatedAt: time.Date(2022, 6, 6, 10, 30, 15, 0, time.UTC), UUID: uuid.New()}
+
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{
+			"uuid",
+			"name",
+			"email",
+			"password",
+			"created_at",
+			"updated_at",
+		}).AddRow(uuid.New(), "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now())
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM users WHERE tenant_id=? ORDER BY created_at DESC, uuid DESC LIMIT ?")).
+		WithArgs(database.DefaultTenant, 21).
+		WillReturnRows(rows())
+
+	query := `
+	SELECT * FROM users 
+	WHERE tenant_id=? AND (created_at < ? OR (created_at = ? AND uuid < ?)) 
+	ORDER BY created_at DESC, uuid DESC 
+	LIMIT ?`
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs(database.DefaultTenant, after.CreatedAt, after.CreatedAt, after.UUID, 21).
+		WillReturnRows(rows())
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	users, err := userRepo.FindPage(context.TODO(), nil, 21)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	users, err = userRepo.FindPage(context.TODO(), after, 21)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "Cyro Dubeux", users[0].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchByName(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	rows := sqlmock.NewRows([]string{
+		"uuid",
+		"name",
+		"email",
+		"password",
+		"created_at",
+		"updated_at",
+	}).AddRow(uuid.New(), "Cyro Dubeux", "xorycx@gmail.com", "12345678", time.Now(), time.Now())
+
+	query := regexp.QuoteMeta("SELECT * FROM users WHERE tenant_id=? AND name LIKE CONCAT(?, '%') ORDER BY name LIMIT ?")
+
+	mock.ExpectQuery(query).
+		WithArgs(database.DefaultTenant, "Cy", 10).
+		WillReturnRows(rows)
+	mock.ExpectQuery(query).
+		WithArgs(database.DefaultTenant, `50\%\_`, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	users, err := userRepo.SearchByName(context.TODO(), "Cy", 10)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "Cyro Dubeux", users[0].Name)
+
+	users, err = userRepo.SearchByName(context.TODO(), "50%_", 10)
+	assert.NoError(t, err)
+	assert.NotNil(t, users)
+	assert.Len(t, users, 0)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogoutAll(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "UPDATE users SET token_version=token_version \\+ 1 WHERE tenant_id=\\? AND uuid=\\?"
+
+	mock.ExpectExec(query).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	assert.NoError(t, userRepo.LogoutAll(context.TODO(), newUUID))
+	assert.Equal(t, domain.ErrResourceNotFound, userRepo.LogoutAll(context.TODO(), newUUID))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetAvatar(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "UPDATE users SET avatar_key=\\?, avatar_url=\\? WHERE tenant_id=\\? AND uuid=\\?"
+	key := "avatars/" + newUUID.String() + "/a.png"
+	url := "/uploads/" + key
+
+	mock.ExpectExec(query).
+		WithArgs(key, url, database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).
+		WithArgs(nil, nil, database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	assert.NoError(t, userRepo.SetAvatar(context.TODO(), newUUID, &key, &url))
+	assert.Equal(t, domain.ErrResourceNotFound, userRepo.SetAvatar(context.TODO(), newUUID, nil, nil))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetPassword(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "UPDATE users SET password=\\?, password_changed_at=CURRENT_TIMESTAMP, token_version=token_version \\+ 1 WHERE tenant_id=\\? AND uuid=\\?"
+
+	mock.ExpectExec(query).
+		WithArgs("hash", database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).
+		WithArgs("hash", database.DefaultTenant, newUUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	assert.NoError(t, userRepo.ResetPassword(context.TODO(), newUUID, "hash"))
+	assert.Equal(t, domain.ErrResourceNotFound, userRepo.ResetPassword(context.TODO(), newUUID, "hash"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPasswordHistory(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO password_history (tenant_id, user_uuid, password) VALUES (?, ?, ?)")).
+		WithArgs(database.DefaultTenant, newUUID, "hash").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec("DELETE FROM password_history").
+		WithArgs(database.DefaultTenant, newUUID, database.DefaultTenant, newUUID, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT password FROM password_history WHERE tenant_id=? AND user_uuid=? ORDER BY id DESC LIMIT ?")).
+		WithArgs(database.DefaultTenant, newUUID, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow("hash").AddRow("previous"))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	assert.NoError(t, userRepo.AddPasswordHistory(context.TODO(), newUUID, "hash", 2))
+
+	hashes, err := userRepo.PasswordHistory(context.TODO(), newUUID, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hash", "previous"}, hashes)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTokenVersion(t *testing.T) {
+	newUUID := uuid.New()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	query := "SELECT token_version FROM users WHERE tenant_id=\\? AND uuid=\\?"
+
+	mock.ExpectQuery(query).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(3))
+	mock.ExpectQuery(query).
+		WithArgs(database.DefaultTenant, newUUID).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	version, err := userRepo.TokenVersion(context.TODO(), newUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, version)
+
+	_, err = userRepo.TokenVersion(context.TODO(), newUUID)
+	assert.Equal(t, domain.ErrResourceNotFound, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreparedStatements(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	newUUID := uuid.New()
+	now := time.Now().UTC().Truncate(time.Second)
+	columns := []string{"uuid", "name", "email", "password", "created_at", "updated_at"}
+
+	row := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(newUUID, "Cyro Dubeux", "xorycx@gmail.com", "12345678", now, now)
+	}
+
+	// The first preparation fails and is retried by the next call.
+	mock.ExpectPrepare("SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?").
+		WillReturnError(sql.ErrConnDone)
+
+	findByID := mock.ExpectPrepare("SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?")
+	findByID.ExpectQuery().WithArgs(database.DefaultTenant, newUUID).WillReturnRows(row())
+	findByID.ExpectQuery().WithArgs(database.DefaultTenant, newUUID).WillReturnRows(row())
+	findByID.WillBeClosed()
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?").WithArgs(database.DefaultTenant, newUUID).WillReturnRows(row())
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	_, err = userRepo.FindByID(context.TODO(), newUUID)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+
+	prepared, err := userRepo.FindByID(context.TODO(), newUUID)
+	assert.NoError(t, err)
+
+	again, err := userRepo.FindByID(context.TODO(), newUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, prepared, again)
+
+	// The prepared statement reads the same user as the plain query.
+	var plain domain.User
+	assert.NoError(t, dbx.GetContext(context.TODO(), &plain, sqlFindByID, database.DefaultTenant, newUUID))
+	assert.Equal(t, &plain, prepared)
+
+	assert.NoError(t, userRepo.(io.Closer).Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// BenchmarkFindByID compares the prepared lookup to a plain query on
+// a real database, e.g. the one of docker-compose:
+//
+//	BENCH_DATABASE_URL="$DB_USER:$DB_PASS@tcp(localhost:$DB_PORT)/hexagony?parseTime=true" \
+//	go test -run '^$' -bench FindByID ./app/users/repository/mariadb/
+func BenchmarkFindByID(b *testing.B) {
+	databaseURL := os.Getenv("BENCH_DATABASE_URL")
+	if databaseURL == "" {
+		b.Skip("BENCH_DATABASE_URL is not set")
+	}
+
+	dbx, err := sqlx.Connect("mysql", databaseURL)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer dbx.Close()
+
+	var id uuid.UUID
+	if err := dbx.Get(&id, "SELECT uuid FROM users LIMIT 1"); err != nil {
+		b.Skip("there is no user to look up")
+	}
+
+	ctx := context.Background()
+
+	b.Run("query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var user domain.User
+			if err := dbx.GetContext(ctx, &user, sqlFindByID, database.DefaultTenant, id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("prepared", func(b *testing.B) {
+		userRepo := NewMariaDBRepository(dbx)
+		defer userRepo.(io.Closer).Close()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := userRepo.FindByID(ctx, id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dbx := sqlx.NewDb(db, "sqlmock")
+
+	acme := database.WithTenant(context.TODO(), "acme")
+	globex := database.WithTenant(context.TODO(), "globex")
+
+	id := uuid.New()
+	now := time.Now().UTC().Truncate(time.Second)
+	columns := []string{"uuid", "tenant_id", "name", "email", "created_at", "updated_at"}
+
+	findByID := mock.ExpectPrepare("SELECT \\* FROM users WHERE tenant_id=\\? AND uuid=\\?")
+	findByID.ExpectQuery().WithArgs("acme", id).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "acme", "Cyro Dubeux", "xorycx@gmail.com", now, now))
+	findByID.ExpectQuery().WithArgs("globex", id).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM password_history WHERE tenant_id=\\? AND user_uuid=\\?").
+		WithArgs("globex", id).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM users WHERE tenant_id=\\? AND uuid=\\?").
+		WithArgs("globex", id).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE tenant_id=?")).
+		WithArgs("globex").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	// The same email can sign up in another tenant.
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO")).ExpectExec().
+		WithArgs(sqlmock.AnyArg(), "globex", "Cyro Dubeux", "xorycx@gmail.com", "xorycx@gmail.com", nil, "hash", domain.RoleUser).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare(regexp.QuoteMeta("SELECT created_at, updated_at FROM users WHERE tenant_id=? AND uuid=?")).ExpectQuery().
+		WithArgs("globex", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(now, now))
+
+	userRepo := NewMariaDBRepository(dbx)
+
+	user, err := userRepo.FindByID(acme, id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, user.UUID)
+	assert.Equal(t, "acme", user.TenantID)
+
+	user, err = userRepo.FindByID(globex, id)
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Nil, user.UUID)
+
+	assert.Equal(t, domain.ErrResourceNotFound, userRepo.Delete(globex, id))
+
+	count, err := userRepo.Count(globex)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	added := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "hash", Role: domain.RoleUser}
+	assert.NoError(t, userRepo.Add(globex, added))
+	assert.Equal(t, "globex", added.TenantID)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantDatabases(t *testing.T) {
+	sharedDB, sharedMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer sharedDB.Close()
+
+	acmeDB, acmeMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer acmeDB.Close()
+
+	shared := sqlx.NewDb(sharedDB, "sqlmock")
+
+	resolver := database.NewTenantResolver(shared, "")
+	resolver.Register("acme", sqlx.NewDb(acmeDB, "sqlmock"))
+
+	acme := database.WithTenant(context.TODO(), "acme")
+	globex := database.WithTenant(context.TODO(), "globex")
+
+	acmeMock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE tenant_id=?")).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	acmeMock.ExpectQuery("SELECT token_version FROM users").
+		WithArgs("acme", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(2))
+
+	sharedMock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE tenant_id=?")).
+		WithArgs("globex").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	userRepo := NewMariaDBTenantRepository(resolver, shared, nil)
+
+	count, err := userRepo.Count(acme)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	version, err := userRepo.TokenVersion(acme, uuid.New())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	count, err = userRepo.Count(globex)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	assert.NoError(t, acmeMock.ExpectationsWereMet())
+	assert.NoError(t, sharedMock.ExpectationsWereMet())
+}
@@ -0,0 +1,65 @@
+package mariadb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type statementKey struct {
+	db    *sqlx.DB
+	query string
+}
+
+// statements holds the hot queries prepared on each pool, so the
+// server parses them once rather than on every call. They're prepared
+// on first use, and a failed preparation is retried on the next call.
+// A sqlx.Stmt is safe for concurrent use, the database/sql pool
+// re-preparing it on the connections that don't have it yet.
+type statements struct {
+	mu       sync.Mutex
+	prepared map[statementKey]*sqlx.Stmt
+}
+
+func newStatements() *statements {
+	return &statements{prepared: map[statementKey]*sqlx.Stmt{}}
+}
+
+// get returns the statement for the query on the pool, preparing it
+// if needed.
+func (s *statements) get(ctx context.Context, db *sqlx.DB, query string) (*sqlx.Stmt, error) {
+	key := statementKey{db, query}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stmt, ok := s.prepared[key]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.prepared[key] = stmt
+
+	return stmt, nil
+}
+
+// close closes the prepared statements, returning the first error.
+func (s *statements) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var first error
+	for key, stmt := range s.prepared {
+		if err := stmt.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(s.prepared, key)
+	}
+
+	return first
+}
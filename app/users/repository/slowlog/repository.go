@@ -0,0 +1,135 @@
+package slowlog
+
+import (
+	"context"
+	"hexagony/app/users/domain"
+	"hexagony/lib/database"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type slowlogRepository struct {
+	next domain.UserRepository
+}
+
+// NewSlowlogRepository wraps the users repository, logging the calls
+// slower than SLOW_QUERY_MS by name, e.g. users.FindByID. It closes the
+// wrapped repository when that one is an io.Closer.
+func NewSlowlogRepository(next domain.UserRepository) domain.UserRepository {
+	return &slowlogRepository{next}
+}
+
+func (r *slowlogRepository) FindAll(ctx context.Context) ([]*domain.User, error) {
+	defer database.LogSlowQuery("users.FindAll", time.Now())
+	return r.next.FindAll(ctx)
+}
+
+// FindAllStream isn't timed, its duration depends on how fast the
+// client reads the rows.
+func (r *slowlogRepository) FindAllStream(ctx context.Context, fn func(*domain.User) error) error {
+	return r.next.FindAllStream(ctx, fn)
+}
+
+func (r *slowlogRepository) FindByID(ctx context.Context, uuid uuid.UUID) (*domain.User, error) {
+	defer database.LogSlowQuery("users.FindByID", time.Now())
+	return r.next.FindByID(ctx, uuid)
+}
+
+func (r *slowlogRepository) FindByIDs(ctx context.Context, uuids []uuid.UUID) ([]*domain.User, error) {
+	defer database.LogSlowQuery("users.FindByIDs", time.Now())
+	return r.next.FindByIDs(ctx, uuids)
+}
+
+func (r *slowlogRepository) FindPage(ctx context.Context, after *domain.ListPosition, limit int) ([]*domain.User, error) {
+	defer database.LogSlowQuery("users.FindPage", time.Now())
+	return r.next.FindPage(ctx, after, limit)
+}
+
+func (r *slowlogRepository) SearchByName(ctx context.Context, prefix string, limit int) ([]*domain.User, error) {
+	defer database.LogSlowQuery("users.SearchByName", time.Now())
+	return r.next.SearchByName(ctx, prefix, limit)
+}
+
+func (r *slowlogRepository) Count(ctx context.Context) (int, error) {
+	defer database.LogSlowQuery("users.Count", time.Now())
+	return r.next.Count(ctx)
+}
+
+func (r *slowlogRepository) CountSignups(ctx context.Context, from, to time.Time, interval string) ([]*domain.SignupBucket, error) {
+	defer database.LogSlowQuery("users.CountSignups", time.Now())
+	return r.next.CountSignups(ctx, from, to, interval)
+}
+
+func (r *slowlogRepository) ListVersion(ctx context.Context) (*domain.ListVersion, error) {
+	defer database.LogSlowQuery("users.ListVersion", time.Now())
+	return r.next.ListVersion(ctx)
+}
+
+func (r *slowlogRepository) Add(ctx context.Context, user *domain.User) error {
+	defer database.LogSlowQuery("users.Add", time.Now())
+	return r.next.Add(ctx, user)
+}
+
+func (r *slowlogRepository) AddWithinQuota(ctx context.Context, user *domain.User, quota int) error {
+	defer database.LogSlowQuery("users.AddWithinQuota", time.Now())
+	return r.next.AddWithinQuota(ctx, user, quota)
+}
+
+func (r *slowlogRepository) Update(ctx context.Context, uuid uuid.UUID, user *domain.User) error {
+	defer database.LogSlowQuery("users.Update", time.Now())
+	return r.next.Update(ctx, uuid, user)
+}
+
+func (r *slowlogRepository) UpdateFields(ctx context.Context, uuid uuid.UUID, fields map[string]interface{}) error {
+	defer database.LogSlowQuery("users.UpdateFields", time.Now())
+	return r.next.UpdateFields(ctx, uuid, fields)
+}
+
+func (r *slowlogRepository) Delete(ctx context.Context, uuid uuid.UUID) error {
+	defer database.LogSlowQuery("users.Delete", time.Now())
+	return r.next.Delete(ctx, uuid)
+}
+
+func (r *slowlogRepository) LogoutAll(ctx context.Context, uuid uuid.UUID) error {
+	defer database.LogSlowQuery("users.LogoutAll", time.Now())
+	return r.next.LogoutAll(ctx, uuid)
+}
+
+func (r *slowlogRepository) ResetPassword(ctx context.Context, uuid uuid.UUID, password string) error {
+	defer database.LogSlowQuery("users.ResetPassword", time.Now())
+	return r.next.ResetPassword(ctx, uuid, password)
+}
+
+func (r *slowlogRepository) PasswordHistory(ctx context.Context, uuid uuid.UUID, limit int) ([]string, error) {
+	defer database.LogSlowQuery("users.PasswordHistory", time.Now())
+	return r.next.PasswordHistory(ctx, uuid, limit)
+}
+
+func (r *slowlogRepository) AddPasswordHistory(ctx context.Context, uuid uuid.UUID, password string, keep int) error {
+	defer database.LogSlowQuery("users.AddPasswordHistory", time.Now())
+	return r.next.AddPasswordHistory(ctx, uuid, password, keep)
+}
+
+func (r *slowlogRepository) SetAvatar(ctx context.Context, uuid uuid.UUID, key, url *string) error {
+	defer database.LogSlowQuery("users.SetAvatar", time.Now())
+	return r.next.SetAvatar(ctx, uuid, key, url)
+}
+
+func (r *slowlogRepository) TokenVersion(ctx context.Context, uuid uuid.UUID) (int, error) {
+	defer database.LogSlowQuery("users.TokenVersion", time.Now())
+	return r.next.TokenVersion(ctx, uuid)
+}
+
+func (r *slowlogRepository) HealthCheck(ctx context.Context) error {
+	defer database.LogSlowQuery("users.HealthCheck", time.Now())
+	return r.next.HealthCheck(ctx)
+}
+
+func (r *slowlogRepository) Close() error {
+	if closer, ok := r.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package slowlog
+
+import (
+	"bytes"
+	"context"
+	"hexagony/app/users/domain"
+	"hexagony/app/users/domain/mocks"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSlowQuery(t *testing.T) {
+	os.Setenv("SLOW_QUERY_MS", "10")
+	defer os.Unsetenv("SLOW_QUERY_MS")
+
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = logger }()
+
+	mockUser := &domain.User{UUID: uuid.New(), Name: "Jane Doe", Email: "jane@doe.com"}
+
+	t.Run("slow", func(t *testing.T) {
+		buf.Reset()
+		mockRepo := new(mocks.UserRepository)
+		mockRepo.On("FindByID", mock.Anything, mockUser.UUID).
+			Run(func(mock.Arguments) { time.Sleep(20 * time.Millisecond) }).
+			Return(mockUser, nil).Once()
+
+		user, err := NewSlowlogRepository(mockRepo).FindByID(context.TODO(), mockUser.UUID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, mockUser, user)
+		assert.Contains(t, buf.String(), `"level":"warn"`)
+		assert.Contains(t, buf.String(), "slow query: users.FindByID took")
+		assert.NotContains(t, buf.String(), mockUser.UUID.String())
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("fast", func(t *testing.T) {
+		buf.Reset()
+		mockRepo := new(mocks.UserRepository)
+		mockRepo.On("FindByID", mock.Anything, mockUser.UUID).Return(mockUser, nil).Once()
+
+		_, err := NewSlowlogRepository(mockRepo).FindByID(context.TODO(), mockUser.UUID)
+
+		assert.NoError(t, err)
+		assert.Empty(t, buf.String())
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		os.Setenv("SLOW_QUERY_MS", "0")
+		defer os.Setenv("SLOW_QUERY_MS", "10")
+
+		buf.Reset()
+		mockRepo := new(mocks.UserRepository)
+		mockRepo.On("FindByID", mock.Anything, mockUser.UUID).
+			Run(func(mock.Arguments) { time.Sleep(20 * time.Millisecond) }).
+			Return(mockUser, nil).Once()
+
+		_, err := NewSlowlogRepository(mockRepo).FindByID(context.TODO(), mockUser.UUID)
+
+		assert.NoError(t, err)
+		assert.Empty(t, buf.String())
+		mockRepo.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,203 @@
+package usecase
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hexagony/app/users/domain"
+	"hexagony/lib/clog"
+	"hexagony/lib/crypto"
+	"hexagony/lib/database"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultPasswordMinLength is the shortest password when
+// PASSWORD_MIN_LENGTH is unset.
+const defaultPasswordMinLength = 8
+
+// bcryptMaxLength is how many bytes of a password bcrypt hashes, it
+// ignores the others.
+const bcryptMaxLength = 72
+
+// PasswordPolicyFunc lets a function be a domain.PasswordPolicy.
+type PasswordPolicyFunc func(ctx context.Context, plaintext string, user *domain.User) error
+
+// Validate calls f.
+func (f PasswordPolicyFunc) Validate(ctx context.Context, plaintext string, user *domain.User) error {
+	return f(ctx, plaintext, user)
+}
+
+// Policies composes the policies, which the password must all pass. They
+// run in order and the first rejection is returned, so the cheap checks
+// go before the ones reading the database.
+func Policies(policies ...domain.PasswordPolicy) domain.PasswordPolicy {
+	return PasswordPolicyFunc(func(ctx context.Context, plaintext string, user *domain.User) error {
+		for _, policy := range policies {
+			if err := policy.Validate(ctx, plaintext, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PasswordPolicyFromEnv is the policy configured by the environment:
+//
+//   - PASSWORD_MIN_LENGTH characters at least, 8 by default
+//   - PASSWORD_MIN_CLASSES of lowercase, uppercase, digits and symbols,
+//     none by default
+//   - none of the passwords of PASSWORD_BREACHED_FILE, one per line
+//   - at most 72 bytes without PASSWORD_PEPPER, bcrypt ignoring the rest
+//   - none of the PASSWORD_HISTORY latest passwords of the user
+func PasswordPolicyFromEnv(ur domain.UserRepository) domain.PasswordPolicy {
+	minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	if err != nil || minLength < 1 {
+		minLength = defaultPasswordMinLength
+	}
+
+	minClasses, _ := strconv.Atoi(os.Getenv("PASSWORD_MIN_CLASSES"))
+
+	var breached []string
+	if path := os.Getenv("PASSWORD_BREACHED_FILE"); path != "" {
+		if breached, err = readPasswords(path); err != nil {
+			clog.Error(err, "failed to read PASSWORD_BREACHED_FILE, breached passwords aren't checked")
+		}
+	}
+
+	return Policies(
+		MinLength(minLength),
+		Complexity(minClasses),
+		NotTruncated(os.Getenv("PASSWORD_PEPPER") != ""),
+		NotBreached(breached),
+		NotReused(ur, passwordHistory()),
+	)
+}
+
+// readPasswords reads a password per line, the blank lines skipped.
+func readPasswords(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var passwords []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.TrimRight(scanner.Text(), "\r"); password != "" {
+			passwords = append(passwords, password)
+		}
+	}
+
+	return passwords, scanner.Err()
+}
+
+// invalidPassword is the rejection of a policy.
+func invalidPassword(format string, args ...interface{}) error {
+	return &domain.ValidationError{Field: "password", Message: fmt.Sprintf(format, args...)}
+}
+
+// MinLength rejects the passwords shorter than n characters.
+func MinLength(n int) domain.PasswordPolicy {
+	return PasswordPolicyFunc(func(ctx context.Context, plaintext string, user *domain.User) error {
+		if utf8.RuneCountInString(plaintext) < n {
+			return invalidPassword("must be at least %d characters", n)
+		}
+		return nil
+	})
+}
+
+// Complexity requires characters of n classes out of lowercase letters,
+// uppercase letters, digits and symbols. Zero lets any password pass.
+func Complexity(n int) domain.PasswordPolicy {
+	return PasswordPolicyFunc(func(ctx context.Context, plaintext string, user *domain.User) error {
+		var lower, upper, digit, symbol int
+		for _, r := range plaintext {
+			switch {
+			case unicode.IsLower(r):
+				lower = 1
+			case unicode.IsUpper(r):
+				upper = 1
+			case unicode.IsDigit(r):
+				digit = 1
+			default:
+				symbol = 1
+			}
+		}
+
+		if lower+upper+digit+symbol < n {
+			return invalidPassword("must mix %d of lowercase letters, uppercase letters, digits and symbols", n)
+		}
+		return nil
+	})
+}
+
+// NotTruncated rejects the passwords longer than bcrypt hashes, unless
+// they are peppered first, which shortens them, see crypto.New.
+func NotTruncated(peppered bool) domain.PasswordPolicy {
+	return PasswordPolicyFunc(func(ctx context.Context, plaintext string, user *domain.User) error {
+		if !peppered && len(plaintext) > bcryptMaxLength {
+			return invalidPassword("must be at most %d bytes", bcryptMaxLength)
+		}
+		return nil
+	})
+}
+
+// NotBreached rejects the passwords known from breaches, compared as
+// they are.
+func NotBreached(passwords []string) domain.PasswordPolicy {
+	breached := make(map[string]bool, len(passwords))
+	for _, password := range passwords {
+		breached[password] = true
+	}
+
+	return PasswordPolicyFunc(func(ctx context.Context, plaintext string, user *domain.User) error {
+		if breached[plaintext] {
+			return invalidPassword("is known from a data breach, choose another one")
+		}
+		return nil
+	})
+}
+
+// NotReused returns domain.ErrPasswordReused when the password matches
+// the current one of the user or one of its history latest, the current
+// one covering the users without history yet. The users being created,
+// without uuid, have neither. Zero lets any password pass.
+func NotReused(ur domain.UserRepository, history int) domain.PasswordPolicy {
+	return PasswordPolicyFunc(func(ctx context.Context, plaintext string, user *domain.User) error {
+		if history == 0 || user == nil || user.UUID == [16]byte{} {
+			return nil
+		}
+
+		ctx = database.WithPrimaryRead(ctx)
+
+		hashes, err := ur.PasswordHistory(ctx, user.UUID, history)
+		if err != nil {
+			return err
+		}
+
+		// A missing user is reported by the reset.
+		current, err := ur.FindByID(ctx, user.UUID)
+		if err != nil {
+			return err
+		}
+		if current != nil && current.UUID == user.UUID && current.Password != "" {
+			hashes = append(hashes, current.Password)
+		}
+
+		bcrypt := crypto.New()
+
+		for _, hash := range hashes {
+			if bcrypt.CheckPasswordHash(plaintext, hash) {
+				return domain.ErrPasswordReused
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"hexagony/app/users/domain"
+	"hexagony/app/users/domain/mocks"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// rejection returns the message of the *domain.ValidationError, empty
+// when the password passes.
+func rejection(t *testing.T, err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var invalid *domain.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	assert.Equal(t, "password", invalid.Field)
+	return invalid.Message
+}
+
+func TestPolicies(t *testing.T) {
+	lenient := Policies(MinLength(8))
+	strict := Policies(MinLength(12), Complexity(3), NotBreached([]string{"Passw0rd!Passw0rd!"}))
+
+	for password, tc := range map[string]struct {
+		lenient string
+		strict  string
+	}{
+		"short":              {"must be at least 8 characters", "must be at least 12 characters"},
+		"lowercase-only":     {"", "must mix 3 of lowercase letters, uppercase letters, digits and symbols"},
+		"Correct-Horse-1":    {"", ""},
+		"Passw0rd!Passw0rd!": {"", "is known from a data breach, choose another one"},
+		"çàéèùïöêâôûñ":       {"", "must mix 3 of lowercase letters, uppercase letters, digits and symbols"},
+	} {
+		t.Run(password, func(t *testing.T) {
+			assert.Equal(t, tc.lenient, rejection(t, lenient.Validate(context.TODO(), password, &domain.User{})))
+			assert.Equal(t, tc.strict, rejection(t, strict.Validate(context.TODO(), password, &domain.User{})))
+		})
+	}
+}
+
+func TestPoliciesFirstRejection(t *testing.T) {
+	called := false
+	last := PasswordPolicyFunc(func(ctx context.Context, plaintext string, user *domain.User) error {
+		called = true
+		return nil
+	})
+
+	err := Policies(MinLength(8), last).Validate(context.TODO(), "short", nil)
+
+	assert.Equal(t, "must be at least 8 characters", rejection(t, err))
+	assert.False(t, called)
+}
+
+func TestNotTruncated(t *testing.T) {
+	long := strings.Repeat("a", bcryptMaxLength+1)
+
+	assert.Equal(t, "must be at most 72 bytes", rejection(t, NotTruncated(false).Validate(context.TODO(), long, nil)))
+	assert.NoError(t, NotTruncated(false).Validate(context.TODO(), long[1:], nil))
+	assert.NoError(t, NotTruncated(true).Validate(context.TODO(), long, nil))
+}
+
+func TestNotReusedNewUser(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+
+	assert.NoError(t, NotReused(mockUserRepo, 5).Validate(context.TODO(), "passw0rd", &domain.User{}))
+	mockUserRepo.AssertNotCalled(t, "PasswordHistory", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPasswordPolicyFromEnv(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(breached, []byte("hunter2hunter2\r\n\nletmein123\n"), 0o600))
+
+	t.Setenv("PASSWORD_MIN_LENGTH", "10")
+	t.Setenv("PASSWORD_MIN_CLASSES", "2")
+	t.Setenv("PASSWORD_BREACHED_FILE", breached)
+	t.Setenv("PASSWORD_HISTORY", "0")
+
+	policy := PasswordPolicyFromEnv(new(mocks.UserRepository))
+
+	for password, expected := range map[string]string{
+		"short1":                       "must be at least 10 characters",
+		"alllowercase":                 "must mix 2 of lowercase letters, uppercase letters, digits and symbols",
+		"hunter2hunter2":               "is known from a data breach, choose another one",
+		"letmein123":                   "is known from a data breach, choose another one",
+		strings.Repeat("a1", 40):       "must be at most 72 bytes",
+		"correct-horse-battery-staple": "",
+	} {
+		assert.Equal(t, expected, rejection(t, policy.Validate(context.TODO(), password, &domain.User{UUID: uuid.New()})), password)
+	}
+}
+
+func TestPasswordPolicyFromEnvDefaults(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "invalid")
+
+	policy := PasswordPolicyFromEnv(new(mocks.UserRepository))
+
+	assert.Equal(t, "must be at least 8 characters", rejection(t, policy.Validate(context.TODO(), "1234567", &domain.User{})))
+	assert.NoError(t, policy.Validate(context.TODO(), "12345678", &domain.User{}))
+}
+
+func TestValidatePassword(t *testing.T) {
+	user := &domain.User{UUID: uuid.New()}
+
+	var validated *domain.User
+	policy := PasswordPolicyFunc(func(ctx context.Context, plaintext string, u *domain.User) error {
+		validated = u
+		if plaintext == "rejected" {
+			return &domain.ValidationError{Field: "password", Message: "is rejected"}
+		}
+		return nil
+	})
+
+	u := NewUserUseCaseWithPolicy(new(mocks.UserRepository), nil, policy)
+
+	assert.NoError(t, u.ValidatePassword(context.TODO(), "accepted", user))
+	assert.Same(t, user, validated)
+	assert.Equal(t, "is rejected", rejection(t, u.ValidatePassword(context.TODO(), "rejected", user)))
+}
@@ -2,52 +2,547 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"hexagony/app/users/domain"
+	"hexagony/lib/cache"
+	"hexagony/lib/clog"
+	"hexagony/lib/database"
+	"hexagony/lib/email"
+	"hexagony/lib/storage"
+	"hexagony/lib/token"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultPasswordHistory is how many of the latest passwords can't
+// be reused when PASSWORD_HISTORY is unset.
+const defaultPasswordHistory = 5
+
+// defaultTokenVersionTTL is how long a token version is cached when
+// TOKEN_VERSION_CACHE_TTL is unset.
+const defaultTokenVersionTTL = 5 * time.Second
+
+const (
+	// mxTimeout bounds the MX lookup of a signup.
+	mxTimeout = 2 * time.Second
+	// mxTTL is how long the MX answer of a domain is cached.
+	mxTTL = time.Hour
 )
 
 type userUseCase struct {
 	userRepository domain.UserRepository
+	findByID       singleflight.Group
+	tokenVersions  *cache.Cache
+	lists          *cache.Cache
+	mx             *email.MXChecker
+	blocklist      *email.Blocklist
+	blobs          storage.BlobStorage
+	history        int
+	policy         domain.PasswordPolicy
+}
+
+// NewUserUseCase stores the avatars in bs and checks the passwords with
+// the policy of PasswordPolicyFromEnv.
+func NewUserUseCase(ur domain.UserRepository, bs storage.BlobStorage) domain.UserUseCase {
+	return NewUserUseCaseWithPolicy(ur, bs, PasswordPolicyFromEnv(ur))
+}
+
+// NewUserUseCaseWithPolicy checks the passwords with policy.
+func NewUserUseCaseWithPolicy(ur domain.UserRepository, bs storage.BlobStorage, policy domain.PasswordPolicy) domain.UserUseCase {
+	return &userUseCase{
+		userRepository: ur,
+		blobs:          bs,
+		tokenVersions:  cache.New(tokenVersionTTL()),
+		lists:          cache.New(usersListTTL()),
+		mx:             email.NewMXChecker(net.DefaultResolver, mxTimeout, mxTTL),
+		blocklist:      loadBlocklist(),
+		history:        passwordHistory(),
+		policy:         policy,
+	}
+}
+
+// loadBlocklist blocks the domains listed in EMAIL_BLOCKLIST_FILE, one
+// per line, or the embedded disposable domains when unset, plus the
+// comma separated EMAIL_BLOCKLIST. The comma separated addresses of
+// EMAIL_ALLOWLIST are let through anyway.
+func loadBlocklist() *email.Blocklist {
+	domains := email.DisposableDomains()
+
+	if path := os.Getenv("EMAIL_BLOCKLIST_FILE"); path != "" {
+		if list, err := readList(path); err != nil {
+			clog.Error(err, "failed to read EMAIL_BLOCKLIST_FILE, using the default blocklist")
+		} else {
+			domains = list
+		}
+	}
+
+	return email.NewBlocklist(
+		append(domains, splitList(os.Getenv("EMAIL_BLOCKLIST"))...),
+		splitList(os.Getenv("EMAIL_ALLOWLIST")),
+	)
 }
 
-func NewUserUseCase(ur domain.UserRepository) domain.UserUseCase {
-	return &userUseCase{userRepository: ur}
+func readList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return email.ParseList(file)
 }
 
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// tokenVersionTTL reads TOKEN_VERSION_CACHE_TTL, e.g. "10s". Zero
+// disables the cache.
+func tokenVersionTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("TOKEN_VERSION_CACHE_TTL"))
+	if err != nil || ttl < 0 {
+		return defaultTokenVersionTTL
+	}
+	return ttl
+}
+
+// usersListTTL reads USERS_LIST_CACHE_TTL, the lists aren't cached
+// when it's unset or 0.
+func usersListTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("USERS_LIST_CACHE_TTL"))
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// passwordHistory reads PASSWORD_HISTORY, 0 allowing any password.
+func passwordHistory() int {
+	n, err := strconv.Atoi(os.Getenv("PASSWORD_HISTORY"))
+	if err != nil || n < 0 {
+		return defaultPasswordHistory
+	}
+	return n
+}
+
+// cacheKey keys the user in the caches and the shared lookups, within
+// the tenant of the context so no result crosses tenants.
+func cacheKey(ctx context.Context, uuid uuid.UUID) string {
+	return database.Tenant(ctx) + "/" + uuid.String()
+}
+
+// cachedList is a cached list of users along with the version of the
+// table it was read at.
+type cachedList struct {
+	version domain.ListVersion
+	users   []*domain.User
+}
+
+// FindAll caches the list of the tenant for USERS_LIST_CACHE_TTL. The
+// cache is per instance, so the cached list is only served while the
+// version of the table, its latest update to the microsecond and its
+// number of users, is the one it was read at: the changes made through
+// the other instances are seen at once too, even within the second of
+// the cached one, for the price of the cheap version query. The
+// users are shared between the callers, which must not modify them.
 func (u *userUseCase) FindAll(ctx context.Context) ([]*domain.User, error) {
-	user, err := u.userRepository.FindAll(ctx)
+	if !u.lists.Enabled() {
+		return u.userRepository.FindAll(ctx)
+	}
+
+	key := database.Tenant(ctx)
+
+	// Read before the list, a change in between makes the list newer
+	// than its version and only costs a miss on the next call.
+	version, err := u.userRepository.ListVersion(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+
+	if cached, ok := u.lists.Get(key); ok {
+		list := cached.(*cachedList)
+		if list.version.Count == version.Count && list.version.LastModified.Equal(version.LastModified) {
+			return append([]*domain.User(nil), list.users...), nil
+		}
+	}
+
+	users, err := u.userRepository.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	u.lists.Set(key, &cachedList{version: *version, users: append([]*domain.User(nil), users...)})
+
+	return users, nil
 }
 
+// changed drops what's cached about the user, and the list of the
+// tenant, after a mutation.
+func (u *userUseCase) changed(ctx context.Context, uuid uuid.UUID) {
+	u.tokenVersions.Delete(cacheKey(ctx, uuid))
+	u.lists.Delete(database.Tenant(ctx))
+}
+
+func (u *userUseCase) FindAllStream(ctx context.Context, fn func(user *domain.User) error) error {
+	if err := u.userRepository.FindAllStream(ctx, fn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FindByID shares a single repository lookup between concurrent calls
+// for the same user, so a hot user doesn't stampede the database. The
+// lookups asking for the primary don't join the others, and the shared
+// one runs detached from the cancellation of the caller that started
+// it, so a caller going away doesn't fail the others. Each caller still
+// stops waiting when its own context is done.
 func (u *userUseCase) FindByID(ctx context.Context, uuid uuid.UUID) (*domain.User, error) {
-	user, err := u.userRepository.FindByID(ctx, uuid)
+	key := cacheKey(ctx, uuid)
+	if database.PrimaryRead(ctx) {
+		key += "/primary"
+	}
+
+	lookup := u.findByID.DoChan(key, func() (interface{}, error) {
+		return u.userRepository.FindByID(detachedContext{ctx}, uuid)
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-lookup:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+
+	user, _ := result.Val.(*domain.User)
+	if user == nil {
+		return nil, nil
+	}
+
+	// Every caller gets its own copy of the shared result.
+	shared := *user
+	return &shared, nil
+}
+
+// detachedContext keeps the values of its parent, the tenant and the
+// primary read hint among them, without its deadline and cancellation.
+type detachedContext struct{ context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// FindByIDs returns the users found in the order they were requested,
+// along with the requested ids that don't exist.
+func (u *userUseCase) FindByIDs(
+	ctx context.Context,
+	uuids []uuid.UUID,
+) ([]*domain.User, []uuid.UUID, error) {
+	users, err := u.userRepository.FindByIDs(ctx, uuids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byID := make(map[uuid.UUID]*domain.User, len(users))
+	for _, user := range users {
+		byID[user.UUID] = user
+	}
+
+	found := make([]*domain.User, 0, len(users))
+	notFound := make([]uuid.UUID, 0)
+
+	for _, id := range uuids {
+		if user, ok := byID[id]; ok {
+			found = append(found, user)
+			continue
+		}
+		notFound = append(notFound, id)
+	}
+
+	return found, notFound, nil
+}
+
+// FindPage isn't cached, unlike FindAll.
+func (u *userUseCase) FindPage(ctx context.Context, after *domain.ListPosition, limit int) ([]*domain.User, error) {
+	users, err := u.userRepository.FindPage(ctx, after, limit)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	return users, nil
+}
+
+func (u *userUseCase) SearchByName(ctx context.Context, prefix string, limit int) ([]*domain.User, error) {
+	users, err := u.userRepository.SearchByName(ctx, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (u *userUseCase) Count(ctx context.Context) (int, error) {
+	count, err := u.userRepository.Count(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
+// ListVersion returns when the users last changed, the zero time when
+// there is none, and how many there are.
+func (u *userUseCase) ListVersion(ctx context.Context) (*domain.ListVersion, error) {
+	return u.userRepository.ListVersion(ctx)
+}
+
+// maxSignupsRange bounds the signup stats, so a single request can't
+// aggregate the whole table day by day.
+const maxSignupsRange = 5 * 366 * 24 * time.Hour
+
+// CountSignups counts the users created from from (inclusive) to to
+// (exclusive), bucketed by day, week or month.
+func (u *userUseCase) CountSignups(
+	ctx context.Context,
+	from, to time.Time,
+	interval string,
+) ([]*domain.SignupBucket, error) {
+	switch interval {
+	case domain.IntervalDay, domain.IntervalWeek, domain.IntervalMonth:
+	default:
+		return nil, domain.ErrStatsInterval
+	}
+
+	if from.IsZero() || to.IsZero() || !from.Before(to) || to.Sub(from) > maxSignupsRange {
+		return nil, domain.ErrStatsRange
+	}
+
+	return u.userRepository.CountSignups(ctx, from, to, interval)
+}
+
+// Add inserts the user, respecting MAX_USERS when it is set. A user
+// breaking the invariants of domain.User is rejected first, one
+// without role gets domain.RoleUser. The blocked email domains are
+// rejected too, and the domain must have MX records when
+// VALIDATE_EMAIL_MX is enabled.
 func (u *userUseCase) Add(ctx context.Context, user *domain.User) error {
-	if err := u.userRepository.Add(ctx, user); err != nil {
+	if err := user.ValidateNew(); err != nil {
+		return err
+	}
+
+	if user.Role == "" {
+		user.Role = domain.RoleUser
+	}
+
+	if err := u.blocklist.Check(user.Email); err != nil {
+		return domain.ErrEmailDomainBlocked
+	}
+
+	if os.Getenv("VALIDATE_EMAIL_MX") == "true" {
+		if err := u.mx.Check(ctx, user.Email); err != nil {
+			return domain.ErrEmailNoMX
+		}
+	}
+
+	maxUsers, _ := strconv.Atoi(os.Getenv("MAX_USERS"))
+
+	add := u.userRepository.Add
+	if maxUsers > 0 {
+		add = func(ctx context.Context, user *domain.User) error {
+			return u.userRepository.AddWithinQuota(ctx, user, maxUsers)
+		}
+	}
+
+	if err := add(ctx, user); err != nil {
 		return err
 	}
+	u.changed(ctx, user.UUID)
 	return nil
 }
 
+// Update validates the user and rejects a new email of a blocked
+// domain, users who signed up before their domain was blocked can still
+// update the other fields. It bumps the token version when the
+// password changes, so the cached version is dropped either way.
 func (u *userUseCase) Update(ctx context.Context, uuid uuid.UUID, user *domain.User) error {
+	if err := user.Validate(); err != nil {
+		return err
+	}
+
+	if err := u.blocklist.Check(user.Email); err != nil {
+		current, err := u.userRepository.FindByID(database.WithPrimaryRead(ctx), uuid)
+		if err != nil {
+			return err
+		}
+		if current == nil || domain.CanonicalEmail(current.Email) != domain.CanonicalEmail(user.Email) {
+			return domain.ErrEmailDomainBlocked
+		}
+	}
+
 	if err := u.userRepository.Update(ctx, uuid, user); err != nil {
 		return err
 	}
+	u.changed(ctx, uuid)
 	return nil
 }
 
+// Delete removes the user with what refers to it: the repository
+// deletes the password history in the same transaction, then the
+// cached token version and the avatar go.
 func (u *userUseCase) Delete(ctx context.Context, uuid uuid.UUID) error {
+	// The avatar is looked up first, the row is gone after.
+	current, err := u.userRepository.FindByID(database.WithPrimaryRead(ctx), uuid)
+	if err != nil {
+		return err
+	}
+
 	if err := u.userRepository.Delete(ctx, uuid); err != nil {
 		return err
 	}
+	u.changed(ctx, uuid)
+
+	if current != nil && current.AvatarKey != nil && u.blobs != nil {
+		u.deleteAvatar(ctx, *current.AvatarKey)
+	}
 	return nil
 }
+
+func (u *userUseCase) LogoutAll(ctx context.Context, uuid uuid.UUID) error {
+	if err := u.userRepository.LogoutAll(ctx, uuid); err != nil {
+		return err
+	}
+	u.changed(ctx, uuid)
+	return nil
+}
+
+// ResetPassword sets the password hash, logging the user out
+// everywhere, without asking for the current one.
+func (u *userUseCase) ResetPassword(ctx context.Context, uuid uuid.UUID, password string) error {
+	if err := u.userRepository.ResetPassword(ctx, uuid, password); err != nil {
+		return err
+	}
+	u.changed(ctx, uuid)
+
+	// The password is set, a history that fails to record only lets
+	// it be reused.
+	if u.history > 0 {
+		if err := u.userRepository.AddPasswordHistory(ctx, uuid, password, u.history); err != nil {
+			clog.Error(err, "failed to record the password history of user "+uuid.String())
+		}
+	}
+	return nil
+}
+
+// CheckPasswordReuse returns ErrPasswordReused when the plain password
+// matches the current one or one of the latest in the history of the
+// user, the current one covering the users without history yet.
+func (u *userUseCase) CheckPasswordReuse(ctx context.Context, uuid uuid.UUID, password string) error {
+	return NotReused(u.userRepository, u.history).Validate(ctx, password, &domain.User{UUID: uuid})
+}
+
+// ValidatePassword checks the password against the policy.
+func (u *userUseCase) ValidatePassword(ctx context.Context, password string, user *domain.User) error {
+	return u.policy.Validate(ctx, password, user)
+}
+
+// avatarExtensions names the avatar blobs by content type.
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// SetAvatar stores the image under a new key, so the caches of the
+// previous URL don't serve the old avatar, then points the user to it
+// and deletes the previous one. The content type must be one of
+// avatarExtensions, the handler checks it against the image itself.
+func (u *userUseCase) SetAvatar(
+	ctx context.Context,
+	uuid uuid.UUID,
+	image io.Reader,
+	contentType string,
+) (*domain.User, error) {
+	extension, ok := avatarExtensions[contentType]
+	if !ok {
+		return nil, domain.ErrAvatarType
+	}
+
+	user, err := u.userRepository.FindByID(database.WithPrimaryRead(ctx), uuid)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, domain.ErrResourceNotFound
+	}
+
+	key := "avatars/" + database.Tenant(ctx) + "/" + uuid.String() + "/" + newAvatarID() + extension
+
+	if err := u.blobs.Put(ctx, key, image, contentType); err != nil {
+		return nil, err
+	}
+
+	url := u.blobs.URL(key)
+
+	if err := u.userRepository.SetAvatar(ctx, uuid, &key, &url); err != nil {
+		u.deleteAvatar(ctx, key)
+		return nil, err
+	}
+	u.changed(ctx, uuid)
+
+	if user.AvatarKey != nil {
+		u.deleteAvatar(ctx, *user.AvatarKey)
+	}
+
+	user.AvatarKey = &key
+	user.AvatarURL = &url
+
+	return user, nil
+}
+
+// newAvatarID names an avatar blob. It's a variable for the tests.
+var newAvatarID = func() string {
+	return uuid.NewString()
+}
+
+// deleteAvatar only logs a failure, the user already points to the
+// right avatar and an orphan blob is harmless.
+func (u *userUseCase) deleteAvatar(ctx context.Context, key string) {
+	if err := u.blobs.Delete(ctx, key); err != nil {
+		clog.Error(err, "failed to delete the avatar "+key)
+	}
+}
+
+// TokenVersion is checked on every authenticated request, so it's
+// cached for TOKEN_VERSION_CACHE_TTL. A bump on another instance is
+// only seen once the entry expires, one on this instance right away.
+func (u *userUseCase) TokenVersion(ctx context.Context, uuid uuid.UUID) (int, error) {
+	if version, ok := u.tokenVersions.Get(cacheKey(ctx, uuid)); ok {
+		return version.(int), nil
+	}
+
+	// The tokens of a deleted user are revoked with it.
+	version, err := u.userRepository.TokenVersion(ctx, uuid)
+	if errors.Is(err, domain.ErrResourceNotFound) {
+		return 0, token.ErrRevoked
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	u.tokenVersions.Set(cacheKey(ctx, uuid), version)
+
+	return version, nil
+}
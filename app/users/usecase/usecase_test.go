@@ -5,6 +5,16 @@ import (
 	"errors"
 	"hexagony/app/users/domain"
 	"hexagony/app/users/domain/mocks"
+	"hexagony/lib/crypto"
+	"hexagony/lib/database"
+	"hexagony/lib/email"
+	"hexagony/lib/storage"
+	"hexagony/lib/token"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,10 +39,10 @@ func TestFindAll(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockUserRepo.On("FindAll",
-			mock.AnythingOfType("*context.emptyCtx")).
+			mock.Anything).
 			Return(mockListUsers, nil).Once()
 
-		a := NewUserUseCase(mockUserRepo)
+		a := NewUserUseCase(mockUserRepo, nil)
 		list, err := a.FindAll(context.TODO())
 
 		assert.Equal(t, "Cyro Dubeux", list[0].Name)
@@ -44,10 +54,10 @@ func TestFindAll(t *testing.T) {
 
 	t.Run("error-failed", func(t *testing.T) {
 		mockUserRepo.On("FindAll",
-			mock.AnythingOfType("*context.emptyCtx")).
+			mock.Anything).
 			Return(nil, errors.New("Unexpected error")).Once()
 
-		a := NewUserUseCase(mockUserRepo)
+		a := NewUserUseCase(mockUserRepo, nil)
 		_, err := a.FindAll(context.TODO())
 
 		assert.NotNil(t, err)
@@ -56,6 +66,202 @@ func TestFindAll(t *testing.T) {
 	})
 }
 
+func TestFindAllCache(t *testing.T) {
+	t.Setenv("USERS_LIST_CACHE_TTL", "1m")
+
+	users := []*domain.User{{UUID: uuid.New(), Name: "Cyro Dubeux", Email: "xorycx@gmail.com"}}
+	version := &domain.ListVersion{LastModified: time.Date(2022, 6, 6, 10, 30, 15, 0, time.UTC), Count: 1}
+
+	t.Run("hit", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ListVersion", mock.Anything).Return(version, nil)
+		mockUserRepo.On("FindAll", mock.Anything).Return(users, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		for i := 0; i < 3; i++ {
+			list, err := u.FindAll(context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, users, list)
+		}
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("mutation", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ListVersion", mock.Anything).Return(version, nil)
+		mockUserRepo.On("FindAll", mock.Anything).Return(users, nil).Twice()
+		mockUserRepo.On("LogoutAll", mock.Anything, users[0].UUID).Return(nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		_, err := u.FindAll(context.TODO())
+		assert.NoError(t, err)
+
+		assert.NoError(t, u.LogoutAll(context.TODO(), users[0].UUID))
+
+		_, err = u.FindAll(context.TODO())
+		assert.NoError(t, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("failed mutation", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ListVersion", mock.Anything).Return(version, nil)
+		mockUserRepo.On("FindAll", mock.Anything).Return(users, nil).Once()
+		mockUserRepo.On("LogoutAll", mock.Anything, users[0].UUID).Return(errors.New("failed")).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		_, err := u.FindAll(context.TODO())
+		assert.NoError(t, err)
+
+		assert.Error(t, u.LogoutAll(context.TODO(), users[0].UUID))
+
+		_, err = u.FindAll(context.TODO())
+		assert.NoError(t, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("other instance", func(t *testing.T) {
+		updated := []*domain.User{{UUID: users[0].UUID, Name: "Cyro", Email: "xorycx@gmail.com"}}
+
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ListVersion", mock.Anything).Return(version, nil).Twice()
+		mockUserRepo.On("FindAll", mock.Anything).Return(users, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		for i := 0; i < 2; i++ {
+			list, err := u.FindAll(context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, users, list)
+		}
+
+		// Another instance updated the user.
+		mockUserRepo.On("ListVersion", mock.Anything).Return(&domain.ListVersion{LastModified: version.LastModified.Add(time.Millisecond), Count: 1}, nil).Once()
+		mockUserRepo.On("FindAll", mock.Anything).Return(updated, nil).Once()
+
+		list, err := u.FindAll(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, updated, list)
+
+		// And another one deleted a user.
+		mockUserRepo.On("ListVersion", mock.Anything).Return(&domain.ListVersion{LastModified: version.LastModified.Add(time.Millisecond)}, nil).Once()
+		mockUserRepo.On("FindAll", mock.Anything).Return([]*domain.User{}, nil).Once()
+
+		list, err = u.FindAll(context.TODO())
+		assert.NoError(t, err)
+		assert.Empty(t, list)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("same second", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ListVersion", mock.Anything).Return(version, nil).Once()
+		mockUserRepo.On("FindAll", mock.Anything).Return(users, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		_, err := u.FindAll(context.TODO())
+		assert.NoError(t, err)
+
+		// Another instance updated a user a few microseconds later.
+		mockUserRepo.On("ListVersion", mock.Anything).Return(&domain.ListVersion{LastModified: version.LastModified.Add(5 * time.Microsecond), Count: 1}, nil).Once()
+		mockUserRepo.On("FindAll", mock.Anything).Return([]*domain.User{}, nil).Once()
+
+		list, err := u.FindAll(context.TODO())
+		assert.NoError(t, err)
+		assert.Empty(t, list)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("version error", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ListVersion", mock.Anything).Return(nil, errors.New("failed")).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		_, err := u.FindAll(context.TODO())
+		assert.Error(t, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		other := []*domain.User{{UUID: uuid.New(), Name: "John Doe", Email: "john@doe.com"}}
+
+		acme := database.WithTenant(context.TODO(), "acme")
+
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ListVersion", mock.Anything).Return(version, nil)
+		mockUserRepo.On("FindAll", mock.MatchedBy(func(ctx context.Context) bool {
+			return database.Tenant(ctx) != "acme"
+		})).Return(users, nil).Once()
+		mockUserRepo.On("FindAll", mock.MatchedBy(func(ctx context.Context) bool {
+			return database.Tenant(ctx) == "acme"
+		})).Return(other, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		for i := 0; i < 2; i++ {
+			list, err := u.FindAll(context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, users, list)
+
+			list, err = u.FindAll(acme)
+			assert.NoError(t, err)
+			assert.Equal(t, other, list)
+		}
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("USERS_LIST_CACHE_TTL", "")
+
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("FindAll", mock.Anything).Return(users, nil).Twice()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		for i := 0; i < 2; i++ {
+			_, err := u.FindAll(context.TODO())
+			assert.NoError(t, err)
+		}
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestFindAllStream(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+	fn := func(user *domain.User) error { return nil }
+
+	t.Run("success", func(t *testing.T) {
+		mockUserRepo.On("FindAllStream",
+			mock.Anything,
+			mock.Anything).
+			Return(nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+		err := u.FindAllStream(context.TODO(), fn)
+
+		assert.NoError(t, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		mockUserRepo.On("FindAllStream",
+			mock.Anything,
+			mock.Anything).
+			Return(errors.New("Unexpected error")).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+		err := u.FindAllStream(context.TODO(), fn)
+
+		assert.NotNil(t, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
 func TestFindByID(t *testing.T) {
 	newUUID := uuid.New()
 	mockUserRepo := new(mocks.UserRepository)
@@ -70,11 +276,11 @@ func TestFindByID(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockUserRepo.On("FindByID",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(mockUser, nil).Once()
 
-		a := NewUserUseCase(mockUserRepo)
+		a := NewUserUseCase(mockUserRepo, nil)
 		user, err := a.FindByID(context.TODO(), newUUID)
 
 		assert.Equal(t, "Cyro Dubeux", user.Name)
@@ -84,11 +290,11 @@ func TestFindByID(t *testing.T) {
 
 	t.Run("failure", func(t *testing.T) {
 		mockUserRepo.On("FindByID",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(nil, errors.New("Unexpected error")).Once()
 
-		a := NewUserUseCase(mockUserRepo)
+		a := NewUserUseCase(mockUserRepo, nil)
 		_, err := a.FindByID(context.TODO(), newUUID)
 
 		assert.NotNil(t, err)
@@ -97,6 +303,269 @@ func TestFindByID(t *testing.T) {
 	})
 }
 
+func TestFindByIDSingleflight(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserRepo := new(mocks.UserRepository)
+	mockUser := &domain.User{
+		UUID: newUUID,
+		Name: "Cyro Dubeux",
+	}
+
+	release := make(chan time.Time)
+
+	mockUserRepo.On("FindByID",
+		mock.Anything,
+		newUUID).
+		WaitUntil(release).
+		Return(mockUser, nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+
+	const lookups = 10
+
+	var wg sync.WaitGroup
+	users := make([]*domain.User, lookups)
+
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user, err := u.FindByID(context.TODO(), newUUID)
+			assert.NoError(t, err)
+			users[i] = user
+		}(i)
+	}
+
+	// Gives every lookup the time to join the one in flight.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mockUserRepo.AssertNumberOfCalls(t, "FindByID", 1)
+
+	for _, user := range users {
+		assert.Equal(t, "Cyro Dubeux", user.Name)
+	}
+
+	// Errors aren't cached, the next lookup hits the repository again.
+	mockUserRepo.On("FindByID",
+		mock.Anything,
+		newUUID).
+		Return(nil, errors.New("Unexpected error")).Once()
+
+	_, err := u.FindByID(context.TODO(), newUUID)
+	assert.Error(t, err)
+
+	mockUserRepo.On("FindByID",
+		mock.Anything,
+		newUUID).
+		Return(mockUser, nil).Once()
+
+	user, err := u.FindByID(context.TODO(), newUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Cyro Dubeux", user.Name)
+
+	mockUserRepo.AssertNumberOfCalls(t, "FindByID", 3)
+}
+
+func TestFindByIDSingleflightContext(t *testing.T) {
+	newUUID := uuid.New()
+	mockUser := &domain.User{
+		UUID: newUUID,
+		Name: "Cyro Dubeux",
+	}
+
+	t.Run("canceled first caller", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		release := make(chan time.Time)
+
+		mockUserRepo.On("FindByID",
+			mock.MatchedBy(func(ctx context.Context) bool {
+				return ctx.Err() == nil && database.Tenant(ctx) == "acme"
+			}),
+			newUUID).
+			WaitUntil(release).
+			Return(mockUser, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		ctx, cancel := context.WithCancel(database.WithTenant(context.TODO(), "acme"))
+
+		first := make(chan error, 1)
+		go func() {
+			_, err := u.FindByID(ctx, newUUID)
+			first <- err
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+
+		second := make(chan *domain.User, 1)
+		go func() {
+			user, err := u.FindByID(database.WithTenant(context.TODO(), "acme"), newUUID)
+			assert.NoError(t, err)
+			second <- user
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		assert.ErrorIs(t, <-first, context.Canceled)
+
+		close(release)
+		assert.Equal(t, "Cyro Dubeux", (<-second).Name)
+
+		mockUserRepo.AssertNumberOfCalls(t, "FindByID", 1)
+	})
+
+	t.Run("primary read", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		release := make(chan time.Time)
+
+		mockUserRepo.On("FindByID",
+			mock.MatchedBy(func(ctx context.Context) bool { return !database.PrimaryRead(ctx) }),
+			newUUID).
+			WaitUntil(release).
+			Return(mockUser, nil).Once()
+		mockUserRepo.On("FindByID",
+			mock.MatchedBy(database.PrimaryRead),
+			newUUID).
+			Return(mockUser, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		replica := make(chan struct{})
+		go func() {
+			_, err := u.FindByID(context.TODO(), newUUID)
+			assert.NoError(t, err)
+			close(replica)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+
+		// Doesn't wait for the replica lookup in flight.
+		user, err := u.FindByID(database.WithPrimaryRead(context.TODO()), newUUID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Cyro Dubeux", user.Name)
+
+		close(release)
+		<-replica
+
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestFindByIDs(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+
+	first := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux"}
+	second := &domain.User{UUID: uuid.New(), Name: "John Doe"}
+	missing := uuid.New()
+
+	requested := []uuid.UUID{second.UUID, missing, first.UUID}
+
+	t.Run("success", func(t *testing.T) {
+		mockUserRepo.On("FindByIDs",
+			mock.Anything,
+			requested).
+			Return([]*domain.User{first, second}, nil).Once()
+
+		a := NewUserUseCase(mockUserRepo, nil)
+		users, notFound, err := a.FindByIDs(context.TODO(), requested)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []*domain.User{second, first}, users)
+		assert.Equal(t, []uuid.UUID{missing}, notFound)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("error-failed", func(t *testing.T) {
+		mockUserRepo.On("FindByIDs",
+			mock.Anything,
+			requested).
+			Return(nil, errors.New("Unexpected error")).Once()
+
+		a := NewUserUseCase(mockUserRepo, nil)
+		_, _, err := a.FindByIDs(context.TODO(), requested)
+
+		assert.NotNil(t, err)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestFindPage(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+	mockUser := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux"}
+	after := &domain.ListPosition{CreatedAt: time.Now(), UUID: uuid.New()}
+
+	t.Run("success", func(t *testing.T) {
+		mockUserRepo.On("FindPage",
+			mock.Anything,
+			after,
+			21).
+			Return([]*domain.User{mockUser}, nil).Once()
+
+		a := NewUserUseCase(mockUserRepo, nil)
+		users, err := a.FindPage(context.TODO(), after, 21)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []*domain.User{mockUser}, users)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("error-failed", func(t *testing.T) {
+		mockUserRepo.On("FindPage",
+			mock.Anything,
+			after,
+			21).
+			Return(nil, errors.New("Unexpected error")).Once()
+
+		a := NewUserUseCase(mockUserRepo, nil)
+		_, err := a.FindPage(context.TODO(), after, 21)
+
+		assert.NotNil(t, err)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestSearchByName(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+	mockUser := &domain.User{UUID: uuid.New(), Name: "Cyro Dubeux"}
+
+	t.Run("success", func(t *testing.T) {
+		mockUserRepo.On("SearchByName",
+			mock.Anything,
+			"Cy",
+			10).
+			Return([]*domain.User{mockUser}, nil).Once()
+
+		a := NewUserUseCase(mockUserRepo, nil)
+		users, err := a.SearchByName(context.TODO(), "Cy", 10)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []*domain.User{mockUser}, users)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("error-failed", func(t *testing.T) {
+		mockUserRepo.On("SearchByName",
+			mock.Anything,
+			"Cy",
+			10).
+			Return(nil, errors.New("Unexpected error")).Once()
+
+		a := NewUserUseCase(mockUserRepo, nil)
+		_, err := a.SearchByName(context.TODO(), "Cy", 10)
+
+		assert.NotNil(t, err)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
 func TestAdd(t *testing.T) {
 	mockUserRepo := new(mocks.UserRepository)
 	mockUser := &domain.User{
@@ -110,11 +579,11 @@ func TestAdd(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockUserRepo.On("Add",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("*domain.User")).
 			Return(nil).Once()
 
-		u := NewUserUseCase(mockUserRepo)
+		u := NewUserUseCase(mockUserRepo, nil)
 		err := u.Add(context.TODO(), mockUser)
 
 		assert.NoError(t, err)
@@ -123,11 +592,11 @@ func TestAdd(t *testing.T) {
 
 	t.Run("failure", func(t *testing.T) {
 		mockUserRepo.On("Add",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("*domain.User")).
 			Return(errors.New("Unexpected error")).Once()
 
-		u := NewUserUseCase(mockUserRepo)
+		u := NewUserUseCase(mockUserRepo, nil)
 		err := u.Add(context.TODO(), mockUser)
 
 		assert.NotNil(t, err)
@@ -136,6 +605,258 @@ func TestAdd(t *testing.T) {
 	})
 }
 
+// fakeResolver knows the MX records of gmail.com only, or fails with err.
+type fakeResolver struct {
+	err error
+}
+
+func (f fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if name != "gmail.com" {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return []*net.MX{{Host: "gmail-smtp-in.l.google.com.", Pref: 5}}, nil
+}
+
+func TestAddMX(t *testing.T) {
+	os.Setenv("VALIDATE_EMAIL_MX", "true")
+	defer os.Unsetenv("VALIDATE_EMAIL_MX")
+
+	for name, tc := range map[string]struct {
+		email    string
+		resolver fakeResolver
+		err      error
+	}{
+		"valid":          {email: "xorycx@gmail.com"},
+		"no-mx":          {email: "xorycx@gmial.com", err: domain.ErrEmailNoMX},
+		"resolver-error": {email: "xorycx@gmial.com", resolver: fakeResolver{err: errors.New("i/o timeout")}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserRepo := new(mocks.UserRepository)
+			if tc.err == nil {
+				mockUserRepo.On("Add", mock.Anything, mock.AnythingOfType("*domain.User")).Return(nil).Once()
+			}
+
+			u := NewUserUseCase(mockUserRepo, nil).(*userUseCase)
+			u.mx = email.NewMXChecker(tc.resolver, time.Second, time.Minute)
+
+			err := u.Add(context.TODO(), &domain.User{Name: "Cyro Dubeux", Email: tc.email, Password: "hash"})
+
+			assert.Equal(t, tc.err, err)
+			mockUserRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAddBlocklist(t *testing.T) {
+	os.Setenv("EMAIL_BLOCKLIST", "spam.test")
+	os.Setenv("EMAIL_ALLOWLIST", "qa@mailinator.com")
+	defer os.Unsetenv("EMAIL_BLOCKLIST")
+	defer os.Unsetenv("EMAIL_ALLOWLIST")
+
+	for name, tc := range map[string]struct {
+		email string
+		err   error
+	}{
+		"blocked":   {email: "xorycx@Mailinator.com", err: domain.ErrEmailDomainBlocked},
+		"env":       {email: "xorycx@spam.test", err: domain.ErrEmailDomainBlocked},
+		"subdomain": {email: "xorycx@eu.spam.test", err: domain.ErrEmailDomainBlocked},
+		"allowed":   {email: "xorycx@gmail.com"},
+		"allowlist": {email: "QA@mailinator.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserRepo := new(mocks.UserRepository)
+			if tc.err == nil {
+				mockUserRepo.On("Add", mock.Anything, mock.AnythingOfType("*domain.User")).Return(nil).Once()
+			}
+
+			u := NewUserUseCase(mockUserRepo, nil)
+			err := u.Add(context.TODO(), &domain.User{Name: "Cyro Dubeux", Email: tc.email, Password: "hash"})
+
+			assert.Equal(t, tc.err, err)
+			mockUserRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateBlocklist(t *testing.T) {
+	userUUID := uuid.New()
+
+	t.Run("changed", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("FindByID", mock.Anything, userUUID).
+			Return(&domain.User{UUID: userUUID, Email: "xorycx@gmail.com"}, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+		err := u.Update(context.TODO(), userUUID, &domain.User{Name: "Cyro", Email: "xorycx@yopmail.com"})
+
+		assert.Equal(t, domain.ErrEmailDomainBlocked, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("FindByID", mock.Anything, userUUID).
+			Return(&domain.User{UUID: userUUID, Email: "xorycx@yopmail.com"}, nil).Once()
+		mockUserRepo.On("Update", mock.Anything, userUUID, mock.AnythingOfType("*domain.User")).
+			Return(nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+		err := u.Update(context.TODO(), userUUID, &domain.User{Name: "Cyro", Email: "XoryCX@yopmail.com"})
+
+		assert.NoError(t, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestAddQuota(t *testing.T) {
+	os.Setenv("MAX_USERS", "2")
+	defer os.Unsetenv("MAX_USERS")
+
+	mockUserRepo := new(mocks.UserRepository)
+	mockUser := &domain.User{
+		UUID:     uuid.New(),
+		Name:     "Cyro Dubeux",
+		Email:    "xorycx@gmailcom",
+		Password: "12345678",
+	}
+
+	t.Run("under-limit", func(t *testing.T) {
+		mockUserRepo.On("AddWithinQuota",
+			mock.Anything,
+			mock.AnythingOfType("*domain.User"),
+			2).
+			Return(nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+		err := u.Add(context.TODO(), mockUser)
+
+		assert.NoError(t, err)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("at-limit", func(t *testing.T) {
+		mockUserRepo.On("AddWithinQuota",
+			mock.Anything,
+			mock.AnythingOfType("*domain.User"),
+			2).
+			Return(domain.ErrQuotaExceeded).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+		err := u.Add(context.TODO(), mockUser)
+
+		assert.ErrorIs(t, err, domain.ErrQuotaExceeded)
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestAddDefaultRole(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+	mockUserRepo.On("Add", mock.Anything, mock.MatchedBy(func(user *domain.User) bool {
+		return user.Role == domain.RoleUser
+	})).Return(nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+	err := u.Add(context.TODO(), &domain.User{Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "hash"})
+
+	assert.NoError(t, err)
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestInvalidUser(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+	u := NewUserUseCase(mockUserRepo, nil)
+
+	var invalid *domain.ValidationError
+
+	err := u.Add(context.TODO(), &domain.User{Name: "Cyro Dubeux", Email: "xorycx@gmail.com"})
+	if assert.ErrorAs(t, err, &invalid) {
+		assert.Equal(t, "password", invalid.Field)
+	}
+
+	err = u.Update(context.TODO(), uuid.New(), &domain.User{Name: "", Email: "xorycx@gmail.com"})
+	if assert.ErrorAs(t, err, &invalid) {
+		assert.Equal(t, "name", invalid.Field)
+	}
+
+	mockUserRepo.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+	mockUserRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCount(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+
+	mockUserRepo.On("Count", mock.Anything).Return(3, nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+	count, err := u.Count(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestListVersion(t *testing.T) {
+	mockUserRepo := new(mocks.UserRepository)
+	version := &domain.ListVersion{LastModified: time.Date(2022, 6, 6, 10, 30, 0, 0, time.UTC), Count: 2}
+
+	mockUserRepo.On("ListVersion", mock.Anything).Return(version, nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+	got, err := u.ListVersion(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Equal(t, version, got)
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestCountSignups(t *testing.T) {
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("success", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		buckets := []*domain.SignupBucket{{Bucket: from, Count: 4}}
+
+		mockUserRepo.On("CountSignups", mock.Anything, from, to, domain.IntervalWeek).Return(buckets, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+		result, err := u.CountSignups(context.TODO(), from, to, domain.IntervalWeek)
+
+		assert.NoError(t, err)
+		assert.Equal(t, buckets, result)
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	cases := []struct {
+		name     string
+		from     time.Time
+		to       time.Time
+		interval string
+		err      error
+	}{
+		{"unknown-interval", from, to, "year", domain.ErrStatsInterval},
+		{"missing-from", time.Time{}, to, domain.IntervalDay, domain.ErrStatsRange},
+		{"missing-to", from, time.Time{}, domain.IntervalDay, domain.ErrStatsRange},
+		{"reversed", to, from, domain.IntervalDay, domain.ErrStatsRange},
+		{"too-long", from, from.AddDate(10, 0, 0), domain.IntervalMonth, domain.ErrStatsRange},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockUserRepo := new(mocks.UserRepository)
+
+			u := NewUserUseCase(mockUserRepo, nil)
+			_, err := u.CountSignups(context.TODO(), c.from, c.to, c.interval)
+
+			assert.ErrorIs(t, err, c.err)
+			mockUserRepo.AssertNotCalled(t, "CountSignups", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	newUUID := uuid.New()
 	mockUserRepo := new(mocks.UserRepository)
@@ -148,12 +869,12 @@ func TestUpdate(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		mockUserRepo.On("Update",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID"),
 			mock.Anything).
 			Return(nil).Once()
 
-		a := NewUserUseCase(mockUserRepo)
+		a := NewUserUseCase(mockUserRepo, nil)
 		err := a.Update(context.TODO(), newUUID, mockUser)
 
 		assert.NoError(t, err)
@@ -162,12 +883,12 @@ func TestUpdate(t *testing.T) {
 
 	t.Run("failure", func(t *testing.T) {
 		mockUserRepo.On("Update",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID"),
 			mock.Anything).
 			Return(errors.New("Unexpected error")).Once()
 
-		a := NewUserUseCase(mockUserRepo)
+		a := NewUserUseCase(mockUserRepo, nil)
 		err := a.Update(context.TODO(), newUUID, mockUser)
 
 		assert.NotNil(t, err)
@@ -181,12 +902,13 @@ func TestDelete(t *testing.T) {
 	mockUserRepo := new(mocks.UserRepository)
 
 	t.Run("success", func(t *testing.T) {
+		mockUserRepo.On("FindByID", mock.Anything, newUUID).Return(&domain.User{UUID: newUUID}, nil).Once()
 		mockUserRepo.On("Delete",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(nil).Once()
 
-		u := NewUserUseCase(mockUserRepo)
+		u := NewUserUseCase(mockUserRepo, nil)
 		err := u.Delete(context.TODO(), newUUID)
 
 		assert.NoError(t, err)
@@ -194,12 +916,13 @@ func TestDelete(t *testing.T) {
 	})
 
 	t.Run("failure", func(t *testing.T) {
+		mockUserRepo.On("FindByID", mock.Anything, newUUID).Return(&domain.User{UUID: newUUID}, nil).Once()
 		mockUserRepo.On("Delete",
-			mock.AnythingOfType("*context.emptyCtx"),
+			mock.Anything,
 			mock.AnythingOfType("uuid.UUID")).
 			Return(errors.New("Unexpected error")).Once()
 
-		a := NewUserUseCase(mockUserRepo)
+		a := NewUserUseCase(mockUserRepo, nil)
 		err := a.Delete(context.TODO(), newUUID)
 
 		assert.NotNil(t, err)
@@ -207,3 +930,297 @@ func TestDelete(t *testing.T) {
 		mockUserRepo.AssertExpectations(t)
 	})
 }
+
+func TestDeleteCascades(t *testing.T) {
+	newUUID := uuid.New()
+	key := "avatars/default/" + newUUID.String() + "/a.png"
+
+	mockUserRepo := new(mocks.UserRepository)
+	mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(2, nil).Once()
+	mockUserRepo.On("FindByID", mock.Anything, newUUID).Return(&domain.User{UUID: newUUID, AvatarKey: &key}, nil).Once()
+	mockUserRepo.On("Delete", mock.Anything, newUUID).Return(nil).Once()
+	mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(0, domain.ErrResourceNotFound).Once()
+
+	blobs := &fakeStorage{blobs: map[string]string{key: "image/png:avatar"}}
+	u := NewUserUseCase(mockUserRepo, blobs)
+
+	_, err := u.TokenVersion(context.TODO(), newUUID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, u.Delete(context.TODO(), newUUID))
+
+	// The avatar is deleted, and so are the tokens, the cached
+	// version dropped.
+	assert.Empty(t, blobs.blobs)
+
+	_, err = u.TokenVersion(context.TODO(), newUUID)
+	assert.Equal(t, token.ErrRevoked, err)
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestLogoutAll(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserRepo := new(mocks.UserRepository)
+
+	mockUserRepo.On("LogoutAll", mock.Anything, newUUID).Return(nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+
+	assert.NoError(t, u.LogoutAll(context.TODO(), newUUID))
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestResetPassword(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserRepo := new(mocks.UserRepository)
+
+	mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(2, nil).Once()
+	mockUserRepo.On("ResetPassword", mock.Anything, newUUID, "hash").Return(nil).Once()
+	mockUserRepo.On("AddPasswordHistory", mock.Anything, newUUID, "hash", defaultPasswordHistory).Return(nil).Once()
+	mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(3, nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+
+	_, err := u.TokenVersion(context.TODO(), newUUID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, u.ResetPassword(context.TODO(), newUUID, "hash"))
+
+	// The cached version is dropped, the bumped one is read.
+	version, err := u.TokenVersion(context.TODO(), newUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, version)
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestCheckPasswordReuse(t *testing.T) {
+	newUUID := uuid.New()
+
+	hash := func(password string) string {
+		hashed, err := crypto.New().HashPassword(password, 4)
+		assert.NoError(t, err)
+		return hashed
+	}
+
+	history := []string{hash("0ld-passw0rd"), hash("older-passw0rd")}
+	current := &domain.User{UUID: newUUID, Password: hash("current-passw0rd")}
+
+	for name, tc := range map[string]struct {
+		password string
+		expected error
+	}{
+		"in history": {"older-passw0rd", domain.ErrPasswordReused},
+		"current":    {"current-passw0rd", domain.ErrPasswordReused},
+		"fresh":      {"fresh-passw0rd", nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockUserRepo := new(mocks.UserRepository)
+			mockUserRepo.On("PasswordHistory", mock.Anything, newUUID, defaultPasswordHistory).Return(history, nil).Once()
+			mockUserRepo.On("FindByID", mock.Anything, newUUID).Return(current, nil).Once()
+
+			u := NewUserUseCase(mockUserRepo, nil)
+
+			assert.Equal(t, tc.expected, u.CheckPasswordReuse(context.TODO(), newUUID, tc.password))
+			mockUserRepo.AssertExpectations(t)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("PASSWORD_HISTORY", "0")
+
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("ResetPassword", mock.Anything, newUUID, "hash").Return(nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		assert.NoError(t, u.CheckPasswordReuse(context.TODO(), newUUID, "current-passw0rd"))
+		assert.NoError(t, u.ResetPassword(context.TODO(), newUUID, "hash"))
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestTokenVersion(t *testing.T) {
+	newUUID := uuid.New()
+	mockUserRepo := new(mocks.UserRepository)
+
+	mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(2, nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+
+	for i := 0; i < 3; i++ {
+		version, err := u.TokenVersion(context.TODO(), newUUID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+	}
+
+	// The lookups after the first one are served by the cache.
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestTokenVersionInvalidated(t *testing.T) {
+	newUUID := uuid.New()
+
+	t.Run("logout-all", func(t *testing.T) {
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(2, nil).Once()
+		mockUserRepo.On("LogoutAll", mock.Anything, newUUID).Return(nil).Once()
+		mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(3, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		version, _ := u.TokenVersion(context.TODO(), newUUID)
+		assert.Equal(t, 2, version)
+
+		assert.NoError(t, u.LogoutAll(context.TODO(), newUUID))
+
+		version, _ = u.TokenVersion(context.TODO(), newUUID)
+		assert.Equal(t, 3, version)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		user := &domain.User{Name: "Cyro Dubeux", Email: "xorycx@gmail.com", Password: "new-password"}
+
+		mockUserRepo := new(mocks.UserRepository)
+		mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(2, nil).Once()
+		mockUserRepo.On("Update", mock.Anything, newUUID, user).Return(nil).Once()
+		mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(3, nil).Once()
+
+		u := NewUserUseCase(mockUserRepo, nil)
+
+		version, _ := u.TokenVersion(context.TODO(), newUUID)
+		assert.Equal(t, 2, version)
+
+		assert.NoError(t, u.Update(context.TODO(), newUUID, user))
+
+		version, _ = u.TokenVersion(context.TODO(), newUUID)
+		assert.Equal(t, 3, version)
+
+		mockUserRepo.AssertExpectations(t)
+	})
+}
+
+func TestTokenVersionCacheDisabled(t *testing.T) {
+	os.Setenv("TOKEN_VERSION_CACHE_TTL", "0")
+	defer os.Unsetenv("TOKEN_VERSION_CACHE_TTL")
+
+	newUUID := uuid.New()
+	mockUserRepo := new(mocks.UserRepository)
+
+	mockUserRepo.On("TokenVersion", mock.Anything, newUUID).Return(2, nil).Twice()
+
+	u := NewUserUseCase(mockUserRepo, nil)
+
+	_, err := u.TokenVersion(context.TODO(), newUUID)
+	assert.NoError(t, err)
+	_, err = u.TokenVersion(context.TODO(), newUUID)
+	assert.NoError(t, err)
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+// fakeStorage keeps the blobs in memory.
+type fakeStorage struct {
+	blobs map[string]string
+	err   error
+}
+
+func (f *fakeStorage) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	f.blobs[key] = contentType + ":" + string(content)
+	return nil
+}
+
+func (f *fakeStorage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	blob, ok := f.blobs[key]
+	if !ok {
+		return nil, "", storage.ErrNotFound
+	}
+
+	contentType, content, _ := strings.Cut(blob, ":")
+	return io.NopCloser(strings.NewReader(content)), contentType, nil
+}
+
+func (f *fakeStorage) Delete(ctx context.Context, key string) error {
+	delete(f.blobs, key)
+	return nil
+}
+
+func (f *fakeStorage) URL(key string) string {
+	return "https://cdn.example.com/" + key
+}
+
+func TestSetAvatar(t *testing.T) {
+	id := uuid.MustParse("7d31461a-6ed5-425e-96fe-fa98e56d6828")
+	oldKey := "avatars/default/" + id.String() + "/old.png"
+	oldURL := "https://cdn.example.com/" + oldKey
+
+	newAvatarID = func() string { return "new" }
+	defer func() { newAvatarID = func() string { return uuid.NewString() } }()
+
+	blobs := &fakeStorage{blobs: map[string]string{oldKey: "image/png:old"}}
+
+	key := "avatars/default/" + id.String() + "/new.jpg"
+	url := "https://cdn.example.com/" + key
+
+	mockUserRepo := new(mocks.UserRepository)
+	mockUserRepo.On("FindByID", mock.Anything, id).
+		Return(&domain.User{UUID: id, Name: "John Doe", AvatarKey: &oldKey, AvatarURL: &oldURL}, nil).Once()
+	mockUserRepo.On("SetAvatar", mock.Anything, id, &key, &url).Return(nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, blobs)
+	user, err := u.SetAvatar(context.TODO(), id, strings.NewReader("jpeg"), "image/jpeg")
+
+	assert.NoError(t, err)
+	assert.Equal(t, url, *user.AvatarURL)
+	assert.Equal(t, map[string]string{key: "image/jpeg:jpeg"}, blobs.blobs)
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestSetAvatarFailure(t *testing.T) {
+	id := uuid.New()
+
+	mockUserRepo := new(mocks.UserRepository)
+	mockUserRepo.On("FindByID", mock.Anything, id).Return(&domain.User{UUID: id}, nil).Twice()
+	mockUserRepo.On("SetAvatar", mock.Anything, id, mock.Anything, mock.Anything).Return(errors.New("error")).Once()
+
+	blobs := &fakeStorage{blobs: map[string]string{}}
+	u := NewUserUseCase(mockUserRepo, blobs)
+
+	// The new blob is removed when the user can't point to it.
+	_, err := u.SetAvatar(context.TODO(), id, strings.NewReader("png"), "image/png")
+	assert.Error(t, err)
+	assert.Empty(t, blobs.blobs)
+
+	blobs.err = errors.New("storage down")
+	_, err = u.SetAvatar(context.TODO(), id, strings.NewReader("png"), "image/png")
+	assert.Equal(t, blobs.err, err)
+
+	_, err = u.SetAvatar(context.TODO(), id, strings.NewReader("svg"), "image/svg+xml")
+	assert.Equal(t, domain.ErrAvatarType, err)
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestSetAvatarNotFound(t *testing.T) {
+	id := uuid.New()
+
+	mockUserRepo := new(mocks.UserRepository)
+	mockUserRepo.On("FindByID", mock.Anything, id).Return(nil, nil).Once()
+
+	u := NewUserUseCase(mockUserRepo, &fakeStorage{blobs: map[string]string{}})
+	_, err := u.SetAvatar(context.TODO(), id, strings.NewReader("png"), "image/png")
+
+	assert.Equal(t, domain.ErrResourceNotFound, err)
+	mockUserRepo.AssertExpectations(t)
+}
@@ -3,21 +3,43 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"time"
 
 	albumsController "hexagony/app/albums/http/controller"
 	albumsRepository "hexagony/app/albums/repository/mariadb"
 	usersController "hexagony/app/users/http/controller"
 	usersRepository "hexagony/app/users/repository/mariadb"
+	usersSlowlog "hexagony/app/users/repository/slowlog"
+	usersUseCase "hexagony/app/users/usecase"
+	"hexagony/lib/buildinfo"
 	"hexagony/lib/clog"
+	"hexagony/lib/database"
+	"hexagony/lib/rest"
+	"hexagony/lib/scheduler"
+	"hexagony/lib/server"
+	"hexagony/lib/storage"
 
 	authController "hexagony/app/auth/http/controller"
 	authRepository "hexagony/app/auth/repository/mariadb"
 	authUseCase "hexagony/app/auth/usecase"
 
+	adminController "hexagony/app/admin/http/controller"
+	apiKeysController "hexagony/app/apikeys/http/controller"
+	apiKeysRepository "hexagony/app/apikeys/repository/mariadb"
+	apiKeysUseCase "hexagony/app/apikeys/usecase"
+
+	healthController "hexagony/app/health/http/controller"
+	healthRepository "hexagony/app/health/repository/mariadb"
+	"hexagony/db"
+
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+
+	// The zones of APP_TIMEZONE, the alpine image has no tzdata.
+	_ "time/tzdata"
 
 	cmiddleware "hexagony/app/shared/http/middleware"
 
@@ -29,10 +51,14 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 
 	_ "hexagony/docs"
+	"hexagony/docs/openapi"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// jobsStopTimeout bounds the wait for the running jobs on shutdown.
+const jobsStopTimeout = 10 * time.Second
+
 // @title        Hexagony API
 // @version      1.0
 // @description  Clean architecture example in Golang.
@@ -56,8 +82,17 @@ func main() {
 		clog.Info("running in production mode")
 	}
 
+	build := buildinfo.Get()
+	clog.Custom(map[string]interface{}{
+		"message":    "starting hexagony",
+		"version":    build.Version,
+		"commit":     build.Commit,
+		"build_time": build.BuildTime,
+		"go_version": build.GoVersion,
+	})
+
 	databaseURL := fmt.Sprintf(
-		"%s:%s@tcp(%s:%s)/%s?parseTime=true",
+		"%s:%s@tcp(%s:%s)/%s?parseTime=true&clientFoundRows=true",
 		os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_HOST"),
 		os.Getenv("DB_PORT"), os.Getenv("DB_NAME"),
 	)
@@ -72,6 +107,28 @@ func main() {
 		clog.Fatal("could not ping the database")
 	}
 
+	var readConn *sqlx.DB
+
+	if readURL := os.Getenv("DB_READ_DSN"); readURL != "" {
+		readConn, err = sqlx.ConnectContext(ctx, "mysql", readURL)
+		if err != nil {
+			clog.Fatal("mariadb read replica failed to start")
+		}
+		defer readConn.Close()
+	}
+
+	schemaVersion, err := db.LatestMigration()
+	if err != nil {
+		clog.Fatal("could not read the embedded migrations")
+	}
+
+	trustedProxies, err := cmiddleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		clog.Fatal("invalid trusted proxies")
+	}
+
+	cmiddleware.SetMaintenance(os.Getenv("MAINTENANCE_MODE") == "true")
+
 	router := chi.NewRouter()
 
 	cors := cors.New(cors.Options{
@@ -80,6 +137,7 @@ func main() {
 			"GET",
 			"POST",
 			"PUT",
+			"PATCH",
 			"DELETE",
 			"OPTIONS",
 		},
@@ -87,16 +145,36 @@ func main() {
 			"Accept",
 			"Authorization",
 			"Content-Type",
+			cmiddleware.APIKeyHeader,
+			cmiddleware.CSRFHeader,
+			cmiddleware.CorrelationHeader,
+			cmiddleware.IdempotencyKeyHeader,
+			cmiddleware.TenantHeader,
 		},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "Retry-After", cmiddleware.CorrelationHeader, cmiddleware.IdempotentReplayedHeader, cmiddleware.ResponseTimeHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
 
 	router.Use(
-		middleware.Timeout(time.Second*60),
+		cmiddleware.ResponseTimeMiddleware,
+		cmiddleware.HeaderMiddleware,
+		cmiddleware.RealIPMiddleware(trustedProxies),
+		cmiddleware.RouteMiddleware,
+		cmiddleware.CorrelationMiddleware,
+		cmiddleware.TenantMiddleware,
+		cmiddleware.PrimaryReadMiddleware,
 		middleware.Recoverer,
+		cmiddleware.CompressMiddleware,
 		cmiddleware.LoggerMiddleware,
+		cmiddleware.BodyLoggerMiddleware,
+		cmiddleware.HTTPSMiddleware(trustedProxies),
+		cmiddleware.TimeoutMiddleware,
+		cmiddleware.SecurityMiddleware,
+		cmiddleware.SlashMiddleware,
+		cmiddleware.MaintenanceMiddleware,
+		cmiddleware.CSRFMiddleware,
+		cmiddleware.AcceptMiddleware("application/json", "text/csv", rest.ProblemMediaType),
 		render.SetContentType(render.ContentTypeJSON),
 		cors.Handler,
 	)
@@ -108,37 +186,93 @@ func main() {
 	})
 
 	router.Get("/docs/*", httpSwagger.WrapHandler)
+	router.Get("/openapi.json", openapi.Handler)
+
+	// Without a DSN template every tenant shares the main database.
+	tenants := database.NewTenantResolver(conn, os.Getenv("TENANT_DSN_TEMPLATE"))
+	defer tenants.Close()
+
+	usersRepository := usersSlowlog.NewSlowlogRepository(
+		usersRepository.NewMariaDBTenantRepository(tenants, conn, readConn),
+	)
+	if statements, ok := usersRepository.(io.Closer); ok {
+		defer statements.Close()
+	}
 
-	usersRepository := usersRepository.NewMariaDBRepository(conn)
-	usersController.NewUserHandler(router, usersRepository)
+	healthRepository := healthRepository.NewMariaDBRepository(conn)
+	healthController.NewHealthHandler(router, healthRepository, usersRepository, schemaVersion)
+
+	authRepository := authRepository.NewMariaDBTenantRepository(tenants)
+	authUseCase := authUseCase.NewAuthUsecase(authRepository)
+	blobs, err := storage.FromEnv()
+	if err != nil {
+		clog.Fatal("invalid blob storage")
+	}
+
+	// The local blobs are served by the API unless they live
+	// behind another URL.
+	if local, ok := blobs.(*storage.Local); ok && local.Prefix() != "" {
+		router.Handle(local.Prefix()+"/*", http.StripPrefix(local.Prefix(), local.Handler()))
+	}
+
+	usersUseCase := usersUseCase.NewUserUseCase(usersRepository, blobs)
+
+	authController.NewAuthHandler(router, authUseCase, usersUseCase)
+
+	apiKeysRepository := apiKeysRepository.NewMariaDBRepository(conn)
+	apiKeysUseCase := apiKeysUseCase.NewAPIKeyUseCase(apiKeysRepository)
+
+	usersController.NewUserHandler(router, usersUseCase, authUseCase, apiKeysUseCase)
+	cmiddleware.ValidateSessionsWith(usersUseCase)
 
 	albumsRepository := albumsRepository.NewMariaDBRepository(conn)
 	albumsController.NewAlbumHandler(router, albumsRepository)
 
-	authRepository := authRepository.NewMariaDBRepository(conn)
-	authUseCase := authUseCase.NewAuthUsecase(authRepository)
-	authController.NewAuthHandler(router, authUseCase)
-
-	srv := &http.Server{
-		Addr:              ":" + os.Getenv("PORT"),
-		ReadTimeout:       time.Duration(time.Second * 5),
-		ReadHeaderTimeout: time.Duration(time.Second * 5),
-		WriteTimeout:      time.Duration(time.Second * 5),
-		IdleTimeout:       time.Duration(time.Second * 20),
-		Handler:           router,
+	adminController.NewAdminHandler(router)
+
+	apiKeysController.NewAPIKeyHandler(router, apiKeysUseCase)
+
+	jobs := scheduler.New()
+	jobs.Locker = scheduler.NewMariaDBLocker(conn)
+
+	// Without a retention the revoked keys are only purged on demand.
+	if retention, err := time.ParseDuration(os.Getenv("API_KEY_RETENTION")); err == nil && retention > 0 {
+		jobs.Register("purge-revoked-api-keys", scheduler.Interval("API_KEY_PURGE_INTERVAL", time.Hour), func(ctx context.Context) error {
+			_, err := apiKeysUseCase.PurgeRevoked(ctx, time.Now().Add(-retention))
+			return err
+		})
 	}
 
+	jobs.Start(ctx)
+
+	srv := server.New(":"+os.Getenv("PORT"), router)
+
 	idleConnsClosed := make(chan struct{})
 
 	go func() {
 		gracefulStop := make(chan os.Signal, 1)
-		signal.Notify(gracefulStop, os.Interrupt)
+		signal.Notify(gracefulStop, os.Interrupt, syscall.SIGTERM)
 		<-gracefulStop
 
+		// Stop issuing tokens first and give the clients some time to
+		// move to another instance before the server stops.
+		cmiddleware.SetDraining(true)
+		if grace, err := time.ParseDuration(os.Getenv("SHUTDOWN_GRACE_PERIOD")); err == nil && grace > 0 {
+			clog.Info("draining for " + grace.String() + "...")
+			time.Sleep(grace)
+		}
+
 		clog.Info("shutting down the server...")
 		if err := srv.Shutdown(ctx); err != nil {
 			clog.Error(err, "server failed to shutdown")
 		}
+
+		// The jobs are cancelled, the ones stuck are left behind.
+		stopCtx, stop := context.WithTimeout(ctx, jobsStopTimeout)
+		if err := jobs.Stop(stopCtx); err != nil {
+			clog.Error(err, "jobs failed to stop")
+		}
+		stop()
 		close(idleConnsClosed)
 	}()
 
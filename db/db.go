@@ -0,0 +1,42 @@
+package db
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Migrations holds the schema migrations, named <version>_<description>.sql.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+// LatestMigration returns the highest version among the embedded migrations.
+func LatestMigration() (int, error) {
+	return latestMigration(Migrations)
+}
+
+func latestMigration(migrations fs.FS) (int, error) {
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	latest := 0
+
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return 0, err
+		}
+
+		if version > latest {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}
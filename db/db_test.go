@@ -0,0 +1,24 @@
+package db
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatestMigration(t *testing.T) {
+	latest, err := LatestMigration()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 17, latest)
+}
+
+func TestLatestMigrationInvalidName(t *testing.T) {
+	_, err := latestMigration(fstest.MapFS{
+		"migrations/0001_add_users_role.sql": {},
+		"migrations/add_users_username.sql":  {},
+	})
+
+	assert.Error(t, err)
+}
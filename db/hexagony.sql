@@ -2,16 +2,30 @@ CREATE DATABASE IF NOT EXISTS `hexagony`;
 
 USE `hexagony`;
 
+DROP TABLE IF EXISTS `password_history`;
+
 DROP TABLE IF EXISTS `users`;
 
 CREATE TABLE `users` (
   `uuid` varchar(36) NOT NULL,
+  `tenant_id` varchar(36) NOT NULL DEFAULT 'default',
   `name` varchar(100) NOT NULL,
   `email` varchar(100) NOT NULL,
+  `email_canonical` varchar(100) NOT NULL,
+  `username` varchar(30) DEFAULT NULL,
   `password` varchar(100) NOT NULL,
-  `created_at` timestamp NULL DEFAULT NULL,
-  `updated_at` timestamp NULL DEFAULT NULL,
-  PRIMARY KEY (`uuid`)
+  `password_changed_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `role` varchar(20) NOT NULL DEFAULT 'user',
+  `token_version` int(10) unsigned NOT NULL DEFAULT 0,
+  `avatar_key` varchar(255) DEFAULT NULL,
+  `avatar_url` varchar(512) DEFAULT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (`uuid`),
+  UNIQUE KEY `users_email_canonical_unique` (`tenant_id`, `email_canonical`),
+  UNIQUE KEY `users_username_unique` (`tenant_id`, `username`),
+  KEY `users_created_at` (`tenant_id`, `created_at`, `uuid`),
+  KEY `users_name` (`tenant_id`, `name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 LOCK TABLES `users` WRITE;
@@ -20,10 +34,21 @@ UNLOCK TABLES;
 
 LOCK TABLES `users` WRITE;
 
-INSERT INTO `users` VALUES ('7d31461a-6ed5-425e-96fe-fa98e56d6828', 'John Doe', 'john@doe.com', '$2a$10$rPyJPskrTN545bXE0cqEU.T3uqluwiPFjGHMjE0/K.QuTe5XedjYi', '2022-06-19 16:53:09.000', '2022-06-19 16:53:09.000');
+INSERT INTO `users` VALUES ('7d31461a-6ed5-425e-96fe-fa98e56d6828', 'default', 'John Doe', 'john@doe.com', 'john@doe.com', NULL, '$2a$10$rPyJPskrTN545bXE0cqEU.T3uqluwiPFjGHMjE0/K.QuTe5XedjYi', '2022-06-19 16:53:09.000', 'admin', 0, NULL, NULL, '2022-06-19 16:53:09.000', '2022-06-19 16:53:09.000');
 
 UNLOCK TABLES;
 
+CREATE TABLE `password_history` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `tenant_id` varchar(36) NOT NULL DEFAULT 'default',
+  `user_uuid` varchar(36) NOT NULL,
+  `password` varchar(100) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `password_history_user` (`tenant_id`, `user_uuid`, `id`),
+  CONSTRAINT `password_history_user_fk` FOREIGN KEY (`user_uuid`) REFERENCES `users` (`uuid`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
 DROP TABLE IF EXISTS `albums`;
 
 CREATE TABLE `albums` (
@@ -38,3 +63,40 @@ CREATE TABLE `albums` (
 LOCK TABLES `albums` WRITE;
 
 UNLOCK TABLES;
+
+DROP TABLE IF EXISTS `api_keys`;
+
+CREATE TABLE `api_keys` (
+  `uuid` varchar(36) NOT NULL,
+  `tenant_id` varchar(36) NOT NULL DEFAULT 'default',
+  `service` varchar(100) NOT NULL,
+  `scopes` varchar(255) NOT NULL DEFAULT '',
+  `key_hash` char(64) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `revoked_at` timestamp NULL DEFAULT NULL,
+  PRIMARY KEY (`uuid`),
+  UNIQUE KEY `api_keys_key_hash_unique` (`key_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `job_locks`;
+
+CREATE TABLE `job_locks` (
+  `name` varchar(100) NOT NULL,
+  `holder` varchar(100) NOT NULL,
+  `expires_at` timestamp(3) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `schema_migrations`;
+
+CREATE TABLE `schema_migrations` (
+  `version` int(10) unsigned NOT NULL,
+  `applied_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+LOCK TABLES `schema_migrations` WRITE;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (1), (2), (3), (4), (5), (6), (7), (8), (9), (10), (11), (12), (13), (14), (15), (16), (17);
+
+UNLOCK TABLES;
@@ -0,0 +1,3 @@
+ALTER TABLE `users` ADD COLUMN `role` varchar(20) NOT NULL DEFAULT 'user' AFTER `password`;
+
+UPDATE `users` SET `role` = 'admin' WHERE `email` = 'john@doe.com';
@@ -0,0 +1,3 @@
+ALTER TABLE `users` ADD COLUMN `username` varchar(30) DEFAULT NULL AFTER `email`;
+
+ALTER TABLE `users` ADD UNIQUE KEY `users_username_unique` (`username`);
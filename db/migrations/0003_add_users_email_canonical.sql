@@ -0,0 +1,9 @@
+ALTER TABLE `users` ADD COLUMN `email_canonical` varchar(100) NOT NULL DEFAULT '' AFTER `email`;
+
+UPDATE `users` SET `email_canonical` = LOWER(TRIM(`email`));
+
+ALTER TABLE `users` ALTER COLUMN `email_canonical` DROP DEFAULT;
+
+-- Fails if the table already holds emails differing only by case,
+-- which must be merged by hand before running it.
+ALTER TABLE `users` ADD UNIQUE KEY `users_email_canonical_unique` (`email_canonical`);
@@ -0,0 +1,7 @@
+UPDATE `users` SET `created_at` = CURRENT_TIMESTAMP WHERE `created_at` IS NULL;
+
+UPDATE `users` SET `updated_at` = `created_at` WHERE `updated_at` IS NULL;
+
+ALTER TABLE `users`
+  MODIFY `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  MODIFY `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;
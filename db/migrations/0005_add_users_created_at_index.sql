@@ -0,0 +1,2 @@
+-- Backs the default listing order, newest first.
+ALTER TABLE `users` ADD KEY `users_created_at` (`created_at`, `uuid`);
@@ -0,0 +1,9 @@
+-- Records the applied migrations, checked by /readyz. Every migration
+-- from now on ends by inserting its own version.
+CREATE TABLE IF NOT EXISTS `schema_migrations` (
+  `version` int(10) unsigned NOT NULL,
+  `applied_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT IGNORE INTO `schema_migrations` (`version`) VALUES (1), (2), (3), (4), (5), (6);
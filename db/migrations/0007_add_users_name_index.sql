@@ -0,0 +1,4 @@
+-- Backs the name prefix search.
+ALTER TABLE `users` ADD KEY `users_name` (`name`);
+
+INSERT INTO `schema_migrations` (`version`) VALUES (7);
@@ -0,0 +1,14 @@
+-- API keys for machine-to-machine callers. Only the SHA-256 of a key is
+-- stored, the key itself is shown once when it's minted.
+CREATE TABLE `api_keys` (
+  `uuid` varchar(36) NOT NULL,
+  `service` varchar(100) NOT NULL,
+  `scopes` varchar(255) NOT NULL DEFAULT '',
+  `key_hash` char(64) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `revoked_at` timestamp NULL DEFAULT NULL,
+  PRIMARY KEY (`uuid`),
+  UNIQUE KEY `api_keys_key_hash_unique` (`key_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (8);
@@ -0,0 +1,4 @@
+-- The tokens of the user issued before this instant are rejected.
+ALTER TABLE `users` ADD COLUMN `tokens_valid_after` timestamp NULL DEFAULT NULL AFTER `role`;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (9);
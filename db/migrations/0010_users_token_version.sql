@@ -0,0 +1,7 @@
+-- Tokens embed the version of their user, bumping it invalidates
+-- them all. It replaces the forced logout timestamp.
+ALTER TABLE `users`
+  ADD COLUMN `token_version` int(10) unsigned NOT NULL DEFAULT 0 AFTER `role`,
+  DROP COLUMN `tokens_valid_after`;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (10);
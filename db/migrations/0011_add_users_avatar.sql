@@ -0,0 +1,7 @@
+-- The avatar is stored by key in the blob storage, the key of the
+-- previous one is needed to delete it on replacement.
+ALTER TABLE `users`
+  ADD COLUMN `avatar_key` varchar(255) DEFAULT NULL AFTER `token_version`,
+  ADD COLUMN `avatar_url` varchar(512) DEFAULT NULL AFTER `avatar_key`;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (11);
@@ -0,0 +1,15 @@
+-- Users are isolated per tenant, the existing ones belong to the
+-- default tenant. Emails and usernames are only unique within a
+-- tenant, and the indexes lead with it as every query filters on it.
+ALTER TABLE `users`
+  ADD COLUMN `tenant_id` varchar(36) NOT NULL DEFAULT 'default' AFTER `uuid`,
+  DROP INDEX `users_email_canonical_unique`,
+  DROP INDEX `users_username_unique`,
+  DROP INDEX `users_created_at`,
+  DROP INDEX `users_name`,
+  ADD UNIQUE KEY `users_email_canonical_unique` (`tenant_id`, `email_canonical`),
+  ADD UNIQUE KEY `users_username_unique` (`tenant_id`, `username`),
+  ADD KEY `users_created_at` (`tenant_id`, `created_at`, `uuid`),
+  ADD KEY `users_name` (`tenant_id`, `name`);
+
+INSERT INTO `schema_migrations` (`version`) VALUES (12);
@@ -0,0 +1,15 @@
+-- The latest password hashes of each user, so a password change can
+-- refuse the recent ones. Only the last PASSWORD_HISTORY are kept, and
+-- they go away with the user.
+CREATE TABLE `password_history` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `tenant_id` varchar(36) NOT NULL DEFAULT 'default',
+  `user_uuid` varchar(36) NOT NULL,
+  `password` varchar(100) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `password_history_user` (`tenant_id`, `user_uuid`, `id`),
+  CONSTRAINT `password_history_user_fk` FOREIGN KEY (`user_uuid`) REFERENCES `users` (`uuid`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (13);
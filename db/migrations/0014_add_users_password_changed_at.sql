@@ -0,0 +1,6 @@
+-- When the password was last set, for PASSWORD_MAX_AGE. The existing
+-- users start counting from the migration.
+ALTER TABLE `users`
+  ADD COLUMN `password_changed_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP AFTER `password`;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (14);
@@ -0,0 +1,11 @@
+-- The locks of the background jobs, so a single instance runs a job
+-- per interval. A lock expires on its own, a crashed holder doesn't
+-- keep it.
+CREATE TABLE `job_locks` (
+  `name` varchar(100) NOT NULL,
+  `holder` varchar(100) NOT NULL,
+  `expires_at` timestamp(3) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (15);
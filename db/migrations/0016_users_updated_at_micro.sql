@@ -0,0 +1,7 @@
+-- The list of users is versioned by its latest update, to the second
+-- two updates in the same second looked like one. The creation date
+-- stays to the second, it's never compared that finely.
+ALTER TABLE `users`
+  MODIFY `updated_at` timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6);
+
+INSERT INTO `schema_migrations` (`version`) VALUES (16);
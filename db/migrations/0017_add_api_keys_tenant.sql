@@ -0,0 +1,6 @@
+-- A key only reads its own tenant, the one of the admin who minted it.
+-- The existing keys belong to the default tenant.
+ALTER TABLE `api_keys`
+  ADD COLUMN `tenant_id` varchar(36) NOT NULL DEFAULT 'default' AFTER `uuid`;
+
+INSERT INTO `schema_migrations` (`version`) VALUES (17);
@@ -0,0 +1,19 @@
+// Package openapi serves the hand-maintained OpenAPI 3 document of the API.
+// Keep openapi.json in sync when the user or auth endpoints change.
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Handler serves the OpenAPI document.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(spec); err != nil {
+		return
+	}
+}
@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type document struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		SecuritySchemes map[string]json.RawMessage `json:"securitySchemes"`
+		Schemas         map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rec := httptest.NewRecorder()
+
+	Handler(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var doc document
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+
+	assert.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+	assert.Contains(t, doc.Components.SecuritySchemes, "bearerAuth")
+
+	expected := map[string][]string{
+		"/auth":                  {"post"},
+		"/user":                  {"get", "post"},
+		"/user/{uuid}":           {"get", "put", "patch", "delete"},
+		"/admin/maintenance":     {"post"},
+		"/admin/api-keys":        {"post"},
+		"/admin/api-keys/{uuid}": {"delete"},
+	}
+
+	for path, methods := range expected {
+		assert.Contains(t, doc.Paths, path)
+		for _, method := range methods {
+			assert.Contains(t, doc.Paths[path], method, path)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	var doc document
+	assert.NoError(t, json.Unmarshal(spec, &doc))
+
+	prefix := "#/components/schemas/"
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				if ref, ok := child.(string); ok && key == "$ref" {
+					assert.True(t, strings.HasPrefix(ref, prefix), ref)
+					assert.Contains(t, doc.Components.Schemas, strings.TrimPrefix(ref, prefix))
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+
+	var raw interface{}
+	assert.NoError(t, json.Unmarshal(spec, &raw))
+	walk(raw)
+}
@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"context"
+	"hexagony/lib/clog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Entry is a sensitive action kept for later review.
+type Entry struct {
+	Action string
+	Actor  uuid.UUID
+	Target uuid.UUID
+}
+
+// Logger records the audit entries.
+type Logger interface {
+	Record(ctx context.Context, entry Entry)
+}
+
+type logLogger struct{}
+
+// New returns a Logger writing the entries to the application log.
+func New() Logger {
+	return &logLogger{}
+}
+
+func (l *logLogger) Record(ctx context.Context, entry Entry) {
+	clog.Custom(map[string]interface{}{
+		"audit":  entry.Action,
+		"actor":  entry.Actor.String(),
+		"target": entry.Target.String(),
+		"at":     time.Now().UTC(),
+	})
+}
@@ -0,0 +1,34 @@
+// Package buildinfo tells which build is running. The values are
+// injected when building, e.g.
+//
+//	go build -ldflags "-X hexagony/lib/buildinfo.Version=1.4.0 \
+//	  -X hexagony/lib/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X hexagony/lib/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import "runtime"
+
+// Set with -ldflags -X, they are dev and unknown otherwise.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// Info is the build of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -0,0 +1,96 @@
+// Package cache is a small in-process cache whose entries expire
+// after a fixed time to live.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Cache is safe for concurrent use. A zero time to live disables it,
+// every lookup missing.
+type Cache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+// New returns a cache keeping the entries for ttl.
+func New(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]entry{},
+	}
+}
+
+// Enabled tells whether the cache keeps anything, its time to live
+// isn't zero.
+func (c *Cache) Enabled() bool {
+	return c.ttl > 0
+}
+
+// Get returns the value stored for the key unless it expired.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+// Set stores the value for the key, replacing the previous one.
+func (c *Cache) Set(key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+
+	c.entries[key] = entry{value: value, expires: now.Add(c.ttl)}
+}
+
+// Delete drops the value stored for the key, if any.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// sweep drops the expired entries, at most once per time to live,
+// so keys that are never read again don't pile up.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	c.lastSweep = now
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2022, 6, 19, 16, 53, 9, 0, time.UTC)
+
+	c := New(time.Minute)
+	c.now = func() time.Time { return now }
+
+	_, ok := c.Get("user")
+	assert.False(t, ok)
+
+	c.Set("user", 1)
+
+	value, ok := c.Get("user")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	c.Delete("user")
+
+	_, ok = c.Get("user")
+	assert.False(t, ok)
+
+	c.Set("user", 2)
+	now = now.Add(time.Minute)
+
+	_, ok = c.Get("user")
+	assert.False(t, ok)
+}
+
+func TestCacheSweep(t *testing.T) {
+	now := time.Date(2022, 6, 19, 16, 53, 9, 0, time.UTC)
+
+	c := New(time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("first", 1)
+	c.Set("second", 2)
+
+	now = now.Add(2 * time.Minute)
+	c.Set("third", 3)
+
+	assert.Len(t, c.entries, 1)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := New(0)
+	assert.False(t, c.Enabled())
+	assert.True(t, New(time.Minute).Enabled())
+
+	c.Set("user", 1)
+
+	_, ok := c.Get("user")
+	assert.False(t, ok)
+}
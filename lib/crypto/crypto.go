@@ -1,17 +1,26 @@
 package crypto
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 type Crypto interface {
 	HashPassword(password string, cost int) (string, error)
 	CheckPasswordHash(password, hash string) bool
 }
 
-type bcryptHash struct{}
+type bcryptHash struct {
+	pepper string
+}
 
 // HashPassword encrypts a given password using bcrypt algorithm.
 func (b bcryptHash) HashPassword(password string, cost int) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	bytes, err := bcrypt.GenerateFromPassword(b.peppered(password), cost)
 	if err != nil {
 		return "", err
 	}
@@ -21,11 +30,29 @@ func (b bcryptHash) HashPassword(password string, cost int) (string, error) {
 
 // CheckPasswordHash checks if the given passwords matches.
 func (b bcryptHash) CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	err := bcrypt.CompareHashAndPassword([]byte(hash), b.peppered(password))
 
 	return err == nil
 }
 
+// peppered mixes the pepper into the password with HMAC-SHA256.
+// The digest is base64 encoded to stay below the bcrypt 72 bytes limit.
+// Without a pepper the password is returned untouched.
+func (b bcryptHash) peppered(password string) []byte {
+	if b.pepper == "" {
+		return []byte(password)
+	}
+
+	mac := hmac.New(sha256.New, []byte(b.pepper))
+	mac.Write([]byte(password))
+
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// New creates a new Crypto using the PASSWORD_PEPPER secret, when set.
+//
+// Changing or removing the pepper invalidates every hash created with
+// the previous value, so rotating it requires users to reset their passwords.
 func New() Crypto {
-	return &bcryptHash{}
+	return &bcryptHash{pepper: os.Getenv("PASSWORD_PEPPER")}
 }
@@ -0,0 +1,42 @@
+package crypto
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPepper(t *testing.T) {
+	os.Setenv("PASSWORD_PEPPER", "pepper")
+	defer os.Unsetenv("PASSWORD_PEPPER")
+
+	bcrypt := New()
+
+	hash, err := bcrypt.HashPassword("12345678", 4)
+	assert.NoError(t, err)
+
+	assert.True(t, bcrypt.CheckPasswordHash("12345678", hash))
+	assert.False(t, bcrypt.CheckPasswordHash("87654321", hash))
+
+	os.Setenv("PASSWORD_PEPPER", "other")
+	assert.False(t, New().CheckPasswordHash("12345678", hash))
+
+	os.Unsetenv("PASSWORD_PEPPER")
+	assert.False(t, New().CheckPasswordHash("12345678", hash))
+}
+
+func TestWithoutPepper(t *testing.T) {
+	os.Unsetenv("PASSWORD_PEPPER")
+
+	// Hashes created before the pepper existed must keep working.
+	legacy, err := bcrypt.GenerateFromPassword([]byte("12345678"), 4)
+	assert.NoError(t, err)
+
+	assert.True(t, New().CheckPasswordHash("12345678", string(legacy)))
+
+	hash, err := New().HashPassword("12345678", 4)
+	assert.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("12345678")))
+}
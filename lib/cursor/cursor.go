@@ -0,0 +1,118 @@
+// Package cursor encodes the opaque cursors of keyset paginations. A
+// cursor holds the sort keys of the last row of a page, signed with an
+// HMAC along with the query it pages through, so clients can neither
+// forge one nor reuse it with other filters.
+package cursor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hexagony/lib/clog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Param is the query parameter carrying the cursor, left out of Scope.
+const Param = "cursor"
+
+// ErrInvalid is returned for the cursors that were tampered with, were
+// issued for another query or aren't cursors at all. Handlers answer it
+// with a 400.
+var ErrInvalid = errors.New("the cursor is invalid")
+
+// secretSize is the size of the random secret used without
+// CURSOR_SECRET.
+const secretSize = 32
+
+// Signer encodes and decodes the cursors signed with its secret.
+type Signer struct {
+	secret []byte
+}
+
+// New returns a Signer using secret.
+func New(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// FromEnv returns a Signer using the CURSOR_SECRET secret. Without one
+// a random secret is drawn, so the cursors only hold on the instance
+// that issued them and until it restarts.
+func FromEnv() *Signer {
+	if secret := os.Getenv("CURSOR_SECRET"); secret != "" {
+		return New([]byte(secret))
+	}
+
+	clog.Warn("CURSOR_SECRET is unset, the cursors only hold on this instance")
+
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		clog.Panic("failed to draw a cursor secret")
+	}
+	return New(secret)
+}
+
+// Scope identifies the query of r, its path and parameters but the
+// cursor, in a stable order, to sign the cursors of its pages with.
+func Scope(r *http.Request) string {
+	params := r.URL.Query()
+	params.Del(Param)
+
+	return r.URL.Path + "?" + params.Encode()
+}
+
+// Encode returns the cursor of the values, e.g. a struct of the sort
+// keys of the last row, for the query scope.
+func (s *Signer) Encode(scope string, values interface{}) (string, error) {
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(scope, payload)), nil
+}
+
+// Decode checks the cursor was issued by Encode for the query scope and
+// stores its values in the value pointed to by values. Any failure is
+// ErrInvalid.
+func (s *Signer) Decode(cursor, scope string, values interface{}) error {
+	encodedPayload, encodedMAC, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return ErrInvalid
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, s.sign(scope, payload)) {
+		return ErrInvalid
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(values); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// sign returns the HMAC of the payload for the scope. A zero byte ends
+// the scope, it can't be moved into the payload.
+func (s *Signer) sign(scope string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(scope))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,105 @@
+package cursor
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// position is the keyset of a list sorted by creation date.
+type position struct {
+	CreatedAt time.Time `json:"created_at"`
+	UUID      uuid.UUID `json:"uuid"`
+}
+
+const scope = "/user?role=admin&sort=created_at"
+
+func TestRoundTrip(t *testing.T) {
+	signer := New([]byte("secret"))
+
+	last := position{CreatedAt: time.Date(2022, 6, 19, 16, 53, 9, 0, time.UTC), UUID: uuid.New()}
+
+	cursor, err := signer.Encode(scope, last)
+	assert.NoError(t, err)
+	assert.NotContains(t, cursor, "=")
+
+	var decoded position
+	assert.NoError(t, signer.Decode(cursor, scope, &decoded))
+	assert.Equal(t, last, decoded)
+}
+
+func TestTampered(t *testing.T) {
+	signer := New([]byte("secret"))
+
+	cursor, err := signer.Encode(scope, position{UUID: uuid.New()})
+	assert.NoError(t, err)
+
+	payload, mac, _ := strings.Cut(cursor, ".")
+
+	// A client moving the cursor to another row keeps the signature.
+	forged, err := New([]byte("guessed")).Encode(scope, position{UUID: uuid.New()})
+	assert.NoError(t, err)
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	flipped := []byte(mac)
+	flipped[0] ^= 1
+
+	other, err := signer.Encode(scope, map[string]string{"role": "user"})
+	assert.NoError(t, err)
+
+	for name, tampered := range map[string]string{
+		"payload":      forgedPayload + "." + mac,
+		"signature":    payload + "." + string(flipped),
+		"other secret": forged,
+		"no signature": payload,
+		"empty":        "",
+		"not base64":   "!!!." + mac,
+		"truncated":    payload + "." + mac[:len(mac)-2],
+		"other values": other,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var decoded position
+			assert.ErrorIs(t, signer.Decode(tampered, scope, &decoded), ErrInvalid)
+		})
+	}
+}
+
+func TestOtherQuery(t *testing.T) {
+	signer := New([]byte("secret"))
+
+	cursor, err := signer.Encode(scope, position{UUID: uuid.New()})
+	assert.NoError(t, err)
+
+	var decoded position
+	assert.ErrorIs(t, signer.Decode(cursor, "/user?role=user&sort=created_at", &decoded), ErrInvalid)
+	assert.ErrorIs(t, signer.Decode(cursor, "/album?role=admin&sort=created_at", &decoded), ErrInvalid)
+}
+
+func TestScope(t *testing.T) {
+	a := httptest.NewRequest("GET", "/user?sort=created_at&role=admin&cursor=abc", nil)
+	b := httptest.NewRequest("GET", "/user?role=admin&sort=created_at", nil)
+	c := httptest.NewRequest("GET", "/user?role=user&sort=created_at", nil)
+
+	assert.Equal(t, scope, Scope(a))
+	assert.Equal(t, Scope(a), Scope(b))
+	assert.NotEqual(t, Scope(a), Scope(c))
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("CURSOR_SECRET", "secret")
+
+	cursor, err := FromEnv().Encode(scope, position{})
+	assert.NoError(t, err)
+
+	var decoded position
+	assert.NoError(t, New([]byte("secret")).Decode(cursor, scope, &decoded))
+
+	// Without a secret every instance draws its own.
+	t.Setenv("CURSOR_SECRET", "")
+
+	assert.ErrorIs(t, FromEnv().Decode(cursor, scope, &decoded), ErrInvalid)
+}
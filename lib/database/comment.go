@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// maxRequestID bounds the id written in the query comments.
+const maxRequestID = 128
+
+// WithRequestID returns a context whose queries, once annotated with
+// Annotate, carry the id of the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// Annotate appends a /* request_id=... */ comment to the query when
+// SQL_COMMENTS is enabled and the context has a request id, so a query
+// of the MariaDB slow log can be traced back to its request. The id is
+// stripped of anything but letters, digits and ._:- so it can't close
+// the comment. Prepared statements must not be annotated, each id
+// would prepare another statement.
+func Annotate(ctx context.Context, query string) string {
+	if os.Getenv("SQL_COMMENTS") != "true" {
+		return query
+	}
+
+	id, _ := ctx.Value(requestIDKey).(string)
+	if id = sanitizeRequestID(id); id == "" {
+		return query
+	}
+
+	return query + " /* request_id=" + id + " */"
+}
+
+// sanitizeRequestID keeps the safe characters of the id, up to maxRequestID.
+func sanitizeRequestID(id string) string {
+	var safe strings.Builder
+
+	for _, c := range id {
+		if safe.Len() == maxRequestID {
+			break
+		}
+
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '_', c == ':', c == '-':
+			safe.WriteRune(c)
+		}
+	}
+
+	return safe.String()
+}
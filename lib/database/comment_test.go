@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotate(t *testing.T) {
+	const query = "SELECT 1"
+
+	os.Setenv("SQL_COMMENTS", "true")
+	defer os.Unsetenv("SQL_COMMENTS")
+
+	t.Run("request id", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), "checkout-42")
+
+		assert.Equal(t, "SELECT 1 /* request_id=checkout-42 */", Annotate(ctx, query))
+	})
+
+	t.Run("unsafe id", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), "x */ DROP TABLE users; /* ")
+
+		assert.Equal(t, "SELECT 1 /* request_id=xDROPTABLEusers */", Annotate(ctx, query))
+	})
+
+	t.Run("long id", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), strings.Repeat("a", 200))
+
+		assert.Equal(t, "SELECT 1 /* request_id="+strings.Repeat("a", maxRequestID)+" */", Annotate(ctx, query))
+	})
+
+	t.Run("nothing safe", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), "*/*/")
+
+		assert.Equal(t, query, Annotate(ctx, query))
+	})
+
+	t.Run("no request id", func(t *testing.T) {
+		assert.Equal(t, query, Annotate(context.Background(), query))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		os.Setenv("SQL_COMMENTS", "false")
+		defer os.Setenv("SQL_COMMENTS", "true")
+
+		ctx := WithRequestID(context.Background(), "checkout-42")
+
+		assert.Equal(t, query, Annotate(ctx, query))
+	})
+}
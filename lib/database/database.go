@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type contextKey string
+
+const (
+	primaryReadKey contextKey = "primary_read"
+	writesKey      contextKey = "writes"
+	requestIDKey   contextKey = "request_id"
+	tenantKey      contextKey = "tenant"
+)
+
+// WithPrimaryRead forces the reads made with the returned context to use
+// the primary database instead of a replica. Use it when a read must see a
+// write made with another context, or before a write that depends on the
+// current row, since replicas may lag behind the primary.
+func WithPrimaryRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadKey, true)
+}
+
+// TrackWrites returns a context in which, once a write is marked with
+// MarkWrite, the following reads use the primary, so a request reads its
+// own writes without asking for it.
+func TrackWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writesKey, new(int32))
+}
+
+// MarkWrite records a write made with the context. It does nothing if the
+// context doesn't track the writes.
+func MarkWrite(ctx context.Context) {
+	if writes, ok := ctx.Value(writesKey).(*int32); ok {
+		atomic.StoreInt32(writes, 1)
+	}
+}
+
+// PrimaryRead checks if the reads must use the primary database, either
+// because it was asked for or because a write was already made.
+func PrimaryRead(ctx context.Context) bool {
+	if primary, _ := ctx.Value(primaryReadKey).(bool); primary {
+		return true
+	}
+
+	writes, ok := ctx.Value(writesKey).(*int32)
+	return ok && atomic.LoadInt32(writes) == 1
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+)
+
+// defaultPurgeBatch is the number of rows deleted per statement when
+// PURGE_BATCH_SIZE is unset.
+const defaultPurgeBatch = 500
+
+// Execer runs a statement, *sqlx.DB and *sql.DB are ones.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// PurgeBatchSize reads PURGE_BATCH_SIZE, the number of rows a purge
+// deletes per statement.
+func PurgeBatchSize() int {
+	size, err := strconv.Atoi(os.Getenv("PURGE_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		return defaultPurgeBatch
+	}
+	return size
+}
+
+// DeleteInBatches runs query, a DELETE ending with LIMIT ?, until it
+// deletes fewer rows than batch, which is appended to args. Every
+// statement commits on its own, so the locks are held for a batch only
+// and replicas keep up. It stops once ctx is done, returning the rows
+// deleted so far along with the error.
+func DeleteInBatches(ctx context.Context, db Execer, query string, batch int, args ...interface{}) (int64, error) {
+	var total int64
+
+	args = append(args[:len(args):len(args)], batch)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		result, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return total, err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+
+		total += deleted
+		if deleted < int64(batch) {
+			return total, nil
+		}
+	}
+}
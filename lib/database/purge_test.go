@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteInBatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	query := "DELETE FROM api_keys WHERE revoked_at < \\? LIMIT \\?"
+	before := time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC)
+
+	// 1200 rows in batches of 500.
+	for _, deleted := range []int64{500, 500, 200} {
+		mock.ExpectExec(query).
+			WithArgs(before, 500).
+			WillReturnResult(sqlmock.NewResult(0, deleted))
+	}
+
+	total, err := DeleteInBatches(
+		context.TODO(),
+		db,
+		"DELETE FROM api_keys WHERE revoked_at < ? LIMIT ?",
+		500,
+		before,
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1200), total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteInBatchesCanceled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	mock.ExpectExec("DELETE FROM api_keys LIMIT \\?").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	total, err := DeleteInBatches(ctx, cancelAfterExec{db, cancel}, "DELETE FROM api_keys LIMIT ?", 2)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(2), total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeBatchSize(t *testing.T) {
+	assert.Equal(t, defaultPurgeBatch, PurgeBatchSize())
+
+	os.Setenv("PURGE_BATCH_SIZE", "100")
+	defer os.Unsetenv("PURGE_BATCH_SIZE")
+
+	assert.Equal(t, 100, PurgeBatchSize())
+}
+
+// cancelAfterExec cancels the purge once its first statement ran.
+type cancelAfterExec struct {
+	Execer
+	cancel context.CancelFunc
+}
+
+func (c cancelAfterExec) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer c.cancel()
+	return c.Execer.ExecContext(ctx, query, args...)
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"hexagony/lib/clog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidTenant = errors.New("invalid tenant")
+	ErrUnknownTenant = errors.New("unknown tenant")
+)
+
+// TenantPlaceholder is replaced by the tenant in a DSN template.
+const TenantPlaceholder = "{tenant}"
+
+// defaultPoolIdleTimeout is how long the pool of a tenant stays open
+// without being used when TENANT_POOL_IDLE_TIMEOUT is unset.
+const defaultPoolIdleTimeout = 10 * time.Minute
+
+// Resolver returns the database of the tenant of the context, see
+// Tenant. Repositories ask for it on every query.
+type Resolver interface {
+	DB(ctx context.Context) (*sqlx.DB, error)
+}
+
+type singleDB struct {
+	db *sqlx.DB
+}
+
+// SingleDB serves every tenant from the same database, the tenants
+// sharing its schema.
+func SingleDB(db *sqlx.DB) Resolver {
+	return singleDB{db: db}
+}
+
+func (s singleDB) DB(ctx context.Context) (*sqlx.DB, error) {
+	return s.db, nil
+}
+
+// TenantResolver gives each tenant its own database. The registered
+// pools are used first, then a pool is opened on the DSN template with
+// the tenant in place of {tenant}, e.g. "user:pass@tcp(db)/hexagony_{tenant}".
+// The default tenant, or every tenant without a template, is served
+// by the fallback database. Only the tenants of KnownTenant get a pool,
+// and the pools left idle for TENANT_POOL_IDLE_TIMEOUT are closed.
+type TenantResolver struct {
+	fallback    *sqlx.DB
+	template    string
+	idleTimeout time.Duration
+	open        func(dsn string) (*sqlx.DB, error)
+	now         func() time.Time
+
+	mu     sync.Mutex
+	pools  map[string]*sqlx.DB
+	opened map[string]*tenantPool
+}
+
+// tenantPool is a pool the resolver opened, closed once idle.
+type tenantPool struct {
+	db       *sqlx.DB
+	lastUsed time.Time
+}
+
+// NewTenantResolver resolves the tenants with the DSN template, which
+// may be empty to only serve the registered ones.
+func NewTenantResolver(fallback *sqlx.DB, template string) *TenantResolver {
+	return &TenantResolver{
+		fallback:    fallback,
+		template:    template,
+		idleTimeout: poolIdleTimeout(),
+		open: func(dsn string) (*sqlx.DB, error) {
+			return sqlx.Open("mysql", dsn)
+		},
+		now:    time.Now,
+		pools:  map[string]*sqlx.DB{},
+		opened: map[string]*tenantPool{},
+	}
+}
+
+func poolIdleTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("TENANT_POOL_IDLE_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return defaultPoolIdleTimeout
+	}
+	return timeout
+}
+
+// Register routes the tenant to a pool opened apart, which the
+// resolver doesn't close.
+func (t *TenantResolver) Register(tenant string, db *sqlx.DB) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pools[tenant] = db
+}
+
+// DB returns the pool of the tenant, opening it on first use. Opening
+// doesn't connect, a missing database fails on the first query.
+func (t *TenantResolver) DB(ctx context.Context) (*sqlx.DB, error) {
+	tenant := Tenant(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if db, ok := t.pools[tenant]; ok {
+		return db, nil
+	}
+
+	if tenant == DefaultTenant || t.template == "" {
+		return t.fallback, nil
+	}
+
+	// The tenant ends up in the DSN, only safe characters get there.
+	if !ValidTenant(tenant) {
+		return nil, ErrInvalidTenant
+	}
+
+	if !KnownTenant(tenant) {
+		return nil, ErrUnknownTenant
+	}
+
+	now := t.now()
+	t.closeIdle(now)
+
+	if pool, ok := t.opened[tenant]; ok {
+		pool.lastUsed = now
+		return pool.db, nil
+	}
+
+	db, err := t.open(strings.ReplaceAll(t.template, TenantPlaceholder, tenant))
+	if err != nil {
+		return nil, err
+	}
+
+	t.opened[tenant] = &tenantPool{db: db, lastUsed: now}
+
+	return db, nil
+}
+
+// closeIdle closes the pools unused for the idle timeout, they are
+// opened again on the next request of their tenant.
+func (t *TenantResolver) closeIdle(now time.Time) {
+	for tenant, pool := range t.opened {
+		if now.Sub(pool.lastUsed) < t.idleTimeout {
+			continue
+		}
+
+		if err := pool.db.Close(); err != nil {
+			clog.Error(err, "failed to close the idle pool of tenant "+tenant)
+		}
+		delete(t.opened, tenant)
+	}
+}
+
+// Close closes the pools the resolver opened.
+func (t *TenantResolver) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	for _, pool := range t.opened {
+		if closeErr := pool.db.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	t.opened = map[string]*tenantPool{}
+	return err
+}
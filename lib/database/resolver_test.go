@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockDB(t *testing.T) *sqlx.DB {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return sqlx.NewDb(db, "sqlmock")
+}
+
+// openMockDB stands for the pools the resolver opens and closes.
+func openMockDB(t *testing.T) *sqlx.DB {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	mock.ExpectClose()
+	return sqlx.NewDb(db, "sqlmock")
+}
+
+func TestSingleDB(t *testing.T) {
+	shared := newMockDB(t)
+	defer shared.Close()
+
+	resolver := SingleDB(shared)
+
+	for _, tenant := range []string{"", "acme", "globex"} {
+		db, err := resolver.DB(WithTenant(context.Background(), tenant))
+		assert.NoError(t, err)
+		assert.Same(t, shared, db)
+	}
+}
+
+func TestTenantResolver(t *testing.T) {
+	fallback := newMockDB(t)
+	defer fallback.Close()
+
+	registered := newMockDB(t)
+	defer registered.Close()
+
+	t.Setenv("TENANTS", "acme,globex")
+
+	var dsns []string
+
+	resolver := NewTenantResolver(fallback, "user:pass@tcp(db)/hexagony_{tenant}?parseTime=true")
+	resolver.open = func(dsn string) (*sqlx.DB, error) {
+		dsns = append(dsns, dsn)
+		return openMockDB(t), nil
+	}
+	resolver.Register("initech", registered)
+
+	resolve := func(tenant string) *sqlx.DB {
+		db, err := resolver.DB(WithTenant(context.Background(), tenant))
+		assert.NoError(t, err)
+		return db
+	}
+
+	acme := resolve("acme")
+	globex := resolve("globex")
+
+	assert.NotSame(t, acme, globex)
+	assert.NotSame(t, fallback, acme)
+	assert.Same(t, acme, resolve("acme"))
+	assert.Same(t, fallback, resolve(""))
+	assert.Same(t, registered, resolve("initech"))
+	assert.Equal(t, []string{
+		"user:pass@tcp(db)/hexagony_acme?parseTime=true",
+		"user:pass@tcp(db)/hexagony_globex?parseTime=true",
+	}, dsns)
+
+	assert.NoError(t, resolver.Close())
+}
+
+func TestTenantResolverLimits(t *testing.T) {
+	fallback := newMockDB(t)
+	defer fallback.Close()
+
+	t.Setenv("TENANT_DSN_TEMPLATE", "/hexagony_{tenant}")
+
+	resolver := NewTenantResolver(fallback, "/hexagony_{tenant}")
+	resolver.open = func(dsn string) (*sqlx.DB, error) {
+		return openMockDB(t), nil
+	}
+
+	_, err := resolver.DB(WithTenant(context.Background(), "acme)/other?"))
+	assert.Equal(t, ErrInvalidTenant, err)
+
+	// Without a list only the default tenant is known.
+	_, err = resolver.DB(WithTenant(context.Background(), "acme"))
+	assert.Equal(t, ErrUnknownTenant, err)
+
+	db, err := resolver.DB(WithTenant(context.Background(), DefaultTenant))
+	assert.NoError(t, err)
+	assert.Same(t, fallback, db)
+
+	t.Setenv("TENANTS", "acme")
+
+	_, err = resolver.DB(WithTenant(context.Background(), "acme"))
+	assert.NoError(t, err)
+
+	_, err = resolver.DB(WithTenant(context.Background(), "junk-1"))
+	assert.Equal(t, ErrUnknownTenant, err)
+	assert.Len(t, resolver.opened, 1)
+
+	assert.NoError(t, resolver.Close())
+
+	// Without a template every tenant shares the fallback.
+	shared := NewTenantResolver(fallback, "")
+
+	db, err = shared.DB(WithTenant(context.Background(), "globex"))
+	assert.NoError(t, err)
+	assert.Same(t, fallback, db)
+}
+
+func TestTenantResolverIdle(t *testing.T) {
+	fallback := newMockDB(t)
+	defer fallback.Close()
+
+	t.Setenv("TENANTS", "acme,globex")
+	t.Setenv("TENANT_POOL_IDLE_TIMEOUT", "5m")
+
+	now := time.Date(2022, 6, 19, 16, 53, 9, 0, time.UTC)
+
+	resolver := NewTenantResolver(fallback, "/hexagony_{tenant}")
+	resolver.now = func() time.Time { return now }
+	resolver.open = func(dsn string) (*sqlx.DB, error) {
+		return openMockDB(t), nil
+	}
+
+	resolve := func(tenant string) *sqlx.DB {
+		db, err := resolver.DB(WithTenant(context.Background(), tenant))
+		assert.NoError(t, err)
+		return db
+	}
+
+	acme := resolve("acme")
+	globex := resolve("globex")
+
+	now = now.Add(4 * time.Minute)
+	assert.Same(t, acme, resolve("acme"))
+
+	// globex was left idle for 5 minutes, acme was used a minute ago.
+	now = now.Add(time.Minute)
+	assert.Same(t, acme, resolve("acme"))
+	assert.NotContains(t, resolver.opened, "globex")
+	assert.Error(t, globex.Ping(), "the idle pool is closed")
+
+	reopened := resolve("globex")
+	assert.NotSame(t, globex, reopened)
+
+	assert.NoError(t, resolver.Close())
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"fmt"
+	"hexagony/lib/clog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultSlowQuery is the threshold when SLOW_QUERY_MS is unset.
+const defaultSlowQuery = 200 * time.Millisecond
+
+// SlowQueryThreshold reads SLOW_QUERY_MS, the duration in milliseconds
+// past which a query is logged as slow. Zero disables the log.
+func SlowQueryThreshold() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("SLOW_QUERY_MS"))
+	if err != nil || ms < 0 {
+		return defaultSlowQuery
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// LogSlowQuery warns when the operation started at start took longer
+// than the threshold. Only the name of the operation is logged, e.g.
+// users.FindByID, never the SQL nor its arguments, which may hold
+// personal data. It's meant to be deferred:
+//
+//	defer database.LogSlowQuery("users.FindByID", time.Now())
+func LogSlowQuery(operation string, start time.Time) {
+	threshold := SlowQueryThreshold()
+	if threshold == 0 {
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed > threshold {
+		clog.Warn(fmt.Sprintf("slow query: %s took %s", operation, elapsed.Round(time.Millisecond)))
+	}
+}
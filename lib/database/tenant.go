@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+)
+
+// DefaultTenant owns the users created before the tenants, and the
+// requests that don't name one.
+const DefaultTenant = "default"
+
+// maxTenantID is the size of the tenant_id column.
+const maxTenantID = 36
+
+// WithTenant returns a context whose queries are scoped to the tenant.
+// An empty id is the DefaultTenant.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// Tenant returns the tenant the queries made with the context are
+// scoped to, the DefaultTenant when none was set.
+func Tenant(ctx context.Context) string {
+	if id, _ := ctx.Value(tenantKey).(string); id != "" {
+		return id
+	}
+	return DefaultTenant
+}
+
+// ValidTenant checks the id fits the tenant_id column and only has
+// letters, digits, - and _.
+func ValidTenant(id string) bool {
+	if id == "" || len(id) > maxTenantID {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// KnownTenant checks the tenant can be served: the default one and
+// those listed in TENANTS, e.g. "acme,globex". Without the list only
+// the default tenant is known, so the ids sent by clients can neither
+// open pools nor reach tenants nobody configured.
+func KnownTenant(id string) bool {
+	if id == DefaultTenant {
+		return true
+	}
+
+	for _, tenant := range strings.Split(os.Getenv("TENANTS"), ",") {
+		if strings.TrimSpace(tenant) == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HostTenant returns the tenant TENANT_HOSTS maps the host to, e.g.
+// "acme.example.com=acme,globex.example.com=globex", empty when it
+// isn't mapped. The port is ignored and the hosts are matched
+// case-insensitively.
+func HostTenant(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+
+	for _, mapping := range strings.Split(os.Getenv("TENANT_HOSTS"), ",") {
+		name, tenant, ok := strings.Cut(mapping, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(name), host) {
+			return strings.TrimSpace(tenant)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenant(t *testing.T) {
+	assert.Equal(t, DefaultTenant, Tenant(context.Background()))
+	assert.Equal(t, DefaultTenant, Tenant(WithTenant(context.Background(), "")))
+	assert.Equal(t, "acme", Tenant(WithTenant(context.Background(), "acme")))
+}
+
+func TestValidTenant(t *testing.T) {
+	assert.True(t, ValidTenant("acme"))
+	assert.True(t, ValidTenant("7d31461a-6ed5-425e-96fe-fa98e56d6828"))
+	assert.False(t, ValidTenant(""))
+	assert.False(t, ValidTenant("acme corp"))
+	assert.False(t, ValidTenant("acme'--"))
+	assert.False(t, ValidTenant(strings.Repeat("a", 37)))
+}
+
+func TestKnownTenant(t *testing.T) {
+	assert.True(t, KnownTenant(DefaultTenant))
+	assert.False(t, KnownTenant("acme"))
+
+	t.Setenv("TENANTS", "acme, globex")
+
+	assert.True(t, KnownTenant("acme"))
+	assert.True(t, KnownTenant("globex"))
+	assert.False(t, KnownTenant("initech"))
+}
+
+func TestHostTenant(t *testing.T) {
+	assert.Empty(t, HostTenant("acme.example.com"))
+
+	t.Setenv("TENANT_HOSTS", "acme.example.com=acme, globex.example.com = globex")
+
+	assert.Equal(t, "acme", HostTenant("acme.example.com"))
+	assert.Equal(t, "acme", HostTenant("Acme.Example.com:8443"))
+	assert.Equal(t, "globex", HostTenant("globex.example.com"))
+	assert.Empty(t, HostTenant("example.com"))
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"io"
+	"strings"
+)
+
+var ErrDomainBlocked = errors.New("the domain of the email is not allowed")
+
+// disposableDomains is the default blocklist, throwaway domains
+// favored by spam signups.
+//
+//go:embed disposable_domains.txt
+var disposableDomains string
+
+// DisposableDomains returns the default blocklist.
+func DisposableDomains() []string {
+	domains, _ := ParseList(strings.NewReader(disposableDomains))
+	return domains
+}
+
+// Blocklist rejects the addresses of some domains and of their
+// subdomains, except the addresses explicitly allowed.
+type Blocklist struct {
+	domains map[string]bool
+	allowed map[string]bool
+}
+
+// NewBlocklist blocks the domains, but not the allowed addresses.
+// Both are compared case-insensitively.
+func NewBlocklist(domains, allowed []string) *Blocklist {
+	b := &Blocklist{domains: map[string]bool{}, allowed: map[string]bool{}}
+
+	for _, domain := range domains {
+		b.domains[strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")] = true
+	}
+	for _, address := range allowed {
+		b.allowed[strings.ToLower(strings.TrimSpace(address))] = true
+	}
+
+	return b
+}
+
+// Check returns ErrDomainBlocked if the domain of the address, or one
+// of its parents, is blocked and the address isn't allowed.
+func (b *Blocklist) Check(address string) error {
+	if b.allowed[strings.ToLower(strings.TrimSpace(address))] {
+		return nil
+	}
+
+	for domain := Domain(address); domain != ""; {
+		if b.domains[domain] {
+			return ErrDomainBlocked
+		}
+
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+
+	return nil
+}
+
+// ParseList reads one entry per line, skipping blank lines and the
+// comments starting with #.
+func ParseList(r io.Reader) ([]string, error) {
+	var entries []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,35 @@
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocklist(t *testing.T) {
+	blocklist := NewBlocklist(DisposableDomains(), []string{"QA@Mailinator.com"})
+
+	t.Run("blocked", func(t *testing.T) {
+		assert.ErrorIs(t, blocklist.Check("spam@mailinator.com"), ErrDomainBlocked)
+		assert.ErrorIs(t, blocklist.Check("spam@YopMail.COM"), ErrDomainBlocked)
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		assert.NoError(t, blocklist.Check("xorycx@gmail.com"))
+		assert.NoError(t, blocklist.Check("xorycx@notmailinator.com"))
+		assert.NoError(t, blocklist.Check("qa@mailinator.com"))
+	})
+
+	t.Run("subdomain", func(t *testing.T) {
+		assert.ErrorIs(t, blocklist.Check("spam@eu.mx.mailinator.com"), ErrDomainBlocked)
+	})
+}
+
+func TestParseList(t *testing.T) {
+	entries, err := ParseList(strings.NewReader("# comment\nexample.com\n\n  spam.test  \n"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "spam.test"}, entries)
+	assert.Contains(t, DisposableDomains(), "mailinator.com")
+}
@@ -0,0 +1,90 @@
+// Package email checks the addresses given at signup.
+package email
+
+import (
+	"context"
+	"errors"
+	"hexagony/lib/cache"
+	"hexagony/lib/clog"
+	"net"
+	"strings"
+	"time"
+)
+
+var ErrNoMX = errors.New("the domain of the email doesn't accept mail")
+
+// Resolver looks up the MX records of a domain, net.DefaultResolver
+// is one.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// MXChecker verifies that the domain of an address has MX records.
+// The answers are cached per domain.
+type MXChecker struct {
+	resolver Resolver
+	timeout  time.Duration
+	domains  *cache.Cache
+}
+
+// NewMXChecker returns a checker giving up on a lookup after timeout
+// and caching the answers for ttl.
+func NewMXChecker(resolver Resolver, timeout, ttl time.Duration) *MXChecker {
+	return &MXChecker{
+		resolver: resolver,
+		timeout:  timeout,
+		domains:  cache.New(ttl),
+	}
+}
+
+// Check returns ErrNoMX when the domain of the address has no MX
+// record, or only a null MX (RFC 7505). It fails open: a lookup that
+// errors or times out is logged and lets the address through.
+func (c *MXChecker) Check(ctx context.Context, address string) error {
+	domain := Domain(address)
+	if domain == "" {
+		return nil
+	}
+
+	if found, ok := c.domains.Get(domain); ok {
+		return mxError(found.(bool))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	records, err := c.resolver.LookupMX(ctx, domain)
+
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		clog.Error(err, "failed to look up the MX records of "+domain)
+		return nil
+	}
+
+	found := false
+	for _, record := range records {
+		if record.Host != "." && record.Host != "" {
+			found = true
+		}
+	}
+
+	c.domains.Set(domain, found)
+	return mxError(found)
+}
+
+func mxError(found bool) error {
+	if !found {
+		return ErrNoMX
+	}
+	return nil
+}
+
+// Domain returns the domain of the address, in lower case and without
+// a trailing dot, or an empty string if it has none.
+func Domain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(address[at+1:])), ".")
+}
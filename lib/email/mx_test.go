@@ -0,0 +1,74 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver answers from a map of domains, counting the lookups.
+type fakeResolver struct {
+	records map[string][]*net.MX
+	err     error
+	lookups int
+}
+
+func (f *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	f.lookups++
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	records, ok := f.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestMXChecker(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]*net.MX{
+		"gmail.com":   {{Host: "gmail-smtp-in.l.google.com.", Pref: 5}},
+		"nomail.test": {{Host: ".", Pref: 0}},
+	}}
+	checker := NewMXChecker(resolver, time.Second, time.Minute)
+
+	t.Run("valid", func(t *testing.T) {
+		assert.NoError(t, checker.Check(context.TODO(), "xorycx@Gmail.com"))
+	})
+
+	t.Run("no-mx", func(t *testing.T) {
+		assert.ErrorIs(t, checker.Check(context.TODO(), "xorycx@gmial.com"), ErrNoMX)
+		assert.ErrorIs(t, checker.Check(context.TODO(), "xorycx@nomail.test"), ErrNoMX)
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		lookups := resolver.lookups
+
+		assert.NoError(t, checker.Check(context.TODO(), "john@gmail.com"))
+		assert.ErrorIs(t, checker.Check(context.TODO(), "john@gmial.com"), ErrNoMX)
+		assert.Equal(t, lookups, resolver.lookups)
+	})
+
+	t.Run("resolver-error", func(t *testing.T) {
+		failing := &fakeResolver{err: errors.New("i/o timeout")}
+		checker := NewMXChecker(failing, time.Second, time.Minute)
+
+		assert.NoError(t, checker.Check(context.TODO(), "xorycx@gmail.com"))
+
+		// Failures aren't cached, the next signup tries again.
+		assert.NoError(t, checker.Check(context.TODO(), "xorycx@gmail.com"))
+		assert.Equal(t, 2, failing.lookups)
+	})
+}
+
+func TestDomain(t *testing.T) {
+	assert.Equal(t, "gmail.com", Domain("XoryCX@Gmail.COM."))
+	assert.Equal(t, "example.com", Domain(`"a@b"@example.com`))
+	assert.Equal(t, "", Domain("xorycx"))
+}
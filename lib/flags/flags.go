@@ -0,0 +1,119 @@
+// Package flags turns features on and off without removing their
+// code, e.g. to dark-launch an endpoint. A flag is on or off by the
+// default given to Define, FEATURE_FLAGS overrides it, e.g.
+//
+//	FEATURE_FLAGS=impersonation=false,user-import
+//
+// where a bare name turns the flag on, and Set overrides both at
+// runtime. Handlers can check Enabled for finer gating.
+package flags
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Impersonation gates POST /user/{uuid}/impersonate.
+const Impersonation = "impersonation"
+
+var (
+	mu       sync.RWMutex
+	defaults = make(map[string]bool)
+	toggles  = make(map[string]bool)
+)
+
+// Define declares a flag with its default. Call it while setting up
+// the routes.
+func Define(name string, on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	defaults[name] = on
+}
+
+// Defined reports whether the flag was declared with Define.
+func Defined(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	_, ok := defaults[name]
+	return ok
+}
+
+// Enabled reports whether the flag is on. The flags never defined are
+// off.
+func Enabled(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if on, ok := toggles[name]; ok {
+		return on
+	}
+
+	if on, ok := Parse(os.Getenv("FEATURE_FLAGS"))[name]; ok {
+		return on
+	}
+
+	return defaults[name]
+}
+
+// Set turns the flag on or off until the restart, whatever
+// FEATURE_FLAGS says.
+func Set(name string, on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	toggles[name] = on
+}
+
+// Reset drops the runtime value of the flag, see Set.
+func Reset(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(toggles, name)
+}
+
+// Names lists the defined flags, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Parse reads a comma-separated list of flags, each a bare name for on
+// or name=bool. The entries with an invalid bool are ignored.
+func Parse(s string) map[string]bool {
+	values := make(map[string]bool)
+
+	for _, entry := range strings.Split(s, ",") {
+		name, value, hasValue := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if !hasValue {
+			values[name] = true
+			continue
+		}
+
+		on, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		values[name] = on
+	}
+
+	return values
+}
@@ -0,0 +1,48 @@
+package flags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert.Equal(t, map[string]bool{
+		"impersonation": false,
+		"user-import":   true,
+		"beta":          true,
+	}, Parse(" impersonation=false, user-import ,beta=1,invalid=maybe,,"))
+
+	assert.Empty(t, Parse(""))
+}
+
+func TestEnabled(t *testing.T) {
+	defer Reset("dark")
+	defer Reset("launched")
+
+	Define("dark", false)
+	Define("launched", true)
+
+	assert.True(t, Defined("dark"))
+	assert.False(t, Defined("unknown"))
+	assert.Contains(t, Names(), "dark")
+
+	assert.False(t, Enabled("dark"))
+	assert.True(t, Enabled("launched"))
+	assert.False(t, Enabled("unknown"))
+
+	t.Setenv("FEATURE_FLAGS", "dark,launched=false")
+
+	assert.True(t, Enabled("dark"))
+	assert.False(t, Enabled("launched"))
+
+	Set("dark", false)
+	Set("launched", true)
+
+	assert.False(t, Enabled("dark"))
+	assert.True(t, Enabled("launched"))
+
+	Reset("dark")
+
+	assert.True(t, Enabled("dark"))
+}
@@ -0,0 +1,20 @@
+package idgen
+
+import (
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// New returns a new id: a random UUID v4 by default, or a time-ordered
+// UUID v7 when UUID_VERSION is 7, which keeps the primary key index
+// append-only instead of inserting all over the B-tree.
+func New() uuid.UUID {
+	if os.Getenv("UUID_VERSION") == "7" {
+		if id, err := uuid.NewV7(); err == nil {
+			return id
+		}
+	}
+
+	return uuid.New()
+}
@@ -0,0 +1,33 @@
+package idgen
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	assert.Equal(t, uuid.Version(4), New().Version())
+
+	os.Setenv("UUID_VERSION", "4")
+	defer os.Unsetenv("UUID_VERSION")
+
+	assert.Equal(t, uuid.Version(4), New().Version())
+}
+
+func TestNewV7(t *testing.T) {
+	os.Setenv("UUID_VERSION", "7")
+	defer os.Unsetenv("UUID_VERSION")
+
+	ids := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		id := New()
+		assert.Equal(t, uuid.Version(7), id.Version())
+		ids = append(ids, id.String())
+	}
+
+	assert.True(t, sort.StringsAreSorted(ids))
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// ErrBodyRequired is returned when the request has no body to decode.
+var ErrBodyRequired = errors.New("body required")
+
+// DecodeJSON decodes the request body into dest. When the body can't be
+// decoded it writes a client error saying what is wrong with it and
+// returns false, so the handler only has to return:
+//
+//   - an empty body answers 400 "body required"
+//   - malformed JSON answers 400 with the offset of the syntax error
+//   - a value of the wrong type answers 422 with the field name
+//
+// encoding/json bounds the nesting depth, so deeply nested bodies are
+// reported as malformed rather than exhausting the stack.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dest interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(dest)
+	if err == nil {
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		DecodeError(w, r, ErrBodyRequired, http.StatusBadRequest)
+	case errors.As(err, &syntaxErr):
+		DecodeError(w, r, fmt.Errorf("malformed JSON at offset %d", syntaxErr.Offset), http.StatusBadRequest)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		DecodeError(w, r, errors.New("malformed JSON, the body ends unexpectedly"), http.StatusBadRequest)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		DecodeError(w, r, fmt.Errorf("the %s field must be %s", typeErr.Field, jsonType(typeErr.Type)), http.StatusUnprocessableEntity)
+	case errors.As(err, &typeErr):
+		DecodeError(w, r, fmt.Errorf("the body must be %s", jsonType(typeErr.Type)), http.StatusUnprocessableEntity)
+	default:
+		DecodeError(w, r, err, http.StatusBadRequest)
+	}
+
+	return false
+}
+
+// jsonType names the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return "a string"
+	}
+}
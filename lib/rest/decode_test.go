@@ -0,0 +1,132 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type payload struct {
+		Name   string   `json:"name"`
+		Age    int      `json:"age"`
+		Active *bool    `json:"active"`
+		Tags   []string `json:"tags"`
+	}
+
+	cases := []struct {
+		name    string
+		body    string
+		ok      bool
+		code    int
+		message string
+	}{
+		{"valid", `{"name":"Cyro Dubeux","age":30}`, true, 0, ""},
+		{"empty", ``, false, http.StatusBadRequest, "body required"},
+		{"syntax", `{"name" "Cyro Dubeux"}`, false, http.StatusBadRequest, "malformed JSON at offset 9"},
+		{"truncated", `{"name":`, false, http.StatusBadRequest, "malformed JSON, the body ends unexpectedly"},
+		{"string-for-number", `{"age":"30"}`, false, http.StatusUnprocessableEntity, "the age field must be a number"},
+		{"number-for-string", `{"name":30}`, false, http.StatusUnprocessableEntity, "the name field must be a string"},
+		{"string-for-boolean", `{"active":"yes"}`, false, http.StatusUnprocessableEntity, "the active field must be a boolean"},
+		{"string-for-array", `{"tags":"a"}`, false, http.StatusUnprocessableEntity, "the tags field must be an array"},
+		{"array-for-object", `[]`, false, http.StatusUnprocessableEntity, "the body must be an object"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+			rec := httptest.NewRecorder()
+
+			var dest payload
+			ok := DecodeJSON(rec, req, &dest)
+
+			assert.Equal(t, c.ok, ok)
+			if c.ok {
+				assert.Equal(t, "Cyro Dubeux", dest.Name)
+				return
+			}
+
+			assert.Equal(t, c.code, rec.Code)
+
+			var message Message
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&message))
+			assert.Equal(t, c.message, message.Message)
+			assert.Equal(t, c.code, message.Status)
+		})
+	}
+}
+
+// fuzzPayload covers the kinds of fields the request payloads use.
+type fuzzPayload struct {
+	Name     string                 `json:"name"`
+	Username *string                `json:"username"`
+	Length   int                    `json:"length"`
+	Count    Int64                  `json:"count"`
+	Enabled  *bool                  `json:"enabled"`
+	Scopes   []string               `json:"scopes"`
+	Extra    map[string]interface{} `json:"extra"`
+	Raw      json.RawMessage        `json:"raw"`
+	Nested   *fuzzPayload           `json:"nested"`
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	seeds := []string{
+		``,
+		` `,
+		`null`,
+		`{}`,
+		`[]`,
+		`"name"`,
+		`{"name":"Cyro Dubeux","length":3,"count":"9007199254740993","scopes":["users:read"]}`,
+		`{"name":`,
+		`{"name":"Cyro`,
+		`{"name" "Cyro Dubeux"}`,
+		`{"length":"3"}`,
+		`{"length":1e400}`,
+		`{"count":null}`,
+		`{"count":"12a"}`,
+		`{"enabled":"yes"}`,
+		`{"scopes":"users:read"}`,
+		`{"nested":{"nested":{"nested":{"name":1}}}}`,
+		`{"extra":{"a":[[[[[[[[[[{}]]]]]]]]]]}}`,
+		`{"raw":` + strings.Repeat("[", 20000) + strings.Repeat("]", 20000) + `}`,
+		`{"name":"\ud800"}`,
+		`{"name":"a"}{"name":"b"}`,
+		"{\"name\":\"\x00\xff\"}",
+	}
+
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+
+		var dest fuzzPayload
+		if DecodeJSON(rec, req, &dest) {
+			if rec.Body.Len() != 0 {
+				t.Fatalf("nothing should be written on success, got %q", rec.Body.String())
+			}
+			return
+		}
+
+		if rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("unexpected status %d for %q", rec.Code, body)
+		}
+
+		var message Message
+		if err := json.Unmarshal(rec.Body.Bytes(), &message); err != nil {
+			t.Fatalf("the error isn't a message: %v", err)
+		}
+
+		if message.Message == "" || message.Status != rec.Code {
+			t.Fatalf("unexpected message %+v for %q", message, body)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package rest
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+)
+
+// Int64 is an integer JavaScript clients may not hold exactly: their
+// numbers are float64, precise up to 2^53. With JSON_INT64_AS_STRING
+// enabled it's written as a JSON string, e.g. "9007199254740993", for
+// every value so the field keeps a single type. It reads both forms.
+type Int64 int64
+
+func (i Int64) MarshalJSON() ([]byte, error) {
+	if os.Getenv("JSON_INT64_AS_STRING") == "true" {
+		return json.Marshal(strconv.FormatInt(int64(i), 10))
+	}
+	return json.Marshal(int64(i))
+}
+
+// UnmarshalJSON leaves the value untouched on null, as encoding/json
+// does for the builtin types.
+func (i *Int64) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var value json.Number
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := strconv.ParseInt(value.String(), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*i = Int64(parsed)
+	return nil
+}
@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type counter struct {
+	Count Int64 `json:"count"`
+}
+
+func TestInt64(t *testing.T) {
+	large := counter{Count: 1<<53 + 1}
+
+	t.Run("number", func(t *testing.T) {
+		payload, err := json.Marshal(large)
+
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"count":9007199254740993}`, string(payload))
+	})
+
+	t.Run("string", func(t *testing.T) {
+		os.Setenv("JSON_INT64_AS_STRING", "true")
+		defer os.Unsetenv("JSON_INT64_AS_STRING")
+
+		payload, err := json.Marshal(large)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"count":"9007199254740993"}`, string(payload))
+
+		payload, err = json.Marshal(counter{Count: 3})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"count":"3"}`, string(payload))
+	})
+
+	t.Run("read", func(t *testing.T) {
+		for _, payload := range []string{`{"count":9007199254740993}`, `{"count":"9007199254740993"}`} {
+			var c counter
+
+			assert.NoError(t, json.Unmarshal([]byte(payload), &c))
+			assert.Equal(t, large, c)
+		}
+
+		var c counter
+		assert.Error(t, json.Unmarshal([]byte(`{"count":"many"}`), &c))
+
+		c = large
+		assert.NoError(t, json.Unmarshal([]byte(`{"count":null}`), &c))
+		assert.Equal(t, large, c)
+	})
+}
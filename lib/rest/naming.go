@@ -0,0 +1,202 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// FieldCase is a naming convention of the JSON field names.
+type FieldCase string
+
+const (
+	// SnakeCase names the fields like their tags, e.g. created_at.
+	SnakeCase FieldCase = "snake"
+	// CamelCase names them in lower camel case, e.g. createdAt.
+	CamelCase FieldCase = "camel"
+)
+
+// snakeName is a json tag name in snake_case.
+var snakeName = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+
+// ResponseFieldCase is the case of the response field names, set by
+// JSON_FIELD_CASE. The tags are snake_case, which is the default.
+func ResponseFieldCase() FieldCase {
+	if FieldCase(strings.ToLower(os.Getenv("JSON_FIELD_CASE"))) == CamelCase {
+		return CamelCase
+	}
+
+	return SnakeCase
+}
+
+// MarshalCase returns the JSON encoding of v, its field names in the
+// given case. The order of the fields and the values are kept as is.
+func MarshalCase(v interface{}, c FieldCase) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || c != CamelCase {
+		return data, err
+	}
+
+	return camelKeys(data), nil
+}
+
+// camelKeys rewrites the object keys of compact JSON to camel case. A
+// key is a string followed by a colon, the other strings are values.
+func camelKeys(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+
+	for i := 0; i < len(data); i++ {
+		if data[i] != '"' {
+			out = append(out, data[i])
+			continue
+		}
+
+		end := i + 1
+		for ; end < len(data) && data[end] != '"'; end++ {
+			if data[end] == '\\' {
+				end++
+			}
+		}
+
+		str := data[i : end+1]
+		if end+1 < len(data) && data[end+1] == ':' {
+			str = camel(str)
+		}
+
+		out = append(out, str...)
+		i = end
+	}
+
+	return out
+}
+
+// camel drops the underscores of a snake_case name, capitalizing the
+// letter after them.
+func camel(name []byte) []byte {
+	out := make([]byte, 0, len(name))
+
+	for i := 0; i < len(name); i++ {
+		if name[i] == '_' && i > 1 && i+1 < len(name) && name[i+1] >= 'a' && name[i+1] <= 'z' {
+			out = append(out, name[i+1]-'a'+'A')
+			i++
+			continue
+		}
+
+		out = append(out, name[i])
+	}
+
+	return out
+}
+
+// prettyIndent indents the pretty-printed responses.
+const prettyIndent = "  "
+
+// PrettyJSON tells whether the responses are indented, set by
+// PRETTY_JSON=true. It's meant for development: compact responses spare
+// bandwidth, so it's off by default.
+func PrettyJSON() bool {
+	return os.Getenv("PRETTY_JSON") == "true"
+}
+
+// Encoder writes JSON values followed by a newline, like json.Encoder,
+// their field names in ResponseFieldCase, indented with PrettyJSON.
+type Encoder struct {
+	w      io.Writer
+	json   *json.Encoder
+	c      FieldCase
+	pretty bool
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	e := &Encoder{w: w, json: json.NewEncoder(w), c: ResponseFieldCase(), pretty: PrettyJSON()}
+	if e.pretty {
+		e.json.SetIndent("", prettyIndent)
+	}
+	return e
+}
+
+// Encode writes the JSON encoding of v.
+func (e *Encoder) Encode(v interface{}) error {
+	if e.c == SnakeCase {
+		return e.json.Encode(v)
+	}
+
+	data, err := MarshalCase(v, e.c)
+	if err != nil {
+		return err
+	}
+
+	if e.pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", prettyIndent); err != nil {
+			return err
+		}
+		data = indented.Bytes()
+	}
+
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+// UntaggedFields lists the exported fields of the struct v, and of the
+// structs it holds, lacking a json tag or whose tag name isn't
+// snake_case, as Type.Field. Without a tag encoding/json would send the
+// Go name, so the response structs are checked by the tests. Embedded
+// structs without a tag are fine, their fields are promoted, and the
+// fields of the types encoding themselves aren't checked.
+func UntaggedFields(v interface{}) []string {
+	var fields []string
+	checkTags(reflect.TypeOf(v), true, map[reflect.Type]bool{}, &fields)
+
+	return fields
+}
+
+func checkTags(t reflect.Type, root bool, seen map[reflect.Type]bool, fields *[]string) {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct || seen[t] || !root && customJSON(t) {
+		return
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		tag, ok := field.Tag.Lookup("json")
+		name := strings.Split(tag, ",")[0]
+
+		switch {
+		case name == "-":
+			continue
+		case field.Anonymous && name == "":
+		case !ok:
+			*fields = append(*fields, fmt.Sprintf("%s.%s: no json tag", t.Name(), field.Name))
+		case !snakeName.MatchString(name):
+			*fields = append(*fields, fmt.Sprintf("%s.%s: %q isn't snake_case", t.Name(), field.Name, name))
+		}
+
+		checkTags(field.Type, false, seen, fields)
+	}
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// customJSON tells if t, or its pointer, encodes or decodes itself.
+func customJSON(t reflect.Type) bool {
+	ptr := reflect.PtrTo(t)
+	return ptr.Implements(marshalerType) || ptr.Implements(unmarshalerType)
+}
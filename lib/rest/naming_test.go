@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type named struct {
+	ID        string            `json:"id"`
+	CreatedAt Time              `json:"created_at"`
+	AvatarURL *string           `json:"avatar_url,omitempty"`
+	Tags      map[string]string `json:"tags"`
+}
+
+func TestMarshalCase(t *testing.T) {
+	v := named{
+		ID:        "last_name",
+		CreatedAt: Time(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)),
+		Tags:      map[string]string{"first_name": `a "b":`},
+	}
+
+	snake, err := MarshalCase(v, SnakeCase)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"last_name","created_at":"2022-01-02T03:04:05Z","tags":{"first_name":"a \"b\":"}}`, string(snake))
+
+	camel, err := MarshalCase(v, CamelCase)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"last_name","createdAt":"2022-01-02T03:04:05Z","tags":{"firstName":"a \"b\":"}}`, string(camel))
+}
+
+func TestEncoder(t *testing.T) {
+	v := named{ID: "1"}
+
+	var buf bytes.Buffer
+	assert.NoError(t, NewEncoder(&buf).Encode(v))
+	assert.Contains(t, buf.String(), `"created_at"`)
+
+	os.Setenv("JSON_FIELD_CASE", "camel")
+	defer os.Unsetenv("JSON_FIELD_CASE")
+
+	buf.Reset()
+	assert.NoError(t, NewEncoder(&buf).Encode(v))
+	assert.Contains(t, buf.String(), `"createdAt"`)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("}\n")))
+}
+
+func TestUntaggedFields(t *testing.T) {
+	assert.Empty(t, UntaggedFields(&named{}))
+	assert.Empty(t, UntaggedFields(&Envelope{}))
+	assert.Empty(t, UntaggedFields(&Problem{}))
+
+	type inner struct {
+		Untagged string
+	}
+
+	type embedded struct {
+		Name string `json:"name"`
+	}
+
+	type bad struct {
+		embedded
+		CreatedAt time.Time `json:"createdAt"`
+		Skipped   string    `json:"-"`
+		Inner     []inner   `json:"inner"`
+		private   string
+	}
+
+	assert.Equal(t, []string{
+		`bad.CreatedAt: "createdAt" isn't snake_case`,
+		"inner.Untagged: no json tag",
+	}, UntaggedFields(bad{private: "ok"}))
+}
@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProblemMediaType is the media type of the RFC 7807 problem documents.
+const ProblemMediaType = "application/problem+json"
+
+// Problem is an RFC 7807 problem document, sent in place of a Message
+// to the clients accepting ProblemMediaType, or to all of them when
+// PROBLEM_JSON is enabled.
+//
+//	{"type": "/problems/not-found", "title": "Not Found", "status": 404,
+//	 "detail": "the resource you requested could not be found", "instance": "/user/7d31..."}
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// problemTypes maps the registered errors to their problem type.
+var problemTypes = map[error]string{}
+
+// RegisterProblemType gives an error, usually a domain sentinel, the
+// problem type /problems/{name}, e.g. /problems/not-found. The other
+// errors are about:blank. Call it while setting up the routes, before
+// the server starts.
+func RegisterProblemType(err error, name string) {
+	problemTypes[err] = "/problems/" + name
+}
+
+// problemType returns the type registered for the error, or for one
+// it wraps.
+func problemType(err error) string {
+	if uri, ok := problemTypes[err]; ok {
+		return uri
+	}
+
+	for registered, uri := range problemTypes {
+		if errors.Is(err, registered) {
+			return uri
+		}
+	}
+
+	return "about:blank"
+}
+
+// wantsProblem checks if PROBLEM_JSON is enabled or the Accept header
+// lists ProblemMediaType.
+func wantsProblem(r *http.Request) bool {
+	if os.Getenv("PROBLEM_JSON") == "true" {
+		return true
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accept)
+		if err != nil || mediaType != ProblemMediaType {
+			continue
+		}
+
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// problem writes err as a problem document.
+func problem(w http.ResponseWriter, r *http.Request, err error, httpCode int) {
+	w.Header().Set("Content-Type", ProblemMediaType)
+	w.WriteHeader(httpCode)
+
+	document := &Problem{
+		Type:     problemType(err),
+		Title:    http.StatusText(httpCode),
+		Status:   httpCode,
+		Detail:   err.Error(),
+		Instance: r.URL.RequestURI(),
+	}
+	if err := NewEncoder(w).Encode(document); err != nil {
+		return
+	}
+}
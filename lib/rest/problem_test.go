@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errNotFound = errors.New("the resource you requested could not be found")
+
+func TestDecodeErrorProblem(t *testing.T) {
+	RegisterProblemType(errNotFound, "not-found")
+	defer delete(problemTypes, errNotFound)
+
+	t.Run("not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/user/7d31a2f0?fields=name", nil)
+		req.Header.Set("Accept", "application/json, application/problem+json")
+		rec := httptest.NewRecorder()
+
+		DecodeError(rec, req, errNotFound, http.StatusNotFound)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, ProblemMediaType, rec.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"type": "/problems/not-found",
+			"title": "Not Found",
+			"status": 404,
+			"detail": "the resource you requested could not be found",
+			"instance": "/user/7d31a2f0?fields=name"
+		}`, rec.Body.String())
+	})
+
+	t.Run("wrapped", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/user/7d31a2f0", nil)
+		req.Header.Set("Accept", ProblemMediaType)
+		rec := httptest.NewRecorder()
+
+		DecodeError(rec, req, fmt.Errorf("find user: %w", errNotFound), http.StatusNotFound)
+
+		var document Problem
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		assert.Equal(t, "/problems/not-found", document.Type)
+	})
+
+	t.Run("unregistered", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Set("Accept", ProblemMediaType)
+		rec := httptest.NewRecorder()
+
+		DecodeError(rec, req, errors.New("boom"), http.StatusInternalServerError)
+
+		var document Problem
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		assert.Equal(t, "about:blank", document.Type)
+		assert.Equal(t, "Internal Server Error", document.Title)
+		assert.Equal(t, http.StatusInternalServerError, document.Status)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		os.Setenv("PROBLEM_JSON", "true")
+		defer os.Unsetenv("PROBLEM_JSON")
+
+		req := httptest.NewRequest(http.MethodGet, "/user/7d31a2f0", nil)
+		rec := httptest.NewRecorder()
+
+		DecodeError(rec, req, errNotFound, http.StatusNotFound)
+
+		assert.Equal(t, ProblemMediaType, rec.Header().Get("Content-Type"))
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/user/7d31a2f0", nil)
+		req.Header.Set("Accept", "application/json, application/problem+json;q=0")
+		rec := httptest.NewRecorder()
+
+		DecodeError(rec, req, errNotFound, http.StatusNotFound)
+
+		assert.NotEqual(t, ProblemMediaType, rec.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"message":"the resource you requested could not be found","status":404}`, rec.Body.String())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/user/7d31a2f0", nil)
+		rec := httptest.NewRecorder()
+
+		DecodeError(rec, req, errNotFound, http.StatusNotFound)
+
+		assert.JSONEq(t, `{"message":"the resource you requested could not be found","status":404}`, rec.Body.String())
+	})
+}
@@ -1,22 +1,67 @@
 package rest
 
 import (
-	"encoding/json"
+	"context"
+	"hexagony/lib/buildinfo"
+	"mime"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
+// EnvelopeProfile is the Accept profile a client sends to receive
+// enveloped responses, e.g. `Accept: application/json; profile="envelope"`.
+const EnvelopeProfile = "envelope"
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
 // Message is a struct for generic JSON response.
 type Message struct {
 	Message string `json:"message,omitempty"`
 	Status  int    `json:"status,omitempty"`
 }
 
-// DecodeError returns unsuccessful JSON error message.
+// Envelope is a struct for enveloped JSON responses.
+//
+//	{"data": ..., "meta": {"count": 2}}
+//
+// Meta is only present for lists, unless RESPONSE_META is enabled.
+type Envelope struct {
+	Data interface{} `json:"data"`
+	Meta *Meta       `json:"meta,omitempty"`
+}
+
+// Meta is a struct for the metadata of enveloped responses: the count
+// of the lists and, when RESPONSE_META is enabled, the request they
+// answer.
+type Meta struct {
+	Count     *int   `json:"count,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Timestamp *Time  `json:"timestamp,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// WithRequestID returns a context whose enveloped responses carry the
+// id of the request in their meta.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// DecodeError returns unsuccessful JSON error message, or a problem
+// document when the client wants one, see Problem.
 func DecodeError(w http.ResponseWriter, r *http.Request, err error, httpCode int) {
+	if wantsProblem(r) {
+		problem(w, r, err, httpCode)
+		return
+	}
+
 	w.WriteHeader(httpCode)
 
 	errorMessage := &Message{err.Error(), httpCode}
-	if err := json.NewEncoder(w).Encode(errorMessage); err != nil {
+	if err := NewEncoder(w).Encode(errorMessage); err != nil {
 		return
 	}
 }
@@ -24,7 +69,66 @@ func DecodeError(w http.ResponseWriter, r *http.Request, err error, httpCode int
 // JSON returns a successful JSON message.
 func JSON(w http.ResponseWriter, httpCode int, dest interface{}) {
 	w.WriteHeader(httpCode)
-	if err := json.NewEncoder(w).Encode(dest); err != nil {
+	if err := NewEncoder(w).Encode(dest); err != nil {
+		return
+	}
+}
+
+// JSONResource returns a single resource. It is raw unless the
+// client asks for the envelope profile or RESPONSE_META is enabled.
+func JSONResource(w http.ResponseWriter, r *http.Request, httpCode int, dest interface{}) {
+	meta := requestMeta(r)
+	if !acceptsEnvelope(r) && meta == nil {
+		JSON(w, httpCode, dest)
+		return
+	}
+
+	JSON(w, httpCode, &Envelope{Data: dest, Meta: meta})
+}
+
+// JSONList returns a list of resources. It is enveloped along with
+// its count when the client asks for the envelope profile or when
+// RESPONSE_ENVELOPE or RESPONSE_META is enabled.
+func JSONList(w http.ResponseWriter, r *http.Request, httpCode int, dest interface{}, count int) {
+	meta := requestMeta(r)
+	if !acceptsEnvelope(r) && os.Getenv("RESPONSE_ENVELOPE") != "true" && meta == nil {
+		JSON(w, httpCode, dest)
 		return
 	}
+
+	if meta == nil {
+		meta = &Meta{}
+	}
+	meta.Count = &count
+
+	JSON(w, httpCode, &Envelope{Data: dest, Meta: meta})
+}
+
+// requestMeta describes the request being answered, nil unless
+// RESPONSE_META is enabled.
+func requestMeta(r *http.Request) *Meta {
+	if os.Getenv("RESPONSE_META") != "true" {
+		return nil
+	}
+
+	id, _ := r.Context().Value(requestIDKey).(string)
+	now := Time(time.Now())
+
+	return &Meta{RequestID: id, Timestamp: &now, Version: buildinfo.Version}
+}
+
+// acceptsEnvelope checks if the Accept header carries the envelope profile.
+func acceptsEnvelope(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+
+		if params["profile"] == EnvelopeProfile {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,165 @@
+package rest
+
+import (
+	"encoding/json"
+	"hexagony/lib/buildinfo"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+func TestJSONList(t *testing.T) {
+	list := []item{{Name: "foo"}, {Name: "bar"}}
+
+	t.Run("raw", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+
+		JSONList(rec, req, http.StatusOK, list, len(list))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[{"name":"foo"},{"name":"bar"}]`, rec.Body.String())
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", `application/json; profile="envelope"`)
+		rec := httptest.NewRecorder()
+
+		JSONList(rec, req, http.StatusOK, list, len(list))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"data":[{"name":"foo"},{"name":"bar"}],"meta":{"count":2}}`, rec.Body.String())
+	})
+
+	t.Run("config", func(t *testing.T) {
+		os.Setenv("RESPONSE_ENVELOPE", "true")
+		defer os.Unsetenv("RESPONSE_ENVELOPE")
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+
+		JSONList(rec, req, http.StatusOK, list, len(list))
+
+		assert.JSONEq(t, `{"data":[{"name":"foo"},{"name":"bar"}],"meta":{"count":2}}`, rec.Body.String())
+	})
+}
+
+func TestJSONResource(t *testing.T) {
+	os.Setenv("RESPONSE_ENVELOPE", "true")
+	defer os.Unsetenv("RESPONSE_ENVELOPE")
+
+	t.Run("raw", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+
+		JSONResource(rec, req, http.StatusOK, item{Name: "foo"})
+
+		assert.JSONEq(t, `{"name":"foo"}`, rec.Body.String())
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", `text/html, application/json;profile=envelope`)
+		rec := httptest.NewRecorder()
+
+		JSONResource(rec, req, http.StatusOK, item{Name: "foo"})
+
+		assert.JSONEq(t, `{"data":{"name":"foo"}}`, rec.Body.String())
+	})
+}
+
+func TestJSONMeta(t *testing.T) {
+	os.Setenv("RESPONSE_META", "true")
+	defer os.Unsetenv("RESPONSE_META")
+
+	serve := func(respond func(w http.ResponseWriter, r *http.Request)) map[string]json.RawMessage {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(WithRequestID(req.Context(), "3f2a9c1e-request"))
+		rec := httptest.NewRecorder()
+
+		respond(rec, req)
+
+		var envelope map[string]json.RawMessage
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &envelope))
+		return envelope
+	}
+
+	meta := func(raw json.RawMessage) Meta {
+		var meta Meta
+		assert.NoError(t, json.Unmarshal(raw, &meta))
+		return meta
+	}
+
+	t.Run("resource", func(t *testing.T) {
+		envelope := serve(func(w http.ResponseWriter, r *http.Request) {
+			JSONResource(w, r, http.StatusOK, item{Name: "foo"})
+		})
+
+		assert.JSONEq(t, `{"name":"foo"}`, string(envelope["data"]))
+
+		m := meta(envelope["meta"])
+		assert.Equal(t, "3f2a9c1e-request", m.RequestID)
+		assert.Equal(t, buildinfo.Version, m.Version)
+		assert.Nil(t, m.Count)
+		assert.WithinDuration(t, time.Now(), time.Time(*m.Timestamp), 2*time.Second)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		envelope := serve(func(w http.ResponseWriter, r *http.Request) {
+			JSONList(w, r, http.StatusOK, []item{}, 0)
+		})
+
+		assert.JSONEq(t, `[]`, string(envelope["data"]))
+
+		m := meta(envelope["meta"])
+		assert.Equal(t, "3f2a9c1e-request", m.RequestID)
+		assert.Equal(t, 0, *m.Count)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		os.Unsetenv("RESPONSE_META")
+		defer os.Setenv("RESPONSE_META", "true")
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", `application/json; profile="envelope"`)
+		req = req.WithContext(WithRequestID(req.Context(), "3f2a9c1e-request"))
+		rec := httptest.NewRecorder()
+
+		JSONResource(rec, req, http.StatusOK, item{Name: "foo"})
+
+		assert.JSONEq(t, `{"data":{"name":"foo"}}`, rec.Body.String())
+	})
+}
+
+func TestJSONPretty(t *testing.T) {
+	dest := map[string]interface{}{"name": "foo", "tags": []string{"a"}}
+
+	rec := httptest.NewRecorder()
+	JSON(rec, http.StatusOK, dest)
+
+	assert.Equal(t, `{"name":"foo","tags":["a"]}`+"\n", rec.Body.String())
+
+	os.Setenv("PRETTY_JSON", "true")
+	defer os.Unsetenv("PRETTY_JSON")
+
+	for _, fieldCase := range []string{"snake", "camel"} {
+		t.Run(fieldCase, func(t *testing.T) {
+			os.Setenv("JSON_FIELD_CASE", fieldCase)
+			defer os.Unsetenv("JSON_FIELD_CASE")
+
+			rec := httptest.NewRecorder()
+			JSON(rec, http.StatusOK, dest)
+
+			assert.Equal(t, "{\n  \"name\": \"foo\",\n  \"tags\": [\n    \"a\"\n  ]\n}\n", rec.Body.String())
+		})
+	}
+}
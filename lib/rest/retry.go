@@ -0,0 +1,26 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetRetryAfter tells the client to retry after the duration, sent in
+// whole seconds, rounded up and at least one so clients don't retry at
+// once.
+func SetRetryAfter(w http.ResponseWriter, after time.Duration) {
+	seconds := int64((after + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+// RetryLater answers err with the status, e.g. a 503 or a 429, along
+// with a Retry-After, which clients and load balancers back off by.
+func RetryLater(w http.ResponseWriter, r *http.Request, err error, httpCode int, after time.Duration) {
+	SetRetryAfter(w, after)
+	DecodeError(w, r, err, httpCode)
+}
@@ -0,0 +1,38 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRetryAfter(t *testing.T) {
+	for after, expected := range map[time.Duration]string{
+		-time.Second:            "1",
+		0:                       "1",
+		time.Millisecond:        "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		2 * time.Minute:         "120",
+	} {
+		rec := httptest.NewRecorder()
+		SetRetryAfter(rec, after)
+
+		assert.Equal(t, expected, rec.Header().Get("Retry-After"), after.String())
+	}
+}
+
+func TestRetryLater(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+
+	RetryLater(rec, req, errors.New("try again later"), http.StatusServiceUnavailable, 30*time.Second)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"message":"try again later","status":503}`, rec.Body.String())
+}
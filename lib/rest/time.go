@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"os"
+	"time"
+)
+
+// Time is written in RFC 3339, in UTC and to the second, e.g.
+// "2022-06-06T10:00:00Z", whatever the location the database returned
+// it in. It reads the formats accepted by ParseTime.
+type Time time.Time
+
+func (t Time) MarshalText() ([]byte, error) {
+	return t.AppendText(make([]byte, 0, len(time.RFC3339)))
+}
+
+// AppendText appends the time to b, without allocating.
+func (t Time) AppendText(b []byte) ([]byte, error) {
+	return time.Time(t).UTC().AppendFormat(b, time.RFC3339), nil
+}
+
+func (t *Time) UnmarshalText(data []byte) error {
+	parsed, err := ParseTime(string(data))
+	if err != nil {
+		return err
+	}
+
+	*t = Time(parsed)
+	return nil
+}
+
+// inputLayouts are the layouts without a zone ParseTime accepts, they
+// are read in the APP_TIMEZONE.
+var inputLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Timezone reads APP_TIMEZONE, the IANA location of the times given
+// without an offset, e.g. America/Sao_Paulo. It's UTC when unset or
+// unknown.
+func Timezone() *time.Location {
+	location, err := time.LoadLocation(os.Getenv("APP_TIMEZONE"))
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// ParseTime reads a time in RFC 3339, with or without fractional
+// seconds, as a date and time without zone, e.g. 2022-06-06T10:00:00 or
+// 2022-06-06 10:00:00, or as a date YYYY-MM-DD. The times without zone
+// are in the APP_TIMEZONE, the offset of RFC 3339 is always respected.
+// The result is in UTC.
+func ParseTime(value string) (time.Time, error) {
+	location := Timezone()
+
+	for _, layout := range inputLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, location); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+
+	// RFC 3339 is tried last, its error tells the most about what's wrong.
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.UTC(), nil
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTime(t *testing.T) {
+	local := time.FixedZone("BRT", -3*60*60)
+	value := time.Date(2022, 6, 6, 7, 30, 15, 123456789, local)
+
+	payload, err := json.Marshal(Time(value))
+	assert.NoError(t, err)
+	assert.Equal(t, `"2022-06-06T10:30:15Z"`, string(payload))
+
+	var decoded Time
+	assert.NoError(t, json.Unmarshal(payload, &decoded))
+	assert.True(t, value.Truncate(time.Second).Equal(time.Time(decoded)))
+
+	assert.Error(t, json.Unmarshal([]byte(`"yesterday"`), &decoded))
+}
+
+func TestParseTime(t *testing.T) {
+	expected := time.Date(2022, 6, 6, 10, 30, 0, 0, time.UTC)
+
+	for _, value := range []string{
+		"2022-06-06T10:30:00Z",
+		"2022-06-06T07:30:00-03:00",
+		"2022-06-06T10:30:00.000Z",
+		"2022-06-06T10:30:00",
+		"2022-06-06 10:30:00",
+	} {
+		parsed, err := ParseTime(value)
+		if assert.NoError(t, err, value) {
+			assert.Equal(t, expected, parsed, value)
+		}
+	}
+
+	parsed, err := ParseTime("2022-06-06")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC), parsed)
+
+	_, err = ParseTime("06/06/2022")
+	assert.Error(t, err)
+}
+
+func TestParseTimeTimezone(t *testing.T) {
+	defer os.Unsetenv("APP_TIMEZONE")
+
+	for timezone, expected := range map[string]time.Time{
+		"America/Sao_Paulo": time.Date(2022, 6, 6, 13, 30, 0, 0, time.UTC),
+		"Asia/Tokyo":        time.Date(2022, 6, 6, 1, 30, 0, 0, time.UTC),
+		"Nowhere/Unknown":   time.Date(2022, 6, 6, 10, 30, 0, 0, time.UTC),
+	} {
+		os.Setenv("APP_TIMEZONE", timezone)
+
+		parsed, err := ParseTime("2022-06-06T10:30:00")
+		if assert.NoError(t, err, timezone) {
+			assert.Equal(t, expected, parsed, timezone)
+			assert.Equal(t, time.UTC, parsed.Location(), timezone)
+		}
+
+		parsed, err = ParseTime("2022-06-06T10:30:00-03:00")
+		if assert.NoError(t, err, timezone) {
+			assert.Equal(t, time.Date(2022, 6, 6, 13, 30, 0, 0, time.UTC), parsed, timezone)
+		}
+	}
+
+	os.Setenv("APP_TIMEZONE", "Asia/Tokyo")
+
+	parsed, err := ParseTime("2022-06-06")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 5, 15, 0, 0, 0, time.UTC), parsed)
+}
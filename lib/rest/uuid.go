@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"encoding/hex"
+
+	"github.com/google/uuid"
+)
+
+// UUID is written like uuid.UUID, e.g. "7d31461a-6ed5-425e-96fe-fa98e56d6828",
+// but without allocating when the encoder appends text values, which
+// matters for long lists. Other encoders fall back to MarshalText.
+type UUID uuid.UUID
+
+func (u UUID) MarshalText() ([]byte, error) {
+	return u.AppendText(make([]byte, 0, 36))
+}
+
+// AppendText appends the canonical form of the uuid to b.
+func (u UUID) AppendText(b []byte) ([]byte, error) {
+	var buf [36]byte
+
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+
+	return append(b, buf[:]...), nil
+}
+
+func (u *UUID) UnmarshalText(data []byte) error {
+	return (*uuid.UUID)(u).UnmarshalText(data)
+}
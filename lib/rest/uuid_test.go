@@ -0,0 +1,26 @@
+package rest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUUID(t *testing.T) {
+	id := uuid.MustParse("7d31461a-6ed5-425e-96fe-fa98e56d6828")
+
+	expected, err := json.Marshal(id)
+	assert.NoError(t, err)
+
+	payload, err := json.Marshal(UUID(id))
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), string(payload))
+
+	var decoded UUID
+	assert.NoError(t, json.Unmarshal(payload, &decoded))
+	assert.Equal(t, UUID(id), decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`"invalid"`), &decoded))
+}
@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Locker elects the instance running a job for an interval, so the
+// replicas sharing it don't all run the job.
+type Locker interface {
+	// Acquire takes the lock of the job until ttl elapses, unless
+	// another holder has it. There's no release: the lock expiring on
+	// its own, a crashed holder only blocks the job for ttl.
+	Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error)
+}
+
+// MemoryLocker is a Locker for the schedulers of a single process.
+type MemoryLocker struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewMemoryLocker returns a locker without locks.
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{now: time.Now, expires: map[string]time.Time{}}
+}
+
+// Acquire takes the lock unless it's held and not expired.
+func (l *MemoryLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Before(l.expires[name]) {
+		return false, nil
+	}
+
+	l.expires[name] = now.Add(ttl)
+	return true, nil
+}
+
+const (
+	// sqlAcquireLock takes the lock when it's missing or expired, or
+	// renews it for its holder. The holder is assigned first, so the
+	// expiry only moves when it's ours. The database clock is the only
+	// one compared, the ones of the instances may drift.
+	sqlAcquireLock = `
+		INSERT INTO job_locks (name, holder, expires_at)
+		VALUES (?, ?, NOW(3) + INTERVAL ? MICROSECOND)
+		ON DUPLICATE KEY UPDATE
+			holder = IF(expires_at <= NOW(3), VALUES(holder), holder),
+			expires_at = IF(holder = VALUES(holder), VALUES(expires_at), expires_at)`
+
+	sqlLockHolder = "SELECT holder FROM job_locks WHERE name=?"
+)
+
+// DB runs the queries of MariaDBLocker, *sqlx.DB and *sql.DB are ones.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// MariaDBLocker is a Locker shared by the instances of the database,
+// storing the locks in the job_locks table.
+type MariaDBLocker struct {
+	db     DB
+	holder string
+}
+
+// NewMariaDBLocker returns a locker holding the locks as this instance,
+// named after the host and a random id.
+func NewMariaDBLocker(db DB) *MariaDBLocker {
+	host, _ := os.Hostname()
+	return &MariaDBLocker{db: db, holder: host + "/" + uuid.NewString()}
+}
+
+// Acquire takes the lock when it's free for ttl, then reads who holds
+// it, the upsert not telling.
+func (l *MariaDBLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	if _, err := l.db.ExecContext(ctx, sqlAcquireLock, name, l.holder, ttl.Microseconds()); err != nil {
+		return false, err
+	}
+
+	var holder string
+	if err := l.db.QueryRowContext(ctx, sqlLockHolder, name).Scan(&holder); err != nil {
+		return false, err
+	}
+
+	return holder == l.holder, nil
+}
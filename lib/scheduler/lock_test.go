@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLocker(t *testing.T) {
+	now := time.Date(2022, 6, 19, 16, 53, 9, 0, time.UTC)
+
+	l := NewMemoryLocker()
+	l.now = func() time.Time { return now }
+
+	for _, expected := range []bool{true, false} {
+		acquired, err := l.Acquire(context.TODO(), "job", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, acquired)
+	}
+
+	// Another job has its own lock.
+	acquired, _ := l.Acquire(context.TODO(), "other", time.Minute)
+	assert.True(t, acquired)
+
+	// The holder crashed, the lock expires anyway.
+	now = now.Add(time.Minute)
+
+	acquired, _ = l.Acquire(context.TODO(), "job", time.Minute)
+	assert.True(t, acquired)
+}
+
+func TestTwoInstances(t *testing.T) {
+	now := time.Date(2022, 6, 19, 16, 53, 9, 0, time.UTC)
+
+	locker := NewMemoryLocker()
+	locker.now = func() time.Time { return now }
+
+	var runs [2]int32
+
+	instances := make([]*Scheduler, 2)
+	for i := range instances {
+		i := i
+
+		instances[i] = New()
+		instances[i].Locker = locker
+		instances[i].Register("purge", time.Minute, func(ctx context.Context) error {
+			atomic.AddInt32(&runs[i], 1)
+			return nil
+		})
+	}
+
+	// Both instances tick in each of the three intervals.
+	for interval := 0; interval < 3; interval++ {
+		for _, instance := range instances {
+			instance.runOnce(context.TODO(), instance.jobs[0])
+		}
+		now = now.Add(time.Minute)
+	}
+
+	assert.Equal(t, int32(3), runs[0]+runs[1])
+	assert.Equal(t, [2]int32{3, 0}, runs)
+}
+
+func TestTwoInstancesRunning(t *testing.T) {
+	const every = 20 * time.Millisecond
+
+	locker := NewMemoryLocker()
+
+	var runs int32
+
+	instances := make([]*Scheduler, 2)
+	for i := range instances {
+		instances[i] = New()
+		instances[i].Jitter = 0
+		instances[i].Locker = locker
+		instances[i].Register("purge", every, func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		})
+		instances[i].Start(context.Background())
+	}
+
+	time.Sleep(5*every + every/2)
+
+	for _, instance := range instances {
+		assert.NoError(t, instance.Stop(context.Background()))
+	}
+
+	// Unlocked, the two would run it about ten times.
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&runs), int32(2))
+	assert.LessOrEqual(t, atomic.LoadInt32(&runs), int32(5))
+}
+
+type failingLocker struct{}
+
+func (failingLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	return false, errors.New("database down")
+}
+
+func TestLockerFailure(t *testing.T) {
+	ran := false
+
+	s := New()
+	s.Locker = failingLocker{}
+	s.Register("purge", time.Minute, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	s.runOnce(context.TODO(), s.jobs[0])
+
+	assert.False(t, ran)
+	assert.Zero(t, s.Stats()["purge"].Runs)
+}
+
+func TestMariaDBLocker(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	l := NewMariaDBLocker(db)
+
+	for holder, expected := range map[string]bool{l.holder: true, "other/instance": false} {
+		mock.ExpectExec("INSERT INTO job_locks").
+			WithArgs("purge", l.holder, int64(3600000000)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT holder FROM job_locks WHERE name=\\?").
+			WithArgs("purge").
+			WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow(holder))
+
+		acquired, err := l.Acquire(context.TODO(), "purge", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, acquired, holder)
+	}
+
+	mock.ExpectExec("INSERT INTO job_locks").WillReturnError(errors.New("database down"))
+
+	acquired, err := l.Acquire(context.TODO(), "purge", time.Hour)
+	assert.Error(t, err)
+	assert.False(t, acquired)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
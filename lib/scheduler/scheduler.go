@@ -0,0 +1,221 @@
+// Package scheduler runs background jobs, e.g. retention and cleanup,
+// at fixed intervals.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"hexagony/lib/clog"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultJitter is the share of the interval the runs are delayed by at
+// most, so the instances started together don't run a job at once.
+const defaultJitter = 0.1
+
+// Job is the work run at each interval. It must return once ctx is
+// done, which happens on shutdown.
+type Job func(ctx context.Context) error
+
+// Stats counts the runs of a job.
+type Stats struct {
+	Runs         int
+	Failures     int
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    error
+}
+
+type job struct {
+	name  string
+	every time.Duration
+	run   Job
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Scheduler runs the registered jobs each in their own goroutine. The
+// interval is counted from the end of the previous run, so the runs of
+// a job never overlap.
+type Scheduler struct {
+	// Jitter is the share of the interval each wait is lengthened by at
+	// most, 0 disabling it.
+	Jitter float64
+
+	// Locker, when set, lets a single instance run a job per interval,
+	// the others skipping it. The lock lasts the interval, the jobs
+	// must be shorter for their runs not to overlap across instances.
+	Locker Locker
+
+	mu      sync.Mutex
+	jobs    []*job
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+// New returns a scheduler without jobs.
+func New() *Scheduler {
+	return &Scheduler{Jitter: defaultJitter}
+}
+
+// Register adds the job, run every interval once the scheduler starts.
+// A zero or negative interval leaves the job out, which is how they are
+// disabled. The jobs registered after Start don't run.
+func (s *Scheduler) Register(name string, every time.Duration, run Job) {
+	if every <= 0 {
+		clog.Info("job " + name + " is disabled")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = append(s.jobs, &job{name: name, every: every, run: run})
+}
+
+// Start runs the jobs until ctx is done or Stop is called.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	for _, j := range s.jobs {
+		s.running.Add(1)
+		go s.loop(ctx, j)
+	}
+}
+
+// Stop cancels the jobs and waits for the running ones to return, at
+// most until ctx is done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the counts of the jobs by name.
+func (s *Scheduler) Stats() map[string]Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]Stats, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		stats[j.name] = j.stats
+		j.mu.Unlock()
+	}
+	return stats
+}
+
+// loop waits for the interval, then runs the job, until ctx is done.
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.running.Done()
+
+	timer := time.NewTimer(s.wait(j.every))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		s.runOnce(ctx, j)
+		timer.Reset(s.wait(j.every))
+	}
+}
+
+// wait is the interval lengthened by the jitter.
+func (s *Scheduler) wait(every time.Duration) time.Duration {
+	if s.Jitter <= 0 {
+		return every
+	}
+	return every + time.Duration(rand.Float64()*s.Jitter*float64(every))
+}
+
+// runOnce runs the job, recovering its panics, and logs the run. With
+// a Locker, the runs of the other instances are skipped, and so are
+// all of them when the lock can't be taken.
+func (s *Scheduler) runOnce(ctx context.Context, j *job) {
+	if s.Locker != nil {
+		acquired, err := s.Locker.Acquire(ctx, j.name, j.every)
+		if err != nil {
+			clog.Error(err, "failed to lock job "+j.name+", skipping its run")
+			return
+		}
+		if !acquired {
+			clog.Debug("job " + j.name + " runs on another instance")
+			return
+		}
+	}
+
+	started := time.Now()
+
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("job panicked: %v", p)
+			}
+		}()
+		return j.run(ctx)
+	}()
+
+	duration := time.Since(started)
+
+	j.mu.Lock()
+	j.stats.Runs++
+	j.stats.LastRun = started
+	j.stats.LastDuration = duration
+	j.stats.LastError = err
+	if err != nil {
+		j.stats.Failures++
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		clog.Error(err, "job "+j.name+" failed after "+duration.String())
+		return
+	}
+
+	clog.Custom(map[string]interface{}{
+		"message":     "job ran",
+		"job":         j.name,
+		"duration_ms": float64(duration) / float64(time.Millisecond),
+	})
+}
+
+// Interval reads the interval of a job from the environment variable,
+// falling back to the default when it's unset or invalid. Zero
+// disables the job, see Register.
+func Interval(env string, fallback time.Duration) time.Duration {
+	every, err := time.ParseDuration(os.Getenv(env))
+	if err != nil || every < 0 {
+		return fallback
+	}
+	return every
+}
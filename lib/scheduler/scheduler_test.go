@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCadence(t *testing.T) {
+	const every = 20 * time.Millisecond
+
+	var (
+		mu   sync.Mutex
+		runs []time.Time
+	)
+
+	s := New()
+	s.Jitter = 0
+	s.Register("tick", every, func(ctx context.Context) error {
+		mu.Lock()
+		runs = append(runs, time.Now())
+		mu.Unlock()
+		return nil
+	})
+
+	started := time.Now()
+	s.Start(context.Background())
+	time.Sleep(5*every + every/2)
+	assert.NoError(t, s.Stop(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.GreaterOrEqual(t, len(runs), 3)
+	assert.LessOrEqual(t, len(runs), 5)
+
+	previous := started
+	for _, run := range runs {
+		assert.GreaterOrEqual(t, run.Sub(previous), every)
+		previous = run
+	}
+
+	assert.Equal(t, len(runs), s.Stats()["tick"].Runs)
+}
+
+func TestStop(t *testing.T) {
+	var runs int32
+	running := make(chan struct{})
+
+	s := New()
+	s.Jitter = 0
+	s.Register("blocking", time.Millisecond, func(ctx context.Context) error {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			close(running)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	s.Start(context.Background())
+	<-running
+
+	assert.NoError(t, s.Stop(context.Background()))
+
+	// Nothing runs once stopped.
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+}
+
+func TestStopTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	running := make(chan struct{})
+
+	s := New()
+	s.Jitter = 0
+	s.Register("stuck", time.Millisecond, func(ctx context.Context) error {
+		close(running)
+		<-release
+		return nil
+	})
+
+	s.Start(context.Background())
+	<-running
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, s.Stop(ctx), context.DeadlineExceeded)
+}
+
+func TestNoOverlap(t *testing.T) {
+	var active, overlaps, runs int32
+
+	s := New()
+	s.Jitter = 0
+	s.Register("slow", time.Millisecond, func(ctx context.Context) error {
+		if atomic.AddInt32(&active, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	s.Start(context.Background())
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, s.Stop(context.Background()))
+
+	assert.Greater(t, atomic.LoadInt32(&runs), int32(1))
+	assert.Zero(t, atomic.LoadInt32(&overlaps))
+}
+
+func TestFailures(t *testing.T) {
+	var runs int32
+	done := make(chan struct{})
+
+	s := New()
+	s.Jitter = 0
+	s.Register("failing", time.Millisecond, func(ctx context.Context) error {
+		switch atomic.AddInt32(&runs, 1) {
+		case 1:
+			return errors.New("failed")
+		case 2:
+			panic("panicked")
+		case 3:
+			close(done)
+		}
+		return nil
+	})
+
+	s.Start(context.Background())
+	<-done
+	assert.NoError(t, s.Stop(context.Background()))
+
+	stats := s.Stats()["failing"]
+	assert.GreaterOrEqual(t, stats.Runs, 3)
+	assert.Equal(t, 2, stats.Failures)
+}
+
+func TestDisabled(t *testing.T) {
+	s := New()
+	s.Register("disabled", 0, func(ctx context.Context) error {
+		t.Fatal("the job shouldn't run")
+		return nil
+	})
+
+	assert.Empty(t, s.Stats())
+}
+
+func TestJitter(t *testing.T) {
+	s := New()
+
+	for i := 0; i < 100; i++ {
+		wait := s.wait(time.Second)
+		assert.GreaterOrEqual(t, wait, time.Second)
+		assert.LessOrEqual(t, wait, time.Second+100*time.Millisecond)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"":        time.Hour,
+		"invalid": time.Hour,
+		"-1m":     time.Hour,
+		"0":       0,
+		"15m":     15 * time.Minute,
+	} {
+		t.Setenv("JOB_INTERVAL", value)
+		assert.Equal(t, expected, Interval("JOB_INTERVAL", time.Hour), value)
+	}
+}
@@ -0,0 +1,72 @@
+// Package server builds the HTTP server of the API. The default
+// http.Server waits forever on slow clients, so every limit is set,
+// either from the environment or to a default.
+package server
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The limits used when the environment doesn't set them. The write
+// timeout outlasts the default REQUEST_TIMEOUT, see writeTimeout.
+const (
+	DefaultReadTimeout       = 5 * time.Second
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultWriteTimeout      = 35 * time.Second
+	DefaultIdleTimeout       = 20 * time.Second
+	DefaultMaxHeaderBytes    = 64 << 10
+)
+
+// writeMargin is the time left after REQUEST_TIMEOUT to write the 504
+// before the write deadline closes the connection.
+const writeMargin = 5 * time.Second
+
+// New returns the server listening on addr, with its timeouts read
+// from SERVER_READ_TIMEOUT, SERVER_READ_HEADER_TIMEOUT,
+// SERVER_WRITE_TIMEOUT and SERVER_IDLE_TIMEOUT and the size of the
+// headers bounded by SERVER_MAX_HEADER_BYTES.
+func New(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       duration("SERVER_READ_TIMEOUT", DefaultReadTimeout),
+		ReadHeaderTimeout: duration("SERVER_READ_HEADER_TIMEOUT", DefaultReadHeaderTimeout),
+		WriteTimeout:      writeTimeout(),
+		IdleTimeout:       duration("SERVER_IDLE_TIMEOUT", DefaultIdleTimeout),
+		MaxHeaderBytes:    maxHeaderBytes(),
+	}
+}
+
+// duration reads a timeout from the environment variable, falling
+// back to the default when it's unset, invalid or not positive: a
+// zero would leave the server without the limit.
+func duration(env string, fallback time.Duration) time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv(env))
+	if err != nil || timeout <= 0 {
+		return fallback
+	}
+	return timeout
+}
+
+// writeTimeout reads SERVER_WRITE_TIMEOUT. Unset, it's REQUEST_TIMEOUT
+// and a margin, so the requests timing out still get their 504; the
+// streamed responses lift it, see middleware.TimeoutMiddleware.
+func writeTimeout() time.Duration {
+	fallback := DefaultWriteTimeout
+	if timeout, err := time.ParseDuration(os.Getenv("REQUEST_TIMEOUT")); err == nil && timeout > 0 {
+		fallback = timeout + writeMargin
+	}
+
+	return duration("SERVER_WRITE_TIMEOUT", fallback)
+}
+
+func maxHeaderBytes() int {
+	size, err := strconv.Atoi(os.Getenv("SERVER_MAX_HEADER_BYTES"))
+	if err != nil || size <= 0 {
+		return DefaultMaxHeaderBytes
+	}
+	return size
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	handler := http.NewServeMux()
+
+	t.Run("defaults", func(t *testing.T) {
+		srv := New(":8000", handler)
+
+		assert.Equal(t, ":8000", srv.Addr)
+		assert.Equal(t, handler, srv.Handler)
+		assert.Equal(t, DefaultReadTimeout, srv.ReadTimeout)
+		assert.Equal(t, DefaultReadHeaderTimeout, srv.ReadHeaderTimeout)
+		assert.Equal(t, DefaultWriteTimeout, srv.WriteTimeout)
+		assert.Equal(t, DefaultIdleTimeout, srv.IdleTimeout)
+		assert.Equal(t, DefaultMaxHeaderBytes, srv.MaxHeaderBytes)
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("SERVER_READ_TIMEOUT", "10s")
+		t.Setenv("SERVER_READ_HEADER_TIMEOUT", "2s")
+		t.Setenv("SERVER_WRITE_TIMEOUT", "40s")
+		t.Setenv("SERVER_IDLE_TIMEOUT", "1m")
+		t.Setenv("SERVER_MAX_HEADER_BYTES", "8192")
+
+		srv := New(":8000", handler)
+
+		assert.Equal(t, 10*time.Second, srv.ReadTimeout)
+		assert.Equal(t, 2*time.Second, srv.ReadHeaderTimeout)
+		assert.Equal(t, 40*time.Second, srv.WriteTimeout)
+		assert.Equal(t, time.Minute, srv.IdleTimeout)
+		assert.Equal(t, 8192, srv.MaxHeaderBytes)
+	})
+
+	t.Run("request timeout", func(t *testing.T) {
+		t.Setenv("REQUEST_TIMEOUT", "1m")
+
+		assert.Equal(t, time.Minute+writeMargin, New(":8000", handler).WriteTimeout)
+
+		t.Setenv("SERVER_WRITE_TIMEOUT", "2m")
+
+		assert.Equal(t, 2*time.Minute, New(":8000", handler).WriteTimeout)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("SERVER_READ_TIMEOUT", "soon")
+		t.Setenv("SERVER_READ_HEADER_TIMEOUT", "0")
+		t.Setenv("SERVER_WRITE_TIMEOUT", "-1s")
+		t.Setenv("SERVER_MAX_HEADER_BYTES", "big")
+
+		srv := New(":8000", handler)
+
+		assert.Equal(t, DefaultReadTimeout, srv.ReadTimeout)
+		assert.Equal(t, DefaultReadHeaderTimeout, srv.ReadHeaderTimeout)
+		assert.Equal(t, DefaultWriteTimeout, srv.WriteTimeout)
+		assert.Equal(t, DefaultMaxHeaderBytes, srv.MaxHeaderBytes)
+	})
+}
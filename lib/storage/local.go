@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores the blobs as files under a directory, for a single
+// instance or a shared volume.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal stores the blobs under dir, downloaded from baseURL,
+// e.g. "/uploads" or "https://cdn.example.com".
+func NewLocal(dir, baseURL string) *Local {
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Put writes the body to a temporary file renamed over the key once
+// complete, so a failed upload never leaves a truncated blob.
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), name)
+}
+
+// Get guesses the content type from the extension of the key.
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, mime.TypeByExtension(filepath.Ext(name)), nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// Prefix is the path of the blob URLs when they are served by the API,
+// empty when baseURL points elsewhere.
+func (l *Local) Prefix() string {
+	if strings.HasPrefix(l.baseURL, "/") {
+		return l.baseURL
+	}
+	return ""
+}
+
+// Handler serves the blobs by key, relative to Prefix. It doesn't
+// list directories nor serve the temporary files of the uploads.
+func (l *Local) Handler() http.Handler {
+	files := http.FileServer(http.Dir(l.dir))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if _, err := cleanKey(key); err != nil || strings.HasPrefix(filepath.Base(key), ".") {
+			http.NotFound(w, r)
+			return
+		}
+
+		if info, err := os.Stat(filepath.Join(l.dir, filepath.FromSlash(key))); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		files.ServeHTTP(w, r)
+	})
+}
+
+func (l *Local) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
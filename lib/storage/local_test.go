@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLocal(dir, "/uploads/")
+
+	err := l.Put(context.TODO(), "avatars/1/a.png", strings.NewReader("png"), "image/png")
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "avatars", "1", "a.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "png", string(content))
+
+	assert.Equal(t, "/uploads/avatars/1/a.png", l.URL("avatars/1/a.png"))
+	assert.Equal(t, "/uploads", l.Prefix())
+
+	assert.NoError(t, l.Delete(context.TODO(), "avatars/1/a.png"))
+	assert.NoError(t, l.Delete(context.TODO(), "avatars/1/a.png"))
+
+	_, err = os.Stat(filepath.Join(dir, "avatars", "1", "a.png"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLocalInvalidKey(t *testing.T) {
+	l := NewLocal(t.TempDir(), "https://cdn.example.com")
+
+	for _, key := range []string{"", "/etc/passwd", "../a.png", "avatars/../../a.png", "avatars//a.png", "a\\b.png"} {
+		assert.Equal(t, ErrInvalidKey, l.Put(context.TODO(), key, strings.NewReader("png"), "image/png"), key)
+	}
+
+	assert.Empty(t, l.Prefix())
+}
+
+func TestLocalHandler(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLocal(dir, "/uploads")
+
+	assert.NoError(t, l.Put(context.TODO(), "avatars/1/a.png", strings.NewReader("png"), "image/png"))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "avatars", ".upload-1"), []byte("partial"), 0o644))
+
+	tests := map[string]int{
+		"/avatars/1/a.png":   http.StatusOK,
+		"/avatars/1/":        http.StatusNotFound,
+		"/avatars":           http.StatusNotFound,
+		"/avatars/.upload-1": http.StatusNotFound,
+		"/avatars/1/b.png":   http.StatusNotFound,
+	}
+
+	for path, status := range tests {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+
+		l.Handler().ServeHTTP(rr, req)
+
+		assert.Equal(t, status, rr.Code, path)
+	}
+}
@@ -0,0 +1,85 @@
+// Package storage keeps the files uploaded to the API, e.g. the
+// avatars, behind a BlobStorage port with pluggable backends.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrInvalidKey is returned for the keys that could escape the storage
+// root, e.g. with "..", or are empty.
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// ErrNotFound is returned by Get for a missing blob.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStorage stores blobs under slash separated keys.
+type BlobStorage interface {
+	// Put stores the body under the key, replacing any previous blob.
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get returns the content of the blob and its type, to be closed.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete removes the blob, deleting a missing one is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns where clients download the blob from.
+	URL(key string) string
+}
+
+// Presigner is implemented by the backends that can hand out
+// temporary download URLs of private blobs.
+type Presigner interface {
+	Presign(key string, expires time.Duration) (string, error)
+}
+
+// s3Timeout bounds a request to the S3 server.
+const s3Timeout = 30 * time.Second
+
+// FromEnv returns the backend picked by STORAGE_BACKEND, "local", the
+// default, or "s3". The local backend writes to STORAGE_DIR,
+// ./uploads when unset, served under STORAGE_PUBLIC_URL, /uploads when
+// unset. The s3 one is configured by the S3_ variables, see S3Config.
+func FromEnv() (BlobStorage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		return NewLocal(envOr("STORAGE_DIR", "./uploads"), envOr("STORAGE_PUBLIC_URL", "/uploads")), nil
+	case "s3":
+		s3, err := NewS3(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       os.Getenv("S3_PATH_STYLE") == "true",
+			PublicURL:       os.Getenv("STORAGE_PUBLIC_URL"),
+		}, &http.Client{Timeout: s3Timeout})
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	default:
+		return nil, errors.New("unknown STORAGE_BACKEND " + backend)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// cleanKey rejects the keys that aren't already clean relative paths.
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") {
+		return "", ErrInvalidKey
+	}
+	return key, nil
+}
@@ -0,0 +1,104 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token is expired")
+	ErrNotYet  = errors.New("token is not valid yet")
+	ErrRevoked = errors.New("token is revoked")
+)
+
+// defaultAlg is the algorithm accepted when JWT_ALG is unset, the one
+// used to sign the tokens issued by this service.
+const defaultAlg = "HS256"
+
+// Parse checks the signature of the token and validates its time claims,
+// allowing JWT_LEEWAY of clock skew between services. Only the algorithms
+// listed in JWT_ALG are accepted, "none" never is.
+func Parse(raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(algorithms()),
+		jwt.WithoutClaimsValidation(),
+	)
+
+	_, err := parser.ParseWithClaims(raw, claims, key)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	if err := validate(claims, time.Now(), leeway()); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validate checks exp, nbf and iat against now, tolerating the given leeway.
+func validate(claims jwt.MapClaims, now time.Time, leeway time.Duration) error {
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), false) {
+		return ErrExpired
+	}
+
+	if !claims.VerifyNotBefore(now.Add(leeway).Unix(), false) {
+		return ErrNotYet
+	}
+
+	if !claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		return ErrNotYet
+	}
+
+	return nil
+}
+
+// key returns the verification key for the token. It's chosen by the
+// family of the signing method, so a token can't pick a key of another
+// kind, e.g. an RSA public key used as an HMAC secret.
+func key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	case *jwt.SigningMethodRSA:
+		return jwt.ParseRSAPublicKeyFromPEM([]byte(os.Getenv("JWT_PUBLIC_KEY")))
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+}
+
+// algorithms reads the comma separated JWT_ALG allowlist, which defaults
+// to HS256. "none" is dropped even when listed.
+func algorithms() []string {
+	algs := []string{}
+
+	for _, alg := range strings.Split(os.Getenv("JWT_ALG"), ",") {
+		alg = strings.TrimSpace(alg)
+		if alg == "" || strings.EqualFold(alg, "none") {
+			continue
+		}
+		algs = append(algs, alg)
+	}
+
+	if len(algs) == 0 {
+		return []string{defaultAlg}
+	}
+	return algs
+}
+
+// leeway reads JWT_LEEWAY, which is zero when unset or invalid.
+func leeway() time.Duration {
+	leeway, err := time.ParseDuration(os.Getenv("JWT_LEEWAY"))
+	if err != nil || leeway < 0 {
+		return 0
+	}
+	return leeway
+}
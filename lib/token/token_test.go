@@ -0,0 +1,147 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+
+	return signed
+}
+
+func TestParse(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	claims, err := Parse(sign(t, jwt.MapClaims{
+		"id":  "7d31461a-6ed5-425e-96fe-fa98e56d6828",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "7d31461a-6ed5-425e-96fe-fa98e56d6828", claims["id"])
+
+	_, err = Parse("not-a-token")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	os.Setenv("JWT_SECRET", "other")
+	_, err = Parse(sign(t, jwt.MapClaims{"id": "7d31461a-6ed5-425e-96fe-fa98e56d6828"}))
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestParseAlgNone(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"id": "7d31461a-6ed5-425e-96fe-fa98e56d6828",
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+
+	_, err = Parse(unsigned)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	os.Setenv("JWT_ALG", "none")
+	defer os.Unsetenv("JWT_ALG")
+
+	_, err = Parse(unsigned)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestParseAlgConfusion(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	assert.NoError(t, err)
+	public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	os.Setenv("JWT_ALG", "RS256")
+	os.Setenv("JWT_PUBLIC_KEY", string(public))
+	defer os.Unsetenv("JWT_ALG")
+	defer os.Unsetenv("JWT_PUBLIC_KEY")
+
+	claims := jwt.MapClaims{"id": "7d31461a-6ed5-425e-96fe-fa98e56d6828"}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(private)
+	assert.NoError(t, err)
+
+	_, err = Parse(signed)
+	assert.NoError(t, err)
+
+	// The public key is no secret, so an HS256 token signed with it must
+	// not be accepted when RS256 is expected.
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(public)
+	assert.NoError(t, err)
+
+	_, err = Parse(forged)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestAlgorithms(t *testing.T) {
+	assert.Equal(t, []string{"HS256"}, algorithms())
+
+	os.Setenv("JWT_ALG", "HS512, none,RS256")
+	defer os.Unsetenv("JWT_ALG")
+
+	assert.Equal(t, []string{"HS512", "RS256"}, algorithms())
+}
+
+func TestParseLeeway(t *testing.T) {
+	os.Setenv("JWT_SECRET", "secret")
+	defer os.Unsetenv("JWT_SECRET")
+
+	expired := sign(t, jwt.MapClaims{"exp": time.Now().Add(-10 * time.Second).Unix()})
+
+	_, err := Parse(expired)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	os.Setenv("JWT_LEEWAY", "30s")
+	defer os.Unsetenv("JWT_LEEWAY")
+
+	_, err = Parse(expired)
+	assert.NoError(t, err)
+
+	_, err = Parse(sign(t, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}))
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+
+	// Decoded claims hold the numbers as float64.
+
+	cases := []struct {
+		name     string
+		claims   jwt.MapClaims
+		leeway   time.Duration
+		expected error
+	}{
+		{"no-time-claims", jwt.MapClaims{}, 0, nil},
+		{"expired", jwt.MapClaims{"exp": float64(now.Add(-time.Second).Unix())}, 0, ErrExpired},
+		{"expired-within-leeway", jwt.MapClaims{"exp": float64(now.Add(-time.Second).Unix())}, 30 * time.Second, nil},
+		{"expired-beyond-leeway", jwt.MapClaims{"exp": float64(now.Add(-time.Minute).Unix())}, 30 * time.Second, ErrExpired},
+		{"not-before", jwt.MapClaims{"nbf": float64(now.Add(10 * time.Second).Unix())}, 0, ErrNotYet},
+		{"not-before-within-leeway", jwt.MapClaims{"nbf": float64(now.Add(10 * time.Second).Unix())}, 30 * time.Second, nil},
+		{"issued-in-future", jwt.MapClaims{"iat": float64(now.Add(time.Minute).Unix())}, 30 * time.Second, ErrNotYet},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, validate(c.claims, now, c.leeway))
+		})
+	}
+}
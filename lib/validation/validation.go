@@ -2,10 +2,18 @@ package validation
 
 import (
 	"context"
-	"encoding/json"
+	"hexagony/lib/rest"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/pt_BR"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -13,37 +21,241 @@ import (
 type Validator interface {
 	BindStruct(ctx context.Context, data interface{}) error
 	BindField(ctx context.Context, data interface{}, tag string) error
-	DecodeError(w http.ResponseWriter, err error)
+	DecodeError(w http.ResponseWriter, r *http.Request, err error)
+	DecodeErrorStatus(w http.ResponseWriter, r *http.Request, err error, httpCode int)
 }
 
-// message is a struct for validation error messages.
+// message is a struct for validation error messages. Code is stable
+// across languages and wordings, clients should key off it. Label is
+// the name of the field to show in forms.
 type message struct {
+	Field   string `json:"field,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message,omitempty"`
 	Status  int    `json:"status,omitempty"`
 }
 
+// codes maps the validation tags to their error code, the tags not
+// listed are upper cased, e.g. uuid gives UUID.
+var codes = map[string]string{
+	"required":         "REQUIRED",
+	"required_without": "REQUIRED",
+	"email":            "EMAIL",
+	"alphanum":         "ALPHANUMERIC",
+	"min":              "MIN_LENGTH",
+	"gte":              "MIN_LENGTH",
+	"max":              "MAX_LENGTH",
+	"lte":              "MAX_LENGTH",
+}
+
+// code returns the error code of a validation tag.
+func code(tag string) string {
+	if code, ok := codes[tag]; ok {
+		return code
+	}
+	return strings.ToUpper(tag)
+}
+
 // errors type is a struct for multiple error messages.
 type errors struct {
 	Errors []*message `json:"errors"`
 }
 
-// errorMap improves error messages.
-func (v message) errorMap(err validator.FieldError) *message {
-	errMap := map[string]string{
-		"required": "is required",
-		"email":    "is not valid",
-		"min":      "minimum length is " + err.Param(),
-		"gte":      "minimum length is " + err.Param(),
+// language holds the messages of a supported language by validation tag.
+// {0} is the field and {1} the param of the rule. The labels translate
+// the fields by json name, the ones missing are labelled by it.
+type language struct {
+	locale   locales.Translator
+	tag      string
+	messages map[string]string
+	labels   map[string]string
+}
+
+// labelKey is the translation key of the label of a field, apart from
+// the ones of the messages.
+func labelKey(field string) string {
+	return "label." + field
+}
+
+// languages lists the supported languages, English being the default.
+var languages = []language{
+	{en.New(), "en", map[string]string{
+		"required":         "the {0} field is required",
+		"required_without": "the {0} field is required",
+		"email":            "the {0} field is not valid",
+		"alphanum":         "the {0} field must be alphanumeric",
+		"min":              "the {0} field minimum length is {1}",
+		"gte":              "the {0} field minimum length is {1}",
+		"max":              "the {0} field maximum length is {1}",
+	}, map[string]string{
+		"name":             "Name",
+		"email":            "Email",
+		"username":         "Username",
+		"password":         "Password",
+		"current_password": "Current password",
+		"identifier":       "Email or username",
+		"role":             "Role",
+		"length":           "Length",
+		"enabled":          "Enabled",
+		"service":          "Service",
+		"scopes":           "Scopes",
+	}},
+	{pt_BR.New(), "pt-BR", map[string]string{
+		"required":         "o campo {0} é obrigatório",
+		"required_without": "o campo {0} é obrigatório",
+		"email":            "o campo {0} não é válido",
+		"alphanum":         "o campo {0} deve ser alfanumérico",
+		"min":              "o tamanho mínimo do campo {0} é {1}",
+		"gte":              "o tamanho mínimo do campo {0} é {1}",
+		"max":              "o tamanho máximo do campo {0} é {1}",
+	}, map[string]string{
+		"name":             "Nome",
+		"email":            "E-mail",
+		"username":         "Nome de usuário",
+		"password":         "Senha",
+		"current_password": "Senha atual",
+		"identifier":       "E-mail ou nome de usuário",
+		"role":             "Perfil",
+		"length":           "Duração",
+		"enabled":          "Ativado",
+		"service":          "Serviço",
+		"scopes":           "Escopos",
+	}},
+	{es.New(), "es", map[string]string{
+		"required":         "el campo {0} es obligatorio",
+		"required_without": "el campo {0} es obligatorio",
+		"email":            "el campo {0} no es válido",
+		"alphanum":         "el campo {0} debe ser alfanumérico",
+		"min":              "la longitud mínima del campo {0} es {1}",
+		"gte":              "la longitud mínima del campo {0} es {1}",
+		"max":              "la longitud máxima del campo {0} es {1}",
+	}, map[string]string{
+		"name":             "Nombre",
+		"email":            "Correo electrónico",
+		"username":         "Nombre de usuario",
+		"password":         "Contraseña",
+		"current_password": "Contraseña actual",
+		"identifier":       "Correo electrónico o nombre de usuario",
+		"role":             "Rol",
+		"length":           "Duración",
+		"enabled":          "Activado",
+		"service":          "Servicio",
+		"scopes":           "Ámbitos",
+	}},
+}
+
+// validate is shared so the translations are registered once
+// and the struct metadata is cached between requests.
+var validate, translator = newValidate()
+
+func newValidate() (*validator.Validate, *ut.UniversalTranslator) {
+	validate := validator.New()
+	translator := ut.New(languages[0].locale)
+
+	// The errors name the fields by json name, the field of the error
+	// keeps the struct one.
+	validate.RegisterTagNameFunc(jsonName)
+
+	for _, lang := range languages {
+		if err := translator.AddTranslator(lang.locale, true); err != nil {
+			panic(err)
+		}
+
+		trans, _ := translator.GetTranslator(lang.locale.Locale())
+
+		for tag, text := range lang.messages {
+			tag, text := tag, text
+
+			register := func(trans ut.Translator) error {
+				return trans.Add(tag, text, true)
+			}
+
+			translate := func(trans ut.Translator, err validator.FieldError) string {
+				msg, _ := trans.T(tag, strings.ToLower(err.StructField()), err.Param())
+				return msg
+			}
+
+			if err := validate.RegisterTranslation(tag, trans, register, translate); err != nil {
+				panic(err)
+			}
+		}
+
+		for field, label := range lang.labels {
+			if err := trans.Add(labelKey(field), label, true); err != nil {
+				panic(err)
+			}
+		}
 	}
 
-	return &message{
-		Message: "the " + strings.ToLower(err.Field()) + " field " + errMap[err.Tag()],
+	return validate, translator
+}
+
+// jsonName is the name of the field in the json payloads, empty for
+// the fields left out of them.
+func jsonName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
 	}
+	return name
+}
+
+// label translates the name of the field, falling back to its json
+// name.
+func label(err validator.FieldError, trans ut.Translator) string {
+	if label, e := trans.T(labelKey(err.Field())); e == nil {
+		return label
+	}
+	return err.Field()
+}
+
+// negotiate picks the supported language preferred by the
+// Accept-Language header, falling back to English.
+func negotiate(acceptLanguage string) language {
+	type preference struct {
+		tag string
+		q   float64
+	}
+
+	preferences := make([]preference, 0)
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if tag != "" && q > 0 {
+			preferences = append(preferences, preference{strings.ToLower(tag), q})
+		}
+	}
+
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].q > preferences[j].q
+	})
+
+	for _, preference := range preferences {
+		base, _, _ := strings.Cut(preference.tag, "-")
+
+		for _, lang := range languages {
+			supported, _, _ := strings.Cut(strings.ToLower(lang.tag), "-")
+			if base == supported {
+				return lang
+			}
+		}
+	}
+
+	return languages[0]
 }
 
 // BindStruct checks if the given struct is valid.
 func (v message) BindStruct(ctx context.Context, data interface{}) error {
-	if err := validator.New().StructCtx(ctx, data); err != nil {
+	if err := validate.StructCtx(ctx, data); err != nil {
 		return err
 	}
 	return nil
@@ -51,23 +263,33 @@ func (v message) BindStruct(ctx context.Context, data interface{}) error {
 
 // BindField checks if the given field is valid.
 func (v message) BindField(ctx context.Context, data interface{}, tag string) error {
-	if err := validator.New().VarCtx(ctx, data, tag); err != nil {
+	if err := validate.VarCtx(ctx, data, tag); err != nil {
 		return err
 	}
 	return nil
 }
 
-// DecodeError returns validation error messages.
-func (v message) DecodeError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusBadRequest)
+// DecodeError returns validation error messages in the
+// language asked by the Accept-Language header.
+func (v message) DecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	v.DecodeErrorStatus(w, r, err, http.StatusBadRequest)
+}
+
+// DecodeErrorStatus is DecodeError answering with the given status.
+func (v message) DecodeErrorStatus(w http.ResponseWriter, r *http.Request, err error, httpCode int) {
+	lang := negotiate(r.Header.Get("Accept-Language"))
+	trans, _ := translator.GetTranslator(lang.locale.Locale())
+
+	w.Header().Set("Content-Language", lang.tag)
+	w.WriteHeader(httpCode)
 
 	message := &errors{}
 
 	for _, err := range err.(validator.ValidationErrors) {
-		message.Errors = append(message.Errors, v.errorMap(err))
+		message.Errors = append(message.Errors, v.errorMap(err, trans))
 	}
 
-	if err := json.NewEncoder(w).Encode(message); err != nil {
+	if err := rest.NewEncoder(w).Encode(message); err != nil {
 		if _, err := w.Write([]byte("could not encode the payload")); err != nil {
 			return
 		}
@@ -75,6 +297,16 @@ func (v message) DecodeError(w http.ResponseWriter, err error) {
 	}
 }
 
+// errorMap improves error messages.
+func (v message) errorMap(err validator.FieldError, trans ut.Translator) *message {
+	return &message{
+		Field:   strings.ToLower(err.StructField()),
+		Label:   label(err, trans),
+		Code:    code(err.Tag()),
+		Message: err.Translate(trans),
+	}
+}
+
 // New creates a new Validator.
 func New() Validator {
 	return message{}
@@ -0,0 +1,186 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type payload struct {
+	Name     string `json:"name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,gte=8"`
+}
+
+func decode(t *testing.T, acceptLanguage string) (*httptest.ResponseRecorder, []string) {
+	t.Helper()
+
+	validation := New()
+
+	err := validation.BindStruct(context.TODO(), payload{Email: "invalid", Password: "123"})
+	assert.Error(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	rec := httptest.NewRecorder()
+
+	validation.DecodeError(rec, req, err)
+
+	var body struct {
+		Errors []message `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+	messages := make([]string, 0, len(body.Errors))
+	for _, message := range body.Errors {
+		messages = append(messages, message.Message)
+	}
+
+	return rec, messages
+}
+
+func TestDecodeError(t *testing.T) {
+	rec, messages := decode(t, "")
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "en", rec.Header().Get("Content-Language"))
+	assert.Equal(t, []string{
+		"the name field is required",
+		"the email field is not valid",
+		"the password field minimum length is 8",
+	}, messages)
+}
+
+func TestDecodeErrorLocalized(t *testing.T) {
+	cases := []struct {
+		name           string
+		acceptLanguage string
+		language       string
+		messages       []string
+	}{
+		{"pt-BR", "pt-BR,pt;q=0.9,en;q=0.8", "pt-BR", []string{
+			"o campo name é obrigatório",
+			"o campo email não é válido",
+			"o tamanho mínimo do campo password é 8",
+		}},
+		{"pt", "pt", "pt-BR", []string{
+			"o campo name é obrigatório",
+			"o campo email não é válido",
+			"o tamanho mínimo do campo password é 8",
+		}},
+		{"es", "fr;q=0.9, es;q=0.8", "es", []string{
+			"el campo name es obligatorio",
+			"el campo email no es válido",
+			"la longitud mínima del campo password es 8",
+		}},
+		{"unsupported", "de-DE", "en", []string{
+			"the name field is required",
+			"the email field is not valid",
+			"the password field minimum length is 8",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec, messages := decode(t, c.acceptLanguage)
+
+			assert.Equal(t, c.language, rec.Header().Get("Content-Language"))
+			assert.Equal(t, c.messages, messages)
+		})
+	}
+}
+
+func TestDecodeErrorCodes(t *testing.T) {
+	type rules struct {
+		Name     string `json:"name" validate:"required"`
+		Email    string `json:"email" validate:"omitempty,email"`
+		Identity string `json:"identity" validate:"required_without=Name"`
+		Username string `json:"username" validate:"omitempty,alphanum"`
+		Short    string `json:"short" validate:"min=3"`
+		Password string `json:"password" validate:"gte=8"`
+		Long     string `json:"long" validate:"max=2"`
+		ID       string `json:"id" validate:"omitempty,uuid"`
+	}
+
+	validation := New()
+
+	err := validation.BindStruct(context.TODO(), rules{
+		Email:    "invalid",
+		Username: "cy ro",
+		Short:    "ab",
+		Password: "123",
+		Long:     "abc",
+		ID:       "abc",
+	})
+	assert.Error(t, err)
+
+	rec := httptest.NewRecorder()
+	validation.DecodeError(rec, httptest.NewRequest(http.MethodPost, "/", nil), err)
+
+	var body struct {
+		Errors []message `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+	codes := map[string]string{}
+	for _, message := range body.Errors {
+		assert.NotEmpty(t, message.Message, message.Field)
+		codes[message.Field] = message.Code
+	}
+
+	assert.Equal(t, map[string]string{
+		"name":     "REQUIRED",
+		"email":    "EMAIL",
+		"identity": "REQUIRED",
+		"username": "ALPHANUMERIC",
+		"short":    "MIN_LENGTH",
+		"password": "MIN_LENGTH",
+		"long":     "MAX_LENGTH",
+		"id":       "UUID",
+	}, codes)
+}
+
+func TestDecodeErrorLabels(t *testing.T) {
+	type form struct {
+		Email           string `json:"email" validate:"required"`
+		CurrentPassword string `json:"current_password" validate:"required"`
+		Nickname        string `json:"nickname" validate:"required"`
+	}
+
+	validation := New()
+
+	err := validation.BindStruct(context.TODO(), form{})
+	assert.Error(t, err)
+
+	for language, expected := range map[string]map[string]string{
+		"":      {"email": "Email", "currentpassword": "Current password", "nickname": "nickname"},
+		"pt-BR": {"email": "E-mail", "currentpassword": "Senha atual", "nickname": "nickname"},
+	} {
+		t.Run(language, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if language != "" {
+				req.Header.Set("Accept-Language", language)
+			}
+			rec := httptest.NewRecorder()
+
+			validation.DecodeError(rec, req, err)
+
+			var body struct {
+				Errors []message `json:"errors"`
+			}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+
+			labels := map[string]string{}
+			for _, message := range body.Errors {
+				labels[message.Field] = message.Label
+			}
+			assert.Equal(t, expected, labels)
+		})
+	}
+}